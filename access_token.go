@@ -0,0 +1,208 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// tokenInfoURL is the endpoint which reports the expiry of an access token.
+	tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+	// accessTokenDirEnv is the environment variable of the plugin process
+	// which names the directory access_token_file must be in. It is set when
+	// registering the plugin, so writing the config cannot read arbitrary
+	// files on the Vault server. If unset, access_token_file is rejected.
+	accessTokenDirEnv = "GCPKMS_ACCESS_TOKEN_DIR"
+
+	// maxAccessTokenFileSize is the largest access token file which is read.
+	maxAccessTokenFileSize = 4096
+)
+
+// accessTokenRegex matches an OAuth bearer token, so a file holding anything
+// else is never sent to KMS.
+var accessTokenRegex = regexp.MustCompile(`^[A-Za-z0-9._~+/-]+=*$`)
+
+// checkAccessTokenPath returns an error unless the path is a clean absolute
+// path in the directory named by accessTokenDirEnv.
+func checkAccessTokenPath(file string) error {
+	dir := os.Getenv(accessTokenDirEnv)
+	if dir == "" {
+		return fmt.Errorf("access_token_file requires the plugin's %s environment "+
+			"variable to name the directory it is in", accessTokenDirEnv)
+	}
+	if !filepath.IsAbs(file) || filepath.Clean(file) != file {
+		return fmt.Errorf("access_token_file must be a clean absolute path, got %q", file)
+	}
+	if !pathWithin(file, filepath.Clean(dir)) {
+		return fmt.Errorf("access_token_file must be in %q, the directory named by %s", dir, accessTokenDirEnv)
+	}
+	return nil
+}
+
+// pathWithin returns true if the path is inside the directory.
+func pathWithin(file, dir string) bool {
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readAccessTokenFile reads the access token from the file. The file, with
+// symlinks resolved, must be a regular file in the directory named by
+// accessTokenDirEnv which only its owner can write, and must hold a single
+// bearer token.
+func readAccessTokenFile(file string) (string, error) {
+	if err := checkAccessTokenPath(file); err != nil {
+		return "", err
+	}
+
+	dir, err := filepath.EvalSymlinks(os.Getenv(accessTokenDirEnv))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", accessTokenDirEnv, err)
+	}
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read access_token_file: %w", err)
+	}
+	if !pathWithin(resolved, dir) {
+		return "", fmt.Errorf("access_token_file resolves to %q, outside of %q", resolved, dir)
+	}
+
+	fi, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("failed to read access_token_file: %w", err)
+	}
+	switch {
+	case !fi.Mode().IsRegular():
+		return "", fmt.Errorf("access_token_file %q is not a regular file", file)
+	case fi.Mode().Perm()&0o022 != 0:
+		return "", fmt.Errorf("access_token_file %q must not be writable by group or others", file)
+	case fi.Size() > maxAccessTokenFileSize:
+		return "", fmt.Errorf("access_token_file %q is larger than %d bytes", file, maxAccessTokenFileSize)
+	}
+
+	b, err := os.ReadFile(resolved)
+	if err != nil {
+		return "", fmt.Errorf("failed to read access_token_file: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("access_token_file %q is empty", file)
+	}
+	if !accessTokenRegex.MatchString(token) {
+		return "", fmt.Errorf("access_token_file %q does not hold an access token", file)
+	}
+	return token, nil
+}
+
+// accessTokenSource is a token source for an access token which is brokered
+// outside of Vault, either stored in the config or read from a file which the
+// broker keeps up to date.
+type accessTokenSource struct {
+	ctx          context.Context
+	token        string
+	file         string
+	tokenInfoURL string
+
+	// last is the last token returned, so its expiry is only looked up once.
+	// Tokens close to expiry are asked for on every call.
+	lock sync.Mutex
+	last *oauth2.Token
+}
+
+// newAccessTokenSource creates a token source for the access token, or for the
+// access token in the file if token is empty. The context is used to look up
+// the expiry of the token.
+func newAccessTokenSource(ctx context.Context, token, file string) *accessTokenSource {
+	return &accessTokenSource{
+		ctx:          ctx,
+		token:        token,
+		file:         file,
+		tokenInfoURL: tokenInfoURL,
+	}
+}
+
+// Token returns the access token with its expiry. The file is read on every
+// call, so a token rotated by the broker is picked up once the cached token
+// expires or is rejected.
+func (s *accessTokenSource) Token() (*oauth2.Token, error) {
+	token := s.token
+	if token == "" {
+		var err error
+		if token, err = readAccessTokenFile(s.file); err != nil {
+			return nil, err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.last != nil && s.last.AccessToken == token {
+		return s.last, nil
+	}
+
+	expiry, err := s.expiry(token)
+	if err != nil {
+		return nil, err
+	}
+
+	s.last = &oauth2.Token{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}
+	return s.last, nil
+}
+
+// expiry looks up when the access token expires. A token which Google reports
+// as invalid is an error, but if the lookup itself fails the expiry is left
+// unknown and the token is used until KMS rejects it.
+func (s *accessTokenSource) expiry(token string) (time.Time, error) {
+	// The token is sent in the body rather than the URL, so it does not end
+	// up in proxy logs.
+	body := url.Values{"access_token": {token}}.Encode()
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.tokenInfoURL, strings.NewReader(body))
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := oauth2.NewClient(s.ctx, nil).Do(req)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		return time.Time{}, fmt.Errorf("access token is invalid or has expired")
+	case resp.StatusCode != http.StatusOK:
+		return time.Time{}, nil
+	}
+
+	var info struct {
+		ExpiresIn string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return time.Time{}, nil
+	}
+	secs, err := strconv.ParseInt(info.ExpiresIn, 10, 64)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return time.Now().Add(time.Duration(secs) * time.Second), nil
+}
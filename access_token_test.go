@@ -0,0 +1,214 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenSource_Token(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		token   string
+		file    string
+		status  int
+		expires bool
+		err     bool
+	}{
+		{
+			"token",
+			"ya29.token",
+			"",
+			http.StatusOK,
+			true,
+			false,
+		},
+		{
+			"file",
+			"",
+			" ya29.token\n",
+			http.StatusOK,
+			true,
+			false,
+		},
+		{
+			"empty_file",
+			"",
+			"\n",
+			http.StatusOK,
+			false,
+			true,
+		},
+		{
+			"invalid",
+			"ya29.token",
+			"",
+			http.StatusBadRequest,
+			false,
+			true,
+		},
+		{
+			"lookup_failed",
+			"ya29.token",
+			"",
+			http.StatusInternalServerError,
+			false,
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			lookups := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lookups++
+				if v, exp := r.PostFormValue("access_token"), "ya29.token"; v != exp {
+					t.Errorf("expected %q to be %q", v, exp)
+				}
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, `{"expires_in": "3600"}`)
+			}))
+			defer srv.Close()
+
+			var file string
+			if tc.file != "" {
+				dir := t.TempDir()
+				t.Setenv(accessTokenDirEnv, dir)
+				file = filepath.Join(dir, "token")
+				if err := os.WriteFile(file, []byte(tc.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := newAccessTokenSource(context.Background(), tc.token, file)
+			s.tokenInfoURL = srv.URL
+
+			token, err := s.Token()
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if tc.err {
+				return
+			}
+
+			if v, exp := token.AccessToken, "ya29.token"; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+			if expires := !token.Expiry.IsZero(); expires != tc.expires {
+				t.Errorf("expected expires to be %t", tc.expires)
+			}
+			if tc.expires {
+				if d := time.Until(token.Expiry); d < 59*time.Minute || d > time.Hour {
+					t.Errorf("expected %s to be about 1h", d)
+				}
+			}
+
+			// The expiry of the same token is only looked up once
+			if _, err := s.Token(); err != nil {
+				t.Fatal(err)
+			}
+			if lookups != 1 {
+				t.Errorf("expected %d to be 1", lookups)
+			}
+		})
+	}
+}
+
+func TestAccessTokenSource_Rotated(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"expires_in": "3600"}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	t.Setenv(accessTokenDirEnv, dir)
+	file := filepath.Join(dir, "token")
+	s := newAccessTokenSource(context.Background(), "", file)
+	s.tokenInfoURL = srv.URL
+
+	for _, exp := range []string{"ya29.first", "ya29.second"} {
+		if err := os.WriteFile(file, []byte(exp), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := s.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := token.AccessToken; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+	}
+}
+
+func TestReadAccessTokenFile(t *testing.T) {
+
+	dir := t.TempDir()
+	outside := t.TempDir()
+
+	write := func(t *testing.T, file, content string, perm os.FileMode) {
+		t.Helper()
+
+		if err := os.WriteFile(file, []byte(content), perm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(file, perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(t, filepath.Join(dir, "token"), "ya29.token\n", 0o600)
+	write(t, filepath.Join(dir, "writable"), "ya29.token", 0o666)
+	write(t, filepath.Join(dir, "not-token"), "[core]\nuser = root\n", 0o600)
+	write(t, filepath.Join(outside, "token"), "ya29.token", 0o600)
+	if err := os.Symlink(filepath.Join(outside, "token"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		dir  string
+		file string
+		err  bool
+	}{
+		{"token", dir, filepath.Join(dir, "token"), false},
+		{"no_dir", "", filepath.Join(dir, "token"), true},
+		{"outside_dir", dir, filepath.Join(outside, "token"), true},
+		{"relative", dir, "token", true},
+		{"dot_dot", dir, dir + "/../" + filepath.Base(outside) + "/token", true},
+		{"symlink_outside_dir", dir, filepath.Join(dir, "link"), true},
+		{"writable_by_others", dir, filepath.Join(dir, "writable"), true},
+		{"not_token", dir, filepath.Join(dir, "not-token"), true},
+		{"directory", dir, dir, true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			t.Setenv(accessTokenDirEnv, tc.dir)
+
+			token, err := readAccessTokenFile(tc.file)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if !tc.err && token != "ya29.token" {
+				t.Errorf("expected %q to be %q", token, "ya29.token")
+			}
+		})
+	}
+}
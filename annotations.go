@@ -0,0 +1,56 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestReasonHeader is the metadata key of the request reason, a Google
+// Cloud system parameter which is recorded in Cloud Audit Logs.
+const requestReasonHeader = "x-goog-request-reason"
+
+// annotatePaths wraps the callbacks of the paths so calls to KMS made for a
+// request carry the request's IDs, if the config enables it.
+func (b *backend) annotatePaths(paths []*framework.Path) {
+	wrapPathCallbacks(paths, func(_ *framework.Path, f framework.OperationFunc) framework.OperationFunc {
+		return b.withRequestAnnotations(f)
+	})
+}
+
+// withRequestAnnotations wraps an OperationFunc and adds the request reason to
+// the outgoing metadata of its context, so every call to KMS made with a
+// context derived from it carries the reason.
+func (b *backend) withRequestAnnotations(f framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		// Errors reading the config are left for the callback to report
+		settings, err := b.settings(ctx, req.Storage)
+		if err == nil && settings.annotateRequests {
+			if reason := requestReason(req, settings.annotateEntityID); reason != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, requestReasonHeader, reason)
+			}
+		}
+		return f(ctx, req, d)
+	}
+}
+
+// requestReason returns the request reason naming the Vault request ID and,
+// if entityID is true, the ID of the entity making the request.
+func requestReason(req *logical.Request, entityID bool) string {
+	reason := ""
+	if req.ID != "" {
+		reason = "vault-request-id=" + req.ID
+	}
+	if entityID && req.EntityID != "" {
+		if reason != "" {
+			reason += " "
+		}
+		reason += "vault-entity-id=" + req.EntityID
+	}
+	return reason
+}
@@ -0,0 +1,94 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestReason(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		req      *logical.Request
+		entityID bool
+		exp      string
+	}{
+		{
+			"request_id",
+			&logical.Request{ID: "req-1", EntityID: "ent-1"},
+			false,
+			"vault-request-id=req-1",
+		},
+		{
+			"entity_id",
+			&logical.Request{ID: "req-1", EntityID: "ent-1"},
+			true,
+			"vault-request-id=req-1 vault-entity-id=ent-1",
+		},
+		{
+			"no_ids",
+			&logical.Request{},
+			true,
+			"",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			if v := requestReason(tc.req, tc.entityID); v != tc.exp {
+				t.Errorf("expected %q to be %q", v, tc.exp)
+			}
+		})
+	}
+}
+
+func TestBackend_WithRequestAnnotations(t *testing.T) {
+
+	for _, enabled := range []bool{false, true} {
+		enabled := enabled
+
+		t.Run(map[bool]string{false: "disabled", true: "enabled"}[enabled], func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			entry, err := logical.StorageEntryJSON("config", &Config{
+				AnnotateRequests: enabled,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put(context.Background(), entry); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			f := b.withRequestAnnotations(func(ctx context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				got = md.Get(requestReasonHeader)
+				return nil, nil
+			})
+			if _, err := f(context.Background(), &logical.Request{
+				ID:      "req-1",
+				Storage: storage,
+			}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if enabled && (len(got) != 1 || got[0] != "vault-request-id=req-1") {
+				t.Errorf("expected the request reason, got %q", got)
+			}
+			if !enabled && len(got) != 0 {
+				t.Errorf("expected no request reason, got %q", got)
+			}
+		})
+	}
+}
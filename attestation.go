@@ -0,0 +1,426 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"embed"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+const (
+	// attestationSignatureLength is the length of the RSA signature appended to
+	// the end of a decompressed Cavium attestation.
+	attestationSignatureLength = 256
+
+	// attestationHeaderLength is the length of the header which precedes the
+	// key attributes of a decompressed Cavium attestation.
+	attestationHeaderLength = 32
+
+	// The PKCS #11 attributes of the attested key which are checked.
+	ckaExtractable      = 0x0162
+	ckaLocal            = 0x0163
+	ckaNeverExtractable = 0x0164
+)
+
+// builtinAttestationRoots are the root certificates built into the plugin.
+// See attestation_roots/README.md.
+//
+//go:embed attestation_roots
+var builtinAttestationRoots embed.FS
+
+// AttestationReport is the result of verifying an HSM attestation.
+type AttestationReport struct {
+	// Valid is true only if every check passed.
+	Valid bool
+
+	// Checks maps the name of each verification step to its result.
+	Checks map[string]bool
+
+	// Errors contains human-readable reasons for any failed checks.
+	Errors []string
+
+	// Certificates contains parsed attributes of each certificate chain, keyed
+	// by chain name.
+	Certificates map[string][]map[string]interface{}
+
+	// ContentLength is the length of the decompressed attestation content.
+	ContentLength int
+
+	// KeyOrigin is "generated" if the attestation says the key was generated
+	// in the HSM, or "imported" if it was not, and Extractable is whether the
+	// key may leave the HSM. They are only set if the attributes were parsed.
+	KeyOrigin   string
+	Extractable bool
+}
+
+// attestationRoots are the trusted root certificates used to verify the
+// attestation certificate chains.
+type attestationRoots struct {
+	// Manufacturer is the pool of HSM manufacturer roots, which anchor the
+	// Cavium certificate chain.
+	Manufacturer *x509.CertPool
+
+	// Owner is the pool of Google roots, which anchor the Google card and
+	// partition certificate chains.
+	Owner *x509.CertPool
+}
+
+// builtinAttestationRootPEMs returns the PEM-encoded Google and manufacturer
+// roots built into the plugin, which are empty if there are none.
+func builtinAttestationRootPEMs() (string, string, error) {
+	var google, manufacturer strings.Builder
+	err := fs.WalkDir(builtinAttestationRoots, "attestation_roots", func(p string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() || !strings.HasSuffix(p, ".pem") {
+			return err
+		}
+		b, err := builtinAttestationRoots.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		switch name := e.Name(); {
+		case strings.HasPrefix(name, "google-"):
+			google.Write(b)
+		case strings.HasPrefix(name, "manufacturer-"):
+			manufacturer.Write(b)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read built-in attestation roots: %w", err)
+	}
+	return google.String(), manufacturer.String(), nil
+}
+
+// configAttestationRoots returns the roots trusted to verify attestations,
+// which are those built into the plugin and those set in the config. A pool
+// is nil if no roots of its kind are trusted.
+func configAttestationRoots(c *Config) (*attestationRoots, error) {
+	google, manufacturer, err := builtinAttestationRootPEMs()
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := certPoolFromPEM(strings.TrimSpace(google + "\n" + c.AttestationGoogleRoots))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Google attestation roots: %w", err)
+	}
+	mfr, err := certPoolFromPEM(strings.TrimSpace(manufacturer + "\n" + c.AttestationManufacturerRoots))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manufacturer attestation roots: %w", err)
+	}
+	return &attestationRoots{
+		Manufacturer: mfr,
+		Owner:        owner,
+	}, nil
+}
+
+// verifyAttestation verifies the given attestation. The certificate chains are
+// verified against the given roots, the partition certificates of the
+// manufacturer and owner chains must share the same public key, and the
+// attestation content must be signed by the partition key. The key attributes
+// in the attestation must show the key cannot be extracted, and that it was
+// generated in the HSM unless the crypto key version was imported.
+func verifyAttestation(att *kmspb.KeyOperationAttestation, roots *attestationRoots, imported bool) *AttestationReport {
+	r := &AttestationReport{
+		Checks:       make(map[string]bool),
+		Certificates: make(map[string][]map[string]interface{}),
+	}
+
+	fail := func(check string, err error) {
+		r.Checks[check] = false
+		r.Errors = append(r.Errors, fmt.Sprintf("%s: %s", check, err))
+	}
+
+	if att == nil || len(att.Content) == 0 {
+		fail("content", errors.New("attestation has no content"))
+		return r
+	}
+
+	content, err := decompressAttestation(att)
+	if err != nil {
+		fail("content", err)
+		return r
+	}
+	r.Checks["content"] = true
+	r.ContentLength = len(content)
+
+	if att.CertChains == nil {
+		fail("cert_chains", errors.New("attestation has no certificate chains"))
+		return r
+	}
+
+	chains := []struct {
+		name  string
+		pems  []string
+		roots *x509.CertPool
+	}{
+		{"cavium_certs", att.CertChains.CaviumCerts, roots.Manufacturer},
+		{"google_card_certs", att.CertChains.GoogleCardCerts, roots.Owner},
+		{"google_partition_certs", att.CertChains.GooglePartitionCerts, roots.Owner},
+	}
+
+	leaves := make(map[string]*x509.Certificate, len(chains))
+	for _, c := range chains {
+		certs, err := parseCertificateChain(c.pems)
+		if err != nil {
+			fail(c.name, err)
+			continue
+		}
+
+		for _, cert := range certs {
+			r.Certificates[c.name] = append(r.Certificates[c.name], certificateAttributes(cert))
+		}
+
+		if err := verifyCertificateChain(certs, c.roots); err != nil {
+			fail(c.name, err)
+			continue
+		}
+		r.Checks[c.name] = true
+		leaves[c.name] = certs[0]
+	}
+
+	// The partition certificate issued by the manufacturer and the partition
+	// certificate issued by Google must certify the same key.
+	mfr, owner := leaves["cavium_certs"], leaves["google_partition_certs"]
+	if mfr == nil || owner == nil {
+		fail("partition_key_match", errors.New("missing verified partition certificate"))
+	} else if !bytes.Equal(mfr.RawSubjectPublicKeyInfo, owner.RawSubjectPublicKeyInfo) {
+		fail("partition_key_match", errors.New("manufacturer and owner partition certificates have different public keys"))
+	} else {
+		r.Checks["partition_key_match"] = true
+	}
+
+	// The attestation is signed by the partition key.
+	if owner == nil {
+		fail("signature", errors.New("missing verified partition certificate"))
+	} else if err := verifyAttestationSignature(content, owner); err != nil {
+		fail("signature", err)
+	} else {
+		r.Checks["signature"] = true
+	}
+
+	attrs, err := parseAttestationAttributes(content)
+	if err != nil {
+		fail("key_origin", err)
+		fail("extractable", err)
+	} else {
+		checkAttestationAttributes(r, attrs, imported, fail)
+	}
+
+	r.Valid = len(r.Errors) == 0
+	return r
+}
+
+// checkAttestationAttributes checks the origin and extractability of the key
+// in the parsed attestation attributes, and records them in the report.
+func checkAttestationAttributes(r *AttestationReport, attrs map[uint32][]byte, imported bool, fail func(string, error)) {
+	local, ok := attestationBool(attrs, ckaLocal)
+	switch {
+	case !ok:
+		fail("key_origin", errors.New("attestation has no CKA_LOCAL attribute"))
+	default:
+		r.KeyOrigin = "imported"
+		if local {
+			r.KeyOrigin = "generated"
+		}
+		if local == imported {
+			fail("key_origin", fmt.Errorf("attestation says the key was %s, "+
+				"but the crypto key version was not", r.KeyOrigin))
+		} else {
+			r.Checks["key_origin"] = true
+		}
+	}
+
+	extractable, ok := attestationBool(attrs, ckaExtractable)
+	neverExtractable, neverOK := attestationBool(attrs, ckaNeverExtractable)
+	r.Extractable = extractable
+	switch {
+	case !ok || !neverOK:
+		fail("extractable", errors.New("attestation has no CKA_EXTRACTABLE or CKA_NEVER_EXTRACTABLE attribute"))
+	case extractable:
+		fail("extractable", errors.New("attestation says the key is extractable"))
+	case !neverExtractable && !imported:
+		fail("extractable", errors.New("attestation says the key was once extractable"))
+	default:
+		r.Checks["extractable"] = true
+	}
+}
+
+// parseAttestationAttributes returns the PKCS #11 attributes of the key in the
+// decompressed attestation, keyed by attribute type. The attributes follow the
+// header as big-endian 32-bit type and length fields and the value, and are
+// followed by the signature.
+func parseAttestationAttributes(content []byte) (map[uint32][]byte, error) {
+	if len(content) < attestationHeaderLength+attestationSignatureLength {
+		return nil, errors.New("attestation is too short to contain key attributes")
+	}
+
+	body := content[attestationHeaderLength : len(content)-attestationSignatureLength]
+	attrs := make(map[uint32][]byte)
+	for len(body) > 0 {
+		if len(body) < 8 {
+			return nil, errors.New("attestation has a truncated key attribute")
+		}
+		typ := binary.BigEndian.Uint32(body[0:4])
+		n := binary.BigEndian.Uint32(body[4:8])
+		body = body[8:]
+		if uint64(n) > uint64(len(body)) {
+			return nil, fmt.Errorf("attestation key attribute 0x%04x is truncated", typ)
+		}
+		attrs[typ] = body[:n]
+		body = body[n:]
+	}
+	return attrs, nil
+}
+
+// attestationBool returns the value of the boolean attribute, and whether the
+// attribute is present.
+func attestationBool(attrs map[uint32][]byte, typ uint32) (bool, bool) {
+	v, ok := attrs[typ]
+	if !ok || len(v) == 0 {
+		return false, false
+	}
+	return v[0] != 0, true
+}
+
+// decompressAttestation returns the raw attestation content, decompressing it
+// if the format requires.
+func decompressAttestation(att *kmspb.KeyOperationAttestation) ([]byte, error) {
+	switch att.Format {
+	case kmspb.KeyOperationAttestation_CAVIUM_V1_COMPRESSED,
+		kmspb.KeyOperationAttestation_CAVIUM_V2_COMPRESSED:
+		zr, err := gzip.NewReader(bytes.NewReader(att.Content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress attestation: %w", err)
+		}
+		defer zr.Close()
+
+		content, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress attestation: %w", err)
+		}
+		return content, nil
+	default:
+		return nil, fmt.Errorf("unsupported attestation format %s", att.Format)
+	}
+}
+
+// parseCertificateChain parses the list of PEM-encoded certificates. The
+// resulting chain is ordered from leaf to root.
+func parseCertificateChain(pems []string) ([]*x509.Certificate, error) {
+	if len(pems) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+
+	var certs []*x509.Certificate
+	for _, p := range pems {
+		rest := []byte(p)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse certificate: %w", err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("certificate chain contains no certificates")
+	}
+	return certs, nil
+}
+
+// verifyCertificateChain verifies that the first certificate chains up to one
+// of the given roots, using the remaining certificates as intermediates.
+func verifyCertificateChain(certs []*x509.Certificate, roots *x509.CertPool) error {
+	if roots == nil {
+		return errors.New("no trusted root certificates were provided")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("failed to verify certificate chain: %w", err)
+	}
+	return nil
+}
+
+// verifyAttestationSignature verifies the signature appended to the
+// attestation content was made by the certificate's key.
+func verifyAttestationSignature(content []byte, cert *x509.Certificate) error {
+	if len(content) <= attestationSignatureLength {
+		return errors.New("attestation is too short to contain a signature")
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("partition certificate has unsupported key type %T", cert.PublicKey)
+	}
+
+	data := content[:len(content)-attestationSignatureLength]
+	sig := content[len(content)-attestationSignatureLength:]
+	digest := sha256.Sum256(data)
+
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+		return fmt.Errorf("attestation signature is invalid: %w", err)
+	}
+	return nil
+}
+
+// certificateAttributes returns the user-facing attributes of a certificate.
+func certificateAttributes(cert *x509.Certificate) map[string]interface{} {
+	return map[string]interface{}{
+		"subject":       cert.Subject.String(),
+		"issuer":        cert.Issuer.String(),
+		"serial_number": cert.SerialNumber.String(),
+		"not_before":    cert.NotBefore.UTC().Format(time.RFC3339),
+		"not_after":     cert.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
+
+// certPoolFromPEM parses the PEM-encoded certificates into a pool. It returns
+// nil if the input is empty.
+func certPoolFromPEM(s string) (*x509.CertPool, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(s)) {
+		return nil, errors.New("no valid PEM-encoded certificates found")
+	}
+	return pool, nil
+}
@@ -0,0 +1,255 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// testCertificate creates a certificate for the given key, signed by the
+// parent. If parent is nil, the certificate is self-signed.
+func testCertificate(tb testing.TB, cn string, key *rsa.PrivateKey, parent *x509.Certificate, parentKey *rsa.PrivateKey) *x509.Certificate {
+	tb.Helper()
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cert
+}
+
+// testRSAKey generates a new RSA key.
+func testRSAKey(tb testing.TB) *rsa.PrivateKey {
+	tb.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return key
+}
+
+// testPEM encodes the certificate as PEM.
+func testPEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func TestVerifyAttestation(t *testing.T) {
+
+	mfrKey, googleKey, partitionKey, cardKey := testRSAKey(t), testRSAKey(t), testRSAKey(t), testRSAKey(t)
+
+	mfrRoot := testCertificate(t, "manufacturer", mfrKey, nil, nil)
+	googleRoot := testCertificate(t, "google", googleKey, nil, nil)
+	mfrPartition := testCertificate(t, "partition", partitionKey, mfrRoot, mfrKey)
+	googlePartition := testCertificate(t, "partition", partitionKey, googleRoot, googleKey)
+	googleCard := testCertificate(t, "card", cardKey, googleRoot, googleKey)
+
+	// Build a signed, compressed attestation
+	signed := func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, partitionKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(data, sig...)
+	}
+	compress := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	content := compress(signed(fakeAttestationAttributes(0, 1, 1)))
+
+	attestation := func() *kmspb.KeyOperationAttestation {
+		return &kmspb.KeyOperationAttestation{
+			Format:  kmspb.KeyOperationAttestation_CAVIUM_V2_COMPRESSED,
+			Content: content,
+			CertChains: &kmspb.KeyOperationAttestation_CertificateChains{
+				CaviumCerts:          []string{testPEM(mfrPartition), testPEM(mfrRoot)},
+				GoogleCardCerts:      []string{testPEM(googleCard), testPEM(googleRoot)},
+				GooglePartitionCerts: []string{testPEM(googlePartition), testPEM(googleRoot)},
+			},
+		}
+	}
+
+	mfrRoots, err := certPoolFromPEM(testPEM(mfrRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	googleRoots, err := certPoolFromPEM(testPEM(googleRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		att      func() *kmspb.KeyOperationAttestation
+		roots    *attestationRoots
+		imported bool
+		valid    bool
+		failed   string
+	}{
+		{
+			"valid",
+			attestation,
+			&attestationRoots{Manufacturer: mfrRoots, Owner: googleRoots},
+			false,
+			true,
+			"",
+		},
+		{
+			"imported",
+			func() *kmspb.KeyOperationAttestation {
+				att := attestation()
+				att.Content = compress(signed(fakeAttestationAttributes(0, 0, 0)))
+				return att
+			},
+			&attestationRoots{Manufacturer: mfrRoots, Owner: googleRoots},
+			true,
+			true,
+			"",
+		},
+		{
+			"not_imported",
+			attestation,
+			&attestationRoots{Manufacturer: mfrRoots, Owner: googleRoots},
+			true,
+			false,
+			"key_origin",
+		},
+		{
+			"extractable",
+			func() *kmspb.KeyOperationAttestation {
+				att := attestation()
+				att.Content = compress(signed(fakeAttestationAttributes(1, 1, 0)))
+				return att
+			},
+			&attestationRoots{Manufacturer: mfrRoots, Owner: googleRoots},
+			false,
+			false,
+			"extractable",
+		},
+		{
+			"truncated_attributes",
+			func() *kmspb.KeyOperationAttestation {
+				att := attestation()
+				att.Content = compress(signed(append(fakeAttestationAttributes(0, 1, 1), 0, 0)))
+				return att
+			},
+			&attestationRoots{Manufacturer: mfrRoots, Owner: googleRoots},
+			false,
+			false,
+			"key_origin",
+		},
+		{
+			"untrusted_manufacturer",
+			attestation,
+			&attestationRoots{Manufacturer: googleRoots, Owner: googleRoots},
+			false,
+			false,
+			"cavium_certs",
+		},
+		{
+			"partition_key_mismatch",
+			func() *kmspb.KeyOperationAttestation {
+				att := attestation()
+				other := testCertificate(t, "partition", testRSAKey(t), mfrRoot, mfrKey)
+				att.CertChains.CaviumCerts = []string{testPEM(other), testPEM(mfrRoot)}
+				return att
+			},
+			&attestationRoots{Manufacturer: mfrRoots, Owner: googleRoots},
+			false,
+			false,
+			"partition_key_match",
+		},
+		{
+			"bad_signature",
+			func() *kmspb.KeyOperationAttestation {
+				att := attestation()
+
+				tampered := signed(fakeAttestationAttributes(0, 1, 1))
+				tampered[0] ^= 1
+				att.Content = compress(tampered)
+				return att
+			},
+			&attestationRoots{Manufacturer: mfrRoots, Owner: googleRoots},
+			false,
+			false,
+			"signature",
+		},
+		{
+			"not_compressed",
+			func() *kmspb.KeyOperationAttestation {
+				att := attestation()
+				att.Content = []byte("not gzip")
+				return att
+			},
+			&attestationRoots{Manufacturer: mfrRoots, Owner: googleRoots},
+			false,
+			false,
+			"content",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			r := verifyAttestation(tc.att(), tc.roots, tc.imported)
+			if r.Valid != tc.valid {
+				t.Errorf("expected valid to be %t: %q", tc.valid, r.Errors)
+			}
+
+			if tc.failed != "" {
+				if v, ok := r.Checks[tc.failed]; !ok || v {
+					t.Errorf("expected check %q to fail: %#v", tc.failed, r.Checks)
+				}
+			}
+		})
+	}
+}
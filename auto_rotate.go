@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// autoRotate rotates every key with a rotation schedule whose next rotation
+// is due, and records the rotation on the key.
+func (b *backend) autoRotate(ctx context.Context, s logical.Storage, now time.Time) error {
+	index, err := b.keyIndex(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var keys []*Key
+	for _, name := range keyIndexNames(index) {
+		if next := index[name].NextRotation; next.IsZero() || now.Before(next) {
+			continue
+		}
+
+		k, err := b.Key(ctx, s, name)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				continue
+			}
+			return err
+		}
+		if k.RotationSchedule <= 0 || k.NextRotation.IsZero() || now.Before(k.NextRotation) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Get the default client up front, so a credentials error fails fast and
+	// the request timeout is loaded. Each key uses the client for its own
+	// endpoint.
+	_, closer, err := b.KMSClient(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	var errs *multierror.Error
+	for _, k := range keys {
+		// The key is reread under its lock when the rotation is recorded, so
+		// a user write made during the rotation is not lost
+		var apply func(*Key) bool
+		if k.RotationWindow > 0 && now.After(k.NextRotation.Add(k.RotationWindow)) {
+			b.Logger().Warn("scheduled rotation missed its rotation window, skipping until the next period",
+				"key", k.Name, "next_rotation", k.NextRotation)
+
+			apply = func(cur *Key) bool {
+				if cur.RotationSchedule <= 0 {
+					return false
+				}
+				cur.NextRotation = nextRotation(cur.NextRotation, cur.RotationSchedule, now)
+				return true
+			}
+		} else {
+			ckv, err := b.autoRotateKey(ctx, s, k)
+			if err != nil {
+				// The rotation is retried on the next tick
+				errs = multierror.Append(errs, errwrap.Wrapf(
+					"failed to rotate key "+k.Name+": {{err}}", err))
+				continue
+			}
+
+			b.Logger().Info("rotated key on schedule",
+				"key", k.Name, "key_version", path.Base(ckv.Name))
+
+			apply = func(cur *Key) bool {
+				cur.applyRotation(ckv, now)
+				return true
+			}
+		}
+
+		if _, err := b.updateKey(ctx, s, k.Name, apply); err != nil && err != ErrKeyNotFound {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// autoRotateKey rotates the crypto key of the key using the client for the
+// key's endpoint.
+func (b *backend) autoRotateKey(ctx context.Context, s logical.Storage, k *Key) (*kmspb.CryptoKeyVersion, error) {
+	kmsClient, closer, err := b.KeyKMSClient(ctx, s, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ckv, err := rotateCryptoKey(ctx, kmsClient, k.CryptoKeyID)
+	b.invalidateCryptoKey(k.CryptoKeyID)
+	return ckv, err
+}
+
+// nextRotation returns the first rotation time after now in the schedule
+// which starts at from and repeats every period.
+func nextRotation(from time.Time, period time.Duration, now time.Time) time.Time {
+	if !from.After(now) {
+		from = from.Add(period * (now.Sub(from)/period + 1))
+	}
+	return from
+}
@@ -0,0 +1,136 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_AutoRotate(t *testing.T) {
+
+	t.Run("not_due", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		next := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key", "crypto_key_id":"foo", "rotation_schedule":86400000000000, "next_rotation":"` + next + `"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The key is not due, so no client is needed
+		if err := b.autoRotate(ctx, storage, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+	defer cleanup()
+
+	cases := []struct {
+		name    string
+		window  time.Duration
+		rotated bool
+	}{
+		{
+			"due",
+			0,
+			true,
+		},
+		{
+			"missed_window",
+			time.Minute,
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			now := time.Now().UTC()
+			k := &Key{
+				Name:             "my-key",
+				CryptoKeyID:      cryptoKey,
+				RotationSchedule: 24 * time.Hour,
+				RotationWindow:   tc.window,
+				NextRotation:     now.Add(-time.Hour),
+			}
+
+			ctx := context.Background()
+			entry, err := logical.StorageEntryJSON("keys/my-key", k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put(ctx, entry); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := b.autoRotate(ctx, storage, now); err != nil {
+				t.Fatal(err)
+			}
+
+			k, err = b.Key(ctx, storage, "my-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rotated := k.LastRotated.Equal(now); rotated != tc.rotated {
+				t.Errorf("expected rotated to be %t: %#v", tc.rotated, k)
+			}
+			if !k.NextRotation.After(now) {
+				t.Errorf("expected next rotation %s to be after %s", k.NextRotation, now)
+			}
+		})
+	}
+}
+
+func TestNextRotation(t *testing.T) {
+
+	from := time.Unix(1000, 0)
+	period := 100 * time.Second
+
+	cases := []struct {
+		name string
+		now  time.Time
+		exp  time.Time
+	}{
+		{
+			"future",
+			time.Unix(900, 0),
+			time.Unix(1000, 0),
+		},
+		{
+			"now",
+			time.Unix(1000, 0),
+			time.Unix(1100, 0),
+		},
+		{
+			"missed_several",
+			time.Unix(1350, 0),
+			time.Unix(1400, 0),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			if v := nextRotation(from, period, tc.now); !v.Equal(tc.exp) {
+				t.Errorf("expected %s to be %s", v, tc.exp)
+			}
+		})
+	}
+}
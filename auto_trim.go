@@ -0,0 +1,216 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iterator"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+var (
+	// defaultAutoTrimInterval is the minimum amount of time between automatic
+	// trims. The periodic func is invoked much more often than this, so runs
+	// are skipped until the interval has passed.
+	defaultAutoTrimInterval = 1 * time.Hour
+
+	// autoTrimClockSkew is added to max_version_age before a crypto key
+	// version is trimmed for its age, so a local clock which runs ahead of
+	// KMS cannot trim versions early.
+	autoTrimClockSkew = 5 * time.Minute
+)
+
+// autoTrim trims the crypto key versions of all keys with auto_trim enabled,
+// according to each key's retention settings.
+func (b *backend) autoTrim(ctx context.Context, s logical.Storage, now time.Time) error {
+	index, err := b.keyIndex(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var keys []*Key
+	for _, name := range keyIndexNames(index) {
+		if !index[name].AutoTrim {
+			continue
+		}
+
+		k, err := b.Key(ctx, s, name)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				continue
+			}
+			return err
+		}
+		if !k.AutoTrim {
+			continue
+		}
+		if k.DeletionProtection && k.autoTrimAction() == trimActionDestroySchedule {
+			b.Logger().Debug("skipping automatic trim of key with deletion protection",
+				"key", k.Name)
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Get the default client up front, so a credentials error fails fast and
+	// the request timeout is loaded. Each key uses the client for its own
+	// endpoint.
+	_, closer, err := b.KMSClient(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	var errs *multierror.Error
+	for _, k := range keys {
+		if err := b.autoTrimKey(ctx, s, k, now); err != nil {
+			errs = multierror.Append(errs, errwrap.Wrapf(
+				"failed to automatically trim key "+k.Name+": {{err}}", err))
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// autoTrimKey trims the crypto key versions of the key which fall outside of
+// the key's retention settings, using the client for the key's endpoint.
+func (b *backend) autoTrimKey(ctx context.Context, s logical.Storage, k *Key, now time.Time) error {
+	kmsClient, closer, err := b.KeyKMSClient(ctx, s, k)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ckvs, err := autoTrimCandidates(ctx, kmsClient, k, now)
+	if err != nil || len(ckvs) == 0 {
+		return err
+	}
+
+	b.Logger().Info("automatically trimming crypto key versions",
+		"key", k.Name, "action", k.autoTrimAction(), "versions", len(ckvs))
+	err = trimCryptoKeyVersions(ctx, kmsClient, ckvs, k.autoTrimAction())
+	b.invalidateCryptoKey(k.CryptoKeyID)
+	b.evictPublicKeys(k.CryptoKeyID)
+	return err
+}
+
+// autoTrimCandidates returns the crypto key versions of the key which fall
+// outside of the key's retention settings.
+func autoTrimCandidates(ctx context.Context, kmsClient keyManagementClient, k *Key, now time.Time) ([]*kmspb.CryptoKeyVersion, error) {
+	ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: k.CryptoKeyID,
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read crypto key: {{err}}", err)
+	}
+
+	var primary string
+	if ck.Primary != nil {
+		primary = ck.Primary.Name
+	}
+
+	var ckvs []*kmspb.CryptoKeyVersion
+	it := kmsClient.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
+		Parent: k.CryptoKeyID,
+	})
+	for {
+		resp, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			return nil, errwrap.Wrapf("failed to list crypto key versions: {{err}}", err)
+		}
+		ckvs = append(ckvs, resp)
+	}
+
+	return selectAutoTrimVersions(ckvs, primary, k.KeepVersions, k.MaxVersionAge, k.autoTrimAction(), now), nil
+}
+
+// selectAutoTrimVersions returns the crypto key versions which should be
+// trimmed. The newest keepVersions versions and any versions newer than
+// maxAge are retained; when both are set, a version is trimmed only if
+// neither retains it. The age of a version is measured from when its key
+// material was generated or imported, and versions without either are
+// retained. The primary version is never trimmed.
+func selectAutoTrimVersions(ckvs []*kmspb.CryptoKeyVersion, primary string, keepVersions int, maxAge time.Duration, action string, now time.Time) []*kmspb.CryptoKeyVersion {
+	if keepVersions <= 0 && maxAge <= 0 {
+		return nil
+	}
+
+	// Versions which are already destroyed do not count towards retention
+	live := make([]*kmspb.CryptoKeyVersion, 0, len(ckvs))
+	for _, ckv := range ckvs {
+		if ckv.State == kmspb.CryptoKeyVersion_DESTROYED ||
+			ckv.State == kmspb.CryptoKeyVersion_DESTROY_SCHEDULED {
+			continue
+		}
+		live = append(live, ckv)
+	}
+
+	// Newest first
+	sort.Slice(live, func(i, j int) bool {
+		return versionNumber(live[i].Name) > versionNumber(live[j].Name)
+	})
+
+	var result []*kmspb.CryptoKeyVersion
+	for i, ckv := range live {
+		if ckv.Name == primary {
+			continue
+		}
+		if keepVersions > 0 && i < keepVersions {
+			continue
+		}
+		if maxAge > 0 {
+			generated, ok := versionGenerateTime(ckv)
+			if !ok || now.Sub(generated) < maxAge+autoTrimClockSkew {
+				continue
+			}
+		}
+		if action == trimActionDisable && ckv.State == kmspb.CryptoKeyVersion_DISABLED {
+			continue
+		}
+		result = append(result, ckv)
+	}
+	return result
+}
+
+// versionGenerateTime returns the time the key material of the crypto key
+// version was generated, or imported for imported versions, and false if it
+// has neither, such as while it is pending generation.
+func versionGenerateTime(ckv *kmspb.CryptoKeyVersion) (time.Time, bool) {
+	switch {
+	case ckv.GenerateTime != nil:
+		return ckv.GenerateTime.AsTime(), true
+	case ckv.ImportTime != nil:
+		return ckv.ImportTime.AsTime(), true
+	}
+	return time.Time{}, false
+}
+
+// versionNumber returns the version number of the crypto key version, or 0 if
+// it cannot be parsed.
+func versionNumber(name string) int {
+	v, err := strconv.Atoi(path.Base(name))
+	if err != nil {
+		return 0
+	}
+	return v
+}
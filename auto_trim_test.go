@@ -0,0 +1,165 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestBackend_AutoTrim(t *testing.T) {
+
+	t.Run("deletion_protection", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key", "crypto_key_id":"foo", "auto_trim":true, "keep_versions":1, "deletion_protection":true}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The key is skipped, so no client is needed
+		if err := b.autoTrim(ctx, storage, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSelectAutoTrimVersions(t *testing.T) {
+
+	now := time.Unix(1000000, 0)
+	version := func(n string, state kmspb.CryptoKeyVersion_CryptoKeyVersionState, age time.Duration) *kmspb.CryptoKeyVersion {
+		return &kmspb.CryptoKeyVersion{
+			Name:         "cryptoKeyVersions/" + n,
+			State:        state,
+			CreateTime:   &timestamp.Timestamp{Seconds: now.Add(-age).Unix()},
+			GenerateTime: &timestamp.Timestamp{Seconds: now.Add(-age).Unix()},
+		}
+	}
+
+	enabled := kmspb.CryptoKeyVersion_ENABLED
+	disabled := kmspb.CryptoKeyVersion_DISABLED
+	destroyed := kmspb.CryptoKeyVersion_DESTROYED
+
+	ckvs := []*kmspb.CryptoKeyVersion{
+		version("1", destroyed, 5*time.Hour),
+		version("2", disabled, 4*time.Hour),
+		version("3", enabled, 3*time.Hour),
+		version("4", enabled, 2*time.Hour),
+		version("5", enabled, 1*time.Hour),
+	}
+
+	cases := []struct {
+		name         string
+		primary      string
+		keepVersions int
+		maxAge       time.Duration
+		action       string
+		exp          []string
+	}{
+		{
+			"no_retention",
+			"",
+			0,
+			0,
+			trimActionDestroySchedule,
+			nil,
+		},
+		{
+			"keep_versions",
+			"",
+			2,
+			0,
+			trimActionDestroySchedule,
+			[]string{"3", "2"},
+		},
+		{
+			"max_age",
+			"",
+			0,
+			150 * time.Minute,
+			trimActionDestroySchedule,
+			[]string{"3", "2"},
+		},
+		{
+			"max_age_allows_clock_skew",
+			"",
+			0,
+			178 * time.Minute,
+			trimActionDestroySchedule,
+			[]string{"2"},
+		},
+		{
+			"keep_versions_and_max_age",
+			"",
+			3,
+			150 * time.Minute,
+			trimActionDestroySchedule,
+			[]string{"2"},
+		},
+		{
+			"skips_primary",
+			"cryptoKeyVersions/3",
+			2,
+			0,
+			trimActionDestroySchedule,
+			[]string{"2"},
+		},
+		{
+			"disable_skips_disabled",
+			"",
+			2,
+			0,
+			trimActionDisable,
+			[]string{"3"},
+		},
+	}
+
+	t.Run("generate_time", func(t *testing.T) {
+
+		// Versions are aged from their generate or import time, not from when
+		// they were created, and retained without either
+		imported := version("2", enabled, time.Hour)
+		imported.GenerateTime = nil
+		imported.ImportTime = &timestamp.Timestamp{Seconds: now.Add(-3 * time.Hour).Unix()}
+		pending := version("3", kmspb.CryptoKeyVersion_PENDING_GENERATION, 3*time.Hour)
+		pending.GenerateTime = nil
+		generatedLater := version("4", enabled, 3*time.Hour)
+		generatedLater.GenerateTime = &timestamp.Timestamp{Seconds: now.Add(-time.Hour).Unix()}
+
+		result := selectAutoTrimVersions([]*kmspb.CryptoKeyVersion{imported, pending, generatedLater},
+			"", 0, 2*time.Hour, trimActionDestroySchedule, now)
+		if len(result) != 1 || result[0] != imported {
+			t.Errorf("expected only the imported version to be trimmed, got %v", result)
+		}
+	})
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			result := selectAutoTrimVersions(ckvs, tc.primary, tc.keepVersions, tc.maxAge, tc.action, now)
+
+			var names []string
+			for _, ckv := range result {
+				names = append(names, ckv.Name[len("cryptoKeyVersions/"):])
+			}
+
+			if !reflect.DeepEqual(names, tc.exp) {
+				t.Errorf("expected %q to be %q", names, tc.exp)
+			}
+		})
+	}
+}
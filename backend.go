@@ -6,18 +6,30 @@ package gcpkms
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/googleapis/gax-go/v2"
 	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault-plugin-secrets-gcpkms/version"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/helper/useragent"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/patrickmn/go-cache"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 
 	kmsapi "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 const (
@@ -28,26 +40,113 @@ const (
 )
 
 var (
-	// defaultClientLifetime is the amount of time to cache the KMS client. This
-	// has to be less than 60 minutes or the oauth token will expire and
-	// subsequent requests will fail. The reason we cache the client is because
-	// the process for looking up credentials is not performant and the overhead
-	// is too significant for a plugin that will receive this much traffic.
-	defaultClientLifetime = 30 * time.Minute
+	// tokenEarlyExpiry is how long before the oauth token expires that the
+	// client fetches a new one, so calls in flight never carry an expired
+	// token.
+	tokenEarlyExpiry = 5 * time.Minute
+
+	// regionalEndpointFormat is the format of the regional KMS endpoint for a
+	// location and universe domain.
+	regionalEndpointFormat = "%s-cloudkms.%s:443"
+
+	// grpcKeepaliveTime and grpcKeepaliveTimeout are how often the client
+	// pings KMS on an idle connection, and how long it waits for a reply
+	// before closing the connection. This keeps idle connections from being
+	// silently dropped by load balancers and NAT gateways. Google's front ends
+	// reject pings more frequent than about once a minute.
+	grpcKeepaliveTime    = 2 * time.Minute
+	grpcKeepaliveTimeout = 20 * time.Second
+
+	// warmUpTimeout is how long warming up a new client waits for its first
+	// oauth token, so a slow token endpoint does not hold up unmounting.
+	warmUpTimeout = 10 * time.Second
+
+	// defaultKeysCacheTTL is the amount of time to cache crypto key metadata
+	// retrieved from KMS. The cache entry for a crypto key is invalidated when
+	// the key is changed through Vault, so this only bounds how long changes
+	// made outside of Vault take to be observed.
+	defaultKeysCacheTTL = 5 * time.Minute
 )
 
 type backend struct {
 	*framework.Backend
 
-	// keysCache holds a temporal copy of keys retrieved from KMS
+	// keysCache holds a temporal copy of crypto keys retrieved from KMS, keyed
+	// by crypto key ID.
 	keysCache *cache.Cache
 
-	// kmsClient is the actual client for connecting to KMS. It is cached on
-	// the backend for efficiency.
-	kmsClient           *kmsapi.KeyManagementClient
-	kmsClientCreateTime time.Time
-	kmsClientLifetime   time.Duration
-	kmsClientLock       sync.RWMutex
+	// publicKeysCache holds the public keys of crypto key versions retrieved
+	// from KMS, keyed by crypto key version. Public keys never change, so the
+	// entries do not expire, and are used by verify when KMS is unreachable.
+	// Entries are evicted when their versions are trimmed or their key is
+	// removed, and the cache holds at most maxCachedPublicKeys entries.
+	publicKeysCache *cache.Cache
+
+	// kmsClients are the handles to the clients for connecting to KMS, keyed
+	// by endpoint and service account override. They are cached on the backend
+	// for efficiency.
+	// kmsClientLock guards the handles and is only ever held briefly, never
+	// across calls to KMS, so refreshing a client does not wait for in-flight
+	// calls. kmsClientCreateLock serializes creating new clients.
+	// kmsClientGen is incremented by ResetClient, so a client created from
+	// the config read before a reset is not cached after it.
+	kmsClients          map[clientKey]*kmsClientHandle
+	kmsClientLock       sync.Mutex
+	kmsClientCreateLock sync.Mutex
+	kmsClientGen        uint64
+
+	// kmsSettings are the settings for KMS calls which are shared by the
+	// clients for every endpoint. They are read from the config when first
+	// needed after the config changes, and are guarded by kmsClientLock.
+	kmsSettings *kmsSettings
+
+	// configLock serializes writes to the config, so two rotations do not
+	// both replace the same service account key, and a rotation and a config
+	// write do not overwrite each other's changes.
+	configLock sync.Mutex
+
+	// hmacKey is the mount's key for HMACs in responses, cached once read
+	// from storage. It is guarded by hmacKeyLock.
+	hmacKey     []byte
+	hmacKeyLock sync.Mutex
+
+	// health records the outcome of the most recent calls to KMS. Like the
+	// throttle, it outlives the client.
+	health *kmsHealth
+
+	// usage holds the operations on each key counted since usage statistics
+	// were last flushed to storage, keyed by key name. It is guarded by
+	// usageLock.
+	usage     map[string]*keyUsage
+	usageLock sync.Mutex
+
+	// throttle holds back calls to KMS while the project's quota is
+	// exhausted. It outlives the client so the backoff is not lost when the
+	// client is recreated.
+	throttle *quotaThrottle
+
+	// autoTrimLastRun is the last time keys were automatically trimmed, and
+	// autoTrimInterval is the minimum time between automatic trims.
+	autoTrimLastRun  time.Time
+	autoTrimInterval time.Duration
+	autoTrimLock     sync.Mutex
+
+	// driftCheckLastRun is the last time keys were checked for drift from
+	// their expected state.
+	driftCheckLastRun time.Time
+	driftCheckLock    sync.Mutex
+
+	// orphanCheckLastRun is the last time keys were checked for orphaned
+	// crypto keys.
+	orphanCheckLastRun time.Time
+	orphanCheckLock    sync.Mutex
+
+	// keyIndexLock serializes updates to the buckets of the key index.
+	keyIndexLock sync.Mutex
+
+	// keyLocks serialize user writes to each key, so check-and-set is not
+	// raced by a concurrent write.
+	keyLocks []*locksutil.LockEntry
 
 	// pluginEnv contains Vault version information. It is used in user-agent headers.
 	pluginEnv *logical.PluginEnvironment
@@ -58,6 +157,10 @@ type backend struct {
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 	ctxLock   sync.Mutex
+
+	// background tracks goroutines started by the plugin, so clean can wait
+	// for them to stop.
+	background sync.WaitGroup
 }
 
 // Factory returns a configured instance of the backend.
@@ -73,29 +176,75 @@ func Factory(ctx context.Context, c *logical.BackendConfig) (logical.Backend, er
 func Backend() *backend {
 	var b backend
 
-	b.kmsClientLifetime = defaultClientLifetime
+	b.autoTrimInterval = defaultAutoTrimInterval
 	b.ctx, b.ctxCancel = context.WithCancel(context.Background())
-	b.keysCache = cache.New(cache.DefaultExpiration, 60*time.Minute)
+	b.throttle = new(quotaThrottle)
+	b.health = new(kmsHealth)
+	b.kmsClients = make(map[clientKey]*kmsClientHandle)
+	b.usage = make(map[string]*keyUsage)
+	b.keyLocks = locksutil.CreateLocks()
+	b.keysCache = cache.New(defaultKeysCacheTTL, 2*defaultKeysCacheTTL)
+	b.publicKeysCache = cache.New(cache.NoExpiration, 0)
 
 	b.Backend = &framework.Backend{
 		BackendType: logical.TypeLogical,
 		Help: "The GCP KMS secrets engine provides pass-through encryption and " +
 			"decryption to Google Cloud KMS keys.",
 
+		PathsSpecial: &logical.Paths{
+			// Usage statistics are counted by each cluster, so performance
+			// secondaries save their own
+			LocalStorage: []string{usageStoragePrefix},
+		},
+
 		Paths: []*framework.Path{
+			// Must come before pathConfigProfile, which would otherwise match
+			// "rotate-root" and "test" as profile names, and before
+			// pathConfig, which would otherwise match config profiles.
+			b.pathConfigRotateRoot(),
+			b.pathConfigCheck(),
+			b.pathConfigProfiles(),
+			b.pathConfigProfile(),
 			b.pathConfig(),
+			b.pathStatus(),
+			b.pathAlgorithms(),
+
+			b.pathKeyRings(),
+			b.pathKeyRingKeys(),
 
 			b.pathKeys(),
+			// Must come before pathKeysCRUD, which would otherwise match
+			// "alias", "register-all", "rotate-all", "trim", and "orphans" as
+			// key names.
+			b.pathKeysAliases(),
+			b.pathKeysRegisterAll(),
+			b.pathKeysRotateAll(),
+			b.pathKeysTrimAll(),
+			b.pathKeysOrphans(),
 			b.pathKeysCRUD(),
+			b.pathKeysAttestation(),
+			b.pathKeysAttestationVerify(),
+			b.pathKeysIAM(),
+			b.pathKeysPermissions(),
+			b.pathKeysProtectedResources(),
+			b.pathKeysStats(),
+			b.pathKeysDrift(),
+			b.pathKeysVersions(),
 			b.pathKeysConfigCRUD(),
+			b.pathKeysAlias(),
 			b.pathKeysDeregister(),
+			b.pathKeysDeregistered(),
+			b.pathKeysRestore(),
 			b.pathKeysRegister(),
+			b.pathKeysAutokey(),
 			b.pathKeysRotate(),
+			b.pathKeysImport(),
 			b.pathKeysTrim(),
 
 			b.pathDecrypt(),
 			b.pathEncrypt(),
 			b.pathPubkey(),
+			b.pathWrapKey(),
 			b.pathReencrypt(),
 			b.pathSign(),
 			b.pathVerify(),
@@ -104,7 +253,12 @@ func Backend() *backend {
 		InitializeFunc: b.initialize,
 		Invalidate:     b.invalidate,
 		Clean:          b.clean,
+		PeriodicFunc:   b.periodicFunc,
+		WALRollback:    b.walRollback,
+		RunningVersion: version.PluginVersion,
 	}
+	b.instrumentPaths(b.Backend.Paths)
+	b.annotatePaths(b.Backend.Paths)
 
 	return &b
 }
@@ -119,105 +273,779 @@ func (b *backend) initialize(ctx context.Context, _ *logical.InitializationReque
 	return nil
 }
 
-// clean cancels the shared contexts. This is called just before unmounting
+// clean cancels the shared contexts, closes the KMS client, and waits for
+// background work to stop. This is called just before unmounting or reloading
 // the plugin.
 func (b *backend) clean(_ context.Context) {
 	b.ctxLock.Lock()
 	b.ctxCancel()
 	b.ctxLock.Unlock()
+
+	b.ResetClient()
+	b.keysCache.Flush()
+	b.publicKeysCache.Flush()
+	b.background.Wait()
 }
 
 // invalidate resets the plugin. This is called when a key is updated via
-// replication.
+// replication, so performance standbys and secondaries do not keep using a
+// client built from stale credentials or stale crypto key metadata.
 func (b *backend) invalidate(ctx context.Context, key string) {
-	switch key {
-	case "config":
+	switch {
+	case key == "config", strings.HasPrefix(key, "config/"):
 		b.ResetClient()
+	case strings.HasPrefix(key, "keys/"):
+		// The crypto key cache is keyed by crypto key ID, and the key's old
+		// crypto key ID is no longer known, so drop every entry. The public
+		// keys are dropped too, since the key may have been trimmed or
+		// deleted on the active node.
+		b.keysCache.Flush()
+		b.publicKeysCache.Flush()
+	case key == responseHMACKeyPath:
+		// The HMAC key may have been generated on the active node after this
+		// node cached one of its own.
+		b.hmacKeyLock.Lock()
+		b.hmacKey = nil
+		b.hmacKeyLock.Unlock()
 	}
 }
 
-// ResetClient closes any connected clients.
+// clientKey identifies the KMS client for a key. The zero value is the client
+// for the configured endpoint and credentials.
+type clientKey struct {
+	// endpoint overrides the configured API endpoint, and serviceAccount is
+	// impersonated instead of the configured impersonate_service_account.
+	// profile is the name of the config profile applied to the config.
+	endpoint       string
+	serviceAccount string
+	profile        string
+}
+
+// kmsClientHandle is a reference counted KMS client. A handle which has been
+// replaced or reset is retired, and its client is closed once the last caller
+// using it is done.
+type kmsClientHandle struct {
+	client     keyManagementClient
+	createTime time.Time
+	lifetime   time.Duration
+
+	// key is the endpoint and service account override the client was
+	// created for, and is its key in the backend's kmsClients.
+	key clientKey
+
+	// tokenSource supplies the client's oauth tokens. It is reloaded when KMS
+	// rejects the client's credentials.
+	tokenSource *reauthTokenSource
+
+	// refs and retired are guarded by the backend's kmsClientLock.
+	refs    int
+	retired bool
+}
+
+// release drops a reference to the handle, returning true if the client
+// should now be closed. The caller must hold kmsClientLock.
+func (h *kmsClientHandle) release() bool {
+	h.refs--
+	return h.retired && h.refs == 0
+}
+
+// retire marks the handle as replaced, returning true if the client should
+// now be closed. The caller must hold kmsClientLock.
+func (h *kmsClientHandle) retire() bool {
+	h.retired = true
+	return h.refs == 0
+}
+
+// ResetClient closes any connected clients. Clients which are in use are
+// closed once the last caller using them is done.
 func (b *backend) ResetClient() {
+	var closeNow []*kmsClientHandle
+
 	b.kmsClientLock.Lock()
-	b.resetClient()
+	for _, h := range b.kmsClients {
+		if h.retire() {
+			closeNow = append(closeNow, h)
+		}
+	}
+	b.kmsClients = make(map[clientKey]*kmsClientHandle)
+	b.kmsSettings = nil
+	b.kmsClientGen++
 	b.kmsClientLock.Unlock()
+
+	for _, h := range closeNow {
+		h.client.Close()
+	}
 }
 
-// resetClient rests the underlying client. The caller is responsible for
-// acquiring and releasing locks. This method is not safe to call concurrently.
-func (b *backend) resetClient() {
-	if b.kmsClient != nil {
-		b.kmsClient.Close()
-		b.kmsClient = nil
+// retireClient drops the cached client if it is still the client for h, so
+// the next caller creates a new client. The client is closed once the last
+// caller using it is done.
+func (b *backend) retireClient(h *kmsClientHandle) {
+	b.kmsClientLock.Lock()
+	closeNow := false
+	if b.kmsClients[h.key] == h {
+		delete(b.kmsClients, h.key)
+		closeNow = h.retire()
 	}
+	b.kmsClientLock.Unlock()
 
-	b.kmsClientCreateTime = time.Unix(0, 0).UTC()
+	if closeNow {
+		h.client.Close()
+	}
 }
 
-// KMSClient creates a new client for talking to the GCP KMS service.
-func (b *backend) KMSClient(s logical.Storage) (*kmsapi.KeyManagementClient, func(), error) {
-	// If the client already exists and is valid, return it
-	b.kmsClientLock.RLock()
-	if b.kmsClient != nil && time.Now().UTC().Sub(b.kmsClientCreateTime) < b.kmsClientLifetime {
-		closer := func() { b.kmsClientLock.RUnlock() }
-		return b.kmsClient, closer, nil
+// acquireClient returns the cached client handle for the client key with a
+// reference held, or nil if there is no client or it has expired.
+func (b *backend) acquireClient(ck clientKey) *kmsClientHandle {
+	b.kmsClientLock.Lock()
+	defer b.kmsClientLock.Unlock()
+
+	h := b.kmsClients[ck]
+	if h == nil || time.Now().UTC().Sub(h.createTime) >= h.lifetime {
+		return nil
+	}
+	h.refs++
+	return h
+}
+
+// releaseClient returns a function which drops the reference to the handle
+// acquired by acquireClient.
+func (b *backend) releaseClient(h *kmsClientHandle) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.kmsClientLock.Lock()
+			closeNow := h.release()
+			b.kmsClientLock.Unlock()
+
+			if closeNow {
+				h.client.Close()
+			}
+		})
+	}
+}
+
+// KMSClient creates a new client for talking to the GCP KMS service. The
+// context is only used to read the configuration - the client itself is
+// shared between requests, so it is bound to the lifetime of the plugin.
+// Callers should make KMS calls with a context from kmsContext, and must call
+// the returned function once they are done with the client.
+func (b *backend) KMSClient(ctx context.Context, s logical.Storage) (keyManagementClient, func(), error) {
+	return b.keyedKMSClient(ctx, s, clientKey{})
+}
+
+// KeyKMSClient is like KMSClient, but returns a client for the key's API
+// endpoint if the key overrides the configured endpoint, or for the regional
+// endpoint of the key's location if regional endpoints are enabled. If the key
+// has its own service account, the client impersonates it, and if the key uses
+// a config profile, the client is created from the profile.
+func (b *backend) KeyKMSClient(ctx context.Context, s logical.Storage, k *Key) (keyManagementClient, func(), error) {
+	ck := clientKey{
+		endpoint:       k.APIEndpoint,
+		serviceAccount: k.ImpersonateServiceAccount,
+		profile:        k.ConfigName,
+	}
+	if ck.endpoint == "" && ck.profile != "" {
+		p, err := b.ConfigProfile(ctx, s, ck.profile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p == nil {
+			return nil, nil, fmt.Errorf("config profile %q does not exist", ck.profile)
+		}
+		ck.endpoint = p.APIEndpoint
+	}
+	if ck.endpoint == "" {
+		settings, err := b.settings(ctx, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if settings.regionalEndpoints {
+			ck.endpoint = regionalEndpoint(k.CryptoKeyID, settings.universeDomain)
+		}
+	}
+	return b.keyedKMSClient(ctx, s, ck)
+}
+
+// kmsSettings are the settings for KMS calls which are shared by the clients
+// for every endpoint.
+type kmsSettings struct {
+	// requestTimeout bounds the KMS calls made for a single request, and
+	// rateLimits limits the crypto operations on each key.
+	requestTimeout time.Duration
+	rateLimits     *rateLimits
+
+	// regionalEndpoints routes calls for each key to the regional endpoint of
+	// the key's location, in universeDomain.
+	regionalEndpoints bool
+	universeDomain    string
+
+	// annotateRequests and annotateEntityID send the request and entity IDs
+	// with calls to KMS.
+	annotateRequests bool
+	annotateEntityID bool
+
+	// fipsEnforcement only allows keys which may be used in FIPS mode to be
+	// used.
+	fipsEnforcement bool
+}
+
+// newKMSSettings creates the settings from the config.
+func newKMSSettings(c *Config) *kmsSettings {
+	return &kmsSettings{
+		requestTimeout:    c.RequestTimeout,
+		rateLimits:        newRateLimits(c),
+		regionalEndpoints: c.RegionalEndpoints,
+		universeDomain:    c.universeDomain(),
+		annotateRequests:  c.AnnotateRequests,
+		annotateEntityID:  c.AnnotateEntityID,
+		fipsEnforcement:   c.FIPSEnforcement,
+	}
+}
+
+// settings returns the settings for KMS calls, reading them from the config
+// if they have not been read since the config last changed.
+func (b *backend) settings(ctx context.Context, s logical.Storage) (*kmsSettings, error) {
+	b.kmsClientLock.Lock()
+	settings := b.kmsSettings
+	b.kmsClientLock.Unlock()
+
+	if settings != nil {
+		return settings, nil
+	}
+
+	config, err := b.Config(ctx, s)
+	if err != nil {
+		return nil, err
 	}
-	b.kmsClientLock.RUnlock()
 
-	// Acquire a full lock. Since all invocations acquire a read lock and defer
-	// the release of that lock, this will block until all clients are no longer
-	// in use. At that point, we can acquire a globally exclusive lock to close
-	// any connections and create a new client.
 	b.kmsClientLock.Lock()
+	defer b.kmsClientLock.Unlock()
+
+	if b.kmsSettings == nil {
+		b.kmsSettings = newKMSSettings(config)
+	}
+	return b.kmsSettings, nil
+}
+
+// regionalEndpoint returns the regional KMS endpoint for the location of the
+// crypto key in the universe domain, or the empty string if the crypto key is
+// global or its location cannot be parsed.
+func regionalEndpoint(cryptoKeyID, universeDomain string) string {
+	n, err := parseCryptoKeyName(cryptoKeyID)
+	if err != nil || n.Location == "global" {
+		return ""
+	}
+	return fmt.Sprintf(regionalEndpointFormat, n.Location, universeDomain)
+}
+
+// keyedKMSClient returns a client for the endpoint, service account, and
+// config profile in the client key, falling back to the configured ones where
+// they are empty.
+func (b *backend) keyedKMSClient(ctx context.Context, s logical.Storage, ck clientKey) (keyManagementClient, func(), error) {
+	// If the client already exists and is valid, return it
+	if h := b.acquireClient(ck); h != nil {
+		return h.client, b.releaseClient(h), nil
+	}
+
+	// Only one caller creates a client. Others wait here and then use the
+	// client it created. Callers using existing clients are not blocked.
+	b.kmsClientCreateLock.Lock()
+	defer b.kmsClientCreateLock.Unlock()
+
+	if h := b.acquireClient(ck); h != nil {
+		return h.client, b.releaseClient(h), nil
+	}
 
-	b.Logger().Debug("creating new KMS client")
+	b.kmsClientLock.Lock()
+	gen := b.kmsClientGen
+	b.kmsClientLock.Unlock()
 
-	// Attempt to close an existing client if we have one.
-	b.resetClient()
+	b.Logger().Debug("creating new KMS client", "endpoint", ck.endpoint,
+		"service_account", ck.serviceAccount, "profile", ck.profile)
 
 	// Get the config
-	config, err := b.Config(b.ctx, s)
+	config, err := b.Config(ctx, s)
 	if err != nil {
-		b.kmsClientLock.Unlock()
 		return nil, nil, err
 	}
 
-	// If credentials were provided, use those. Otherwise fall back to the
-	// default application credentials.
-	var creds *google.Credentials
-	if config.Credentials != "" {
-		creds, err = google.CredentialsFromJSON(b.ctx, []byte(config.Credentials), config.Scopes...)
-		if err != nil {
-			b.kmsClientLock.Unlock()
-			return nil, nil, errwrap.Wrapf("failed to parse credentials: {{err}}", err)
+	// The client is created from the config with the client key's profile and
+	// service account in place. Settings shared by every client still come
+	// from the config itself.
+	clientConfig, err := b.clientConfig(ctx, s, config, ck)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := newTransport(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	credsCtx := t.context(b.ctx)
+
+	// Clients for an emulator do not use credentials
+	emulatorHost := os.Getenv(kmsEmulatorHostEnv)
+	loadTokenSource := func() (oauth2.TokenSource, error) {
+		if emulatorHost != "" {
+			return emulatorTokenSource, nil
 		}
-	} else {
-		creds, err = google.FindDefaultCredentials(b.ctx, config.Scopes...)
+		creds, err := b.credentials(credsCtx, clientConfig)
 		if err != nil {
-			b.kmsClientLock.Unlock()
-			return nil, nil, errwrap.Wrapf("failed to get default token source: {{err}}", err)
+			return nil, err
 		}
+		return creds.TokenSource, nil
+	}
+	ts, err := loadTokenSource()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := &kmsClientHandle{
+		createTime:  time.Now().UTC(),
+		lifetime:    config.ClientLifetime,
+		key:         ck,
+		refs:        1,
+		tokenSource: newReauthTokenSource(ts, loadTokenSource),
 	}
 
 	// Create and return the KMS client with a custom user agent.
-	client, err := kmsapi.NewKeyManagementClient(b.ctx,
-		option.WithCredentials(creds),
-		option.WithScopes(config.Scopes...),
+	opts := []option.ClientOption{
 		option.WithUserAgent(useragent.PluginString(b.pluginEnv, userAgentPluginName)),
-	)
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(
+			b.reauthInterceptor(h),
+			b.throttle.unaryInterceptor,
+			b.health.unaryInterceptor,
+			metricsInterceptor,
+		)),
+		option.WithGRPCDialOption(grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                grpcKeepaliveTime,
+			Timeout:             grpcKeepaliveTimeout,
+			PermitWithoutStream: true,
+		})),
+	}
+	if emulatorHost != "" {
+		b.Logger().Warn("using the KMS emulator", "host", emulatorHost)
+		opts = append(opts, emulatorClientOptions(emulatorHost)...)
+	} else {
+		// The configured scopes were requested when the token source was
+		// created, and would be ignored here.
+		opts = append(opts, option.WithTokenSource(h.tokenSource))
+
+		endpoint := ck.endpoint
+		if endpoint == "" {
+			endpoint = clientConfig.APIEndpoint
+		}
+		if endpoint != "" {
+			// A brokered access token is only ever sent to Google
+			if clientConfig.usesAccessToken() && !endpointInUniverse(endpoint, config.universeDomain()) {
+				return nil, nil, fmt.Errorf("endpoint %q is not in the %q universe "+
+					"domain and cannot be used with an access token", endpoint, config.universeDomain())
+			}
+			opts = append(opts, option.WithEndpoint(endpoint))
+		}
+		if config.UniverseDomain != "" {
+			opts = append(opts, option.WithUniverseDomain(config.UniverseDomain))
+		}
+		if clientConfig.QuotaProject != "" {
+			opts = append(opts, option.WithQuotaProject(clientConfig.QuotaProject))
+		}
+		if config.GRPCConnPoolSize > 0 {
+			opts = append(opts, option.WithGRPCConnectionPool(config.GRPCConnPoolSize))
+		}
+		opts = append(opts, t.clientOptions()...)
+	}
+
+	client, err := kmsapi.NewKeyManagementClient(b.ctx, opts...)
 	if err != nil {
-		b.kmsClientLock.Unlock()
 		return nil, nil, errwrap.Wrapf("failed to create KMS client: {{err}}", err)
 	}
 
-	// Cache the client
-	b.kmsClient = client
-	b.kmsClientCreateTime = time.Now().UTC()
+	setKMSCallOptions(client.CallOptions, config)
+	b.throttle.setQueueDepth(config.ThrottleQueueDepth)
+	h.client = &gcpKMSClient{client}
+
+	// Warm up the client in the background. The warm up does not hold a
+	// reference, so resetting the client closes it right away, and connecting
+	// a closed client does nothing.
+	b.background.Add(1)
+	go func() {
+		defer b.background.Done()
+		b.warmKMSClient(client, h.tokenSource, config.GRPCConnPoolSize)
+	}()
+
+	// Swap in the new client. The old client is closed once the last caller
+	// using it is done. If the clients were reset while this one was being
+	// created, it may be from a stale config, so it is only used by this
+	// caller and closed once released.
+	b.kmsClientLock.Lock()
+	if b.kmsClientGen != gen {
+		h.retire()
+		b.kmsClientLock.Unlock()
+		return h.client, b.releaseClient(h), nil
+	}
+	old := b.kmsClients[h.key]
+	b.kmsClients[h.key] = h
+	closeOld := old != nil && old.retire()
+	if b.kmsSettings == nil {
+		b.kmsSettings = newKMSSettings(config)
+	}
+	b.kmsClientLock.Unlock()
+
+	if closeOld {
+		old.client.Close()
+	}
+
+	return h.client, b.releaseClient(h), nil
+}
+
+// clientConfig returns a copy of the config with the profile and service
+// account of the client key in place of the configured ones.
+func (b *backend) clientConfig(ctx context.Context, s logical.Storage, config *Config, ck clientKey) (*Config, error) {
+	clientConfig := config
+	if ck.profile != "" {
+		p, err := b.ConfigProfile(ctx, s, ck.profile)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, fmt.Errorf("config profile %q does not exist", ck.profile)
+		}
+		clientConfig = p.apply(config)
+	}
+
+	// A key's own service account is impersonated directly by the base
+	// credentials, not through the configured delegates.
+	if ck.serviceAccount != "" {
+		c := *clientConfig
+		c.ImpersonateServiceAccount = ck.serviceAccount
+		c.Delegates = nil
+		clientConfig = &c
+	}
+	return clientConfig, nil
+}
+
+// apiClientOptions returns the options for creating a client for a Cloud KMS
+// API other than the key management API, with the credentials, quota project,
+// and transport the KMS client for the client key would use.
+func (b *backend) apiClientOptions(ctx context.Context, s logical.Storage, ck clientKey) ([]option.ClientOption, error) {
+	config, err := b.Config(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig, err := b.clientConfig(ctx, s, config, ck)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := b.credentials(t.context(b.ctx), clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []option.ClientOption{
+		option.WithTokenSource(creds.TokenSource),
+		option.WithUserAgent(useragent.PluginString(b.pluginEnv, userAgentPluginName)),
+	}
+	if config.UniverseDomain != "" {
+		opts = append(opts, option.WithUniverseDomain(config.UniverseDomain))
+	}
+	if clientConfig.QuotaProject != "" {
+		opts = append(opts, option.WithQuotaProject(clientConfig.QuotaProject))
+	}
+	return append(opts, t.clientOptions()...), nil
+}
+
+// kmsContext returns a context for making KMS calls on behalf of ctx. It is
+// cancelled when ctx is cancelled, when the configured request timeout passes,
+// or when the plugin is shut down, so in-flight KMS calls do not outlive the
+// request or the plugin. The caller must hold the client returned by
+// KMSClient, which is when the request timeout is loaded from the config.
+func (b *backend) kmsContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var timeout time.Duration
+	b.kmsClientLock.Lock()
+	if b.kmsSettings != nil {
+		timeout = b.kmsSettings.requestTimeout
+	}
 	b.kmsClientLock.Unlock()
 
-	b.kmsClientLock.RLock()
-	closer := func() { b.kmsClientLock.RUnlock() }
-	return client, closer, nil
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	stop := context.AfterFunc(b.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// setKMSCallOptions applies the configured timeouts and retry policy to each
+// of the KMS calls made by the plugin. Calls which create resources are never
+// retried, since a retry after a lost response could create a duplicate.
+func setKMSCallOptions(o *kmsapi.KeyManagementCallOptions, c *Config) {
+	for _, group := range []struct {
+		timeout time.Duration
+		retry   bool
+		calls   []*[]gax.CallOption
+	}{
+		{c.CryptoOperationTimeout, true, []*[]gax.CallOption{
+			&o.AsymmetricDecrypt,
+			&o.AsymmetricSign,
+			&o.Decrypt,
+			&o.Encrypt,
+			&o.GetPublicKey,
+		}},
+		{c.AdminOperationTimeout, true, []*[]gax.CallOption{
+			&o.DestroyCryptoKeyVersion,
+			&o.GetCryptoKey,
+			&o.GetCryptoKeyVersion,
+			&o.GetIamPolicy,
+			&o.GetKeyRing,
+			&o.ListCryptoKeyVersions,
+			&o.ListCryptoKeys,
+			&o.ListKeyRings,
+			&o.ListLocations,
+			&o.TestIamPermissions,
+			&o.UpdateCryptoKey,
+			&o.UpdateCryptoKeyPrimaryVersion,
+			&o.UpdateCryptoKeyVersion,
+		}},
+		{c.AdminOperationTimeout, false, []*[]gax.CallOption{
+			&o.CreateCryptoKey,
+			&o.CreateCryptoKeyVersion,
+			&o.CreateKeyRing,
+			&o.SetIamPolicy,
+		}},
+	} {
+		for _, opts := range group.calls {
+			if group.timeout > 0 {
+				*opts = append(*opts, gax.WithTimeout(group.timeout))
+			}
+			if group.retry && c.RetryMaxAttempts > 0 {
+				*opts = append(*opts, gax.WithRetry(func() gax.Retryer {
+					return newKMSRetryer(c)
+				}))
+			}
+		}
+	}
+}
+
+// kmsRetryer is a gax.Retryer which retries calls failing with one of a set of
+// gRPC codes, up to a maximum number of attempts, with exponential backoff and
+// full jitter.
+type kmsRetryer struct {
+	backoff     gax.Backoff
+	codes       map[grpccodes.Code]struct{}
+	maxAttempts int
+	attempts    int
+}
+
+// newKMSRetryer creates a new retryer for a single call from the config.
+func newKMSRetryer(c *Config) *kmsRetryer {
+	codes := make(map[grpccodes.Code]struct{}, len(c.RetryCodes))
+	for _, name := range c.RetryCodes {
+		if code, ok := retryCodes[name]; ok {
+			codes[code] = struct{}{}
+		}
+	}
+
+	return &kmsRetryer{
+		backoff: gax.Backoff{
+			Initial:    c.RetryInitialBackoff,
+			Max:        c.RetryMaxBackoff,
+			Multiplier: 2,
+		},
+		codes:       codes,
+		maxAttempts: c.RetryMaxAttempts,
+	}
+}
+
+// Retry implements gax.Retryer.
+func (r *kmsRetryer) Retry(err error) (time.Duration, bool) {
+	r.attempts++
+	if r.attempts >= r.maxAttempts {
+		return 0, false
+	}
+
+	s, ok := grpcstatus.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	if _, ok := r.codes[s.Code()]; !ok {
+		return 0, false
+	}
+	return r.backoff.Pause(), true
+}
+
+// cryptoKey returns the crypto key metadata from KMS, using the cached copy if
+// one exists.
+func (b *backend) cryptoKey(ctx context.Context, kmsClient keyManagementClient, cryptoKeyID string) (*kmspb.CryptoKey, error) {
+	if v, ok := b.keysCache.Get(cryptoKeyID); ok {
+		if ck, ok := v.(*kmspb.CryptoKey); ok {
+			return ck, nil
+		}
+	}
+
+	ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: cryptoKeyID,
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to get underlying crypto key: {{err}}", err)
+	}
+
+	b.keysCache.SetDefault(cryptoKeyID, ck)
+	return ck, nil
+}
+
+// cryptoKeyVersion returns the crypto key version metadata from KMS, using the
+// cached copy if one exists. The state of a cached copy may be stale, so only
+// fields which never change, such as the algorithm and protection level,
+// should be read from it.
+func (b *backend) cryptoKeyVersion(ctx context.Context, kmsClient keyManagementClient, cryptoKeyVersion string) (*kmspb.CryptoKeyVersion, error) {
+	if v, ok := b.keysCache.Get(cryptoKeyVersion); ok {
+		if ckv, ok := v.(*kmspb.CryptoKeyVersion); ok {
+			return ckv, nil
+		}
+	}
+
+	ckv, err := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+		Name: cryptoKeyVersion,
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to read crypto key version: {{err}}", err)
+	}
+
+	b.keysCache.SetDefault(cryptoKeyVersion, ckv)
+	return ckv, nil
+}
+
+// invalidateCryptoKey removes the cached copy of the crypto key, if any. This
+// must be called after any change to the crypto key or its versions.
+func (b *backend) invalidateCryptoKey(cryptoKeyID string) {
+	b.keysCache.Delete(cryptoKeyID)
+}
+
+// credentials returns the Google credentials for the given config. If
+// credentials were provided, those are used. Otherwise this falls back to the
+// default application credentials.
+func (b *backend) credentials(ctx context.Context, config *Config) (*google.Credentials, error) {
+	params := google.CredentialsParams{
+		Scopes:         config.Scopes,
+		UniverseDomain: config.UniverseDomain,
+	}
+
+	// When impersonating, the base credentials only call the IAM Credentials
+	// API, and the configured scopes are requested for the impersonated
+	// service account instead.
+	if config.ImpersonateServiceAccount != "" {
+		params.Scopes = []string{cloudPlatformScope}
+	}
+
+	var creds *google.Credentials
+	var err error
+	switch {
+	case config.AccessToken != "" || config.AccessTokenFile != "":
+		// An access token does not say which universe it is for, so it is
+		// trusted to be for the configured one.
+		creds = &google.Credentials{
+			TokenSource: newAccessTokenSource(ctx, config.AccessToken, config.AccessTokenFile),
+			UniverseDomainProvider: func() (string, error) {
+				return config.universeDomain(), nil
+			},
+		}
+	case config.Credentials != "":
+		creds, err = google.CredentialsFromJSONWithParams(ctx, []byte(config.Credentials), params)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to parse credentials: {{err}}", err)
+		}
+	default:
+		creds, err = google.FindDefaultCredentialsWithParams(ctx, params)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to get default token source: {{err}}", err)
+		}
+	}
+
+	// Tokens from one universe are rejected by every other universe, so catch
+	// a mismatch here rather than on the first KMS call.
+	ud, err := creds.GetUniverseDomain()
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to get universe domain of credentials: {{err}}", err)
+	}
+	if exp := config.universeDomain(); ud != exp {
+		return nil, fmt.Errorf("credentials are for universe domain %q, but the configured universe domain is %q", ud, exp)
+	}
+
+	if config.ImpersonateServiceAccount == "" {
+		return creds, nil
+	}
+	if ud != defaultUniverseDomain {
+		return nil, fmt.Errorf("impersonating a service account is only supported in the %q universe domain", defaultUniverseDomain)
+	}
+
+	// The IAM Credentials API is called with an HTTP client built from ctx, so
+	// it goes through the same proxy as the base credentials.
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: config.ImpersonateServiceAccount,
+		Scopes:          config.Scopes,
+		Delegates:       config.Delegates,
+	}, option.WithHTTPClient(oauth2.NewClient(ctx, creds.TokenSource)))
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to impersonate service account: {{err}}", err)
+	}
+	return &google.Credentials{
+		ProjectID:   creds.ProjectID,
+		TokenSource: ts,
+	}, nil
+}
+
+// warmKMSClient fetches the first oauth token and starts connecting to KMS,
+// so the first call made with a new client does not pay for the token
+// exchange and the TLS handshake. It stops waiting for the token after
+// warmUpTimeout or once the plugin is shutting down, so clean never waits on
+// the token endpoint.
+func (b *backend) warmKMSClient(client *kmsapi.KeyManagementClient, ts oauth2.TokenSource, poolSize int) {
+	tokenCh := make(chan error, 1)
+	go func() {
+		_, err := ts.Token()
+		tokenCh <- err
+	}()
+
+	timer := time.NewTimer(warmUpTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-tokenCh:
+		if err != nil {
+			b.Logger().Debug("failed to warm up KMS client token", "error", err)
+		}
+	case <-timer.C:
+		b.Logger().Debug("timed out warming up KMS client token")
+		return
+	case <-b.ctx.Done():
+		return
+	}
+
+	// The connections are handed out round-robin, so this connects each
+	// connection in the pool.
+	for i := 0; i < poolSize || i == 0; i++ {
+		client.Connection().Connect() //nolint:staticcheck
+	}
+}
+
+// refreshingTokenSource returns a token source which caches the token from ts
+// and fetches a new one shortly before it expires. This lets the client live
+// for longer than a single token.
+func refreshingTokenSource(ts oauth2.TokenSource) oauth2.TokenSource {
+	return oauth2.ReuseTokenSourceWithExpiry(nil, ts, tokenEarlyExpiry)
 }
 
 // Config parses and returns the configuration data from the storage backend.
@@ -15,15 +15,17 @@ import (
 	"time"
 
 	"github.com/gammazero/workerpool"
+	"github.com/hashicorp/vault-plugin-secrets-gcpkms/version"
 	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc/connectivity"
 
 	kmsapi "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 	hclog "github.com/hashicorp/go-hclog"
 	uuid "github.com/satori/go.uuid"
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
 )
@@ -64,20 +66,37 @@ func testFieldValidation(tb testing.TB, op logical.Operation, pth string) {
 	}
 }
 
+// testOfflineKMSClient creates a new KMS client which does not authenticate
+// and never reaches KMS, for tests which only manage the client.
+func testOfflineKMSClient(tb testing.TB) *gcpKMSClient {
+	tb.Helper()
+
+	kmsClient, err := kmsapi.NewKeyManagementClient(context.Background(),
+		option.WithoutAuthentication(),
+		option.WithEndpoint("localhost:0"),
+	)
+	if err != nil {
+		tb.Fatalf("failed to create kms client: %s", err)
+	}
+
+	return &gcpKMSClient{kmsClient}
+}
+
 // testKMSClient creates a new KMS client with the default scopes and user
-// agent.
-func testKMSClient(tb testing.TB) *kmsapi.KeyManagementClient {
+// agent, for the fake KMS server unless the tests run against Google Cloud.
+func testKMSClient(tb testing.TB) *gcpKMSClient {
 	tb.Helper()
 
 	ctx := context.Background()
-	kmsClient, err := kmsapi.NewKeyManagementClient(ctx,
+	opts := append([]option.ClientOption{
 		option.WithScopes(defaultScope),
-	)
+	}, testEmulatorKMSClientOptions()...)
+	kmsClient, err := kmsapi.NewKeyManagementClient(ctx, opts...)
 	if err != nil {
 		tb.Fatalf("failed to create kms client: %s", err)
 	}
 
-	return kmsClient
+	return &gcpKMSClient{kmsClient}
 }
 
 // testKMSKeyRingName creates a keyring name. If the given "name" is
@@ -261,13 +280,40 @@ func testCleanupKeyRing(tb testing.TB, keyRing string) {
 	wp.StopWait()
 }
 
+// testCryptoKeyVersionsDestroyed verifies all versions of the crypto key are
+// destroyed or scheduled for destruction.
+func testCryptoKeyVersionsDestroyed(tb testing.TB, cryptoKey string) {
+	tb.Helper()
+
+	kmsClient := testKMSClient(tb)
+
+	ctx := context.Background()
+	it := kmsClient.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
+		Parent: cryptoKey,
+	})
+	for {
+		ckv, err := it.Next()
+		if err != nil {
+			if err != iterator.Done {
+				tb.Fatal(err)
+			}
+			break
+		}
+
+		if ckv.State != kmspb.CryptoKeyVersion_DESTROYED &&
+			ckv.State != kmspb.CryptoKeyVersion_DESTROY_SCHEDULED {
+			tb.Errorf("expected %q to be destroyed, was %s", ckv.Name, ckv.State)
+		}
+	}
+}
+
 func TestBackend_KMSClient(t *testing.T) {
 
 	t.Run("allows_concurrent_reads", func(t *testing.T) {
 
 		b, storage := testBackend(t)
 
-		_, closer1, err := b.KMSClient(storage)
+		_, closer1, err := b.KMSClient(context.Background(), storage)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -275,7 +321,7 @@ func TestBackend_KMSClient(t *testing.T) {
 
 		doneCh := make(chan struct{})
 		go func() {
-			_, closer2, err := b.KMSClient(storage)
+			_, closer2, err := b.KMSClient(context.Background(), storage)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -294,13 +340,13 @@ func TestBackend_KMSClient(t *testing.T) {
 
 		b, storage := testBackend(t)
 
-		client1, closer1, err := b.KMSClient(storage)
+		client1, closer1, err := b.KMSClient(context.Background(), storage)
 		if err != nil {
 			t.Fatal(err)
 		}
 		defer closer1()
 
-		client2, closer2, err := b.KMSClient(storage)
+		client2, closer2, err := b.KMSClient(context.Background(), storage)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -315,17 +361,27 @@ func TestBackend_KMSClient(t *testing.T) {
 	t.Run("expires", func(t *testing.T) {
 
 		b, storage := testBackend(t)
-		b.kmsClientLifetime = 50 * time.Millisecond
 
-		client1, closer1, err := b.KMSClient(storage)
+		if _, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"client_lifetime": 1,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		client1, closer1, err := b.KMSClient(context.Background(), storage)
 		if err != nil {
 			t.Fatal(err)
 		}
 		closer1()
 
-		time.Sleep(100 * time.Millisecond)
+		time.Sleep(1100 * time.Millisecond)
 
-		client2, closer2, err := b.KMSClient(storage)
+		client2, closer2, err := b.KMSClient(context.Background(), storage)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -343,10 +399,11 @@ func TestBackend_ResetClient(t *testing.T) {
 
 		b, storage := testBackend(t)
 
-		client, closer, err := b.KMSClient(storage)
+		kmsClient, closer, err := b.KMSClient(context.Background(), storage)
 		if err != nil {
 			t.Fatal(err)
 		}
+		client := kmsClient.(*gcpKMSClient)
 
 		// Verify the client is "open"
 		if client.Connection().GetState() == connectivity.Shutdown {
@@ -364,6 +421,652 @@ func TestBackend_ResetClient(t *testing.T) {
 			t.Errorf("expected client to be closed, was: %v", state)
 		}
 	})
+
+	t.Run("does_not_wait_for_callers", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		client := testOfflineKMSClient(t)
+		b.kmsClients[clientKey{}] = &kmsClientHandle{
+			client:     client,
+			createTime: time.Now().UTC(),
+			lifetime:   time.Hour,
+		}
+
+		client1, closer, err := b.KMSClient(context.Background(), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client1 != client {
+			t.Fatalf("expected %#v to be %#v", client1, client)
+		}
+
+		doneCh := make(chan struct{})
+		go func() {
+			b.ResetClient()
+			close(doneCh)
+		}()
+
+		select {
+		case <-doneCh:
+		case <-time.After(1 * time.Second):
+			t.Fatal("reset waited for the client to be released")
+		}
+
+		// The client is still in use, so it must not be closed yet
+		if state := client.Connection().GetState(); state == connectivity.Shutdown {
+			t.Fatalf("client was closed while in use")
+		}
+
+		closer()
+
+		if state := client.Connection().GetState(); state != connectivity.Shutdown {
+			t.Errorf("expected client to be closed, was: %v", state)
+		}
+	})
+}
+
+// countingTokenSource returns tokens which expire after ttl, counting how many
+// tokens it has issued.
+type countingTokenSource struct {
+	ttl   time.Duration
+	count int
+}
+
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	s.count++
+	return &oauth2.Token{
+		AccessToken: fmt.Sprintf("token-%d", s.count),
+		Expiry:      time.Now().Add(s.ttl),
+	}, nil
+}
+
+func TestRefreshingTokenSource(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		ttl   time.Duration
+		count int
+	}{
+		{
+			"reuses_valid_token",
+			time.Hour,
+			1,
+		},
+		{
+			"refreshes_expiring_token",
+			tokenEarlyExpiry - time.Second,
+			3,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			src := &countingTokenSource{ttl: tc.ttl}
+			ts := refreshingTokenSource(src)
+
+			for i := 0; i < 3; i++ {
+				if _, err := ts.Token(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if src.count != tc.count {
+				t.Errorf("expected %d to be %d", src.count, tc.count)
+			}
+		})
+	}
+}
+
+func TestBackend_WarmKMSClient(t *testing.T) {
+
+	b, _ := testBackend(t)
+
+	client := testOfflineKMSClient(t)
+	defer client.Close()
+
+	src := &countingTokenSource{ttl: time.Hour}
+	b.warmKMSClient(client.KeyManagementClient, refreshingTokenSource(src), 0)
+
+	if src.count != 1 {
+		t.Errorf("expected %d to be %d", src.count, 1)
+	}
+	// Connecting happens in the background
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn := client.Connection()
+	if state := conn.GetState(); state == connectivity.Idle && !conn.WaitForStateChange(ctx, state) {
+		t.Errorf("expected client to be connecting, was: %v", state)
+	}
+}
+
+func TestBackend_WarmKMSClient_Shutdown(t *testing.T) {
+
+	b, _ := testBackend(t)
+
+	client := testOfflineKMSClient(t)
+	defer client.Close()
+
+	// The token endpoint never answers
+	block := make(chan struct{})
+	defer close(block)
+	src := oauth2.TokenSource(blockingTokenSource(block))
+
+	doneCh := make(chan struct{})
+	go func() {
+		b.warmKMSClient(client.KeyManagementClient, src, 0)
+		close(doneCh)
+	}()
+	b.ctxCancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("warm up waited for the token after shutdown")
+	}
+}
+
+// blockingTokenSource returns a token once the channel is closed.
+type blockingTokenSource chan struct{}
+
+func (s blockingTokenSource) Token() (*oauth2.Token, error) {
+	<-s
+	return &oauth2.Token{AccessToken: "token"}, nil
+}
+
+func TestBackend_KeyKMSClient(t *testing.T) {
+
+	b, storage := testBackend(t)
+	if err := storage.Put(context.Background(), &logical.StorageEntry{
+		Key:   "config/dr",
+		Value: []byte(`{"name":"dr", "api_endpoint":"localhost:9012"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.kmsSettings = &kmsSettings{
+		regionalEndpoints: true,
+		universeDomain:    defaultUniverseDomain,
+	}
+
+	for _, ck := range []clientKey{
+		{},
+		{endpoint: "localhost:9010"},
+		{endpoint: "us-east1-cloudkms.googleapis.com:443"},
+		{serviceAccount: "kms@p.iam.gserviceaccount.com"},
+		{endpoint: "localhost:9012", profile: "dr"},
+	} {
+		b.kmsClients[ck] = &kmsClientHandle{
+			client:     testOfflineKMSClient(t),
+			createTime: time.Now().UTC(),
+			lifetime:   time.Hour,
+			key:        ck,
+		}
+	}
+
+	cases := []struct {
+		name string
+		key  *Key
+		ck   clientKey
+	}{
+		{
+			"global",
+			&Key{
+				CryptoKeyID: "projects/p/locations/global/keyRings/r/cryptoKeys/k",
+			},
+			clientKey{},
+		},
+		{
+			"regional",
+			&Key{
+				CryptoKeyID: "projects/p/locations/us-east1/keyRings/r/cryptoKeys/k",
+			},
+			clientKey{endpoint: "us-east1-cloudkms.googleapis.com:443"},
+		},
+		{
+			"override",
+			&Key{
+				CryptoKeyID: "projects/p/locations/us-east1/keyRings/r/cryptoKeys/k",
+				APIEndpoint: "localhost:9010",
+			},
+			clientKey{endpoint: "localhost:9010"},
+		},
+		{
+			"service_account",
+			&Key{
+				CryptoKeyID:               "projects/p/locations/global/keyRings/r/cryptoKeys/k",
+				ImpersonateServiceAccount: "kms@p.iam.gserviceaccount.com",
+			},
+			clientKey{serviceAccount: "kms@p.iam.gserviceaccount.com"},
+		},
+		{
+			"profile",
+			&Key{
+				CryptoKeyID: "projects/p/locations/us-east1/keyRings/r/cryptoKeys/k",
+				ConfigName:  "dr",
+			},
+			clientKey{endpoint: "localhost:9012", profile: "dr"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			client, closer, err := b.KeyKMSClient(context.Background(), storage, tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closer()
+
+			// Note: not a bug; literally checking object equality
+			if exp := b.kmsClients[tc.ck].client; client != exp {
+				t.Errorf("expected %#v to be %#v", client, exp)
+			}
+		})
+	}
+}
+
+func TestBackend_Credentials(t *testing.T) {
+
+	creds := func(universeDomain string) string {
+		return fmt.Sprintf(`{
+			"type": "service_account",
+			"client_email": "vault@p.iam.gserviceaccount.com",
+			"private_key_id": "1",
+			"token_uri": "https://oauth2.googleapis.com/token",
+			"universe_domain": %q
+		}`, universeDomain)
+	}
+
+	cases := []struct {
+		name string
+		c    *Config
+		err  bool
+	}{
+		{
+			"default_universe",
+			&Config{Credentials: creds(defaultUniverseDomain)},
+			false,
+		},
+		{
+			"configured_universe",
+			&Config{Credentials: creds("example.goog"), UniverseDomain: "example.goog"},
+			false,
+		},
+		{
+			"mismatched_universe",
+			&Config{Credentials: creds("example.goog")},
+			true,
+		},
+		{
+			"access_token",
+			&Config{AccessToken: "ya29.token", UniverseDomain: "example.goog"},
+			false,
+		},
+		{
+			"impersonate_with_universe_domain",
+			&Config{
+				AccessToken:               "ya29.token",
+				UniverseDomain:            "example.goog",
+				ImpersonateServiceAccount: "kms@p.iam.gserviceaccount.com",
+			},
+			true,
+		},
+		{
+			"impersonate",
+			&Config{
+				Credentials:               creds(defaultUniverseDomain),
+				Scopes:                    []string{defaultScope},
+				ImpersonateServiceAccount: "kms@p.iam.gserviceaccount.com",
+			},
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, _ := testBackend(t)
+
+			if _, err := b.credentials(context.Background(), tc.c); (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRegionalEndpoint(t *testing.T) {
+
+	cases := []struct {
+		name           string
+		cryptoKeyID    string
+		universeDomain string
+		exp            string
+	}{
+		{
+			"regional",
+			"projects/p/locations/europe-west1/keyRings/r/cryptoKeys/k",
+			defaultUniverseDomain,
+			"europe-west1-cloudkms.googleapis.com:443",
+		},
+		{
+			"universe_domain",
+			"projects/p/locations/u-west1/keyRings/r/cryptoKeys/k",
+			"example.goog",
+			"u-west1-cloudkms.example.goog:443",
+		},
+		{
+			"global",
+			"projects/p/locations/global/keyRings/r/cryptoKeys/k",
+			defaultUniverseDomain,
+			"",
+		},
+		{
+			"invalid",
+			"not-a-crypto-key",
+			defaultUniverseDomain,
+			"",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			if v := regionalEndpoint(tc.cryptoKeyID, tc.universeDomain); v != tc.exp {
+				t.Errorf("expected %q to be %q", v, tc.exp)
+			}
+		})
+	}
+}
+
+func TestBackend_Invalidate(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		key     string
+		client  bool
+		flushed bool
+		hmacKey bool
+	}{
+		{
+			"config",
+			"config",
+			true,
+			false,
+			false,
+		},
+		{
+			"config_profile",
+			"config/dr",
+			true,
+			false,
+			false,
+		},
+		{
+			"key",
+			"keys/my-key",
+			false,
+			true,
+			false,
+		},
+		{
+			"hmac_key",
+			"hmac_key",
+			false,
+			false,
+			true,
+		},
+		{
+			"other",
+			"aliases/my-alias",
+			false,
+			false,
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, _ := testBackend(t)
+
+			b.kmsClients[clientKey{}] = &kmsClientHandle{
+				createTime: time.Now().UTC(),
+				lifetime:   time.Hour,
+				refs:       1,
+			}
+			b.keysCache.SetDefault("my-crypto-key", &kmspb.CryptoKey{})
+			b.cachePublicKey("my-crypto-key/cryptoKeyVersions/1", &kmspb.PublicKey{},
+				kmspb.CryptoKey_ASYMMETRIC_SIGN)
+			b.hmacKey = []byte("my-hmac-key")
+
+			b.invalidate(context.Background(), tc.key)
+
+			if reset := b.kmsClients[clientKey{}] == nil; reset != tc.client {
+				t.Errorf("expected client reset to be %t", tc.client)
+			}
+			if _, ok := b.keysCache.Get("my-crypto-key"); ok == tc.flushed {
+				t.Errorf("expected cache flushed to be %t", tc.flushed)
+			}
+			if _, ok := b.cachedPublicKey("my-crypto-key/cryptoKeyVersions/1"); ok == tc.flushed {
+				t.Errorf("expected public key cache flushed to be %t", tc.flushed)
+			}
+			if cleared := b.hmacKey == nil; cleared != tc.hmacKey {
+				t.Errorf("expected HMAC key cleared to be %t", tc.hmacKey)
+			}
+		})
+	}
+}
+
+func TestBackend_PluginVersion(t *testing.T) {
+
+	b, _ := testBackend(t)
+
+	var versioner logical.PluginVersioner = b
+	v := versioner.PluginVersion().Version
+	if exp := "v" + version.Version; v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+}
+
+func TestBackend_OperationResponses(t *testing.T) {
+
+	b, _ := testBackend(t)
+
+	for _, p := range b.Paths {
+		for op, h := range p.Operations {
+			if len(h.Properties().Responses) == 0 {
+				t.Errorf("%s operation on %q has no responses", op, p.Pattern)
+			}
+		}
+	}
+}
+
+func TestBackend_Clean(t *testing.T) {
+
+	b, _ := testBackend(t)
+
+	client := testOfflineKMSClient(t)
+	b.kmsClients[clientKey{}] = &kmsClientHandle{
+		client:     client,
+		createTime: time.Now().UTC(),
+		lifetime:   time.Hour,
+	}
+	b.keysCache.SetDefault("my-crypto-key", &kmspb.CryptoKey{})
+	b.cachePublicKey("my-crypto-key/cryptoKeyVersions/1", &kmspb.PublicKey{},
+		kmspb.CryptoKey_ASYMMETRIC_SIGN)
+
+	b.clean(context.Background())
+
+	if err := b.ctx.Err(); err == nil {
+		t.Errorf("expected plugin context to be cancelled")
+	}
+	if state := client.Connection().GetState(); state != connectivity.Shutdown {
+		t.Errorf("expected client to be closed, was: %v", state)
+	}
+	if n := b.keysCache.ItemCount(); n != 0 {
+		t.Errorf("expected %d to be %d", n, 0)
+	}
+	if n := b.publicKeysCache.ItemCount(); n != 0 {
+		t.Errorf("expected %d to be %d", n, 0)
+	}
+}
+
+func TestBackend_KMSContext(t *testing.T) {
+
+	t.Run("request_cancelled", func(t *testing.T) {
+
+		b, _ := testBackend(t)
+
+		reqCtx, reqCancel := context.WithCancel(context.Background())
+		ctx, cancel := b.kmsContext(reqCtx)
+		defer cancel()
+
+		reqCancel()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected context to be cancelled")
+		}
+	})
+
+	t.Run("plugin_shutdown", func(t *testing.T) {
+
+		b, _ := testBackend(t)
+
+		ctx, cancel := b.kmsContext(context.Background())
+		defer cancel()
+
+		b.clean(context.Background())
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected context to be cancelled")
+		}
+	})
+}
+
+func TestKMSRetryer(t *testing.T) {
+
+	c := DefaultConfig()
+	c.RetryMaxAttempts = 3
+
+	cases := []struct {
+		name    string
+		err     error
+		retries int
+	}{
+		{
+			"retryable",
+			grpcstatus.Error(grpccodes.Unavailable, "unavailable"),
+			2,
+		},
+		{
+			"not_retryable",
+			grpcstatus.Error(grpccodes.NotFound, "not found"),
+			0,
+		},
+		{
+			"not_grpc",
+			errors.New("boom"),
+			0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			r := newKMSRetryer(c)
+
+			retries := 0
+			for {
+				pause, ok := r.Retry(tc.err)
+				if !ok {
+					break
+				}
+				if pause > c.RetryMaxBackoff {
+					t.Errorf("expected %s to be at most %s", pause, c.RetryMaxBackoff)
+				}
+				retries++
+			}
+
+			if retries != tc.retries {
+				t.Errorf("expected %d to be %d", retries, tc.retries)
+			}
+		})
+	}
+}
+
+func TestBackend_CryptoKey(t *testing.T) {
+
+	t.Run("cached", func(t *testing.T) {
+
+		b, _ := testBackend(t)
+
+		cryptoKeyID := "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+		exp := &kmspb.CryptoKey{
+			Name:    cryptoKeyID,
+			Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+		}
+		b.keysCache.SetDefault(cryptoKeyID, exp)
+
+		// A cached crypto key does not need a client
+		ck, err := b.cryptoKey(context.Background(), nil, cryptoKeyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ck != exp {
+			t.Errorf("expected %#v to be %#v", ck, exp)
+		}
+
+		b.invalidateCryptoKey(cryptoKeyID)
+		if _, ok := b.keysCache.Get(cryptoKeyID); ok {
+			t.Errorf("expected %q to be removed from the cache", cryptoKeyID)
+		}
+	})
+}
+
+func TestBackend_CryptoKeyVersion(t *testing.T) {
+
+	b, _ := testBackend(t)
+	f := testFakeKMSClient(t, b)
+	cryptoKey := testFakeCryptoKey(t, f, kmspb.CryptoKey_ASYMMETRIC_DECRYPT,
+		kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256)
+	name := cryptoKey + "/cryptoKeyVersions/1"
+
+	ctx := context.Background()
+	ckv, err := b.cryptoKeyVersion(ctx, f, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := ckv.Algorithm, kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256; v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+
+	// The second lookup is served from the cache and does not need a client
+	cached, err := b.cryptoKeyVersion(ctx, nil, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != ckv {
+		t.Errorf("expected %#v to be %#v", cached, ckv)
+	}
 }
 
 func TestBackend_Config(t *testing.T) {
@@ -384,8 +1087,18 @@ func TestBackend_Config(t *testing.T) {
 			"saved",
 			[]byte(`{"credentials":"foo", "scopes":["bar"]}`),
 			&Config{
-				Credentials: "foo",
-				Scopes:      []string{"bar"},
+				Credentials:            "foo",
+				Scopes:                 []string{"bar"},
+				ClientLifetime:         defaultClientLifetime,
+				CryptoOperationTimeout: defaultCryptoOperationTimeout,
+				AdminOperationTimeout:  defaultAdminOperationTimeout,
+				RetryMaxAttempts:       defaultRetryMaxAttempts,
+				RetryInitialBackoff:    defaultRetryInitialBackoff,
+				RetryMaxBackoff:        defaultRetryMaxBackoff,
+				RetryCodes:             defaultRetryCodes,
+				ThrottleQueueDepth:     defaultThrottleQueueDepth,
+				ResponseWrapping:       responseWrappingNone,
+				ResponseWrapTTL:        defaultResponseWrapTTL,
 			},
 			false,
 		},
@@ -8,16 +8,22 @@ import (
 
 	hclog "github.com/hashicorp/go-hclog"
 	gcpkms "github.com/hashicorp/vault-plugin-secrets-gcpkms"
+	"github.com/hashicorp/vault-plugin-secrets-gcpkms/version"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/plugin"
 )
 
 func main() {
-	logger := hclog.New(&hclog.LoggerOptions{})
+	// Vault reads the plugin's stderr as JSON-formatted logs
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:       version.Name,
+		Output:     os.Stderr,
+		JSONFormat: true,
+	})
 
 	defer func() {
 		if r := recover(); r != nil {
-			logger.Error("plugin paniced", "error", r)
+			logger.Error("plugin panicked", "error", r)
 			os.Exit(1)
 		}
 	}()
@@ -25,18 +31,23 @@ func main() {
 	meta := &api.PluginAPIClientMeta{}
 
 	flags := meta.FlagSet()
-	flags.Parse(os.Args[1:])
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		logger.Error("failed to parse plugin flags", "error", err)
+		os.Exit(1)
+	}
 
+	// Vault negotiates mTLS with the plugin automatically when it supports
+	// AutoMTLS. Older versions of Vault instead provide a wrapping token which
+	// the TLS provider unwraps to fetch the plugin's certificate.
 	tlsConfig := meta.GetTLSConfig()
 	tlsProviderFunc := api.VaultPluginTLSProvider(tlsConfig)
 
 	if err := plugin.ServeMultiplex(&plugin.ServeOpts{
 		BackendFactoryFunc: gcpkms.Factory,
-		// set the TLSProviderFunc so that the plugin maintains backwards
-		// compatibility with Vault versions that don’t support plugin AutoMTLS
-		TLSProviderFunc: tlsProviderFunc,
+		TLSProviderFunc:    tlsProviderFunc,
+		Logger:             logger,
 	}); err != nil {
-		logger.Error("plugin shutting down", "error", err)
+		logger.Error("plugin shutting down", "error", err, "version", version.HumanVersion)
 		os.Exit(1)
 	}
 }
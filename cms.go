@@ -0,0 +1,216 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/hashicorp/errwrap"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSASSAPSS     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+)
+
+// cmsContentInfo is the CMS ContentInfo of RFC 5652 section 3.
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// cmsSignedData is the CMS SignedData of RFC 5652 section 5.1.
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+// cmsEncapContentInfo is the CMS EncapsulatedContentInfo. The content is
+// omitted because the signature is detached.
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+}
+
+// cmsSignerInfo is the CMS SignerInfo of RFC 5652 section 5.3, without signed
+// attributes, so the signature is over the content digest itself.
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+// cmsIssuerAndSerialNumber identifies the signer by its certificate.
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// rsaPSSParams are the RSASSA-PSS-params of RFC 4055 section 3.1.
+type rsaPSSParams struct {
+	Hash       pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF        pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength int                      `asn1:"explicit,tag:2"`
+}
+
+// cmsAlgorithms returns the CMS digest and signature algorithm identifiers of
+// the crypto key version algorithm.
+func cmsAlgorithms(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) (pkix.AlgorithmIdentifier, pkix.AlgorithmIdentifier, error) {
+	sha256 := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+	sha384 := pkix.AlgorithmIdentifier{Algorithm: oidSHA384}
+
+	switch a {
+	case kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256:
+		return sha256, pkix.AlgorithmIdentifier{
+			Algorithm:  oidRSAEncryption,
+			Parameters: asn1.NullRawValue,
+		}, nil
+	case kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:
+		// KMS uses a salt the length of the digest
+		hash := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+		mgfHash, err := asn1.Marshal(hash)
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, pkix.AlgorithmIdentifier{}, err
+		}
+		params, err := asn1.Marshal(rsaPSSParams{
+			Hash:       hash,
+			MGF:        pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfHash}},
+			SaltLength: 32,
+		})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, pkix.AlgorithmIdentifier{}, err
+		}
+		return sha256, pkix.AlgorithmIdentifier{
+			Algorithm:  oidRSASSAPSS,
+			Parameters: asn1.RawValue{FullBytes: params},
+		}, nil
+	case kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:
+		return sha256, pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	case kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:
+		return sha384, pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA384}, nil
+	}
+	return pkix.AlgorithmIdentifier{}, pkix.AlgorithmIdentifier{}, fmt.Errorf(
+		"algorithm %q is not supported for CMS signatures", algorithmToString(a))
+}
+
+// parseSignerCertificate parses the PEM-encoded signer certificate of a key.
+func parseSignerCertificate(p string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(p))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("signer_certificate is not a PEM-encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to parse signer_certificate: {{err}}", err)
+	}
+	return cert, nil
+}
+
+// detachedCMSSignature wraps the signature of a content digest into a detached
+// CMS SignedData. The signer is identified by the certificate, which must be
+// for the DER-encoded public key, or by the subject key identifier of the
+// public key if cert is nil.
+func detachedCMSSignature(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm, signature, publicKey []byte, cert *x509.Certificate) ([]byte, error) {
+	digestAlg, sigAlg, err := cmsAlgorithms(a)
+	if err != nil {
+		return nil, err
+	}
+
+	si := cmsSignerInfo{
+		DigestAlgorithm:    digestAlg,
+		SignatureAlgorithm: sigAlg,
+		Signature:          signature,
+	}
+	sd := cmsSignedData{
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: cmsEncapContentInfo{EContentType: oidData},
+	}
+
+	if cert != nil {
+		if !bytes.Equal(cert.RawSubjectPublicKeyInfo, publicKey) {
+			return nil, fmt.Errorf("signer_certificate is not for the public key " +
+				"of the signing crypto key version")
+		}
+		sid, err := asn1.Marshal(cmsIssuerAndSerialNumber{
+			Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+			SerialNumber: cert.SerialNumber,
+		})
+		if err != nil {
+			return nil, err
+		}
+		si.Version = 1
+		si.SID = asn1.RawValue{FullBytes: sid}
+		sd.Version = 1
+		sd.Certificates = asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      cert.Raw,
+		}
+	} else {
+		ski, err := subjectKeyID(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		si.Version = 3
+		si.SID = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ski}
+		sd.Version = 3
+	}
+	sd.SignerInfos = []cmsSignerInfo{si}
+
+	content, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to encode CMS signed data: {{err}}", err)
+	}
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      content,
+		},
+	})
+}
+
+// subjectKeyID returns the subject key identifier of the DER-encoded public
+// key, the SHA-1 digest of its subjectPublicKey as in RFC 5280 section 4.2.1.2.
+func subjectKeyID(publicKey []byte) ([]byte, error) {
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(publicKey, &spki); err != nil {
+		return nil, errwrap.Wrapf("failed to parse public key: {{err}}", err)
+	}
+	sum := sha1.Sum(spki.SubjectPublicKey.Bytes)
+	return sum[:], nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestDetachedCMSSignature(t *testing.T) {
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("hello world"))
+	signature, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := parseSignerCertificate(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		cert    *x509.Certificate
+		version int
+	}{
+		{"certificate", cert, 1},
+		{"subject_key_id", nil, 3},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, err := detachedCMSSignature(kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256, signature, publicKey, tc.cert)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ci cmsContentInfo
+			if _, err := asn1.Unmarshal(b, &ci); err != nil {
+				t.Fatal(err)
+			}
+			if !ci.ContentType.Equal(oidSignedData) {
+				t.Errorf("expected %v to be %v", ci.ContentType, oidSignedData)
+			}
+
+			var sd cmsSignedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+				t.Fatal(err)
+			}
+			if v, exp := sd.Version, tc.version; v != exp {
+				t.Errorf("expected %d to be %d", v, exp)
+			}
+			if v, exp := len(sd.SignerInfos), 1; v != exp {
+				t.Fatalf("expected %d to be %d", v, exp)
+			}
+			if tc.cert != nil && !bytes.Equal(sd.Certificates.Bytes, tc.cert.Raw) {
+				t.Error("expected the signer certificate")
+			}
+
+			si := sd.SignerInfos[0]
+			if !si.SignatureAlgorithm.Algorithm.Equal(oidECDSAWithSHA256) {
+				t.Errorf("expected %v to be %v", si.SignatureAlgorithm.Algorithm, oidECDSAWithSHA256)
+			}
+			if !ecdsa.VerifyASN1(&priv.PublicKey, digest[:], si.Signature) {
+				t.Error("expected signature to verify")
+			}
+		})
+	}
+
+	t.Run("certificate_mismatch", func(t *testing.T) {
+
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherKey, err := x509.MarshalPKIXPublicKey(&other.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := detachedCMSSignature(kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256, signature, otherKey, cert); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("unsupported_algorithm", func(t *testing.T) {
+
+		if _, err := detachedCMSSignature(kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256, signature, publicKey, nil); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestParseSignerCertificate(t *testing.T) {
+
+	if _, err := parseSignerCertificate("not a certificate"); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := parseSignerCertificate("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -4,26 +4,258 @@
 package gcpkms
 
 import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
 	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
+
+	grpccodes "google.golang.org/grpc/codes"
 )
 
 const (
 	defaultScope = "https://www.googleapis.com/auth/cloudkms"
+
+	// cloudPlatformScope is the scope requested for credentials which call
+	// Google Cloud APIs other than KMS, such as to impersonate a service
+	// account or to rotate the credentials.
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	// minRotationPeriod is the shortest period at which the service account
+	// key in the config may be rotated. Service accounts hold at most ten
+	// keys, so rotating too often risks running out if deletes fail.
+	minRotationPeriod = time.Hour
+
+	// defaultUniverseDomain is the domain of the public Google Cloud universe.
+	defaultUniverseDomain = "googleapis.com"
+
+	// defaultClientLifetime is the amount of time to cache the KMS client. The
+	// client refreshes its own oauth token, so this is only a fallback which
+	// picks up changes to the default credentials. Recreating the client tears
+	// down its connections, so it is done rarely.
+	defaultClientLifetime = 24 * time.Hour
+
+	// defaultCryptoOperationTimeout and defaultAdminOperationTimeout are the
+	// default maximum amount of time a single call to KMS may take, including
+	// retries, before it is cancelled.
+	defaultCryptoOperationTimeout = 30 * time.Second
+	defaultAdminOperationTimeout  = 60 * time.Second
+
+	// defaultRetryMaxAttempts, defaultRetryInitialBackoff, and
+	// defaultRetryMaxBackoff are the default retry policy for KMS calls.
+	defaultRetryMaxAttempts    = 5
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+
+	// defaultThrottleQueueDepth is the default maximum number of calls to KMS
+	// which wait while backing off from an exhausted quota.
+	defaultThrottleQueueDepth = 100
 )
 
+// defaultRetryCodes is the default list of gRPC codes on which KMS calls are
+// retried.
+var defaultRetryCodes = []string{"aborted", "resource_exhausted", "unavailable"}
+
+// retryCodes is the list of gRPC codes on which KMS calls may be retried.
+var retryCodes = map[string]grpccodes.Code{
+	"aborted":            grpccodes.Aborted,
+	"deadline_exceeded":  grpccodes.DeadlineExceeded,
+	"internal":           grpccodes.Internal,
+	"resource_exhausted": grpccodes.ResourceExhausted,
+	"unavailable":        grpccodes.Unavailable,
+	"unknown":            grpccodes.Unknown,
+}
+
+// retryCodeNames returns the list of gRPC codes on which KMS calls may be
+// retried.
+func retryCodeNames() []string {
+	list := make([]string, 0, len(retryCodes))
+	for k := range retryCodes {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
 // Config is the stored configuration.
 type Config struct {
 	Credentials string   `json:"credentials"`
 	Scopes      []string `json:"scopes"`
+
+	// AccessToken is an OAuth access token brokered outside of Vault, and
+	// AccessTokenFile is the path of a file holding one. Either is used instead
+	// of Credentials.
+	AccessToken     string `json:"access_token"`
+	AccessTokenFile string `json:"access_token_file"`
+
+	// ImpersonateServiceAccount is the email of the service account which the
+	// credentials impersonate to call KMS, through the ordered chain of
+	// Delegates. Empty means the credentials call KMS directly.
+	ImpersonateServiceAccount string   `json:"impersonate_service_account"`
+	Delegates                 []string `json:"delegates"`
+
+	// APIEndpoint is the host and port of the KMS API. Empty means the default
+	// Google Cloud KMS endpoint is used.
+	APIEndpoint string `json:"api_endpoint"`
+
+	// RegionalEndpoints routes the calls for each key to the regional
+	// endpoint of the key's location, instead of to APIEndpoint.
+	RegionalEndpoints bool `json:"regional_endpoints"`
+
+	// AnnotateRequests sends the Vault request ID with each call to KMS as
+	// the request reason, which Cloud Audit Logs records, and
+	// AnnotateEntityID also sends the ID of the entity making the request.
+	AnnotateRequests bool `json:"annotate_requests"`
+	AnnotateEntityID bool `json:"annotate_entity_id"`
+
+	// ProxyURL is the URL of an HTTP or HTTPS proxy through which KMS and the
+	// token endpoints are reached. CACertificate is a PEM-encoded bundle of CA
+	// certificates trusted when connecting to them. Empty means there is no
+	// proxy and the system roots are trusted.
+	ProxyURL      string `json:"proxy_url"`
+	CACertificate string `json:"ca_certificate"`
+
+	// UniverseDomain is the domain of the Google Cloud universe in which KMS
+	// is reached, for Trusted Partner Cloud and sovereign cloud deployments.
+	// Empty means the default "googleapis.com" universe is used.
+	UniverseDomain string `json:"universe_domain"`
+
+	// QuotaProject is the project which KMS calls are billed and counted
+	// against. Empty means the project of the credentials is used.
+	QuotaProject string `json:"quota_project"`
+
+	// ClientLifetime is how long the KMS client is cached before it is
+	// recreated. GRPCConnPoolSize is the number of gRPC connections the client
+	// spreads calls over. Zero means the client library's default pool size
+	// is used.
+	ClientLifetime   time.Duration `json:"client_lifetime"`
+	GRPCConnPoolSize int           `json:"grpc_conn_pool_size"`
+
+	// RequestTimeout bounds all the KMS calls made for a single request. Zero
+	// means there is no overall bound.
+	RequestTimeout time.Duration `json:"request_timeout"`
+
+	// CryptoOperationTimeout bounds each encrypt, decrypt, sign, and public
+	// key call, and AdminOperationTimeout bounds every other KMS call. Zero
+	// means the client library's default timeout is used.
+	CryptoOperationTimeout time.Duration `json:"crypto_operation_timeout"`
+	AdminOperationTimeout  time.Duration `json:"admin_operation_timeout"`
+
+	// RetryMaxAttempts is the maximum number of attempts of each KMS call,
+	// including the first. Zero means the client library's default retry
+	// policy is used. Calls are retried with exponential backoff and full
+	// jitter between RetryInitialBackoff and RetryMaxBackoff when they fail
+	// with one of RetryCodes.
+	RetryMaxAttempts    int           `json:"retry_max_attempts"`
+	RetryInitialBackoff time.Duration `json:"retry_initial_backoff"`
+	RetryMaxBackoff     time.Duration `json:"retry_max_backoff"`
+	RetryCodes          []string      `json:"retry_codes"`
+
+	// RateLimit and KeyRateLimit are the maximum number of crypto operations
+	// per second across all keys and on each key. KeyMaxConcurrency is the
+	// maximum number of concurrent crypto operations on each key. Zero means
+	// there is no limit.
+	RateLimit         float64 `json:"rate_limit"`
+	KeyRateLimit      float64 `json:"key_rate_limit"`
+	KeyMaxConcurrency int     `json:"key_max_concurrency"`
+
+	// ThrottleQueueDepth is the maximum number of calls to KMS which wait
+	// while backing off from an exhausted quota. Calls beyond the depth fail
+	// immediately.
+	ThrottleQueueDepth int `json:"throttle_queue_depth"`
+
+	// RotationPeriod is the period at which the service account key in
+	// Credentials is rotated, and LastRotated is when it was last rotated.
+	// Zero means the key is not rotated automatically.
+	RotationPeriod time.Duration `json:"rotation_period"`
+	LastRotated    time.Time     `json:"last_rotated"`
+
+	// ResponseWrapping is the policy for responses which return plaintext,
+	// for keys which do not set their own, and ResponseWrapTTL is the TTL of
+	// the wrapping token when responses are wrapped by force.
+	ResponseWrapping string        `json:"response_wrapping"`
+	ResponseWrapTTL  time.Duration `json:"response_wrap_ttl"`
+
+	// IncludeHMAC adds HMACs of the plaintext and ciphertext, keyed per
+	// mount, to the responses of encrypt, decrypt, and reencrypt.
+	IncludeHMAC bool `json:"include_hmac"`
+
+	// MetricsKeyLabels labels request metrics with the key name.
+	MetricsKeyLabels bool `json:"metrics_key_labels"`
+
+	// ForwardUsage forwards operations which count usage from performance
+	// standbys to the active node, so the usage of every operation is saved.
+	ForwardUsage bool `json:"forward_usage"`
+
+	// AllowedLocations restricts the locations in which keys may be created
+	// or registered, such as for data residency. Empty means keys may be in
+	// any location.
+	AllowedLocations []string `json:"allowed_locations"`
+
+	// AllowedProjects restricts the projects in which keys may be created or
+	// registered. Empty means keys may be in any project.
+	AllowedProjects []string `json:"allowed_projects"`
+
+	// AllowedProtectionLevels and AllowedAlgorithms restrict the protection
+	// levels and algorithms of keys which may be created or registered. Empty
+	// means any protection level or algorithm is allowed.
+	AllowedProtectionLevels []string `json:"allowed_protection_levels"`
+	AllowedAlgorithms       []string `json:"allowed_algorithms"`
+
+	// FIPSEnforcement only allows keys which use the HSM protection level and
+	// a FIPS-approved algorithm to be created, registered, or used.
+	FIPSEnforcement bool `json:"fips_enforcement"`
+
+	// CryptoKeyNameRegex and KeyRingNameRegex are regular expressions which
+	// the names of crypto keys and key rings must match when keys are
+	// created. CryptoKeyNameTemplate generates the name of the crypto key
+	// when none is given. Empty means names are not restricted and default to
+	// the name of the key.
+	CryptoKeyNameRegex    string `json:"crypto_key_name_regex"`
+	KeyRingNameRegex      string `json:"key_ring_name_regex"`
+	CryptoKeyNameTemplate string `json:"crypto_key_name_template"`
+
+	// DriftCheckInterval is the period at which keys are checked for changes
+	// made to their crypto keys outside of Vault. Zero means keys are only
+	// checked on request.
+	DriftCheckInterval time.Duration `json:"drift_check_interval"`
+
+	// OrphanCheckInterval is the period at which keys are checked for crypto
+	// keys which no longer exist. Zero means keys are not checked.
+	// MountLabel is a "key=value" label applied to crypto keys created by
+	// this mount, so the check can also find those with no key in Vault.
+	OrphanCheckInterval time.Duration `json:"orphan_check_interval"`
+	MountLabel          string        `json:"mount_label"`
+
+	// AttestationGoogleRoots and AttestationManufacturerRoots are PEM-encoded
+	// root certificates trusted to verify HSM attestations, in addition to
+	// those built into the plugin.
+	AttestationGoogleRoots       string `json:"attestation_google_roots"`
+	AttestationManufacturerRoots string `json:"attestation_manufacturer_roots"`
 }
 
 // DefaultConfig returns a config with the default values.
 func DefaultConfig() *Config {
 	return &Config{
-		Scopes: []string{defaultScope},
+		Scopes:                 []string{defaultScope},
+		ClientLifetime:         defaultClientLifetime,
+		CryptoOperationTimeout: defaultCryptoOperationTimeout,
+		AdminOperationTimeout:  defaultAdminOperationTimeout,
+		RetryMaxAttempts:       defaultRetryMaxAttempts,
+		RetryInitialBackoff:    defaultRetryInitialBackoff,
+		RetryMaxBackoff:        defaultRetryMaxBackoff,
+		RetryCodes:             defaultRetryCodes,
+		ThrottleQueueDepth:     defaultThrottleQueueDepth,
+		ResponseWrapping:       responseWrappingNone,
+		ResponseWrapTTL:        defaultResponseWrapTTL,
 	}
 }
 
@@ -43,6 +275,37 @@ func (c *Config) Update(d *framework.FieldData) (bool, error) {
 		}
 	}
 
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"access_token", &c.AccessToken},
+		{"access_token_file", &c.AccessTokenFile},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv := strings.TrimSpace(v.(string))
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	sources := 0
+	for _, v := range []string{c.Credentials, c.AccessToken, c.AccessTokenFile} {
+		if v != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return false, fmt.Errorf("only one of credentials, access_token, and access_token_file can be set")
+	}
+	if _, ok := d.GetOk("access_token_file"); ok && c.AccessTokenFile != "" {
+		if err := checkAccessTokenPath(c.AccessTokenFile); err != nil {
+			return false, err
+		}
+	}
+
 	if v, ok := d.GetOk("scopes"); ok {
 		nv := strutil.RemoveDuplicates(v.([]string), true)
 		if !strutil.EquivalentSlices(nv, c.Scopes) {
@@ -51,5 +314,560 @@ func (c *Config) Update(d *framework.FieldData) (bool, error) {
 		}
 	}
 
+	// The scopes of a brokered access token are chosen by the broker, so
+	// other scopes would be silently ignored.
+	if c.usesAccessToken() && len(c.Scopes) > 0 && !strutil.EquivalentSlices(c.Scopes, []string{defaultScope}) {
+		return false, fmt.Errorf("scopes cannot be set when access_token or " +
+			"access_token_file is set, the scopes of the token are chosen when it is issued")
+	}
+
+	if v, ok := d.GetOk("impersonate_service_account"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if nv != c.ImpersonateServiceAccount {
+			c.ImpersonateServiceAccount = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("delegates"); ok {
+		// The order of the chain matters, so this is not deduplicated or
+		// compared as a set.
+		nv := strutil.RemoveEmpty(strutil.TrimStrings(v.([]string)))
+		if !slices.Equal(nv, c.Delegates) {
+			c.Delegates = nv
+			changed = true
+		}
+	}
+
+	if len(c.Delegates) > 0 && c.ImpersonateServiceAccount == "" {
+		return false, fmt.Errorf("delegates requires impersonate_service_account")
+	}
+
+	if v, ok := d.GetOk("api_endpoint"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if nv != c.APIEndpoint {
+			c.APIEndpoint = nv
+			changed = true
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *bool
+	}{
+		{"annotate_requests", &c.AnnotateRequests},
+		{"annotate_entity_id", &c.AnnotateEntityID},
+		{"fips_enforcement", &c.FIPSEnforcement},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv := v.(bool)
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	if v, ok := d.GetOk("regional_endpoints"); ok {
+		nv := v.(bool)
+		if nv != c.RegionalEndpoints {
+			c.RegionalEndpoints = nv
+			changed = true
+		}
+	}
+
+	if c.APIEndpoint != "" && c.RegionalEndpoints {
+		return false, fmt.Errorf("api_endpoint and regional_endpoints cannot both be set")
+	}
+
+	if v, ok := d.GetOk("proxy_url"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if nv != "" {
+			if _, err := parseProxyURL(nv); err != nil {
+				return false, err
+			}
+		}
+		if nv != c.ProxyURL {
+			c.ProxyURL = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("ca_certificate"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if nv != "" {
+			if _, err := parseCACertificate(nv); err != nil {
+				return false, err
+			}
+		}
+		if nv != c.CACertificate {
+			c.CACertificate = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("universe_domain"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if nv == defaultUniverseDomain {
+			nv = ""
+		}
+		if nv != c.UniverseDomain {
+			c.UniverseDomain = nv
+			changed = true
+		}
+	}
+
+	if c.ImpersonateServiceAccount != "" && c.universeDomain() != defaultUniverseDomain {
+		return false, fmt.Errorf("impersonate_service_account is only supported in the %q universe domain", defaultUniverseDomain)
+	}
+
+	// A brokered access token is only ever sent to Google
+	if c.usesAccessToken() && c.APIEndpoint != "" && !endpointInUniverse(c.APIEndpoint, c.universeDomain()) {
+		return false, fmt.Errorf("api_endpoint must be a host in the %q universe "+
+			"domain when access_token or access_token_file is set", c.universeDomain())
+	}
+
+	if v, ok := d.GetOk("quota_project"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if nv != c.QuotaProject {
+			c.QuotaProject = nv
+			changed = true
+		}
+	}
+
+	v, ok, err := d.GetOkErr("client_lifetime")
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		nv := time.Duration(v.(int)) * time.Second
+		if nv <= 0 {
+			return false, fmt.Errorf("client_lifetime must be positive")
+		}
+		if nv != c.ClientLifetime {
+			c.ClientLifetime = nv
+			changed = true
+		}
+	}
+
+	v, ok, err = d.GetOkErr("rotation_period")
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		nv := time.Duration(v.(int)) * time.Second
+		if nv < 0 || (nv > 0 && nv < minRotationPeriod) {
+			return false, fmt.Errorf("rotation_period must be 0 or at least %s", minRotationPeriod)
+		}
+		if nv != c.RotationPeriod {
+			c.RotationPeriod = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("allowed_projects"); ok {
+		nv := strutil.RemoveDuplicates(strutil.RemoveEmpty(strutil.TrimStrings(v.([]string))), false)
+		for _, p := range nv {
+			if !projectIDRegex.MatchString(p) {
+				return false, fmt.Errorf("invalid project ID %q in allowed_projects", p)
+			}
+		}
+		if !strutil.EquivalentSlices(nv, c.AllowedProjects) {
+			c.AllowedProjects = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("allowed_locations"); ok {
+		nv := strutil.RemoveDuplicates(strutil.RemoveEmpty(strutil.TrimStrings(v.([]string))), true)
+		for _, l := range nv {
+			if !locationIDRegex.MatchString(l) {
+				return false, fmt.Errorf("invalid location %q in allowed_locations", l)
+			}
+		}
+		if !strutil.EquivalentSlices(nv, c.AllowedLocations) {
+			c.AllowedLocations = nv
+			changed = true
+		}
+	}
+
+	// Protection levels and algorithms may be given by their Vault or Cloud
+	// KMS names, and are stored by the names the key policy compares.
+	for _, f := range []struct {
+		name  string
+		value *[]string
+		parse func(string) (string, bool)
+	}{
+		{"allowed_protection_levels", &c.AllowedProtectionLevels, parsePolicyProtectionLevel},
+		{"allowed_algorithms", &c.AllowedAlgorithms, parsePolicyAlgorithm},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv := strutil.RemoveEmpty(strutil.TrimStrings(v.([]string)))
+			for i, name := range nv {
+				pn, ok := f.parse(strings.ToLower(name))
+				if !ok {
+					return false, fmt.Errorf("unknown value %q in %s", name, f.name)
+				}
+				nv[i] = pn
+			}
+			nv = strutil.RemoveDuplicates(nv, false)
+			if !strutil.EquivalentSlices(nv, *f.value) {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"crypto_key_name_regex", &c.CryptoKeyNameRegex},
+		{"key_ring_name_regex", &c.KeyRingNameRegex},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv := strings.TrimSpace(v.(string))
+			if _, err := regexp.Compile(nv); err != nil {
+				return false, errwrap.Wrapf(fmt.Sprintf("invalid %s: {{err}}", f.name), err)
+			}
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	if v, ok := d.GetOk("crypto_key_name_template"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if nv != "" {
+			if _, err := expandKeyNameTemplate(nv, "", ""); err != nil {
+				return false, errwrap.Wrapf("invalid crypto_key_name_template: {{err}}", err)
+			}
+		}
+		if nv != c.CryptoKeyNameTemplate {
+			c.CryptoKeyNameTemplate = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("include_hmac"); ok {
+		nv := v.(bool)
+		if nv != c.IncludeHMAC {
+			c.IncludeHMAC = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("metrics_key_labels"); ok {
+		nv := v.(bool)
+		if nv != c.MetricsKeyLabels {
+			c.MetricsKeyLabels = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("forward_usage"); ok {
+		nv := v.(bool)
+		if nv != c.ForwardUsage {
+			c.ForwardUsage = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("response_wrapping"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if err := validateResponseWrapping(nv); err != nil {
+			return false, err
+		}
+		if nv != c.ResponseWrapping {
+			c.ResponseWrapping = nv
+			changed = true
+		}
+	}
+
+	v, ok, err = d.GetOkErr("drift_check_interval")
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		nv := time.Duration(v.(int)) * time.Second
+		if nv < 0 {
+			return false, fmt.Errorf("drift_check_interval cannot be negative")
+		}
+		if nv != c.DriftCheckInterval {
+			c.DriftCheckInterval = nv
+			changed = true
+		}
+	}
+
+	v, ok, err = d.GetOkErr("orphan_check_interval")
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		nv := time.Duration(v.(int)) * time.Second
+		if nv < 0 {
+			return false, fmt.Errorf("orphan_check_interval cannot be negative")
+		}
+		if nv != c.OrphanCheckInterval {
+			c.OrphanCheckInterval = nv
+			changed = true
+		}
+	}
+
+	if v, ok := d.GetOk("mount_label"); ok {
+		nv := strings.TrimSpace(v.(string))
+		if nv != "" {
+			if _, _, err := parseMountLabel(nv); err != nil {
+				return false, err
+			}
+		}
+		if nv != c.MountLabel {
+			c.MountLabel = nv
+			changed = true
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"attestation_google_roots", &c.AttestationGoogleRoots},
+		{"attestation_manufacturer_roots", &c.AttestationManufacturerRoots},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv := strings.TrimSpace(v.(string))
+			if _, err := certPoolFromPEM(nv); err != nil {
+				return false, fmt.Errorf("invalid %s: %w", f.name, err)
+			}
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	v, ok, err = d.GetOkErr("response_wrap_ttl")
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		nv := time.Duration(v.(int)) * time.Second
+		if nv <= 0 {
+			return false, fmt.Errorf("response_wrap_ttl must be positive")
+		}
+		if nv != c.ResponseWrapTTL {
+			c.ResponseWrapTTL = nv
+			changed = true
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"request_timeout", &c.RequestTimeout},
+		{"crypto_operation_timeout", &c.CryptoOperationTimeout},
+		{"admin_operation_timeout", &c.AdminOperationTimeout},
+	} {
+		v, ok, err := d.GetOkErr(f.name)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			nv := time.Duration(v.(int)) * time.Second
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	if v, ok := d.GetOk("retry_max_attempts"); ok {
+		nv := v.(int)
+		if nv < 0 {
+			return false, fmt.Errorf("retry_max_attempts cannot be negative")
+		}
+		if nv != c.RetryMaxAttempts {
+			c.RetryMaxAttempts = nv
+			changed = true
+		}
+	}
+
+	// Backoffs are commonly sub-second, so they are parsed as strings instead
+	// of whole seconds.
+	for _, f := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"retry_initial_backoff", &c.RetryInitialBackoff},
+		{"retry_max_backoff", &c.RetryMaxBackoff},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv, err := parseutil.ParseDurationSecond(v)
+			if err != nil {
+				return false, errwrap.Wrapf(fmt.Sprintf("invalid %s: {{err}}", f.name), err)
+			}
+			if nv <= 0 {
+				return false, fmt.Errorf("%s must be positive", f.name)
+			}
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	if c.RetryMaxBackoff < c.RetryInitialBackoff {
+		return false, fmt.Errorf("retry_max_backoff cannot be less than retry_initial_backoff")
+	}
+
+	if v, ok := d.GetOk("retry_codes"); ok {
+		nv := strutil.RemoveDuplicates(v.([]string), true)
+		for _, code := range nv {
+			if _, ok := retryCodes[code]; !ok {
+				return false, fmt.Errorf("unknown retry code %q, valid codes are %q",
+					code, retryCodeNames())
+			}
+		}
+		if !strutil.EquivalentSlices(nv, c.RetryCodes) {
+			c.RetryCodes = nv
+			changed = true
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *float64
+	}{
+		{"rate_limit", &c.RateLimit},
+		{"key_rate_limit", &c.KeyRateLimit},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv := v.(float64)
+			if nv < 0 {
+				return false, fmt.Errorf("%s cannot be negative", f.name)
+			}
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *int
+	}{
+		{"grpc_conn_pool_size", &c.GRPCConnPoolSize},
+		{"key_max_concurrency", &c.KeyMaxConcurrency},
+		{"throttle_queue_depth", &c.ThrottleQueueDepth},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv := v.(int)
+			if nv < 0 {
+				return false, fmt.Errorf("%s cannot be negative", f.name)
+			}
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
 	return changed, nil
 }
+
+// rotationDue returns true if the service account key in the config is due to
+// be rotated automatically.
+func (c *Config) rotationDue(now time.Time) bool {
+	if c.RotationPeriod <= 0 || c.Credentials == "" {
+		return false
+	}
+	return !now.Before(c.LastRotated.Add(c.RotationPeriod))
+}
+
+// credentialIdentity describes the identity of the configured credentials
+// without any of their secrets, so operators can confirm which identity the
+// mount is using.
+type credentialIdentity struct {
+	// Type is the kind of credentials: "access_token",
+	// "application_default", or the type of a credentials file, such as
+	// "service_account" or "external_account" for workload identity
+	// federation. It is "unknown" if the credentials file cannot be parsed.
+	Type string
+
+	// ClientEmail, ProjectID, and PrivateKeyID are read from the credentials
+	// file, where present. For workload identity federation, ClientEmail is
+	// the service account which is impersonated, if any.
+	ClientEmail  string
+	ProjectID    string
+	PrivateKeyID string
+}
+
+// identity returns the identity of the configured credentials. Application
+// default credentials are not resolved, so only their type is known.
+func (c *Config) identity() *credentialIdentity {
+	switch {
+	case c.AccessToken != "" || c.AccessTokenFile != "":
+		return &credentialIdentity{Type: "access_token"}
+	case c.Credentials == "":
+		return &credentialIdentity{Type: "application_default"}
+	}
+
+	var f struct {
+		Type                           string `json:"type"`
+		ClientEmail                    string `json:"client_email"`
+		ProjectID                      string `json:"project_id"`
+		QuotaProjectID                 string `json:"quota_project_id"`
+		PrivateKeyID                   string `json:"private_key_id"`
+		ServiceAccountImpersonationURL string `json:"service_account_impersonation_url"`
+	}
+	if err := json.Unmarshal([]byte(c.Credentials), &f); err != nil || f.Type == "" {
+		return &credentialIdentity{Type: "unknown"}
+	}
+
+	id := &credentialIdentity{
+		Type:         f.Type,
+		ClientEmail:  f.ClientEmail,
+		ProjectID:    f.ProjectID,
+		PrivateKeyID: f.PrivateKeyID,
+	}
+	if id.ProjectID == "" {
+		id.ProjectID = f.QuotaProjectID
+	}
+	if id.ClientEmail == "" && f.ServiceAccountImpersonationURL != "" {
+		// The URL ends in "serviceAccounts/EMAIL:generateAccessToken"
+		u := f.ServiceAccountImpersonationURL
+		if i := strings.LastIndex(u, "/"); i >= 0 {
+			u = u[i+1:]
+		}
+		id.ClientEmail = strings.TrimSuffix(u, ":generateAccessToken")
+	}
+	return id
+}
+
+// usesAccessToken returns true if the config authenticates with a brokered
+// access token.
+func (c *Config) usesAccessToken() bool {
+	return c.AccessToken != "" || c.AccessTokenFile != ""
+}
+
+// endpointInUniverse returns true if the host of the endpoint, given as host
+// or host:port, is the universe domain or a subdomain of it.
+func endpointInUniverse(endpoint, universeDomain string) bool {
+	host := endpoint
+	if h, _, err := net.SplitHostPort(endpoint); err == nil {
+		host = h
+	}
+	return host == universeDomain || strings.HasSuffix(host, "."+universeDomain)
+}
+
+// universeDomain returns the configured universe domain, or the default
+// universe domain if none is configured.
+func (c *Config) universeDomain() string {
+	if c.UniverseDomain == "" {
+		return defaultUniverseDomain
+	}
+	return c.UniverseDomain
+}
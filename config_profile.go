@@ -0,0 +1,139 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// ConfigProfile is a named set of credentials and connection settings which
+// keys can use in place of the ones in the config, so keys in different
+// projects or environments can share a mount.
+type ConfigProfile struct {
+	Name string `json:"name"`
+
+	// Credentials and Scopes are used instead of the configured ones. Empty
+	// means the configured ones are used.
+	Credentials string   `json:"credentials"`
+	Scopes      []string `json:"scopes"`
+
+	// APIEndpoint and QuotaProject are used instead of the configured ones.
+	// Empty means the configured ones are used.
+	APIEndpoint  string `json:"api_endpoint"`
+	QuotaProject string `json:"quota_project"`
+}
+
+// reservedProfileNames are the names under config/ which are not profiles,
+// so profiles cannot be given them.
+var reservedProfileNames = map[string]bool{
+	"rotate-root": true,
+	"test":        true,
+}
+
+// checkProfileName returns an error if the name is reserved.
+func checkProfileName(name string) error {
+	if reservedProfileNames[name] {
+		return logical.CodedError(400, fmt.Sprintf("%q is reserved and cannot be used as a profile name", name))
+	}
+	return nil
+}
+
+// Update updates the profile from the given field data.
+func (p *ConfigProfile) Update(d *framework.FieldData) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+
+	changed := false
+
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"credentials", &p.Credentials},
+		{"api_endpoint", &p.APIEndpoint},
+		{"quota_project", &p.QuotaProject},
+	} {
+		if v, ok := d.GetOk(f.name); ok {
+			nv := strings.TrimSpace(v.(string))
+			if nv != *f.value {
+				*f.value = nv
+				changed = true
+			}
+		}
+	}
+
+	if v, ok := d.GetOk("scopes"); ok {
+		nv := strutil.RemoveDuplicates(v.([]string), true)
+		if !strutil.EquivalentSlices(nv, p.Scopes) {
+			p.Scopes = nv
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// apply returns a copy of the config with the profile's settings in place of
+// the configured ones. Credentials from the profile replace every configured
+// source of credentials, including impersonation.
+func (p *ConfigProfile) apply(c *Config) *Config {
+	r := *c
+
+	if p.Credentials != "" {
+		r.Credentials = p.Credentials
+		r.AccessToken = ""
+		r.AccessTokenFile = ""
+		r.ImpersonateServiceAccount = ""
+		r.Delegates = nil
+	}
+	if len(p.Scopes) > 0 {
+		r.Scopes = p.Scopes
+	}
+	if p.APIEndpoint != "" {
+		r.APIEndpoint = p.APIEndpoint
+	}
+	if p.QuotaProject != "" {
+		r.QuotaProject = p.QuotaProject
+	}
+
+	return &r
+}
+
+// ConfigProfile retrieves the named profile from the storage backend, or nil if
+// one does not exist.
+func (b *backend) ConfigProfile(ctx context.Context, s logical.Storage, name string) (*ConfigProfile, error) {
+	entry, err := s.Get(ctx, "config/"+name)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to retrieve profile %q: {{err}}", name), err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result ConfigProfile
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to decode entry for %q: {{err}}", name), err)
+	}
+	return &result, nil
+}
+
+// checkConfigProfile returns a 400 error if the named profile does not exist.
+func (b *backend) checkConfigProfile(ctx context.Context, s logical.Storage, name string) error {
+	p, err := b.ConfigProfile(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return logical.CodedError(400, fmt.Sprintf("config profile %q does not exist", name))
+	}
+	return nil
+}
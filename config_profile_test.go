@@ -0,0 +1,158 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+)
+
+func TestConfigProfile_Update(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		new     *ConfigProfile
+		d       *framework.FieldData
+		r       *ConfigProfile
+		changed bool
+	}{
+		{
+			"empty",
+			&ConfigProfile{},
+			nil,
+			&ConfigProfile{},
+			false,
+		},
+		{
+			"overwrites",
+			&ConfigProfile{
+				Credentials: "creds",
+				Scopes:      []string{"foo"},
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"credentials":   " new-creds ",
+					"scopes":        "bar,bar,baz",
+					"api_endpoint":  "localhost:9010",
+					"quota_project": "my-project",
+				},
+			},
+			&ConfigProfile{
+				Credentials:  "new-creds",
+				Scopes:       []string{"bar", "baz"},
+				APIEndpoint:  "localhost:9010",
+				QuotaProject: "my-project",
+			},
+			true,
+		},
+		{
+			"no_change",
+			&ConfigProfile{
+				Credentials: "creds",
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"credentials": "creds",
+				},
+			},
+			&ConfigProfile{
+				Credentials: "creds",
+			},
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			if tc.d != nil {
+				var b backend
+				tc.d.Schema = b.pathConfigProfile().Fields
+			}
+
+			changed, err := tc.new.Update(tc.d)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if changed != tc.changed {
+				t.Errorf("expected %t to be %t", changed, tc.changed)
+			}
+
+			if !reflect.DeepEqual(tc.new, tc.r) {
+				t.Errorf("expected %#v to be %#v", tc.new, tc.r)
+			}
+		})
+	}
+}
+
+func TestConfigProfile_Apply(t *testing.T) {
+
+	c := &Config{
+		AccessToken:               "ya29.token",
+		Scopes:                    []string{defaultScope},
+		ImpersonateServiceAccount: "kms@p.iam.gserviceaccount.com",
+		Delegates:                 []string{"a@p.iam.gserviceaccount.com"},
+		APIEndpoint:               "localhost:9010",
+		QuotaProject:              "my-project",
+		ClientLifetime:            defaultClientLifetime,
+	}
+
+	cases := []struct {
+		name string
+		p    *ConfigProfile
+		r    *Config
+	}{
+		{
+			"empty",
+			&ConfigProfile{},
+			c,
+		},
+		{
+			"credentials",
+			&ConfigProfile{
+				Credentials: "creds",
+			},
+			&Config{
+				Credentials:    "creds",
+				Scopes:         []string{defaultScope},
+				APIEndpoint:    "localhost:9010",
+				QuotaProject:   "my-project",
+				ClientLifetime: defaultClientLifetime,
+			},
+		},
+		{
+			"settings",
+			&ConfigProfile{
+				Scopes:       []string{"foo"},
+				APIEndpoint:  "localhost:9011",
+				QuotaProject: "my-other-project",
+			},
+			&Config{
+				AccessToken:               "ya29.token",
+				Scopes:                    []string{"foo"},
+				ImpersonateServiceAccount: "kms@p.iam.gserviceaccount.com",
+				Delegates:                 []string{"a@p.iam.gserviceaccount.com"},
+				APIEndpoint:               "localhost:9011",
+				QuotaProject:              "my-other-project",
+				ClientLifetime:            defaultClientLifetime,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			if v := tc.p.apply(c); !reflect.DeepEqual(v, tc.r) {
+				t.Errorf("expected %#v to be %#v", v, tc.r)
+			}
+		})
+	}
+}
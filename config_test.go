@@ -6,12 +6,15 @@ package gcpkms
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 )
 
 func TestConfig_Update(t *testing.T) {
 
+	t.Setenv(accessTokenDirEnv, "/run/secrets")
+
 	cases := []struct {
 		name    string
 		new     *Config
@@ -122,6 +125,447 @@ func TestConfig_Update(t *testing.T) {
 			false,
 			false,
 		},
+		{
+			"access_token_file",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"access_token_file": " /run/secrets/gcp-token ",
+				},
+			},
+			&Config{
+				AccessTokenFile: "/run/secrets/gcp-token",
+			},
+			true,
+			false,
+		},
+		{
+			"access_token_file_outside_dir",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"access_token_file": "/etc/passwd",
+				},
+			},
+			&Config{
+				AccessTokenFile: "/etc/passwd",
+			},
+			false,
+			true,
+		},
+		{
+			"access_token_with_other_endpoint",
+			&Config{
+				AccessToken: "ya29.token",
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"api_endpoint": "kms.example.com:443",
+				},
+			},
+			&Config{
+				AccessToken: "ya29.token",
+				APIEndpoint: "kms.example.com:443",
+			},
+			false,
+			true,
+		},
+		{
+			"access_token_with_credentials",
+			&Config{
+				Credentials: "creds",
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"access_token": "ya29.token",
+				},
+			},
+			&Config{
+				Credentials: "creds",
+				AccessToken: "ya29.token",
+			},
+			false,
+			true,
+		},
+		{
+			"access_token_with_scopes",
+			&Config{
+				AccessToken: "ya29.token",
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"scopes": "https://www.googleapis.com/auth/cloud-platform",
+				},
+			},
+			&Config{
+				AccessToken: "ya29.token",
+				Scopes:      []string{"https://www.googleapis.com/auth/cloud-platform"},
+			},
+			false,
+			true,
+		},
+		{
+			"impersonate_service_account",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"impersonate_service_account": "kms@p.iam.gserviceaccount.com",
+					"delegates":                   "b@p.iam.gserviceaccount.com, a@p.iam.gserviceaccount.com",
+				},
+			},
+			&Config{
+				ImpersonateServiceAccount: "kms@p.iam.gserviceaccount.com",
+				Delegates: []string{
+					"b@p.iam.gserviceaccount.com",
+					"a@p.iam.gserviceaccount.com",
+				},
+			},
+			true,
+			false,
+		},
+		{
+			"delegates_without_impersonate_service_account",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"delegates": "a@p.iam.gserviceaccount.com",
+				},
+			},
+			&Config{
+				Delegates: []string{"a@p.iam.gserviceaccount.com"},
+			},
+			false,
+			true,
+		},
+		{
+			"impersonate_service_account_with_universe_domain",
+			&Config{
+				UniverseDomain: "example.goog",
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"impersonate_service_account": "kms@p.iam.gserviceaccount.com",
+				},
+			},
+			&Config{
+				ImpersonateServiceAccount: "kms@p.iam.gserviceaccount.com",
+				UniverseDomain:            "example.goog",
+			},
+			false,
+			true,
+		},
+		{
+			"api_endpoint",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"api_endpoint": " localhost:9010 ",
+				},
+			},
+			&Config{
+				APIEndpoint: "localhost:9010",
+			},
+			true,
+			false,
+		},
+		{
+			"quota_project",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"quota_project": " my-billing-project ",
+				},
+			},
+			&Config{
+				QuotaProject: "my-billing-project",
+			},
+			true,
+			false,
+		},
+		{
+			"universe_domain",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"universe_domain": " example.goog ",
+				},
+			},
+			&Config{
+				UniverseDomain: "example.goog",
+			},
+			true,
+			false,
+		},
+		{
+			"default_universe_domain",
+			&Config{
+				UniverseDomain: "example.goog",
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"universe_domain": "googleapis.com",
+				},
+			},
+			&Config{},
+			true,
+			false,
+		},
+		{
+			"regional_endpoints",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"regional_endpoints": true,
+				},
+			},
+			&Config{
+				RegionalEndpoints: true,
+			},
+			true,
+			false,
+		},
+		{
+			"regional_endpoints_with_api_endpoint",
+			&Config{
+				APIEndpoint: "localhost:9010",
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"regional_endpoints": true,
+				},
+			},
+			&Config{
+				APIEndpoint:       "localhost:9010",
+				RegionalEndpoints: true,
+			},
+			false,
+			true,
+		},
+		{
+			"proxy",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"proxy_url": "http://proxy.example.com:3128",
+				},
+			},
+			&Config{
+				ProxyURL: "http://proxy.example.com:3128",
+			},
+			true,
+			false,
+		},
+		{
+			"invalid_proxy",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"proxy_url": "proxy.example.com:3128",
+				},
+			},
+			&Config{},
+			false,
+			true,
+		},
+		{
+			"invalid_ca_certificate",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"ca_certificate": "not a certificate",
+				},
+			},
+			&Config{},
+			false,
+			true,
+		},
+		{
+			"client",
+			&Config{
+				ClientLifetime: 24 * time.Hour,
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"client_lifetime":     "1h",
+					"grpc_conn_pool_size": 4,
+				},
+			},
+			&Config{
+				ClientLifetime:   time.Hour,
+				GRPCConnPoolSize: 4,
+			},
+			true,
+			false,
+		},
+		{
+			"zero_client_lifetime",
+			&Config{
+				ClientLifetime: 24 * time.Hour,
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"client_lifetime": 0,
+				},
+			},
+			&Config{
+				ClientLifetime: 24 * time.Hour,
+			},
+			false,
+			true,
+		},
+		{
+			"timeouts",
+			&Config{
+				CryptoOperationTimeout: 30 * time.Second,
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"request_timeout":          "2m",
+					"crypto_operation_timeout": 30,
+					"admin_operation_timeout":  "1m",
+				},
+			},
+			&Config{
+				RequestTimeout:         2 * time.Minute,
+				CryptoOperationTimeout: 30 * time.Second,
+				AdminOperationTimeout:  time.Minute,
+			},
+			true,
+			false,
+		},
+		{
+			"retry",
+			&Config{
+				RetryInitialBackoff: 100 * time.Millisecond,
+				RetryMaxBackoff:     5 * time.Second,
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"retry_max_attempts":    3,
+					"retry_initial_backoff": "250ms",
+					"retry_max_backoff":     "10s",
+					"retry_codes":           "Unavailable,aborted",
+				},
+			},
+			&Config{
+				RetryMaxAttempts:    3,
+				RetryInitialBackoff: 250 * time.Millisecond,
+				RetryMaxBackoff:     10 * time.Second,
+				RetryCodes:          []string{"aborted", "unavailable"},
+			},
+			true,
+			false,
+		},
+		{
+			"retry_invalid_code",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"retry_codes": "not_found",
+				},
+			},
+			&Config{},
+			false,
+			true,
+		},
+		{
+			"retry_max_less_than_initial",
+			&Config{
+				RetryInitialBackoff: 100 * time.Millisecond,
+				RetryMaxBackoff:     5 * time.Second,
+			},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"retry_max_backoff": "50ms",
+				},
+			},
+			&Config{
+				RetryInitialBackoff: 100 * time.Millisecond,
+				RetryMaxBackoff:     50 * time.Millisecond,
+			},
+			false,
+			true,
+		},
+		{
+			"rate_limits",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"rate_limit":          100,
+					"key_rate_limit":      "2.5",
+					"key_max_concurrency": 10,
+				},
+			},
+			&Config{
+				RateLimit:         100,
+				KeyRateLimit:      2.5,
+				KeyMaxConcurrency: 10,
+			},
+			true,
+			false,
+		},
+		{
+			"negative_rate_limit",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"key_rate_limit": -1,
+				},
+			},
+			&Config{},
+			false,
+			true,
+		},
+		{
+			"rotation_period",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"rotation_period": "720h",
+				},
+			},
+			&Config{
+				RotationPeriod: 720 * time.Hour,
+			},
+			true,
+			false,
+		},
+		{
+			"rotation_period_too_short",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"rotation_period": "10m",
+				},
+			},
+			&Config{},
+			false,
+			true,
+		},
+		{
+			"invalid_response_wrapping",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"response_wrapping": "sometimes",
+				},
+			},
+			&Config{},
+			false,
+			true,
+		},
+		{
+			"negative_timeout",
+			&Config{},
+			&framework.FieldData{
+				Raw: map[string]interface{}{
+					"request_timeout": -1,
+				},
+			},
+			&Config{},
+			false,
+			true,
+		},
 	}
 
 	for _, tc := range cases {
@@ -150,6 +594,191 @@ func TestConfig_Update(t *testing.T) {
 			if v, exp := tc.new.Credentials, tc.r.Credentials; v != exp {
 				t.Errorf("expected %q to be %q", v, exp)
 			}
+
+			if v, exp := tc.new.AccessToken, tc.r.AccessToken; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.AccessTokenFile, tc.r.AccessTokenFile; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.ImpersonateServiceAccount, tc.r.ImpersonateServiceAccount; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.Delegates, tc.r.Delegates; !reflect.DeepEqual(v, exp) {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.APIEndpoint, tc.r.APIEndpoint; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.UniverseDomain, tc.r.UniverseDomain; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.QuotaProject, tc.r.QuotaProject; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.ProxyURL, tc.r.ProxyURL; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.RegionalEndpoints, tc.r.RegionalEndpoints; v != exp {
+				t.Errorf("expected %t to be %t", v, exp)
+			}
+
+			if v, exp := tc.new.ClientLifetime, tc.r.ClientLifetime; v != exp {
+				t.Errorf("expected %s to be %s", v, exp)
+			}
+
+			if v, exp := tc.new.GRPCConnPoolSize, tc.r.GRPCConnPoolSize; v != exp {
+				t.Errorf("expected %d to be %d", v, exp)
+			}
+
+			if v, exp := tc.new.RequestTimeout, tc.r.RequestTimeout; v != exp {
+				t.Errorf("expected %s to be %s", v, exp)
+			}
+
+			if v, exp := tc.new.CryptoOperationTimeout, tc.r.CryptoOperationTimeout; v != exp {
+				t.Errorf("expected %s to be %s", v, exp)
+			}
+
+			if v, exp := tc.new.AdminOperationTimeout, tc.r.AdminOperationTimeout; v != exp {
+				t.Errorf("expected %s to be %s", v, exp)
+			}
+
+			if v, exp := tc.new.RetryMaxAttempts, tc.r.RetryMaxAttempts; v != exp {
+				t.Errorf("expected %d to be %d", v, exp)
+			}
+
+			if v, exp := tc.new.RetryInitialBackoff, tc.r.RetryInitialBackoff; v != exp {
+				t.Errorf("expected %s to be %s", v, exp)
+			}
+
+			if v, exp := tc.new.RetryMaxBackoff, tc.r.RetryMaxBackoff; v != exp {
+				t.Errorf("expected %s to be %s", v, exp)
+			}
+
+			if v, exp := tc.new.RetryCodes, tc.r.RetryCodes; !reflect.DeepEqual(v, exp) {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if v, exp := tc.new.RateLimit, tc.r.RateLimit; v != exp {
+				t.Errorf("expected %f to be %f", v, exp)
+			}
+
+			if v, exp := tc.new.KeyRateLimit, tc.r.KeyRateLimit; v != exp {
+				t.Errorf("expected %f to be %f", v, exp)
+			}
+
+			if v, exp := tc.new.KeyMaxConcurrency, tc.r.KeyMaxConcurrency; v != exp {
+				t.Errorf("expected %d to be %d", v, exp)
+			}
+
+			if v, exp := tc.new.RotationPeriod, tc.r.RotationPeriod; v != exp {
+				t.Errorf("expected %s to be %s", v, exp)
+			}
+		})
+	}
+}
+
+func TestConfig_RotationDue(t *testing.T) {
+
+	now := time.Now().UTC()
+
+	cases := []struct {
+		name string
+		c    *Config
+		due  bool
+	}{
+		{
+			"disabled",
+			&Config{Credentials: "creds", LastRotated: now.Add(-48 * time.Hour)},
+			false,
+		},
+		{
+			"no_credentials",
+			&Config{RotationPeriod: 24 * time.Hour, LastRotated: now.Add(-48 * time.Hour)},
+			false,
+		},
+		{
+			"not_yet",
+			&Config{Credentials: "creds", RotationPeriod: 24 * time.Hour, LastRotated: now.Add(-time.Hour)},
+			false,
+		},
+		{
+			"due",
+			&Config{Credentials: "creds", RotationPeriod: 24 * time.Hour, LastRotated: now.Add(-24 * time.Hour)},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			if v := tc.c.rotationDue(now); v != tc.due {
+				t.Errorf("expected %t to be %t", v, tc.due)
+			}
+		})
+	}
+}
+
+func TestConfig_Identity(t *testing.T) {
+
+	cases := []struct {
+		name string
+		c    *Config
+		exp  *credentialIdentity
+	}{
+		{
+			"application_default",
+			&Config{},
+			&credentialIdentity{Type: "application_default"},
+		},
+		{
+			"access_token",
+			&Config{AccessTokenFile: "/var/run/token"},
+			&credentialIdentity{Type: "access_token"},
+		},
+		{
+			"unparseable",
+			&Config{Credentials: "creds"},
+			&credentialIdentity{Type: "unknown"},
+		},
+		{
+			"service_account",
+			&Config{Credentials: `{"type":"service_account","client_email":"sa@my-project.iam.gserviceaccount.com",` +
+				`"project_id":"my-project","private_key_id":"abc123","private_key":"secret"}`},
+			&credentialIdentity{
+				Type:         "service_account",
+				ClientEmail:  "sa@my-project.iam.gserviceaccount.com",
+				ProjectID:    "my-project",
+				PrivateKeyID: "abc123",
+			},
+		},
+		{
+			"external_account",
+			&Config{Credentials: `{"type":"external_account","service_account_impersonation_url":` +
+				`"https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/sa@my-project.iam.gserviceaccount.com:generateAccessToken"}`},
+			&credentialIdentity{
+				Type:        "external_account",
+				ClientEmail: "sa@my-project.iam.gserviceaccount.com",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			if v := tc.c.identity(); !reflect.DeepEqual(v, tc.exp) {
+				t.Errorf("expected %#v to be %#v", v, tc.exp)
+			}
 		})
 	}
 }
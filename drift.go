@@ -0,0 +1,199 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// keyExpectation is the state of a crypto key which Vault recorded when the
+// key was created, registered, or updated through Vault. Differences from the
+// live crypto key mean it was changed outside of Vault, such as with gcloud or
+// Terraform.
+type keyExpectation struct {
+	Purpose         string            `json:"purpose"`
+	Algorithm       string            `json:"algorithm"`
+	ProtectionLevel string            `json:"protection_level"`
+	RotationPeriod  time.Duration     `json:"rotation_period,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+// newKeyExpectation returns the expectation of the crypto key's current state.
+func newKeyExpectation(ck *kmspb.CryptoKey) *keyExpectation {
+	e := &keyExpectation{
+		Purpose:         purposeToString(ck.Purpose),
+		Algorithm:       policyAlgorithmName(ck.GetVersionTemplate().GetAlgorithm()),
+		ProtectionLevel: policyProtectionLevelName(ck.GetVersionTemplate().GetProtectionLevel()),
+		Labels:          ck.Labels,
+	}
+	if p := ck.GetRotationPeriod(); p != nil {
+		e.RotationPeriod = time.Duration(p.Seconds) * time.Second
+	}
+	return e
+}
+
+// keyDrift is a difference between the expected and actual state of a crypto
+// key.
+type keyDrift struct {
+	Field    string
+	Expected interface{}
+	Actual   interface{}
+}
+
+// drift returns the differences between the expected and actual state of the
+// crypto key, ordered by field.
+func (e *keyExpectation) drift(actual *keyExpectation) []*keyDrift {
+	var drifts []*keyDrift
+	for _, f := range []struct {
+		name             string
+		expected, actual string
+	}{
+		{"purpose", e.Purpose, actual.Purpose},
+		{"algorithm", e.Algorithm, actual.Algorithm},
+		{"protection_level", e.ProtectionLevel, actual.ProtectionLevel},
+	} {
+		if f.expected != f.actual {
+			drifts = append(drifts, &keyDrift{Field: f.name, Expected: f.expected, Actual: f.actual})
+		}
+	}
+
+	if e.RotationPeriod != actual.RotationPeriod {
+		drifts = append(drifts, &keyDrift{
+			Field:    "rotation_period",
+			Expected: int64(e.RotationPeriod.Seconds()),
+			Actual:   int64(actual.RotationPeriod.Seconds()),
+		})
+	}
+
+	var labels []string
+	for k := range e.Labels {
+		labels = append(labels, k)
+	}
+	for k := range actual.Labels {
+		if _, ok := e.Labels[k]; !ok {
+			labels = append(labels, k)
+		}
+	}
+	sort.Strings(labels)
+	for _, k := range labels {
+		ev, eok := e.Labels[k]
+		av, aok := actual.Labels[k]
+		if ev == av && eok == aok {
+			continue
+		}
+		d := &keyDrift{Field: "labels." + k}
+		if eok {
+			d.Expected = ev
+		}
+		if aok {
+			d.Actual = av
+		}
+		drifts = append(drifts, d)
+	}
+
+	return drifts
+}
+
+// driftWarning describes the difference as a warning.
+func (d *keyDrift) driftWarning() string {
+	switch {
+	case d.Expected == nil:
+		return fmt.Sprintf("%s was added outside of Vault with value %v", d.Field, d.Actual)
+	case d.Actual == nil:
+		return fmt.Sprintf("%s was removed outside of Vault, expected %v", d.Field, d.Expected)
+	}
+	return fmt.Sprintf("%s was changed outside of Vault from %v to %v", d.Field, d.Expected, d.Actual)
+}
+
+// keyCryptoKeyDrift reads the live state of the key's crypto key and returns
+// how it differs from the state Vault expects.
+func (b *backend) keyCryptoKeyDrift(ctx context.Context, s logical.Storage, k *Key) ([]*keyDrift, error) {
+	kmsClient, closer, err := b.KeyKMSClient(ctx, s, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: k.CryptoKeyID,
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to read crypto key: {{err}}", err)
+	}
+	return k.Expected.drift(newKeyExpectation(ck)), nil
+}
+
+// driftCheckDue returns true if the interval has passed since the last drift
+// scan, and records now as the last run if so. A zero interval disables the
+// scan.
+func (b *backend) driftCheckDue(now time.Time, interval time.Duration) bool {
+	if interval <= 0 {
+		return false
+	}
+
+	b.driftCheckLock.Lock()
+	defer b.driftCheckLock.Unlock()
+
+	if now.Sub(b.driftCheckLastRun) < interval {
+		return false
+	}
+	b.driftCheckLastRun = now
+	return true
+}
+
+// driftCheck compares every key which has an expected state with its crypto
+// key, and logs a warning for each difference.
+func (b *backend) driftCheck(ctx context.Context, s logical.Storage) error {
+	index, err := b.keyIndex(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var errs *multierror.Error
+	for _, name := range keyIndexNames(index) {
+		if !index[name].HasExpected {
+			continue
+		}
+
+		k, err := b.Key(ctx, s, name)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				continue
+			}
+			return err
+		}
+		if k.Expected == nil {
+			continue
+		}
+
+		drifts, err := b.keyCryptoKeyDrift(ctx, s, k)
+		if err != nil {
+			errs = multierror.Append(errs, errwrap.Wrapf(
+				"failed to check key "+k.Name+" for drift: {{err}}", err))
+			continue
+		}
+
+		metrics.SetGaugeWithLabels([]string{metricsPrefix, "key", "drift"}, float32(len(drifts)), []metrics.Label{
+			{Name: "key", Value: k.Name},
+		})
+		for _, d := range drifts {
+			b.Logger().Warn("crypto key drifted from the state expected by Vault",
+				"key", k.Name, "crypto_key", k.CryptoKeyID, "drift", d.driftWarning())
+		}
+	}
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestKeyExpectation_Drift(t *testing.T) {
+
+	ck := &kmspb.CryptoKey{
+		Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+		VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			ProtectionLevel: kmspb.ProtectionLevel_HSM,
+			Algorithm:       kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+		},
+		RotationSchedule: &kmspb.CryptoKey_RotationPeriod{
+			RotationPeriod: &duration.Duration{Seconds: 86400},
+		},
+		Labels: map[string]string{"team": "payments", "env": "prod"},
+	}
+
+	expected := newKeyExpectation(ck)
+	if v, exp := expected.RotationPeriod, 24*time.Hour; v != exp {
+		t.Errorf("expected %s to be %s", v, exp)
+	}
+	if drifts := expected.drift(newKeyExpectation(ck)); len(drifts) != 0 {
+		t.Errorf("expected no drift, got %v", drifts)
+	}
+
+	changed := &kmspb.CryptoKey{
+		Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+		VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+			Algorithm:       kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+		},
+		Labels: map[string]string{"team": "billing", "owner": "alice"},
+	}
+
+	var fields []string
+	for _, d := range expected.drift(newKeyExpectation(changed)) {
+		fields = append(fields, d.Field)
+	}
+	exp := []string{"protection_level", "rotation_period", "labels.env", "labels.owner", "labels.team"}
+	if !reflect.DeepEqual(fields, exp) {
+		t.Errorf("expected %q to be %q", fields, exp)
+	}
+}
+
+func TestKeyDrift_DriftWarning(t *testing.T) {
+
+	cases := []struct {
+		drift *keyDrift
+		exp   string
+	}{
+		{
+			&keyDrift{Field: "algorithm", Expected: "ec_sign_p256_sha256", Actual: "ec_sign_p384_sha384"},
+			"algorithm was changed outside of Vault from ec_sign_p256_sha256 to ec_sign_p384_sha384",
+		},
+		{
+			&keyDrift{Field: "labels.owner", Actual: "alice"},
+			"labels.owner was added outside of Vault with value alice",
+		},
+		{
+			&keyDrift{Field: "labels.env", Expected: "prod"},
+			"labels.env was removed outside of Vault, expected prod",
+		},
+	}
+
+	for _, tc := range cases {
+		if v := tc.drift.driftWarning(); v != tc.exp {
+			t.Errorf("expected %q to be %q", v, tc.exp)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// envelopePrefix is the prefix of multi-key ciphertext, which distinguishes
+// it from the base64-encoded ciphertext of a single crypto key.
+const envelopePrefix = "gcpkms:envelope:v1:"
+
+// envelope is plaintext encrypted locally with a random data key, which is in
+// turn encrypted by the crypto key of each recipient key. Any one recipient
+// can decrypt it, so the data survives the loss of a project or location.
+type envelope struct {
+	Nonce      []byte               `json:"nonce"`
+	Ciphertext []byte               `json:"ciphertext"`
+	Recipients []*envelopeRecipient `json:"recipients"`
+}
+
+// envelopeRecipient is the data key of an envelope encrypted by the crypto key
+// of a key.
+type envelopeRecipient struct {
+	Key          string `json:"key"`
+	CryptoKey    string `json:"crypto_key"`
+	KeyVersion   string `json:"key_version"`
+	EncryptedKey []byte `json:"encrypted_key"`
+}
+
+// sealEnvelope encrypts the plaintext with a new data key using AES-256-GCM,
+// binding the additional authenticated data. It returns the envelope without
+// recipients, and the data key.
+func sealEnvelope(plaintext, aad []byte) (*envelope, []byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, errwrap.Wrapf("failed to generate data key: {{err}}", err)
+	}
+
+	gcm, err := envelopeGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, errwrap.Wrapf("failed to generate nonce: {{err}}", err)
+	}
+
+	return &envelope{
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, aad),
+	}, dataKey, nil
+}
+
+// open decrypts the envelope with the data key.
+func (e *envelope) open(dataKey, aad []byte) ([]byte, error) {
+	gcm, err := envelopeGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid envelope nonce")
+	}
+	return gcm.Open(nil, e.Nonce, e.Ciphertext, aad)
+}
+
+// envelopeGCM returns the AES-GCM cipher for the data key.
+func envelopeGCM(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, errwrap.Wrapf("invalid data key: {{err}}", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// recipient returns the recipient of the envelope whose data key can be
+// decrypted by the key, or nil if there is none.
+func (e *envelope) recipient(k *Key) *envelopeRecipient {
+	for _, r := range e.Recipients {
+		if r.CryptoKey == k.CryptoKeyID {
+			return r
+		}
+		for _, id := range k.FailoverCryptoKeys {
+			if r.CryptoKey == id {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// encode returns the envelope as multi-key ciphertext.
+func (e *envelope) encode() (string, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", errwrap.Wrapf("failed to encode envelope: {{err}}", err)
+	}
+	return envelopePrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// decodeEnvelope parses multi-key ciphertext.
+func decodeEnvelope(s string) (*envelope, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, envelopePrefix))
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to base64 decode envelope: {{err}}", err)
+	}
+
+	var e envelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, errwrap.Wrapf("failed to decode envelope: {{err}}", err)
+	}
+	return &e, nil
+}
+
+// encryptEnvelope encrypts the plaintext so it can be decrypted with any of
+// the keys, and returns the multi-key ciphertext and the names of its
+// recipient keys.
+func (b *backend) encryptEnvelope(ctx context.Context, s logical.Storage, keys []*Key, plaintext, aad string) (string, []string, error) {
+	e, dataKey, err := sealEnvelope([]byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		r, err := b.encryptEnvelopeKey(ctx, s, k, dataKey, aad)
+		if err != nil {
+			return "", nil, errwrap.Wrapf(fmt.Sprintf("failed to encrypt data key "+
+				"with key %q: {{err}}", k.Name), err)
+		}
+		e.Recipients = append(e.Recipients, r)
+		names = append(names, k.Name)
+	}
+
+	ciphertext, err := e.encode()
+	if err != nil {
+		return "", nil, err
+	}
+	return ciphertext, names, nil
+}
+
+// encryptEnvelopeKey encrypts the data key of an envelope with the crypto key
+// of the key.
+func (b *backend) encryptEnvelopeKey(ctx context.Context, s logical.Storage, k *Key, dataKey []byte, aad string) (*envelopeRecipient, error) {
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	release, err := b.acquireKey(k.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var resp *kmspb.EncryptResponse
+	used, err := b.cryptoKeyFailover(ctx, s, k, false, isKMSUnavailable, func(kmsClient keyManagementClient, cryptoKeyID string) error {
+		ck, err := b.cryptoKey(ctx, kmsClient, cryptoKeyID)
+		if err != nil {
+			return err
+		}
+		if err := checkKeyPurpose(k.Name, ck, "encrypt"); err != nil {
+			return err
+		}
+		if err := b.checkKeyFIPS(ctx, s, k.Name, ck); err != nil {
+			return err
+		}
+
+		cryptoKey, err := k.encryptCryptoKey(cryptoKeyID, ck)
+		if err != nil {
+			return err
+		}
+
+		resp, err = kmsClient.Encrypt(ctx, &kmspb.EncryptRequest{
+			Name:                        cryptoKey,
+			Plaintext:                   dataKey,
+			AdditionalAuthenticatedData: []byte(aad),
+		})
+		if err != nil {
+			return wrapKMSError("failed to encrypt data key: {{err}}", err)
+		}
+		return b.checkKeyVersionFIPS(ctx, s, k.Name, resp.ProtectionLevel, cryptoKeyAlgorithm(ck))
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.recordUsage(k.Name, "encrypt")
+
+	return &envelopeRecipient{
+		Key:          k.Name,
+		CryptoKey:    used,
+		KeyVersion:   path.Base(resp.Name),
+		EncryptedKey: resp.Ciphertext,
+	}, nil
+}
@@ -0,0 +1,132 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestEnvelope(t *testing.T) {
+
+	e, dataKey, err := sealEnvelope([]byte("hello"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Recipients = []*envelopeRecipient{
+		{Key: "a", CryptoKey: "projects/p/locations/us-east1/keyRings/r/cryptoKeys/a"},
+		{Key: "b", CryptoKey: "projects/p/locations/us-west1/keyRings/r/cryptoKeys/b"},
+	}
+
+	s, err := e.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s, envelopePrefix) {
+		t.Errorf("expected %q to have prefix %q", s, envelopePrefix)
+	}
+
+	e, err = decodeEnvelope(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pt, err := e.open(dataKey, []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := string(pt), "hello"; v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+
+	if _, err := e.open(dataKey, []byte("other")); err == nil {
+		t.Errorf("expected an error for mismatched additional authenticated data")
+	}
+
+	k := &Key{
+		CryptoKeyID:        "projects/p/locations/us-east1/keyRings/r/cryptoKeys/c",
+		FailoverCryptoKeys: []string{"projects/p/locations/us-west1/keyRings/r/cryptoKeys/b"},
+	}
+	if r := e.recipient(k); r == nil || r.Key != "b" {
+		t.Errorf("expected recipient b: %#v", r)
+	}
+	if r := e.recipient(&Key{CryptoKeyID: "other"}); r != nil {
+		t.Errorf("expected no recipient: %#v", r)
+	}
+}
+
+func TestPathEncrypt_AdditionalKeys(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if err := b.putKey(ctx, storage, &Key{Name: "my-key", CryptoKeyID: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		data map[string]interface{}
+	}{
+		{
+			"not_exist",
+			map[string]interface{}{"plaintext": "hello", "additional_keys": "nope"},
+		},
+		{
+			"duplicate",
+			map[string]interface{}{"plaintext": "hello", "additional_keys": "my-key"},
+		},
+		{
+			"key_version",
+			map[string]interface{}{"plaintext": "hello", "additional_keys": "nope", "key_version": 1},
+		},
+	}
+
+	for _, tc := range cases {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "encrypt/my-key",
+			Data:      tc.data,
+		})
+		if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+			t.Errorf("%s: expected %q to be a 400", tc.name, err)
+		}
+	}
+
+	// Keys not in the key's envelope recipients are rejected
+	if err := b.putKey(ctx, storage, &Key{Name: "other-key", CryptoKeyID: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/my-key",
+		Data:      map[string]interface{}{"plaintext": "hello", "additional_keys": "other-key"},
+	})
+	if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 403 {
+		t.Errorf("expected %q to be a 403", err)
+	}
+
+	// Multi-key ciphertext which the key cannot decrypt is rejected
+	e := &envelope{Recipients: []*envelopeRecipient{{Key: "other", CryptoKey: "bar"}}}
+	ciphertext, err := e.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "decrypt/my-key",
+		Data: map[string]interface{}{
+			"ciphertext": ciphertext,
+		},
+	})
+	if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+		t.Errorf("expected %q to be a 400", err)
+	}
+}
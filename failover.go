@@ -0,0 +1,135 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// kmsErrorCode returns the gRPC code of the KMS error, which may be wrapped,
+// or Unknown if it is not a KMS error.
+func kmsErrorCode(err error) grpccodes.Code {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if s, ok := grpcstatus.FromError(err); ok {
+			return s.Code()
+		}
+	}
+	return grpccodes.Unknown
+}
+
+// isKMSUnavailable returns true if the KMS error means the location of the
+// crypto key is unavailable.
+func isKMSUnavailable(err error) bool {
+	return kmsErrorCode(err) == grpccodes.Unavailable
+}
+
+// isKMSUnreachable returns true if the KMS error means KMS could not be reached
+// or did not respond in time. Deadlines are reported by wrapKMSError as coded
+// 504 errors, which no longer carry the gRPC code.
+func isKMSUnreachable(err error) bool {
+	switch kmsErrorCode(err) {
+	case grpccodes.Unavailable, grpccodes.DeadlineExceeded:
+		return true
+	}
+	var cerr logical.HTTPCodedError
+	if errors.As(err, &cerr) && cerr.Code() == 504 {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// isKMSWrongKey returns true if the KMS error means the location of the crypto
+// key is unavailable, or that the ciphertext was not encrypted with the crypto
+// key, so another crypto key of a failover group may decrypt it.
+func isKMSWrongKey(err error) bool {
+	switch kmsErrorCode(err) {
+	case grpccodes.Unavailable, grpccodes.InvalidArgument:
+		return true
+	}
+	return false
+}
+
+// cryptoKeyFailover calls fn with the key's crypto key and, while fn fails
+// with an error for which retry returns true, with each failover crypto key in
+// order. Each crypto key is called with its own client, since the client of
+// the key's crypto key may be pinned to the endpoint of its location. It
+// returns the crypto key for which fn succeeded, or the last error. Pinned
+// requests, such as for a specific key_version, never fail over because crypto
+// key versions belong to the primary crypto key.
+func (b *backend) cryptoKeyFailover(ctx context.Context, s logical.Storage, k *Key, pinned bool, retry func(error) bool, fn func(kmsClient keyManagementClient, cryptoKey string) error) (string, error) {
+	ids := []string{k.CryptoKeyID}
+	if !pinned {
+		ids = append(ids, k.FailoverCryptoKeys...)
+	}
+
+	call := func(id string) error {
+		kmsClient, closer, err := b.KeyKMSClient(ctx, s, k.forCryptoKey(id))
+		if err != nil {
+			return err
+		}
+		defer closer()
+		return fn(kmsClient, id)
+	}
+
+	var err error
+	for i, id := range ids {
+		if err = call(id); err == nil {
+			if i > 0 {
+				metrics.IncrCounterWithLabels([]string{metricsPrefix, "key", "failover"}, 1, []metrics.Label{
+					{Name: "key", Value: k.Name},
+				})
+			}
+			return id, nil
+		}
+		if i == len(ids)-1 || !retry(err) {
+			break
+		}
+		b.Logger().Warn("failing over to the next crypto key of the key",
+			"key", k.Name, "crypto_key", id, "next_crypto_key", ids[i+1], "error", err)
+	}
+	return "", err
+}
+
+// forCryptoKey returns the key for one of its crypto keys, which is either its
+// own crypto key or one of its failover crypto keys. The min and max versions
+// and the API endpoint of the key only apply to its own crypto key, so
+// failover crypto keys use the configured or regional endpoint of their own
+// location.
+func (k *Key) forCryptoKey(cryptoKeyID string) *Key {
+	if cryptoKeyID == k.CryptoKeyID {
+		return k
+	}
+	fk := *k
+	fk.CryptoKeyID = cryptoKeyID
+	fk.MinVersion, fk.MaxVersion = 0, 0
+	fk.APIEndpoint = ""
+	return &fk
+}
+
+// validateFailoverCryptoKeys returns an error if the failover crypto keys are
+// not distinct crypto key resource IDs other than the primary crypto key.
+func validateFailoverCryptoKeys(primary string, ids []string) error {
+	if len(strutil.RemoveDuplicates(append([]string(nil), ids...), false)) != len(ids) {
+		return fmt.Errorf("failover_crypto_keys cannot contain duplicates")
+	}
+	for _, id := range ids {
+		if _, err := parseCryptoKeyName(id); err != nil {
+			return err
+		}
+		if id == primary {
+			return fmt.Errorf("failover_crypto_keys cannot contain the primary "+
+				"crypto key %q", primary)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestBackend_CryptoKeyFailover(t *testing.T) {
+
+	k := &Key{
+		Name:               "my-key",
+		CryptoKeyID:        "projects/p/locations/us-east1/keyRings/r/cryptoKeys/k",
+		FailoverCryptoKeys: []string{"projects/p/locations/us-west1/keyRings/r/cryptoKeys/k"},
+	}
+	unavailable := wrapKMSError("failed to encrypt plaintext: {{err}}",
+		grpcstatus.Error(grpccodes.Unavailable, "location unavailable"))
+	denied := grpcstatus.Error(grpccodes.PermissionDenied, "denied")
+
+	cases := []struct {
+		name   string
+		pinned bool
+		errs   []error
+		used   string
+		tried  int
+	}{
+		{"primary", false, []error{nil}, k.CryptoKeyID, 1},
+		{"failover", false, []error{unavailable, nil}, k.FailoverCryptoKeys[0], 2},
+		{"pinned", true, []error{unavailable}, "", 1},
+		{"not_retried", false, []error{denied}, "", 1},
+		{"all_unavailable", false, []error{unavailable, unavailable}, "", 2},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+			testFakeKMSClient(t, b)
+
+			var tried int
+			used, err := b.cryptoKeyFailover(context.Background(), storage, k, tc.pinned, isKMSUnavailable, func(_ keyManagementClient, cryptoKeyID string) error {
+				tried++
+				return tc.errs[tried-1]
+			})
+			if (err != nil) != (tc.used == "") {
+				t.Errorf("expected error to be %t, got %v", tc.used == "", err)
+			}
+			if used != tc.used {
+				t.Errorf("expected %q to be %q", used, tc.used)
+			}
+			if tried != tc.tried {
+				t.Errorf("expected %d to be %d", tried, tc.tried)
+			}
+		})
+	}
+}
+
+func TestBackend_CryptoKeyFailover_Clients(t *testing.T) {
+
+	b, storage := testBackend(t)
+	failoverClient := testFakeKMSClient(t, b)
+
+	// The key's crypto key is pinned to the endpoint of its location, which
+	// the failover crypto key must not use.
+	k := &Key{
+		Name:               "my-key",
+		CryptoKeyID:        "projects/p/locations/us-east1/keyRings/r/cryptoKeys/k",
+		APIEndpoint:        "cloudkms-us-east1.p.googleapis.com:443",
+		FailoverCryptoKeys: []string{"projects/p/locations/us-west1/keyRings/r/cryptoKeys/k"},
+	}
+	primaryClient := newFakeKMSClient()
+	b.kmsClients[clientKey{endpoint: k.APIEndpoint}] = &kmsClientHandle{
+		client:     primaryClient,
+		createTime: time.Now().UTC(),
+		lifetime:   time.Hour,
+	}
+
+	clients := make(map[string]keyManagementClient)
+	if _, err := b.cryptoKeyFailover(context.Background(), storage, k, false, isKMSUnavailable, func(kmsClient keyManagementClient, cryptoKeyID string) error {
+		clients[cryptoKeyID] = kmsClient
+		if cryptoKeyID == k.CryptoKeyID {
+			return grpcstatus.Error(grpccodes.Unavailable, "location unavailable")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if clients[k.CryptoKeyID] != primaryClient {
+		t.Errorf("expected the crypto key to use the client of its endpoint")
+	}
+	if clients[k.FailoverCryptoKeys[0]] != failoverClient {
+		t.Errorf("expected the failover crypto key to use the configured client")
+	}
+}
+
+func TestIsKMSUnreachable(t *testing.T) {
+
+	cases := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{"unavailable", wrapKMSError("failed: {{err}}",
+			grpcstatus.Error(grpccodes.Unavailable, "unavailable")), true},
+		{"deadline", wrapKMSError("failed: {{err}}",
+			grpcstatus.Error(grpccodes.DeadlineExceeded, "deadline")), true},
+		{"context_deadline", context.DeadlineExceeded, true},
+		{"not_found", wrapKMSError("failed: {{err}}",
+			grpcstatus.Error(grpccodes.NotFound, "not found")), false},
+		{"coded", logical.CodedError(400, "bad request"), false},
+	}
+
+	for _, tc := range cases {
+		if v := isKMSUnreachable(tc.err); v != tc.exp {
+			t.Errorf("%s: expected %t to be %t", tc.name, v, tc.exp)
+		}
+	}
+}
+
+func TestValidateFailoverCryptoKeys(t *testing.T) {
+
+	primary := "projects/p/locations/us-east1/keyRings/r/cryptoKeys/k"
+	replica := "projects/p/locations/us-west1/keyRings/r/cryptoKeys/k"
+
+	cases := []struct {
+		name string
+		ids  []string
+		err  bool
+	}{
+		{"empty", nil, false},
+		{"valid", []string{replica}, false},
+		{"primary", []string{primary}, true},
+		{"duplicate", []string{replica, replica}, true},
+		{"invalid", []string{"not-a-crypto-key"}, true},
+	}
+
+	for _, tc := range cases {
+		if err := validateFailoverCryptoKeys(primary, tc.ids); (err != nil) != tc.err {
+			t.Errorf("%s: expected error to be %t, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestPathKeysConfig_FailoverCryptoKeys(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if err := b.putKey(ctx, storage, &Key{
+		Name:        "my-key",
+		CryptoKeyID: "projects/p/locations/us-east1/keyRings/r/cryptoKeys/k",
+		MinVersion:  3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"projects/p/locations/us-west1/keyRings/r/cryptoKeys/k"}
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/config/my-key",
+		Data: map[string]interface{}{
+			"failover_crypto_keys": exp,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := b.Key(ctx, storage, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(k.FailoverCryptoKeys, exp) {
+		t.Errorf("expected %q to be %q", k.FailoverCryptoKeys, exp)
+	}
+
+	fk := k.forCryptoKey(exp[0])
+	if fk.CryptoKeyID != exp[0] || fk.MinVersion != 0 || k.MinVersion != 3 {
+		t.Errorf("expected versions to only apply to the primary: %#v", fk)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// fakeAttestationCA is a self-signed root and its key, standing in for the
+// HSM manufacturer or Google roots in tests.
+type fakeAttestationCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+	pem  string
+}
+
+// fakeAttestationPKI is the partition key and the certificates the fake KMS
+// attests HSM crypto key versions with. It is generated once per test binary,
+// since generating RSA keys is slow.
+var fakeAttestationPKI struct {
+	once sync.Once
+	err  error
+
+	manufacturer *fakeAttestationCA
+	google       *fakeAttestationCA
+	partition    *rsa.PrivateKey
+	chains       *kmspb.KeyOperationAttestation_CertificateChains
+}
+
+// fakeAttestationRoots returns the PEM-encoded manufacturer and Google roots
+// of the fake KMS attestations.
+func fakeAttestationRoots() (string, string, error) {
+	if err := initFakeAttestationPKI(); err != nil {
+		return "", "", err
+	}
+	return fakeAttestationPKI.manufacturer.pem, fakeAttestationPKI.google.pem, nil
+}
+
+func initFakeAttestationPKI() error {
+	p := &fakeAttestationPKI
+	p.once.Do(func() {
+		if p.manufacturer, p.err = newFakeAttestationCA("Fake HSM Manufacturer Root"); p.err != nil {
+			return
+		}
+		if p.google, p.err = newFakeAttestationCA("Fake Google Root"); p.err != nil {
+			return
+		}
+		if p.partition, p.err = rsa.GenerateKey(rand.Reader, 2048); p.err != nil {
+			return
+		}
+
+		// The manufacturer and Google both certify the partition key, and
+		// Google certifies the card the partition is on.
+		mfrPartition, err := fakeAttestationCert(p.manufacturer, "Fake Partition", &p.partition.PublicKey)
+		if err != nil {
+			p.err = err
+			return
+		}
+		googlePartition, err := fakeAttestationCert(p.google, "Fake Partition", &p.partition.PublicKey)
+		if err != nil {
+			p.err = err
+			return
+		}
+		googleCard, err := fakeAttestationCert(p.google, "Fake Card", &p.partition.PublicKey)
+		if err != nil {
+			p.err = err
+			return
+		}
+
+		p.chains = &kmspb.KeyOperationAttestation_CertificateChains{
+			CaviumCerts:          []string{mfrPartition},
+			GoogleCardCerts:      []string{googleCard},
+			GooglePartitionCerts: []string{googlePartition},
+		}
+	})
+	return p.err
+}
+
+func newFakeAttestationCA(cn string) (*fakeAttestationCA, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeAttestationCA{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}, nil
+}
+
+// fakeAttestationCert returns a PEM-encoded leaf certificate for the public
+// key issued by the CA.
+func fakeAttestationCert(ca *fakeAttestationCA, cn string, pub *rsa.PublicKey) (string, error) {
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return "", err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// fakeAttestationAttributes returns the header and key attributes of an
+// attestation, before the signature, with the given CKA_EXTRACTABLE,
+// CKA_LOCAL, and CKA_NEVER_EXTRACTABLE values.
+func fakeAttestationAttributes(extractable, local, neverExtractable byte) []byte {
+	var content bytes.Buffer
+	content.Write(make([]byte, attestationHeaderLength))
+	for _, attr := range []struct {
+		typ   uint32
+		value byte
+	}{
+		{ckaExtractable, extractable},
+		{ckaLocal, local},
+		{ckaNeverExtractable, neverExtractable},
+	} {
+		binary.Write(&content, binary.BigEndian, attr.typ)
+		binary.Write(&content, binary.BigEndian, uint32(1))
+		content.WriteByte(attr.value)
+	}
+	return content.Bytes()
+}
+
+// fakeAttestation returns a compressed attestation of a key generated in the
+// HSM which cannot be extracted, signed by the fake partition key.
+func fakeAttestation() (*kmspb.KeyOperationAttestation, error) {
+	if err := initFakeAttestationPKI(); err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	content.Write(fakeAttestationAttributes(0, 1, 1))
+
+	digest := sha256.Sum256(content.Bytes())
+	sig, err := rsa.SignPKCS1v15(rand.Reader, fakeAttestationPKI.partition, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	content.Write(sig)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(content.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &kmspb.KeyOperationAttestation{
+		Format:     kmspb.KeyOperationAttestation_CAVIUM_V2_COMPRESSED,
+		Content:    compressed.Bytes(),
+		CertChains: fakeAttestationPKI.chains,
+	}, nil
+}
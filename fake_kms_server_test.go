@@ -0,0 +1,317 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	kmsapi "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// fakeKMSProject is the project of the tests when they run against the fake
+// KMS server.
+const fakeKMSProject = "vault-fake-kms"
+
+// TestMain runs the tests against a fake KMS server unless GOOGLE_CLOUD_PROJECT
+// or KMS_EMULATOR_HOST is set, so the tests run without Google Cloud
+// credentials.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	if os.Getenv("GOOGLE_CLOUD_PROJECT") == "" && os.Getenv(kmsEmulatorHostEnv) == "" {
+		addr, stop, err := startFakeKMSServer()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start fake KMS server: %s\n", err)
+			return 1
+		}
+		defer stop()
+
+		os.Setenv(kmsEmulatorHostEnv, addr)
+		os.Setenv("GOOGLE_CLOUD_PROJECT", fakeKMSProject)
+	}
+	return m.Run()
+}
+
+// startFakeKMSServer serves a new fake KMS on a local port. It returns the
+// address of the server and a function which stops it.
+func startFakeKMSServer() (string, func(), error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	srv := &fakeKMSServer{fake: newFakeKMSClient()}
+	s := grpc.NewServer()
+	kmspb.RegisterKeyManagementServiceServer(s, srv)
+	iampb.RegisterIAMPolicyServer(s, srv)
+	locationpb.RegisterLocationsServer(s, srv)
+	go s.Serve(l)
+
+	return l.Addr().String(), s.Stop, nil
+}
+
+// testEmulatorKMSClientOptions returns the options for a test client of the
+// KMS emulator or fake KMS server, if KMS_EMULATOR_HOST is set.
+func testEmulatorKMSClientOptions() []option.ClientOption {
+	if host := os.Getenv(kmsEmulatorHostEnv); host != "" {
+		return emulatorClientOptions(host)
+	}
+	return nil
+}
+
+// testFakeKMSServerClient creates a KMS client of a new fake KMS server, which
+// is stopped when the test finishes.
+func testFakeKMSServerClient(tb testing.TB) *gcpKMSClient {
+	tb.Helper()
+
+	addr, stop, err := startFakeKMSServer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(stop)
+
+	kmsClient, err := kmsapi.NewKeyManagementClient(context.Background(), emulatorClientOptions(addr)...)
+	if err != nil {
+		tb.Fatalf("failed to create kms client: %s", err)
+	}
+	tb.Cleanup(func() { kmsClient.Close() })
+
+	return &gcpKMSClient{kmsClient}
+}
+
+// fakeKMSServer serves a fakeKMSClient over gRPC, so tests against it exercise
+// the real KMS client and the plugin's interceptors. List responses are never
+// paginated.
+type fakeKMSServer struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+	iampb.UnimplementedIAMPolicyServer
+	locationpb.UnimplementedLocationsServer
+
+	fake *fakeKMSClient
+}
+
+// listAll returns every result of a list iterator of the fake.
+func listAll[T any](it interface{ Next() (T, error) }) ([]T, error) {
+	var items []T
+	for {
+		item, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				return items, nil
+			}
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (s *fakeKMSServer) ListKeyRings(ctx context.Context, req *kmspb.ListKeyRingsRequest) (*kmspb.ListKeyRingsResponse, error) {
+	krs, err := listAll[*kmspb.KeyRing](s.fake.ListKeyRings(ctx, req))
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.ListKeyRingsResponse{KeyRings: krs, TotalSize: int32(len(krs))}, nil
+}
+
+func (s *fakeKMSServer) ListCryptoKeys(ctx context.Context, req *kmspb.ListCryptoKeysRequest) (*kmspb.ListCryptoKeysResponse, error) {
+	cks, err := listAll[*kmspb.CryptoKey](s.fake.ListCryptoKeys(ctx, req))
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.ListCryptoKeysResponse{CryptoKeys: cks, TotalSize: int32(len(cks))}, nil
+}
+
+func (s *fakeKMSServer) ListCryptoKeyVersions(ctx context.Context, req *kmspb.ListCryptoKeyVersionsRequest) (*kmspb.ListCryptoKeyVersionsResponse, error) {
+	ckvs, err := listAll[*kmspb.CryptoKeyVersion](s.fake.ListCryptoKeyVersions(ctx, req))
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.ListCryptoKeyVersionsResponse{CryptoKeyVersions: ckvs, TotalSize: int32(len(ckvs))}, nil
+}
+
+func (s *fakeKMSServer) GetKeyRing(ctx context.Context, req *kmspb.GetKeyRingRequest) (*kmspb.KeyRing, error) {
+	return s.fake.GetKeyRing(ctx, req)
+}
+
+func (s *fakeKMSServer) CreateKeyRing(ctx context.Context, req *kmspb.CreateKeyRingRequest) (*kmspb.KeyRing, error) {
+	return s.fake.CreateKeyRing(ctx, req)
+}
+
+func (s *fakeKMSServer) GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	return s.fake.GetCryptoKey(ctx, req)
+}
+
+func (s *fakeKMSServer) CreateCryptoKey(ctx context.Context, req *kmspb.CreateCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	return s.fake.CreateCryptoKey(ctx, req)
+}
+
+func (s *fakeKMSServer) UpdateCryptoKey(ctx context.Context, req *kmspb.UpdateCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	return s.fake.UpdateCryptoKey(ctx, req)
+}
+
+func (s *fakeKMSServer) UpdateCryptoKeyPrimaryVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyPrimaryVersionRequest) (*kmspb.CryptoKey, error) {
+	return s.fake.UpdateCryptoKeyPrimaryVersion(ctx, req)
+}
+
+func (s *fakeKMSServer) GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.fake.GetCryptoKeyVersion(ctx, req)
+}
+
+func (s *fakeKMSServer) CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.fake.CreateCryptoKeyVersion(ctx, req)
+}
+
+func (s *fakeKMSServer) UpdateCryptoKeyVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.fake.UpdateCryptoKeyVersion(ctx, req)
+}
+
+func (s *fakeKMSServer) DestroyCryptoKeyVersion(ctx context.Context, req *kmspb.DestroyCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.fake.DestroyCryptoKeyVersion(ctx, req)
+}
+
+func (s *fakeKMSServer) RestoreCryptoKeyVersion(ctx context.Context, req *kmspb.RestoreCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	return s.fake.RestoreCryptoKeyVersion(ctx, req)
+}
+
+func (s *fakeKMSServer) GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest) (*kmspb.PublicKey, error) {
+	return s.fake.GetPublicKey(ctx, req)
+}
+
+func (s *fakeKMSServer) Encrypt(ctx context.Context, req *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error) {
+	return s.fake.Encrypt(ctx, req)
+}
+
+func (s *fakeKMSServer) Decrypt(ctx context.Context, req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+	return s.fake.Decrypt(ctx, req)
+}
+
+func (s *fakeKMSServer) AsymmetricSign(ctx context.Context, req *kmspb.AsymmetricSignRequest) (*kmspb.AsymmetricSignResponse, error) {
+	return s.fake.AsymmetricSign(ctx, req)
+}
+
+func (s *fakeKMSServer) AsymmetricDecrypt(ctx context.Context, req *kmspb.AsymmetricDecryptRequest) (*kmspb.AsymmetricDecryptResponse, error) {
+	return s.fake.AsymmetricDecrypt(ctx, req)
+}
+
+func (s *fakeKMSServer) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest) (*iampb.Policy, error) {
+	return s.fake.GetIamPolicy(ctx, req)
+}
+
+func (s *fakeKMSServer) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest) (*iampb.Policy, error) {
+	return s.fake.SetIamPolicy(ctx, req)
+}
+
+func (s *fakeKMSServer) TestIamPermissions(ctx context.Context, req *iampb.TestIamPermissionsRequest) (*iampb.TestIamPermissionsResponse, error) {
+	return s.fake.TestIamPermissions(ctx, req)
+}
+
+func (s *fakeKMSServer) ListLocations(ctx context.Context, req *locationpb.ListLocationsRequest) (*locationpb.ListLocationsResponse, error) {
+	locations, err := listAll[*locationpb.Location](s.fake.ListLocations(ctx, req))
+	if err != nil {
+		return nil, err
+	}
+	return &locationpb.ListLocationsResponse{Locations: locations}, nil
+}
+
+func TestFakeKMSServer(t *testing.T) {
+
+	kmsClient := testFakeKMSServerClient(t)
+
+	ctx := context.Background()
+	kr, err := kmsClient.CreateKeyRing(ctx, &kmspb.CreateKeyRingRequest{
+		Parent:    "projects/p/locations/us-east1",
+		KeyRingId: "r",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ck, err := kmsClient.CreateCryptoKey(ctx, &kmspb.CreateCryptoKeyRequest{
+		Parent:      kr.Name,
+		CryptoKeyId: "k",
+		CryptoKey: &kmspb.CryptoKey{
+			Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("encrypt_decrypt", func(t *testing.T) {
+
+		enc, err := kmsClient.Encrypt(ctx, &kmspb.EncryptRequest{
+			Name:      ck.Name,
+			Plaintext: []byte("hello world"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := kmsClient.Decrypt(ctx, &kmspb.DecryptRequest{
+			Name:       ck.Name,
+			Ciphertext: enc.Ciphertext,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := string(dec.Plaintext), "hello world"; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+	})
+
+	t.Run("disabled_version", func(t *testing.T) {
+
+		if _, err := kmsClient.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+			Parent: ck.Name,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kmsClient.DestroyCryptoKeyVersion(ctx, &kmspb.DestroyCryptoKeyVersionRequest{
+			Name: ck.Name + "/cryptoKeyVersions/2",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := kmsClient.Encrypt(ctx, &kmspb.EncryptRequest{
+			Name:      ck.Name + "/cryptoKeyVersions/2",
+			Plaintext: []byte("hello world"),
+		})
+		if grpcstatus.Code(err) != grpccodes.FailedPrecondition {
+			t.Errorf("expected failed precondition, got %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+
+		ckvs, err := listAll[*kmspb.CryptoKeyVersion](kmsClient.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
+			Parent: ck.Name,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := len(ckvs), 2; v != exp {
+			t.Errorf("expected %d to be %d", v, exp)
+		}
+
+		locations, err := listLocations(ctx, kmsClient, "p")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := fmt.Sprint(locations), "[global us-east1]"; v != exp {
+			t.Errorf("expected %s to be %s", v, exp)
+		}
+	})
+}
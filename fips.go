@@ -0,0 +1,112 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// fipsAlgorithms is the set of FIPS-approved algorithms. Raw PKCS#1 signing,
+// the secp256k1 and Ed25519 curves, and SHA-1 digests are not approved.
+var fipsAlgorithms = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]bool{
+	kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION:  true,
+	kmspb.CryptoKeyVersion_AES_128_GCM:                  true,
+	kmspb.CryptoKeyVersion_AES_256_GCM:                  true,
+	kmspb.CryptoKeyVersion_AES_128_CBC:                  true,
+	kmspb.CryptoKeyVersion_AES_256_CBC:                  true,
+	kmspb.CryptoKeyVersion_AES_128_CTR:                  true,
+	kmspb.CryptoKeyVersion_AES_256_CTR:                  true,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256:     true,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256:     true,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:     true,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA512:     true,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256:   true,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256:   true,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256:   true,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA512:   true,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256: true,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256: true,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256: true,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512: true,
+	kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:          true,
+	kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:          true,
+	kmspb.CryptoKeyVersion_HMAC_SHA224:                  true,
+	kmspb.CryptoKeyVersion_HMAC_SHA256:                  true,
+	kmspb.CryptoKeyVersion_HMAC_SHA384:                  true,
+	kmspb.CryptoKeyVersion_HMAC_SHA512:                  true,
+}
+
+// checkFIPS returns an error if the protection level and algorithm are not
+// allowed in FIPS mode, which requires HSM keys using a FIPS-approved
+// algorithm.
+func checkFIPS(p kmspb.ProtectionLevel, a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) error {
+	if p != kmspb.ProtectionLevel_HSM {
+		return logical.CodedError(400, fmt.Sprintf("protection level %q is not "+
+			"allowed by FIPS enforcement, keys must use the %q protection level",
+			policyProtectionLevelName(p), "hsm"))
+	}
+	if !fipsAlgorithms[a] {
+		return errNotFIPSApproved(a)
+	}
+	return nil
+}
+
+// errNotFIPSApproved is a logical coded error that is returned when FIPS
+// enforcement is enabled and the algorithm is not FIPS-approved.
+func errNotFIPSApproved(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) error {
+	return logical.CodedError(400, fmt.Sprintf("algorithm %q is not "+
+		"FIPS-approved and is not allowed by FIPS enforcement", policyAlgorithmName(a)))
+}
+
+// checkCryptoKeyFIPS returns an error if the crypto key's version template or
+// primary version is not allowed in FIPS mode.
+func checkCryptoKeyFIPS(ck *kmspb.CryptoKey) error {
+	t := ck.GetVersionTemplate()
+	if err := checkFIPS(t.GetProtectionLevel(), t.GetAlgorithm()); err != nil {
+		return err
+	}
+	if p := ck.GetPrimary(); p != nil {
+		return checkFIPS(p.ProtectionLevel, p.Algorithm)
+	}
+	return nil
+}
+
+// checkKeyVersionFIPS returns an error if FIPS enforcement is enabled and a
+// crypto key version of the named key with the given protection level and
+// algorithm may not be used in FIPS mode.
+func (b *backend) checkKeyVersionFIPS(ctx context.Context, s logical.Storage, key string, p kmspb.ProtectionLevel, a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) error {
+	settings, err := b.settings(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !settings.fipsEnforcement {
+		return nil
+	}
+	if err := checkFIPS(p, a); err != nil {
+		return logical.CodedError(400, fmt.Sprintf("key %q cannot be used: %s", key, err))
+	}
+	return nil
+}
+
+// checkKeyFIPS returns an error if FIPS enforcement is enabled and the crypto
+// key of the named key may not be used in FIPS mode. Keys registered before
+// FIPS enforcement was enabled are checked on each use.
+func (b *backend) checkKeyFIPS(ctx context.Context, s logical.Storage, key string, ck *kmspb.CryptoKey) error {
+	settings, err := b.settings(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !settings.fipsEnforcement {
+		return nil
+	}
+	if err := checkCryptoKeyFIPS(ck); err != nil {
+		return logical.CodedError(400, fmt.Sprintf("key %q cannot be used: %s", key, err))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestFIPSAlgorithms(t *testing.T) {
+
+	// Every FIPS-approved algorithm must be defined by the KMS API version
+	// this plugin is built against.
+	for a := range fipsAlgorithms {
+		if _, ok := kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm_name[int32(a)]; !ok || a == kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED {
+			t.Errorf("algorithm %d is not a defined crypto key version algorithm", a)
+		}
+	}
+}
+
+func TestCheckCryptoKeyFIPS(t *testing.T) {
+
+	cases := []struct {
+		name            string
+		protectionLevel kmspb.ProtectionLevel
+		algorithm       kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+		primary         *kmspb.CryptoKeyVersion
+		err             bool
+	}{
+		{"hsm_symmetric", kmspb.ProtectionLevel_HSM, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION, nil, false},
+		{"hsm_p256", kmspb.ProtectionLevel_HSM, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256, nil, false},
+		{"software", kmspb.ProtectionLevel_SOFTWARE, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION, nil, true},
+		{"raw_pkcs1", kmspb.ProtectionLevel_HSM, kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_2048, nil, true},
+		{"secp256k1", kmspb.ProtectionLevel_HSM, kmspb.CryptoKeyVersion_EC_SIGN_SECP256K1_SHA256, nil, true},
+		{"oaep_sha1", kmspb.ProtectionLevel_HSM, kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA1, nil, true},
+		{
+			"software_primary",
+			kmspb.ProtectionLevel_HSM,
+			kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+			&kmspb.CryptoKeyVersion{
+				ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+				Algorithm:       kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+			},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			ck := &kmspb.CryptoKey{
+				Primary: tc.primary,
+				VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+					ProtectionLevel: tc.protectionLevel,
+					Algorithm:       tc.algorithm,
+				},
+			}
+			if err := checkCryptoKeyFIPS(ck); (err != nil) != tc.err {
+				t.Errorf("expected error to be %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestConfig_CheckAlgorithmFIPS(t *testing.T) {
+
+	c := &Config{FIPSEnforcement: true}
+	if err := c.checkAlgorithm(kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := c.checkAlgorithm(kmspb.CryptoKeyVersion_EC_SIGN_ED25519); err == nil {
+		t.Error("expected an error for a non-FIPS-approved algorithm")
+	}
+}
+
+func TestBackend_CheckKeyFIPS(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	software := &kmspb.CryptoKey{
+		VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+			Algorithm:       kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+		},
+	}
+
+	ctx := context.Background()
+	if err := b.checkKeyFIPS(ctx, storage, "my-key", software); err != nil {
+		t.Errorf("expected no error without FIPS enforcement, got %v", err)
+	}
+
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"fips_enforcement": true,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := b.checkKeyFIPS(ctx, storage, "my-key", software)
+	if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+		t.Errorf("expected %q to be a 400", err)
+	}
+}
@@ -5,14 +5,21 @@ package gcpkms
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/jeffchao/backoff"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 // withFieldValidator wraps an OperationFunc and validates the user-supplied
@@ -26,6 +33,21 @@ func withFieldValidator(f framework.OperationFunc) framework.OperationFunc {
 	}
 }
 
+// wrapPathCallbacks replaces the callback of every operation of the paths with
+// the result of wrap.
+func wrapPathCallbacks(paths []*framework.Path, wrap func(*framework.Path, framework.OperationFunc) framework.OperationFunc) {
+	for _, p := range paths {
+		for op, f := range p.Callbacks {
+			p.Callbacks[op] = wrap(p, f)
+		}
+		for _, h := range p.Operations {
+			if po, ok := h.(*framework.PathOperation); ok {
+				po.Callback = wrap(p, po.Callback)
+			}
+		}
+	}
+}
+
 // validateFields verifies that no bad arguments were given to the request.
 func validateFields(req *logical.Request, data *framework.FieldData) error {
 	var unknownFields []string
@@ -69,3 +91,72 @@ func retryExp(op func() error) error {
 	f.MaxRetries = 10
 	return f.Retry(op)
 }
+
+// kmsPermissionRegex extracts the missing IAM permission from a Google Cloud
+// KMS permission denied message.
+var kmsPermissionRegex = regexp.MustCompile(`Permission '([^']+)' denied`)
+
+// wrapKMSError wraps an error returned by the Google Cloud KMS API like
+// errwrap.Wrapf, translating well-known gRPC status codes into coded errors so
+// Vault responds with a meaningful HTTP status instead of a 500. Errors which
+// do not carry one of these codes are wrapped as-is.
+func wrapKMSError(format string, err error) error {
+	var terr *throttledError
+	if errors.As(err, &terr) {
+		return logical.CodedError(429, strings.Replace(format, "{{err}}", terr.Error(), -1))
+	}
+
+	s, ok := grpcstatus.FromError(err)
+	if !ok {
+		return errwrap.Wrapf(format, err)
+	}
+
+	msg := strings.Replace(format, "{{err}}", s.Message(), -1)
+
+	switch s.Code() {
+	case grpccodes.PermissionDenied:
+		permission := ""
+		for _, d := range s.Details() {
+			if info, ok := d.(*errdetails.ErrorInfo); ok && info.Metadata["permission"] != "" {
+				permission = info.Metadata["permission"]
+			}
+		}
+		if permission == "" {
+			if m := kmsPermissionRegex.FindStringSubmatch(s.Message()); len(m) > 1 {
+				permission = m[1]
+			}
+		}
+
+		if permission != "" {
+			msg += fmt.Sprintf(" (the configured credentials are missing the %q permission)", permission)
+		} else {
+			msg += " (check the IAM permissions of the configured credentials)"
+		}
+		return logical.CodedError(403, msg)
+	case grpccodes.NotFound:
+		for _, d := range s.Details() {
+			if info, ok := d.(*errdetails.ResourceInfo); ok && info.ResourceName != "" {
+				msg += fmt.Sprintf(" (resource %q does not exist)", info.ResourceName)
+			}
+		}
+		return logical.CodedError(404, msg)
+	case grpccodes.ResourceExhausted:
+		retryAfter := ""
+		for _, d := range s.Details() {
+			if info, ok := d.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+				retryAfter = info.RetryDelay.AsDuration().String()
+			}
+		}
+
+		if retryAfter != "" {
+			msg += fmt.Sprintf(" (quota exceeded, retry after %s)", retryAfter)
+		} else {
+			msg += " (quota exceeded, retry the request later)"
+		}
+		return logical.CodedError(429, msg)
+	case grpccodes.DeadlineExceeded:
+		return logical.CodedError(504, msg)
+	}
+
+	return errwrap.Wrapf(format, err)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestWrapKMSError(t *testing.T) {
+
+	withDetails := func(s *grpcstatus.Status, details ...protoadapt.MessageV1) error {
+		s, err := s.WithDetails(details...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Err()
+	}
+
+	cases := []struct {
+		name     string
+		err      error
+		code     int
+		contains string
+	}{
+		{
+			"not_grpc",
+			errors.New("boom"),
+			0,
+			"failed to encrypt: boom",
+		},
+		{
+			"unmapped_code",
+			grpcstatus.Error(grpccodes.Internal, "boom"),
+			0,
+			"boom",
+		},
+		{
+			"permission_denied_message",
+			grpcstatus.Error(grpccodes.PermissionDenied,
+				"Permission 'cloudkms.cryptoKeyVersions.useToEncrypt' denied on resource"),
+			403,
+			`"cloudkms.cryptoKeyVersions.useToEncrypt" permission`,
+		},
+		{
+			"permission_denied_details",
+			withDetails(grpcstatus.New(grpccodes.PermissionDenied, "denied"),
+				&errdetails.ErrorInfo{
+					Metadata: map[string]string{
+						"permission": "cloudkms.cryptoKeys.get",
+					},
+				}),
+			403,
+			`"cloudkms.cryptoKeys.get" permission`,
+		},
+		{
+			"permission_denied_unknown",
+			grpcstatus.Error(grpccodes.PermissionDenied, "denied"),
+			403,
+			"check the IAM permissions",
+		},
+		{
+			"not_found",
+			withDetails(grpcstatus.New(grpccodes.NotFound, "not found"),
+				&errdetails.ResourceInfo{
+					ResourceName: "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+				}),
+			404,
+			`resource "projects/p/locations/l/keyRings/r/cryptoKeys/k" does not exist`,
+		},
+		{
+			"resource_exhausted",
+			withDetails(grpcstatus.New(grpccodes.ResourceExhausted, "quota"),
+				&errdetails.RetryInfo{
+					RetryDelay: durationpb.New(30 * time.Second),
+				}),
+			429,
+			"retry after 30s",
+		},
+		{
+			"throttled",
+			&throttledError{retryAfter: 5 * time.Second},
+			429,
+			"retry after 5s",
+		},
+		{
+			"deadline_exceeded",
+			grpcstatus.Error(grpccodes.DeadlineExceeded, "too slow"),
+			504,
+			"failed to encrypt: too slow",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			err := wrapKMSError("failed to encrypt: {{err}}", tc.err)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			code := 0
+			if cerr, ok := err.(logical.HTTPCodedError); ok {
+				code = cerr.Code()
+			}
+			if code != tc.code {
+				t.Errorf("expected %d to be %d", code, tc.code)
+			}
+			if !strings.Contains(err.Error(), tc.contains) {
+				t.Errorf("expected %q to contain %q", err, tc.contains)
+			}
+		})
+	}
+}
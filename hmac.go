@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// responseHMACKeyPath is the storage path of the mount's key for HMACs in
+	// responses. The key is generated once and never leaves storage.
+	responseHMACKeyPath = "hmac_key"
+
+	// responseHMACPrefix is the prefix of HMACs in responses, naming the
+	// algorithm.
+	responseHMACPrefix = "hmac-sha256:"
+)
+
+// responseHMACKey returns the mount's key for HMACs in responses, generating
+// and saving it if it does not exist yet.
+func (b *backend) responseHMACKey(ctx context.Context, s logical.Storage) ([]byte, error) {
+	b.hmacKeyLock.Lock()
+	defer b.hmacKeyLock.Unlock()
+
+	if b.hmacKey != nil {
+		return b.hmacKey, nil
+	}
+
+	entry, err := s.Get(ctx, responseHMACKeyPath)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read HMAC key: {{err}}", err)
+	}
+	if entry != nil {
+		b.hmacKey = entry.Value
+		return b.hmacKey, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errwrap.Wrapf("failed to generate HMAC key: {{err}}", err)
+	}
+	if err := s.Put(ctx, &logical.StorageEntry{
+		Key:   responseHMACKeyPath,
+		Value: key,
+	}); err != nil {
+		return nil, errwrap.Wrapf("failed to save HMAC key: {{err}}", err)
+	}
+	b.hmacKey = key
+	return b.hmacKey, nil
+}
+
+// addResponseHMACs adds an HMAC of each of the values to the response, under
+// the value's name with an "_hmac" suffix, if the config enables HMACs in
+// responses. The same value always has the same HMAC within a mount, so
+// operations on the same data can be correlated across audit logs without
+// logging the data itself.
+func (b *backend) addResponseHMACs(ctx context.Context, s logical.Storage, resp *logical.Response, values map[string][]byte) error {
+	c, err := b.Config(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !c.IncludeHMAC {
+		return nil
+	}
+
+	key, err := b.responseHMACKey(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	for name, v := range values {
+		resp.Data[name+"_hmac"] = responseHMAC(key, v)
+	}
+	return nil
+}
+
+// responseHMAC returns the HMAC of the value with the key.
+func responseHMAC(key, v []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write(v)
+	return responseHMACPrefix + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_AddResponseHMACs(t *testing.T) {
+
+	t.Run("disabled", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		resp := &logical.Response{Data: map[string]interface{}{}}
+		if err := b.addResponseHMACs(context.Background(), storage, resp, map[string][]byte{
+			"plaintext": []byte("hello"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := resp.Data["plaintext_hmac"]; ok {
+			t.Errorf("expected no HMAC")
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"include_hmac": true,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The key is generated when HMACs are enabled
+		entry, err := storage.Get(ctx, responseHMACKeyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil || len(entry.Value) != 32 {
+			t.Fatalf("expected a 32 byte key, got %#v", entry)
+		}
+
+		hmacs := func(b *backend) map[string]interface{} {
+			t.Helper()
+
+			resp := &logical.Response{Data: map[string]interface{}{}}
+			if err := b.addResponseHMACs(ctx, storage, resp, map[string][]byte{
+				"plaintext":  []byte("hello"),
+				"ciphertext": []byte("world"),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			return resp.Data
+		}
+
+		first := hmacs(b)
+		for _, name := range []string{"plaintext_hmac", "ciphertext_hmac"} {
+			if v, ok := first[name].(string); !ok || !strings.HasPrefix(v, responseHMACPrefix) {
+				t.Errorf("expected %q to be an HMAC, got %#v", name, first[name])
+			}
+		}
+		if first["plaintext_hmac"] == first["ciphertext_hmac"] {
+			t.Errorf("expected HMACs of different values to differ")
+		}
+
+		// Another backend on the same mount computes the same HMACs
+		b2, _ := testBackend(t)
+		second := hmacs(b2)
+		if first["plaintext_hmac"] != second["plaintext_hmac"] {
+			t.Errorf("expected %q to be %q", second["plaintext_hmac"], first["plaintext_hmac"])
+		}
+	})
+}
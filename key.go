@@ -5,17 +5,86 @@ package gcpkms
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
 	"fmt"
+	"hash"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iterator"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 var (
 	ErrKeyNotFound = errors.New("encryption key not found")
+
+	// cryptoKeyNameRegex matches the full resource ID of a crypto key.
+	cryptoKeyNameRegex = regexp.MustCompile(
+		`^projects/([^/]+)/locations/([^/]+)/keyRings/([^/]+)/cryptoKeys/([^/]+)$`)
+
+	// keyRingNameRegex matches the full resource ID of a key ring.
+	keyRingNameRegex = regexp.MustCompile(
+		`^projects/([^/]+)/locations/([^/]+)/keyRings/([^/]+)$`)
+
+	// projectIDRegex matches a Google Cloud project ID, optionally scoped to a
+	// domain (e.g. "example.com:my-project").
+	projectIDRegex = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+	// locationIDRegex matches a Google Cloud location ID.
+	locationIDRegex = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+	// resourceIDRegex matches a key ring or crypto key ID.
+	resourceIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,63}$`)
 )
 
+// reservedKeyNames are the names under keys/ which are not keys, so keys
+// cannot be given them.
+var reservedKeyNames = map[string]bool{
+	"alias":        true,
+	"deregistered": true,
+	"orphans":      true,
+	"register-all": true,
+	"rotate-all":   true,
+	"trim":         true,
+}
+
+// checkKeyName returns an error if the name is reserved.
+func checkKeyName(name string) error {
+	if reservedKeyNames[name] {
+		return logical.CodedError(400, fmt.Sprintf("%q is reserved and cannot be used as a key name", name))
+	}
+	return nil
+}
+
+// checkNewKeyName returns an error if the name is reserved or is the name of
+// an alias, since the key would shadow the alias.
+func (b *backend) checkNewKeyName(ctx context.Context, s logical.Storage, name string) error {
+	if err := checkKeyName(name); err != nil {
+		return err
+	}
+	a, err := b.keyAlias(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	if a != nil {
+		return logical.CodedError(400, fmt.Sprintf(
+			"an alias named %q points to key %q, keys cannot shadow aliases", name, a.Key))
+	}
+	return nil
+}
+
 // Key represents a key from the storage backend.
 type Key struct {
 	// Name is the name of the key in Vault.
@@ -31,6 +100,386 @@ type Key struct {
 	// MaxVersion is the maximum crypto key version to allow. If left unset or set
 	// to a negative number, all versions are allowed.
 	MaxVersion int `json:"max_version"`
+
+	// DeletionProtection prevents the key from being deleted, deregistered, or
+	// trimmed until it is disabled.
+	DeletionProtection bool `json:"deletion_protection"`
+
+	// AutoTrim enables periodic trimming of crypto key versions which fall
+	// outside of KeepVersions and MaxVersionAge.
+	AutoTrim bool `json:"auto_trim"`
+
+	// AutoTrimAction is the trim action used by automatic trimming. If unset,
+	// versions are scheduled for destruction.
+	AutoTrimAction string `json:"auto_trim_action,omitempty"`
+
+	// KeepVersions is the number of newest crypto key versions retained by
+	// automatic trimming.
+	KeepVersions int `json:"keep_versions,omitempty"`
+
+	// MaxVersionAge is the age after which crypto key versions are trimmed by
+	// automatic trimming.
+	MaxVersionAge time.Duration `json:"max_version_age,omitempty"`
+
+	// RotationSchedule is the period at which Vault rotates the crypto key. If
+	// unset, Vault does not rotate the crypto key on a schedule.
+	RotationSchedule time.Duration `json:"rotation_schedule,omitempty"`
+
+	// RotationWindow is the amount of time after NextRotation in which a
+	// scheduled rotation may still occur. If a rotation is missed by more than
+	// this, it is skipped until the following period. If unset, a missed
+	// rotation occurs as soon as possible.
+	RotationWindow time.Duration `json:"rotation_window,omitempty"`
+
+	// LastRotated and NextRotation are the times of the last rotation and of
+	// the next scheduled rotation.
+	LastRotated  time.Time `json:"last_rotated"`
+	NextRotation time.Time `json:"next_rotation"`
+
+	// AutoBumpMinVersion raises MinVersion after each rotation to the new
+	// version less MinVersionLag.
+	AutoBumpMinVersion bool `json:"auto_bump_min_version"`
+	MinVersionLag      int  `json:"min_version_lag,omitempty"`
+
+	// RequireAAD requires additional authenticated data on encrypt, decrypt,
+	// and reencrypt. AllowedAADRegex, if set, is a regular expression which
+	// the additional authenticated data must match.
+	RequireAAD      bool   `json:"require_aad"`
+	AllowedAADRegex string `json:"allowed_aad_regex,omitempty"`
+
+	// AllowedDigestAlgorithms, if set, are the digest algorithms accepted by
+	// sign. MinDigestLength, if set, is the minimum length in bits of digests
+	// accepted by sign.
+	AllowedDigestAlgorithms []string `json:"allowed_digest_algorithms,omitempty"`
+	MinDigestLength         int      `json:"min_digest_length,omitempty"`
+
+	// APIEndpoint is the host and port of the KMS API used for this key. If
+	// unset, the endpoint from the config is used.
+	APIEndpoint string `json:"api_endpoint,omitempty"`
+
+	// ImpersonateServiceAccount is the service account impersonated for calls
+	// on this key, so keys in other projects can use their own service
+	// accounts. If unset, the credentials from the config are used.
+	ImpersonateServiceAccount string `json:"impersonate_service_account,omitempty"`
+
+	// ConfigName is the name of the config profile used for calls on this key.
+	// If unset, the config is used.
+	ConfigName string `json:"config_name,omitempty"`
+
+	// ResponseWrapping is the policy for responses which return plaintext
+	// from this key. If unset, the policy from the config is used.
+	ResponseWrapping string `json:"response_wrapping,omitempty"`
+
+	// FailoverCryptoKeys are the full resource IDs of crypto keys in other
+	// locations, in the order they are failed over to when the location of
+	// CryptoKeyID is unavailable.
+	FailoverCryptoKeys []string `json:"failover_crypto_keys,omitempty"`
+
+	// SignerCertificate is the PEM-encoded certificate of the key's public key
+	// which identifies the signer of CMS signatures. If unset, CMS signatures
+	// identify the signer by subject key identifier.
+	SignerCertificate string `json:"signer_certificate,omitempty"`
+
+	// OfflineVerification allows verify to use cached public keys when KMS is
+	// unreachable.
+	OfflineVerification bool `json:"offline_verification,omitempty"`
+
+	// EnvelopeRecipients are the names of the keys which encrypt may also
+	// encrypt this key's plaintext under with additional_keys.
+	EnvelopeRecipients []string `json:"envelope_recipients,omitempty"`
+
+	// KeyHandle is the resource name of the Autokey key handle which
+	// provisioned the crypto key, if the key was created with Autokey.
+	KeyHandle string `json:"key_handle,omitempty"`
+
+	// Version is incremented each time the key is saved, and is returned as
+	// cas_version for check-and-set on writes.
+	Version int `json:"version"`
+
+	// Expected is the state of the crypto key when it was last created,
+	// registered, or updated through Vault, against which changes made
+	// outside of Vault are detected. It is nil if the state is not known.
+	Expected *keyExpectation `json:"expected,omitempty"`
+}
+
+// applyRotation updates the key's rotation schedule and min version after the
+// given crypto key version was created by a rotation. It returns true if the
+// key was changed.
+func (k *Key) applyRotation(ckv *kmspb.CryptoKeyVersion, now time.Time) bool {
+	var changed bool
+
+	if k.RotationSchedule > 0 {
+		k.LastRotated = now
+		k.NextRotation = now.Add(k.RotationSchedule)
+		changed = true
+	}
+
+	// Only ever raise the min version, and never past the max version or to
+	// a version which cannot be used yet, which would leave no usable version
+	if k.AutoBumpMinVersion {
+		version := versionNumber(ckv.Name)
+		v := version - k.MinVersionLag
+		switch {
+		case v <= k.MinVersion || v <= 0:
+		case k.MaxVersion > 0 && v > k.MaxVersion:
+		case v == version && ckv.State == kmspb.CryptoKeyVersion_PENDING_GENERATION:
+		default:
+			k.MinVersion = v
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// checkCAS returns an error if the request sets cas and it does not match the
+// version of the key, which is nil if the key does not exist yet.
+func checkCAS(k *Key, d *framework.FieldData) error {
+	cas, ok := d.GetOk("cas")
+	if !ok {
+		return nil
+	}
+
+	var version int
+	if k != nil {
+		version = k.Version
+	}
+	if cas.(int) != version {
+		return logical.CodedError(400, fmt.Sprintf("check-and-set parameter "+
+			"%d did not match the current cas_version %d of the key", cas.(int), version))
+	}
+	return nil
+}
+
+// autoTrimAction returns the trim action to use when automatically trimming
+// the key.
+func (k *Key) autoTrimAction() string {
+	if k.AutoTrimAction == "" {
+		return trimActionDestroySchedule
+	}
+	return k.AutoTrimAction
+}
+
+// checkVersion returns an error response if the crypto key version is outside
+// of the key's min and max versions.
+func (k *Key) checkVersion(keyVersion int) (*logical.Response, error) {
+	if k.MinVersion > 0 && keyVersion < k.MinVersion {
+		resp := fmt.Sprintf("requested version %d is less than minimum allowed version of %d",
+			keyVersion, k.MinVersion)
+		return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	}
+
+	if k.MaxVersion > 0 && keyVersion > k.MaxVersion {
+		resp := fmt.Sprintf("requested version %d is greater than maximum allowed version of %d",
+			keyVersion, k.MaxVersion)
+		return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	}
+
+	return nil, nil
+}
+
+// encryptCryptoKey returns the name of the crypto key or version to encrypt
+// with when no version is requested. KMS encrypts with the primary version
+// regardless of the key's min and max versions, so if the key has either, the
+// primary version is named explicitly and must be within them.
+func (k *Key) encryptCryptoKey(cryptoKeyID string, ck *kmspb.CryptoKey) (string, error) {
+	if k.MinVersion <= 0 && k.MaxVersion <= 0 {
+		return cryptoKeyID, nil
+	}
+	if ck.Primary == nil {
+		return "", logical.CodedError(400, fmt.Sprintf("crypto key %q has no primary version", cryptoKeyID))
+	}
+
+	primary := versionNumber(ck.Primary.Name)
+	if (k.MinVersion > 0 && primary < k.MinVersion) || (k.MaxVersion > 0 && primary > k.MaxVersion) {
+		return "", logical.CodedError(403, fmt.Sprintf("primary version %d of key %q "+
+			"is outside of its min_version and max_version, rotate the key or give "+
+			"a key_version", primary, k.Name))
+	}
+	return fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKeyID, primary), nil
+}
+
+// checkAAD returns an error response if the additional authenticated data does
+// not satisfy the key's AAD policy.
+func (k *Key) checkAAD(aad string) (*logical.Response, error) {
+	if k.RequireAAD && aad == "" {
+		resp := fmt.Sprintf("key %q requires additional_authenticated_data", k.Name)
+		return logical.ErrorResponse(resp), logical.ErrInvalidRequest
+	}
+
+	if k.AllowedAADRegex != "" && aad != "" {
+		re, err := regexp.Compile(k.AllowedAADRegex)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to compile allowed_aad_regex: {{err}}", err)
+		}
+		if !re.MatchString(aad) {
+			resp := fmt.Sprintf("additional_authenticated_data is not allowed for key %q", k.Name)
+			return logical.ErrorResponse(resp), logical.ErrInvalidRequest
+		}
+	}
+
+	return nil, nil
+}
+
+// digestAlgorithms are the names of the digest algorithms accepted by sign,
+// and the length of their digests in bits.
+var digestAlgorithms = map[string]int{
+	"sha1":   160,
+	"sha256": 256,
+	"sha384": 384,
+	"sha512": 512,
+}
+
+// digestAlgorithmNames returns the sorted names of the digest algorithms.
+func digestAlgorithmNames() []string {
+	list := make([]string, 0, len(digestAlgorithms))
+	for k := range digestAlgorithms {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// hasDigestPolicy returns true if the key restricts the digests it signs.
+func (k *Key) hasDigestPolicy() bool {
+	return len(k.AllowedDigestAlgorithms) > 0 || k.MinDigestLength > 0
+}
+
+// hashDigest returns the digest of the message using the named digest
+// algorithm, which must be one of digestAlgorithms.
+func hashDigest(algorithm string, msg []byte) []byte {
+	var h hash.Hash
+	switch algorithm {
+	case "sha1":
+		h = sha1.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		h = sha256.New()
+	}
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// checkDigest returns a coded error if a digest of the given algorithm does
+// not satisfy the key's digest policy. The digest must have the length of its
+// algorithm, so a shorter digest cannot be passed off as a stronger one. The
+// policy is only meaningful for digests Vault computed itself.
+func (k *Key) checkDigest(algorithm string, digest []byte) error {
+	if len(k.AllowedDigestAlgorithms) > 0 && !strutil.StrListContains(k.AllowedDigestAlgorithms, algorithm) {
+		return logical.CodedError(400, fmt.Sprintf("digest algorithm %q is not "+
+			"allowed for key %q, allowed algorithms are %q", algorithm, k.Name,
+			k.AllowedDigestAlgorithms))
+	}
+
+	bits := len(digest) * 8
+	if exp, ok := digestAlgorithms[algorithm]; ok && bits != exp {
+		return logical.CodedError(400, fmt.Sprintf("digest is %d bits, but %s "+
+			"digests are %d bits", bits, algorithm, exp))
+	}
+	if bits < k.MinDigestLength {
+		return logical.CodedError(400, fmt.Sprintf("digest is %d bits, but key %q "+
+			"requires digests of at least %d bits", bits, k.Name, k.MinDigestLength))
+	}
+	return nil
+}
+
+// latestKeyVersion returns the newest enabled crypto key version of the key
+// which is within the key's min and max versions. It returns a coded error if
+// there is no such version.
+func latestKeyVersion(ctx context.Context, kmsClient keyManagementClient, k *Key) (int, error) {
+	var latest int
+	it := kmsClient.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
+		Parent: k.CryptoKeyID,
+	})
+	for {
+		ckv, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			return 0, wrapKMSError("failed to list crypto key versions: {{err}}", err)
+		}
+
+		v := versionNumber(ckv.Name)
+		if ckv.State != kmspb.CryptoKeyVersion_ENABLED || v <= latest {
+			continue
+		}
+		if k.MinVersion > 0 && v < k.MinVersion {
+			continue
+		}
+		if k.MaxVersion > 0 && v > k.MaxVersion {
+			continue
+		}
+		latest = v
+	}
+
+	if latest == 0 {
+		return 0, logical.CodedError(400, fmt.Sprintf("key %q has no enabled crypto "+
+			"key versions within its min_version and max_version - specify key_version", k.Name))
+	}
+	return latest, nil
+}
+
+// errCryptoKeyVersionState returns a coded error naming the state of the crypto
+// key version and how to remediate it, or nil if the version is enabled.
+func errCryptoKeyVersionState(key string, ckv *kmspb.CryptoKeyVersion) error {
+	var hint string
+	switch ckv.State {
+	case kmspb.CryptoKeyVersion_ENABLED:
+		return nil
+	case kmspb.CryptoKeyVersion_DISABLED:
+		hint = fmt.Sprintf("enable it by writing state=enabled to keys/%s/versions/%d "+
+			"or use a different key_version", key, versionNumber(ckv.Name))
+	case kmspb.CryptoKeyVersion_DESTROY_SCHEDULED:
+		hint = fmt.Sprintf("restore it by writing state=enabled to keys/%s/versions/%d "+
+			"before it is destroyed or use a different key_version", key, versionNumber(ckv.Name))
+	case kmspb.CryptoKeyVersion_DESTROYED:
+		hint = "destroyed versions cannot be recovered, use a different key_version"
+	default:
+		hint = "wait for it to become enabled or use a different key_version"
+	}
+
+	return logical.CodedError(400, fmt.Sprintf("version %d of key %q is %s - %s",
+		versionNumber(ckv.Name), key, strings.ToLower(ckv.State.String()), hint))
+}
+
+// wrapKMSVersionError is like wrapKMSError, but if KMS rejected the request
+// because of the state of the crypto key version, it returns an error naming
+// the state instead. It makes an extra KMS call only in that case.
+func wrapKMSVersionError(ctx context.Context, kmsClient keyManagementClient, key, cryptoKeyVersion, format string, err error) error {
+	s, ok := grpcstatus.FromError(err)
+	if ok && s.Code() == grpccodes.FailedPrecondition && strings.Contains(cryptoKeyVersion, "/cryptoKeyVersions/") {
+		ckv, gerr := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: cryptoKeyVersion,
+		})
+		if gerr == nil {
+			if verr := errCryptoKeyVersionState(key, ckv); verr != nil {
+				return verr
+			}
+		}
+	}
+	return wrapKMSError(format, err)
+}
+
+// KeyAlias is an additional name for a key.
+type KeyAlias struct {
+	// Alias is the name of the alias.
+	Alias string `json:"alias"`
+
+	// Key is the name of the key in Vault which the alias points to.
+	Key string `json:"key"`
+}
+
+// DeregisteredKey is the tombstone of a key which was deregistered with soft
+// delete enabled.
+type DeregisteredKey struct {
+	*Key
+
+	// DeregisteredAt is the time the key was deregistered.
+	DeregisteredAt time.Time `json:"deregistered_at"`
 }
 
 // Key retrieves the named key from the storage backend, or an error if one does
@@ -51,6 +500,24 @@ func (b *backend) Key(ctx context.Context, s logical.Storage, key string) (*Key,
 	return &result, nil
 }
 
+// resolveKey retrieves the named key from the storage backend, or the key the
+// named alias points to if there is no such key.
+func (b *backend) resolveKey(ctx context.Context, s logical.Storage, key string) (*Key, error) {
+	k, err := b.Key(ctx, s, key)
+	if err != ErrKeyNotFound {
+		return k, err
+	}
+
+	a, err := b.keyAlias(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, ErrKeyNotFound
+	}
+	return b.Key(ctx, s, a.Key)
+}
+
 // Keys returns the list of keys
 func (b *backend) Keys(ctx context.Context, s logical.Storage) ([]string, error) {
 	entries, err := s.List(ctx, "keys/")
@@ -59,3 +526,103 @@ func (b *backend) Keys(ctx context.Context, s logical.Storage) ([]string, error)
 	}
 	return entries, nil
 }
+
+// CryptoKeyName is the parsed form of a crypto key's full resource ID.
+type CryptoKeyName struct {
+	Project   string
+	Location  string
+	KeyRing   string
+	CryptoKey string
+}
+
+// KeyRingID returns the full resource ID of the key ring.
+func (n *CryptoKeyName) KeyRingID() string {
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s",
+		n.Project, n.Location, n.KeyRing)
+}
+
+// parseCryptoKeyName parses the full resource ID of a crypto key into its
+// components.
+func parseCryptoKeyName(s string) (*CryptoKeyName, error) {
+	m := cryptoKeyNameRegex.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid crypto key resource ID %q, expected "+
+			"projects/<project>/locations/<location>/keyRings/<key_ring>/cryptoKeys/<crypto_key>", s)
+	}
+	return &CryptoKeyName{
+		Project:   m[1],
+		Location:  m[2],
+		KeyRing:   m[3],
+		CryptoKey: m[4],
+	}, nil
+}
+
+// keyRingID builds the full resource ID of a key ring. The keyRing may either
+// be a full resource ID, in which case the project and location must be empty
+// or match it, or a key ring name, in which case the project and location are
+// required.
+func keyRingID(project, location, keyRing string) (string, error) {
+	if strings.Contains(keyRing, "/") {
+		m := keyRingNameRegex.FindStringSubmatch(keyRing)
+		if m == nil {
+			return "", fmt.Errorf("invalid key ring resource ID %q, expected "+
+				"projects/<project>/locations/<location>/keyRings/<key_ring>", keyRing)
+		}
+		if project != "" && project != m[1] {
+			return "", fmt.Errorf("project %q does not match the project %q of "+
+				"key ring %q", project, m[1], keyRing)
+		}
+		if location != "" && location != m[2] {
+			return "", fmt.Errorf("location %q does not match the location %q of "+
+				"key ring %q", location, m[2], keyRing)
+		}
+		return keyRing, nil
+	}
+
+	if !resourceIDRegex.MatchString(keyRing) {
+		return "", fmt.Errorf("invalid key ring name %q, must be 1-63 letters, "+
+			"numbers, underscores, or hyphens", keyRing)
+	}
+	if location == "" {
+		return "", fmt.Errorf("location is required when key_ring is not a " +
+			"full resource ID")
+	}
+	if !locationIDRegex.MatchString(location) {
+		return "", fmt.Errorf("invalid location %q", location)
+	}
+	if !projectIDRegex.MatchString(project) {
+		return "", fmt.Errorf("invalid project %q", project)
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s", project, location, keyRing), nil
+}
+
+// keyRingFromFields returns the full resource ID of the key ring given by the
+// "project", "location", and "key_ring" fields. If the key ring is given by
+// name and the project is unspecified, the project of the configured
+// credentials is used. It returns an empty string if no key ring was given.
+func (b *backend) keyRingFromFields(ctx context.Context, s logical.Storage, d *framework.FieldData) (string, error) {
+	project := d.Get("project").(string)
+	location := d.Get("location").(string)
+	keyRing := d.Get("key_ring").(string)
+
+	if keyRing == "" {
+		if project != "" || location != "" {
+			return "", errMissingFields("key_ring")
+		}
+		return "", nil
+	}
+
+	if project == "" && !strings.Contains(keyRing, "/") {
+		var err error
+		project, err = b.projectOrDefault(ctx, s, project)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	id, err := keyRingID(project, location, keyRing)
+	if err != nil {
+		return "", logical.CodedError(400, err.Error())
+	}
+	return id, nil
+}
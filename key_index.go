@@ -0,0 +1,346 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// keyIndexStoragePrefix is the storage prefix of the key index. The index
+	// is split into buckets by a hash of the key name, so listing a mount with
+	// many keys reads a bounded number of small entries instead of every key,
+	// and updating one key rewrites only its bucket.
+	keyIndexStoragePrefix = "index/keys/"
+
+	// keyIndexBuiltPath marks that the key index is complete. Mounts created
+	// before the index existed, or whose index failed to update, have no
+	// marker and the index is rebuilt from the keys on next use.
+	keyIndexBuiltPath = "index/built"
+
+	// keyIndexBuckets is the number of buckets of the key index.
+	keyIndexBuckets = 256
+)
+
+// keyIndexEntry is the summary of a key held in the key index: the fields
+// needed to list, filter, and select keys for bulk operations without reading
+// each key.
+type keyIndexEntry struct {
+	CryptoKeyID        string            `json:"crypto_key_id"`
+	MinVersion         int               `json:"min_version,omitempty"`
+	MaxVersion         int               `json:"max_version,omitempty"`
+	Purpose            string            `json:"purpose,omitempty"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	ConfigName         string            `json:"config_name,omitempty"`
+	DeletionProtection bool              `json:"deletion_protection,omitempty"`
+	AutoTrim           bool              `json:"auto_trim,omitempty"`
+	NextRotation       time.Time         `json:"next_rotation,omitempty"`
+	HasExpected        bool              `json:"has_expected,omitempty"`
+}
+
+// newKeyIndexEntry returns the index entry of the key.
+func newKeyIndexEntry(k *Key) *keyIndexEntry {
+	e := &keyIndexEntry{
+		CryptoKeyID:        k.CryptoKeyID,
+		MinVersion:         k.MinVersion,
+		MaxVersion:         k.MaxVersion,
+		ConfigName:         k.ConfigName,
+		DeletionProtection: k.DeletionProtection,
+		AutoTrim:           k.AutoTrim,
+		HasExpected:        k.Expected != nil,
+	}
+	if k.RotationSchedule > 0 {
+		e.NextRotation = k.NextRotation
+	}
+	if k.Expected != nil {
+		e.Purpose = k.Expected.Purpose
+		e.Labels = k.Expected.Labels
+	}
+	return e
+}
+
+// hasLabels returns true if the recorded labels of the key's crypto key
+// include all of the given labels.
+func (e *keyIndexEntry) hasLabels(labels map[string]string) bool {
+	for l, v := range labels {
+		if ev, ok := e.Labels[l]; !ok || ev != v {
+			return false
+		}
+	}
+	return true
+}
+
+// keyIndexBucket returns the storage path of the index bucket of the key.
+func keyIndexBucket(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%s%02x", keyIndexStoragePrefix, h.Sum32()%keyIndexBuckets)
+}
+
+// putKey saves the key as a new version and updates its entry in the key
+// index.
+func (b *backend) putKey(ctx context.Context, s logical.Storage, k *Key) error {
+	k.Version++
+
+	entry, err := logical.StorageEntryJSON("keys/"+k.Name, k)
+	if err != nil {
+		return errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return errwrap.Wrapf("failed to write to storage: {{err}}", err)
+	}
+	return b.updateKeyIndex(ctx, s, k.Name, newKeyIndexEntry(k))
+}
+
+// lockKey takes the write lock of the named key and returns the function which
+// releases it. Every write to a key holds the lock, so check-and-set is not
+// raced by another write.
+func (b *backend) lockKey(key string) func() {
+	lock := locksutil.LockForKey(b.keyLocks, key)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// updateKey reads the named key under its lock, applies f, and saves the key
+// if f returns true. Writes made outside of the key's own endpoints use it, so
+// they neither overwrite a concurrent user write nor slip past check-and-set.
+func (b *backend) updateKey(ctx context.Context, s logical.Storage, key string, f func(*Key) bool) (*Key, error) {
+	unlock := b.lockKey(key)
+	defer unlock()
+
+	k, err := b.Key(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	if !f(k) {
+		return k, nil
+	}
+	if err := b.putKey(ctx, s, k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// putNewKey saves the key under its lock if no key with its name exists, and
+// returns false without saving it if one does.
+func (b *backend) putNewKey(ctx context.Context, s logical.Storage, k *Key) (bool, error) {
+	unlock := b.lockKey(k.Name)
+	defer unlock()
+
+	if _, err := b.Key(ctx, s, k.Name); err != ErrKeyNotFound {
+		return false, err
+	}
+	if err := b.putKey(ctx, s, k); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deleteKey deletes the key, removes it from the key index, and deletes the
+// aliases which point to it so they cannot resolve to a later key of the same
+// name. It must be called under the key's lock.
+func (b *backend) deleteKey(ctx context.Context, s logical.Storage, key string) error {
+	if err := s.Delete(ctx, "keys/"+key); err != nil {
+		return errwrap.Wrapf("failed to delete from storage: {{err}}", err)
+	}
+	if err := b.updateKeyIndex(ctx, s, key, nil); err != nil {
+		return err
+	}
+	return b.deleteKeyAliases(ctx, s, key)
+}
+
+// updateKeyIndex sets the index entry of the key, or removes it if e is nil.
+// Storage has no transactions, so if the bucket cannot be saved after the key
+// was, the index is marked incomplete and rebuilt on next use rather than
+// left stale.
+func (b *backend) updateKeyIndex(ctx context.Context, s logical.Storage, key string, e *keyIndexEntry) error {
+	b.keyIndexLock.Lock()
+	defer b.keyIndexLock.Unlock()
+
+	path := keyIndexBucket(key)
+	bucket, err := readKeyIndexBucket(ctx, s, path)
+	if err != nil {
+		return b.invalidateKeyIndex(ctx, s, err)
+	}
+	if e == nil {
+		delete(bucket, key)
+	} else {
+		bucket[key] = e
+	}
+	if err := writeKeyIndexBucket(ctx, s, path, bucket); err != nil {
+		return b.invalidateKeyIndex(ctx, s, err)
+	}
+	return nil
+}
+
+// invalidateKeyIndex removes the marker of a complete key index after the
+// index failed to update with err, so the index is rebuilt on next use.
+func (b *backend) invalidateKeyIndex(ctx context.Context, s logical.Storage, err error) error {
+	b.Logger().Warn("failed to update key index, it will be rebuilt", "error", err)
+	if derr := s.Delete(ctx, keyIndexBuiltPath); derr != nil {
+		return errwrap.Wrapf("failed to update key index: {{err}}", err)
+	}
+	return nil
+}
+
+// keyIndex returns the index entries of all keys, keyed by key name. If the
+// index is incomplete it is rebuilt from the keys first.
+func (b *backend) keyIndex(ctx context.Context, s logical.Storage) (map[string]*keyIndexEntry, error) {
+	b.keyIndexLock.Lock()
+	defer b.keyIndexLock.Unlock()
+
+	built, err := s.Get(ctx, keyIndexBuiltPath)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read key index: {{err}}", err)
+	}
+	if built == nil {
+		return b.rebuildKeyIndex(ctx, s)
+	}
+
+	paths, err := s.List(ctx, keyIndexStoragePrefix)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list key index: {{err}}", err)
+	}
+
+	index := make(map[string]*keyIndexEntry)
+	for _, p := range paths {
+		bucket, err := readKeyIndexBucket(ctx, s, keyIndexStoragePrefix+p)
+		if err != nil {
+			return nil, err
+		}
+		for key, e := range bucket {
+			index[key] = e
+		}
+	}
+	return index, nil
+}
+
+// keyIndexNames returns the sorted names of the keys in the index.
+func keyIndexNames(index map[string]*keyIndexEntry) []string {
+	names := make([]string, 0, len(index))
+	for key := range index {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rebuildKeyIndex builds the key index by reading every key and saves it. On
+// a performance standby, where storage is read-only, the index is returned
+// without being saved. The caller must hold keyIndexLock.
+func (b *backend) rebuildKeyIndex(ctx context.Context, s logical.Storage) (map[string]*keyIndexEntry, error) {
+	names, err := b.Keys(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*keyIndexEntry, len(names))
+	buckets := make(map[string]map[string]*keyIndexEntry)
+	for _, name := range names {
+		k, err := b.Key(ctx, s, name)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				continue
+			}
+			return nil, err
+		}
+
+		e := newKeyIndexEntry(k)
+		index[name] = e
+
+		path := keyIndexBucket(name)
+		if buckets[path] == nil {
+			buckets[path] = make(map[string]*keyIndexEntry)
+		}
+		buckets[path][name] = e
+	}
+
+	err = b.saveKeyIndex(ctx, s, buckets)
+	if err == logical.ErrReadOnly {
+		return index, nil
+	}
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to save key index: {{err}}", err)
+	}
+
+	b.Logger().Info("rebuilt key index", "keys", len(index))
+	return index, nil
+}
+
+// saveKeyIndex replaces the buckets of the key index and marks it complete.
+// It returns storage errors unwrapped so a read-only storage can be detected.
+func (b *backend) saveKeyIndex(ctx context.Context, s logical.Storage, buckets map[string]map[string]*keyIndexEntry) error {
+	existing, err := s.List(ctx, keyIndexStoragePrefix)
+	if err != nil {
+		return err
+	}
+	for _, p := range existing {
+		if _, ok := buckets[keyIndexStoragePrefix+p]; ok {
+			continue
+		}
+		if err := s.Delete(ctx, keyIndexStoragePrefix+p); err != nil {
+			return err
+		}
+	}
+
+	for path, bucket := range buckets {
+		entry, err := logical.StorageEntryJSON(path, bucket)
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   keyIndexBuiltPath,
+		Value: []byte(time.Now().UTC().Format(time.RFC3339)),
+	})
+}
+
+// readKeyIndexBucket returns the entries of the index bucket at the path.
+func readKeyIndexBucket(ctx context.Context, s logical.Storage, path string) (map[string]*keyIndexEntry, error) {
+	bucket := make(map[string]*keyIndexEntry)
+
+	entry, err := s.Get(ctx, path)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read key index: {{err}}", err)
+	}
+	if entry == nil {
+		return bucket, nil
+	}
+	if err := entry.DecodeJSON(&bucket); err != nil {
+		return nil, errwrap.Wrapf("failed to decode key index: {{err}}", err)
+	}
+	return bucket, nil
+}
+
+// writeKeyIndexBucket saves the entries of the index bucket at the path, or
+// deletes the bucket if it is empty.
+func writeKeyIndexBucket(ctx context.Context, s logical.Storage, path string, bucket map[string]*keyIndexEntry) error {
+	if len(bucket) == 0 {
+		if err := s.Delete(ctx, path); err != nil {
+			return errwrap.Wrapf("failed to delete key index: {{err}}", err)
+		}
+		return nil
+	}
+
+	entry, err := logical.StorageEntryJSON(path, bucket)
+	if err != nil {
+		return errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return errwrap.Wrapf("failed to write key index: {{err}}", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_KeyIndex(t *testing.T) {
+
+	t.Run("rebuild", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key", "crypto_key_id":"foo", "auto_trim":true}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		index, err := b.keyIndex(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, ok := index["my-key"]; !ok || e.CryptoKeyID != "foo" || !e.AutoTrim {
+			t.Errorf("expected my-key to be indexed: %#v", index)
+		}
+
+		entry, err := storage.Get(ctx, keyIndexBuiltPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			t.Errorf("expected the index to be marked complete")
+		}
+	})
+
+	t.Run("put_and_delete", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if _, err := b.keyIndex(ctx, storage); err != nil {
+			t.Fatal(err)
+		}
+
+		next := time.Now().UTC().Add(time.Hour)
+		for _, k := range []*Key{
+			{Name: "a", CryptoKeyID: "foo"},
+			{Name: "b", CryptoKeyID: "bar", RotationSchedule: time.Hour, NextRotation: next},
+		} {
+			if err := b.putKey(ctx, storage, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		index, err := b.keyIndex(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := keyIndexNames(index), []string{"a", "b"}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+		if v := index["b"].NextRotation; !v.Equal(next) {
+			t.Errorf("expected %s to be %s", v, next)
+		}
+
+		if err := b.deleteKey(ctx, storage, "a"); err != nil {
+			t.Fatal(err)
+		}
+
+		index, err = b.keyIndex(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := keyIndexNames(index), []string{"b"}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+		if _, err := b.Key(ctx, storage, "a"); err != ErrKeyNotFound {
+			t.Errorf("expected %q to be %q", err, ErrKeyNotFound)
+		}
+	})
+}
@@ -5,10 +5,15 @@ package gcpkms
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func TestKey_Key(t *testing.T) {
@@ -119,3 +124,514 @@ func TestKey_Keys(t *testing.T) {
 		})
 	}
 }
+
+func TestKey_ParseCryptoKeyName(t *testing.T) {
+
+	cases := []struct {
+		name string
+		s    string
+		e    *CryptoKeyName
+		err  bool
+	}{
+		{
+			"valid",
+			"projects/p/locations/us-east1/keyRings/kr/cryptoKeys/ck",
+			&CryptoKeyName{
+				Project:   "p",
+				Location:  "us-east1",
+				KeyRing:   "kr",
+				CryptoKey: "ck",
+			},
+			false,
+		},
+		{
+			"version",
+			"projects/p/locations/us-east1/keyRings/kr/cryptoKeys/ck/cryptoKeyVersions/1",
+			nil,
+			true,
+		},
+		{
+			"key_ring",
+			"projects/p/locations/us-east1/keyRings/kr",
+			nil,
+			true,
+		},
+		{
+			"empty",
+			"",
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			n, err := parseCryptoKeyName(tc.s)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(n, tc.e) {
+				t.Errorf("expected %#v to be %#v", n, tc.e)
+			}
+		})
+	}
+}
+
+func TestKey_KeyRingID(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		project  string
+		location string
+		keyRing  string
+		e        string
+		err      bool
+	}{
+		{
+			"full",
+			"",
+			"",
+			"projects/my-project/locations/us-east1/keyRings/kr",
+			"projects/my-project/locations/us-east1/keyRings/kr",
+			false,
+		},
+		{
+			"full_matching_fields",
+			"my-project",
+			"us-east1",
+			"projects/my-project/locations/us-east1/keyRings/kr",
+			"projects/my-project/locations/us-east1/keyRings/kr",
+			false,
+		},
+		{
+			"full_project_mismatch",
+			"other-project",
+			"",
+			"projects/my-project/locations/us-east1/keyRings/kr",
+			"",
+			true,
+		},
+		{
+			"full_location_mismatch",
+			"",
+			"global",
+			"projects/my-project/locations/us-east1/keyRings/kr",
+			"",
+			true,
+		},
+		{
+			"full_invalid",
+			"",
+			"",
+			"projects/my-project/keyRings/kr",
+			"",
+			true,
+		},
+		{
+			"name",
+			"my-project",
+			"global",
+			"kr",
+			"projects/my-project/locations/global/keyRings/kr",
+			false,
+		},
+		{
+			"name_missing_location",
+			"my-project",
+			"",
+			"kr",
+			"",
+			true,
+		},
+		{
+			"name_invalid_project",
+			"P",
+			"global",
+			"kr",
+			"",
+			true,
+		},
+		{
+			"name_invalid_key_ring",
+			"my-project",
+			"global",
+			"kr.1",
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			id, err := keyRingID(tc.project, tc.location, tc.keyRing)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			if id != tc.e {
+				t.Errorf("expected %q to be %q", id, tc.e)
+			}
+		})
+	}
+}
+
+func TestKey_ApplyRotation(t *testing.T) {
+
+	now := time.Unix(1000, 0)
+
+	cases := []struct {
+		name    string
+		key     *Key
+		version int
+		pending bool
+		exp     *Key
+		changed bool
+	}{
+		{
+			"no_policy",
+			&Key{MinVersion: 2},
+			5,
+			false,
+			&Key{MinVersion: 2},
+			false,
+		},
+		{
+			"auto_bump_pending",
+			&Key{AutoBumpMinVersion: true, MinVersion: 2},
+			5,
+			true,
+			&Key{AutoBumpMinVersion: true, MinVersion: 2},
+			false,
+		},
+		{
+			"auto_bump_pending_lag",
+			&Key{AutoBumpMinVersion: true, MinVersionLag: 1},
+			5,
+			true,
+			&Key{AutoBumpMinVersion: true, MinVersionLag: 1, MinVersion: 4},
+			true,
+		},
+		{
+			"auto_bump_past_max_version",
+			&Key{AutoBumpMinVersion: true, MaxVersion: 3},
+			5,
+			false,
+			&Key{AutoBumpMinVersion: true, MaxVersion: 3},
+			false,
+		},
+		{
+			"auto_bump",
+			&Key{AutoBumpMinVersion: true},
+			5,
+			false,
+			&Key{AutoBumpMinVersion: true, MinVersion: 5},
+			true,
+		},
+		{
+			"auto_bump_lag",
+			&Key{AutoBumpMinVersion: true, MinVersionLag: 2},
+			5,
+			false,
+			&Key{AutoBumpMinVersion: true, MinVersionLag: 2, MinVersion: 3},
+			true,
+		},
+		{
+			"auto_bump_never_lowers",
+			&Key{AutoBumpMinVersion: true, MinVersionLag: 2, MinVersion: 4},
+			5,
+			false,
+			&Key{AutoBumpMinVersion: true, MinVersionLag: 2, MinVersion: 4},
+			false,
+		},
+		{
+			"auto_bump_lag_exceeds_version",
+			&Key{AutoBumpMinVersion: true, MinVersionLag: 5},
+			2,
+			false,
+			&Key{AutoBumpMinVersion: true, MinVersionLag: 5},
+			false,
+		},
+		{
+			"rotation_schedule",
+			&Key{RotationSchedule: time.Hour},
+			5,
+			false,
+			&Key{RotationSchedule: time.Hour, LastRotated: now, NextRotation: now.Add(time.Hour)},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			ckv := &kmspb.CryptoKeyVersion{
+				Name:  fmt.Sprintf("projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/%d", tc.version),
+				State: kmspb.CryptoKeyVersion_ENABLED,
+			}
+			if tc.pending {
+				ckv.State = kmspb.CryptoKeyVersion_PENDING_GENERATION
+			}
+			if changed := tc.key.applyRotation(ckv, now); changed != tc.changed {
+				t.Errorf("expected changed to be %t", tc.changed)
+			}
+			if !reflect.DeepEqual(tc.key, tc.exp) {
+				t.Errorf("expected %#v to be %#v", tc.key, tc.exp)
+			}
+		})
+	}
+}
+
+func TestKey_CheckVersion(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		key     *Key
+		version int
+		err     bool
+	}{
+		{
+			"no_bounds",
+			&Key{},
+			5,
+			false,
+		},
+		{
+			"within_bounds",
+			&Key{MinVersion: 2, MaxVersion: 5},
+			5,
+			false,
+		},
+		{
+			"below_min",
+			&Key{MinVersion: 2},
+			1,
+			true,
+		},
+		{
+			"above_max",
+			&Key{MaxVersion: 5},
+			6,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			resp, err := tc.key.checkVersion(tc.version)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if err != nil && err != logical.ErrPermissionDenied {
+				t.Errorf("expected permission denied, got %s", err)
+			}
+			if (resp != nil) != tc.err {
+				t.Errorf("expected error response: %#v", resp)
+			}
+		})
+	}
+}
+
+func TestKey_CheckAAD(t *testing.T) {
+
+	cases := []struct {
+		name string
+		key  *Key
+		aad  string
+		err  bool
+	}{
+		{
+			"no_policy",
+			&Key{},
+			"",
+			false,
+		},
+		{
+			"required_present",
+			&Key{RequireAAD: true},
+			"tenant/a",
+			false,
+		},
+		{
+			"required_missing",
+			&Key{RequireAAD: true},
+			"",
+			true,
+		},
+		{
+			"regex_match",
+			&Key{AllowedAADRegex: "^tenant/"},
+			"tenant/a",
+			false,
+		},
+		{
+			"regex_no_match",
+			&Key{AllowedAADRegex: "^tenant/"},
+			"other/a",
+			true,
+		},
+		{
+			"regex_empty_not_required",
+			&Key{AllowedAADRegex: "^tenant/"},
+			"",
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			if _, err := tc.key.checkAAD(tc.aad); (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestKey_CheckDigest(t *testing.T) {
+
+	sha1 := make([]byte, 20)
+	sha256 := make([]byte, 32)
+	sha384 := make([]byte, 48)
+
+	cases := []struct {
+		name      string
+		key       *Key
+		algorithm string
+		digest    []byte
+		err       bool
+	}{
+		{
+			"no_policy",
+			&Key{},
+			"sha256",
+			sha256,
+			false,
+		},
+		{
+			"wrong_length",
+			&Key{},
+			"sha256",
+			sha1,
+			true,
+		},
+		{
+			"allowed",
+			&Key{AllowedDigestAlgorithms: []string{"sha256", "sha384"}},
+			"sha384",
+			sha384,
+			false,
+		},
+		{
+			"not_allowed",
+			&Key{AllowedDigestAlgorithms: []string{"sha256"}},
+			"sha1",
+			sha1,
+			true,
+		},
+		{
+			"min_length",
+			&Key{MinDigestLength: 256},
+			"sha256",
+			sha256,
+			false,
+		},
+		{
+			"below_min_length",
+			&Key{MinDigestLength: 256},
+			"sha1",
+			sha1,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			err := tc.key.checkDigest(tc.algorithm, tc.digest)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if err != nil {
+				if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 400 {
+					t.Errorf("expected 400 error, got %#v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestErrCryptoKeyVersionState(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		state    kmspb.CryptoKeyVersion_CryptoKeyVersionState
+		contains string
+	}{
+		{
+			"enabled",
+			kmspb.CryptoKeyVersion_ENABLED,
+			"",
+		},
+		{
+			"disabled",
+			kmspb.CryptoKeyVersion_DISABLED,
+			"keys/my-key/versions/3 or",
+		},
+		{
+			"destroy_scheduled",
+			kmspb.CryptoKeyVersion_DESTROY_SCHEDULED,
+			"keys/my-key/versions/3 before",
+		},
+		{
+			"destroyed",
+			kmspb.CryptoKeyVersion_DESTROYED,
+			"cannot be recovered",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			err := errCryptoKeyVersionState("my-key", &kmspb.CryptoKeyVersion{
+				Name:  "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/3",
+				State: tc.state,
+			})
+			if tc.contains == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if code := err.(logical.HTTPCodedError).Code(); code != 400 {
+				t.Errorf("expected %d to be %d", code, 400)
+			}
+			for _, s := range []string{"version 3", tc.name, tc.contains} {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("expected %q to contain %q", err, s)
+				}
+			}
+		})
+	}
+}
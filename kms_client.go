@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	kmsapi "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
+)
+
+// kmsEmulatorHostEnv is the environment variable which, if set, points every
+// KMS client at the host and port of a KMS emulator or fake, such as
+// "localhost:9010". The emulator is reached without credentials or TLS.
+const kmsEmulatorHostEnv = "KMS_EMULATOR_HOST"
+
+// emulatorTokenSource stands in for the credentials of clients for an
+// emulator. Its token is never sent.
+var emulatorTokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "emulator"})
+
+// keyManagementClient is the subset of the KMS key management API used by the
+// plugin. It is implemented by gcpKMSClient for the Google Cloud KMS client,
+// and by fakes in tests, so the plugin can be tested without a Google Cloud
+// project.
+type keyManagementClient interface {
+	GetKeyRing(context.Context, *kmspb.GetKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
+	CreateKeyRing(context.Context, *kmspb.CreateKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
+	ListKeyRings(context.Context, *kmspb.ListKeyRingsRequest, ...gax.CallOption) keyRingIterator
+
+	GetCryptoKey(context.Context, *kmspb.GetCryptoKeyRequest, ...gax.CallOption) (*kmspb.CryptoKey, error)
+	CreateCryptoKey(context.Context, *kmspb.CreateCryptoKeyRequest, ...gax.CallOption) (*kmspb.CryptoKey, error)
+	UpdateCryptoKey(context.Context, *kmspb.UpdateCryptoKeyRequest, ...gax.CallOption) (*kmspb.CryptoKey, error)
+	UpdateCryptoKeyPrimaryVersion(context.Context, *kmspb.UpdateCryptoKeyPrimaryVersionRequest, ...gax.CallOption) (*kmspb.CryptoKey, error)
+	ListCryptoKeys(context.Context, *kmspb.ListCryptoKeysRequest, ...gax.CallOption) cryptoKeyIterator
+
+	GetCryptoKeyVersion(context.Context, *kmspb.GetCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	CreateCryptoKeyVersion(context.Context, *kmspb.CreateCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	ImportCryptoKeyVersion(context.Context, *kmspb.ImportCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	UpdateCryptoKeyVersion(context.Context, *kmspb.UpdateCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	DestroyCryptoKeyVersion(context.Context, *kmspb.DestroyCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	RestoreCryptoKeyVersion(context.Context, *kmspb.RestoreCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	ListCryptoKeyVersions(context.Context, *kmspb.ListCryptoKeyVersionsRequest, ...gax.CallOption) cryptoKeyVersionIterator
+	GetPublicKey(context.Context, *kmspb.GetPublicKeyRequest, ...gax.CallOption) (*kmspb.PublicKey, error)
+
+	Encrypt(context.Context, *kmspb.EncryptRequest, ...gax.CallOption) (*kmspb.EncryptResponse, error)
+	Decrypt(context.Context, *kmspb.DecryptRequest, ...gax.CallOption) (*kmspb.DecryptResponse, error)
+	AsymmetricSign(context.Context, *kmspb.AsymmetricSignRequest, ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error)
+	AsymmetricDecrypt(context.Context, *kmspb.AsymmetricDecryptRequest, ...gax.CallOption) (*kmspb.AsymmetricDecryptResponse, error)
+
+	GetIamPolicy(context.Context, *iampb.GetIamPolicyRequest, ...gax.CallOption) (*iampb.Policy, error)
+	SetIamPolicy(context.Context, *iampb.SetIamPolicyRequest, ...gax.CallOption) (*iampb.Policy, error)
+	TestIamPermissions(context.Context, *iampb.TestIamPermissionsRequest, ...gax.CallOption) (*iampb.TestIamPermissionsResponse, error)
+
+	ListLocations(context.Context, *locationpb.ListLocationsRequest, ...gax.CallOption) locationIterator
+
+	Close() error
+}
+
+// keyRingIterator iterates over the results of ListKeyRings. Next returns
+// iterator.Done once there are no more results.
+type keyRingIterator interface {
+	Next() (*kmspb.KeyRing, error)
+}
+
+// cryptoKeyIterator iterates over the results of ListCryptoKeys.
+type cryptoKeyIterator interface {
+	Next() (*kmspb.CryptoKey, error)
+}
+
+// cryptoKeyVersionIterator iterates over the results of ListCryptoKeyVersions.
+type cryptoKeyVersionIterator interface {
+	Next() (*kmspb.CryptoKeyVersion, error)
+}
+
+// locationIterator iterates over the results of ListLocations.
+type locationIterator interface {
+	Next() (*locationpb.Location, error)
+}
+
+// gcpKMSClient is the keyManagementClient for the Google Cloud KMS client. The
+// list methods return the client's iterators as iterator interfaces, since
+// fakes cannot construct them.
+type gcpKMSClient struct {
+	*kmsapi.KeyManagementClient
+}
+
+var _ keyManagementClient = (*gcpKMSClient)(nil)
+
+// ListKeyRings implements keyManagementClient.
+func (c *gcpKMSClient) ListKeyRings(ctx context.Context, req *kmspb.ListKeyRingsRequest, opts ...gax.CallOption) keyRingIterator {
+	return c.KeyManagementClient.ListKeyRings(ctx, req, opts...)
+}
+
+// ListCryptoKeys implements keyManagementClient.
+func (c *gcpKMSClient) ListCryptoKeys(ctx context.Context, req *kmspb.ListCryptoKeysRequest, opts ...gax.CallOption) cryptoKeyIterator {
+	return c.KeyManagementClient.ListCryptoKeys(ctx, req, opts...)
+}
+
+// ListCryptoKeyVersions implements keyManagementClient.
+func (c *gcpKMSClient) ListCryptoKeyVersions(ctx context.Context, req *kmspb.ListCryptoKeyVersionsRequest, opts ...gax.CallOption) cryptoKeyVersionIterator {
+	return c.KeyManagementClient.ListCryptoKeyVersions(ctx, req, opts...)
+}
+
+// ListLocations implements keyManagementClient.
+func (c *gcpKMSClient) ListLocations(ctx context.Context, req *locationpb.ListLocationsRequest, opts ...gax.CallOption) locationIterator {
+	return c.KeyManagementClient.ListLocations(ctx, req, opts...)
+}
+
+// emulatorClientOptions returns the options for a client of the KMS emulator
+// at the host, which is reached without credentials or TLS.
+func emulatorClientOptions(host string) []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(host),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+}
@@ -0,0 +1,1014 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	"github.com/hashicorp/vault/sdk/helper/testhelpers/schema"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// fakeKMSClient is an in-memory keyManagementClient for tests which do not
+// need a Google Cloud project. It supports key rings, crypto keys, and crypto
+// key versions with symmetric encryption, and EC and RSA signing and RSA
+// decryption. HSM versions have attestations signed by fake roots. IAM
+// policies are stored but not enforced, and importing crypto key versions is
+// not implemented.
+type fakeKMSClient struct {
+	lock       sync.Mutex
+	keyRings   map[string]*kmspb.KeyRing
+	cryptoKeys map[string]*kmspb.CryptoKey
+	versions   map[string][]*fakeCryptoKeyVersion
+	policies   map[string]*iampb.Policy
+	etag       uint64
+}
+
+// fakeCryptoKeyVersion is a crypto key version and its key material, which is
+// a symmetric key or a private key depending on the algorithm.
+type fakeCryptoKeyVersion struct {
+	ckv       *kmspb.CryptoKeyVersion
+	secret    []byte
+	signer    crypto.Signer
+	decrypter *rsa.PrivateKey
+}
+
+var _ keyManagementClient = (*fakeKMSClient)(nil)
+
+func newFakeKMSClient() *fakeKMSClient {
+	return &fakeKMSClient{
+		keyRings:   make(map[string]*kmspb.KeyRing),
+		cryptoKeys: make(map[string]*kmspb.CryptoKey),
+		versions:   make(map[string][]*fakeCryptoKeyVersion),
+		policies:   make(map[string]*iampb.Policy),
+	}
+}
+
+// testFakeKMSClient installs a fake KMS client as the backend's client, so
+// every key without an endpoint or profile override uses it.
+func testFakeKMSClient(tb testing.TB, b *backend) *fakeKMSClient {
+	tb.Helper()
+
+	f := newFakeKMSClient()
+	b.kmsClients[clientKey{}] = &kmsClientHandle{
+		client:     f,
+		createTime: time.Now().UTC(),
+		lifetime:   time.Hour,
+	}
+	return f
+}
+
+// testFakeCryptoKey creates a key ring and a crypto key with the algorithm in
+// the fake, returning the crypto key's resource ID.
+func testFakeCryptoKey(tb testing.TB, f *fakeKMSClient, purpose kmspb.CryptoKey_CryptoKeyPurpose, algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) string {
+	tb.Helper()
+
+	ctx := context.Background()
+	parent := "projects/p/locations/global"
+	kr, err := f.GetKeyRing(ctx, &kmspb.GetKeyRingRequest{Name: parent + "/keyRings/r"})
+	if err != nil {
+		kr, err = f.CreateKeyRing(ctx, &kmspb.CreateKeyRingRequest{
+			Parent:    parent,
+			KeyRingId: "r",
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	ck, err := f.CreateCryptoKey(ctx, &kmspb.CreateCryptoKeyRequest{
+		Parent:      kr.Name,
+		CryptoKeyId: fmt.Sprintf("k%d", len(f.cryptoKeys)),
+		CryptoKey: &kmspb.CryptoKey{
+			Purpose: purpose,
+			VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+				Algorithm:       algorithm,
+				ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+			},
+		},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ck.Name
+}
+
+func fakeNotFound(name string) error {
+	return grpcstatus.Errorf(grpccodes.NotFound, "%s not found.", name)
+}
+
+func fakeUnimplemented(method string) error {
+	return grpcstatus.Errorf(grpccodes.Unimplemented, "%s is not implemented by the fake", method)
+}
+
+// fakeIterator iterates over a copy of the results of a list call.
+type fakeIterator[T any] struct {
+	items []T
+	err   error
+}
+
+// Next implements the list iterators of keyManagementClient.
+func (it *fakeIterator[T]) Next() (T, error) {
+	var item T
+	if it.err != nil {
+		return item, it.err
+	}
+	if len(it.items) == 0 {
+		return item, iterator.Done
+	}
+	item, it.items = it.items[0], it.items[1:]
+	return item, nil
+}
+
+// GetKeyRing implements keyManagementClient.
+func (f *fakeKMSClient) GetKeyRing(_ context.Context, req *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	kr, ok := f.keyRings[req.Name]
+	if !ok {
+		return nil, fakeNotFound(req.Name)
+	}
+	return proto.Clone(kr).(*kmspb.KeyRing), nil
+}
+
+// CreateKeyRing implements keyManagementClient.
+func (f *fakeKMSClient) CreateKeyRing(_ context.Context, req *kmspb.CreateKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	name := req.Parent + "/keyRings/" + req.KeyRingId
+	if _, ok := f.keyRings[name]; ok {
+		return nil, grpcstatus.Errorf(grpccodes.AlreadyExists, "%s already exists.", name)
+	}
+	kr := &kmspb.KeyRing{Name: name, CreateTime: timestamppb.Now()}
+	f.keyRings[name] = kr
+	return proto.Clone(kr).(*kmspb.KeyRing), nil
+}
+
+// ListKeyRings implements keyManagementClient.
+func (f *fakeKMSClient) ListKeyRings(_ context.Context, req *kmspb.ListKeyRingsRequest, _ ...gax.CallOption) keyRingIterator {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	it := new(fakeIterator[*kmspb.KeyRing])
+	for name, kr := range f.keyRings {
+		if path.Dir(path.Dir(name)) == req.Parent {
+			it.items = append(it.items, proto.Clone(kr).(*kmspb.KeyRing))
+		}
+	}
+	sort.Slice(it.items, func(i, j int) bool { return it.items[i].Name < it.items[j].Name })
+	return it
+}
+
+// cryptoKey returns a copy of the crypto key with its primary version. The
+// caller must hold the lock.
+func (f *fakeKMSClient) cryptoKey(name string) (*kmspb.CryptoKey, error) {
+	ck, ok := f.cryptoKeys[name]
+	if !ok {
+		return nil, fakeNotFound(name)
+	}
+	ck = proto.Clone(ck).(*kmspb.CryptoKey)
+	if ck.Primary != nil {
+		v, err := f.version(ck.Primary.Name)
+		if err != nil {
+			return nil, err
+		}
+		ck.Primary = proto.Clone(v.ckv).(*kmspb.CryptoKeyVersion)
+	}
+	return ck, nil
+}
+
+// version returns the crypto key version. The caller must hold the lock.
+func (f *fakeKMSClient) version(name string) (*fakeCryptoKeyVersion, error) {
+	cryptoKey, id := path.Dir(path.Dir(name)), path.Base(name)
+	for _, v := range f.versions[cryptoKey] {
+		if path.Base(v.ckv.Name) == id {
+			return v, nil
+		}
+	}
+	return nil, fakeNotFound(name)
+}
+
+// GetCryptoKey implements keyManagementClient.
+func (f *fakeKMSClient) GetCryptoKey(_ context.Context, req *kmspb.GetCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.cryptoKey(req.Name)
+}
+
+// CreateCryptoKey implements keyManagementClient.
+func (f *fakeKMSClient) CreateCryptoKey(_ context.Context, req *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.keyRings[req.Parent]; !ok {
+		return nil, fakeNotFound(req.Parent)
+	}
+	if !resourceIDRegex.MatchString(req.CryptoKeyId) {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "invalid crypto key ID %q", req.CryptoKeyId)
+	}
+	name := req.Parent + "/cryptoKeys/" + req.CryptoKeyId
+	if _, ok := f.cryptoKeys[name]; ok {
+		return nil, grpcstatus.Errorf(grpccodes.AlreadyExists, "%s already exists.", name)
+	}
+
+	ck := &kmspb.CryptoKey{}
+	if req.CryptoKey != nil {
+		ck = proto.Clone(req.CryptoKey).(*kmspb.CryptoKey)
+	}
+	ck.Name = name
+	ck.CreateTime = timestamppb.Now()
+	if ck.VersionTemplate == nil {
+		ck.VersionTemplate = &kmspb.CryptoKeyVersionTemplate{}
+	}
+	if ck.VersionTemplate.Algorithm == kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED {
+		if ck.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+			return nil, grpcstatus.Error(grpccodes.InvalidArgument, "version_template.algorithm is required")
+		}
+		ck.VersionTemplate.Algorithm = kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION
+	}
+	if ck.VersionTemplate.ProtectionLevel == kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED {
+		ck.VersionTemplate.ProtectionLevel = kmspb.ProtectionLevel_SOFTWARE
+	}
+	if algorithmPurpose(ck.VersionTemplate.Algorithm) != ck.Purpose {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "algorithm %s is not valid for purpose %s",
+			ck.VersionTemplate.Algorithm, ck.Purpose)
+	}
+	f.cryptoKeys[name] = ck
+
+	if !req.SkipInitialVersionCreation {
+		v, err := f.createVersion(ck)
+		if err != nil {
+			delete(f.cryptoKeys, name)
+			return nil, err
+		}
+		if ck.Purpose == kmspb.CryptoKey_ENCRYPT_DECRYPT {
+			ck.Primary = &kmspb.CryptoKeyVersion{Name: v.ckv.Name}
+		}
+	}
+	return f.cryptoKey(name)
+}
+
+// UpdateCryptoKey implements keyManagementClient.
+func (f *fakeKMSClient) UpdateCryptoKey(_ context.Context, req *kmspb.UpdateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ck, ok := f.cryptoKeys[req.CryptoKey.GetName()]
+	if !ok {
+		return nil, fakeNotFound(req.CryptoKey.GetName())
+	}
+	for _, p := range req.UpdateMask.GetPaths() {
+		switch p {
+		case "labels":
+			ck.Labels = req.CryptoKey.Labels
+		case "rotation_period":
+			ck.RotationSchedule = req.CryptoKey.RotationSchedule
+		case "next_rotation_time":
+			ck.NextRotationTime = req.CryptoKey.NextRotationTime
+		case "version_template.algorithm":
+			ck.VersionTemplate.Algorithm = req.CryptoKey.GetVersionTemplate().GetAlgorithm()
+		default:
+			return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "update_mask path %q is not supported by the fake", p)
+		}
+	}
+	return f.cryptoKey(ck.Name)
+}
+
+// UpdateCryptoKeyPrimaryVersion implements keyManagementClient.
+func (f *fakeKMSClient) UpdateCryptoKeyPrimaryVersion(_ context.Context, req *kmspb.UpdateCryptoKeyPrimaryVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ck, ok := f.cryptoKeys[req.Name]
+	if !ok {
+		return nil, fakeNotFound(req.Name)
+	}
+	if ck.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		return nil, grpcstatus.Error(grpccodes.FailedPrecondition, "crypto key does not have a primary version")
+	}
+	v, err := f.version(req.Name + "/cryptoKeyVersions/" + req.CryptoKeyVersionId)
+	if err != nil {
+		return nil, err
+	}
+	if v.ckv.State != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s is not enabled.", v.ckv.Name)
+	}
+	ck.Primary = &kmspb.CryptoKeyVersion{Name: v.ckv.Name}
+	return f.cryptoKey(ck.Name)
+}
+
+// ListCryptoKeys implements keyManagementClient.
+func (f *fakeKMSClient) ListCryptoKeys(_ context.Context, req *kmspb.ListCryptoKeysRequest, _ ...gax.CallOption) cryptoKeyIterator {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	it := new(fakeIterator[*kmspb.CryptoKey])
+	for name := range f.cryptoKeys {
+		if path.Dir(path.Dir(name)) != req.Parent {
+			continue
+		}
+		ck, err := f.cryptoKey(name)
+		if err != nil {
+			return &fakeIterator[*kmspb.CryptoKey]{err: err}
+		}
+		it.items = append(it.items, ck)
+	}
+	sort.Slice(it.items, func(i, j int) bool { return it.items[i].Name < it.items[j].Name })
+	return it
+}
+
+// createVersion creates a new enabled crypto key version of the crypto key
+// with the algorithm of its version template. The caller must hold the lock.
+func (f *fakeKMSClient) createVersion(ck *kmspb.CryptoKey) (*fakeCryptoKeyVersion, error) {
+	t := ck.VersionTemplate
+	v := &fakeCryptoKeyVersion{
+		ckv: &kmspb.CryptoKeyVersion{
+			Name:            fmt.Sprintf("%s/cryptoKeyVersions/%d", ck.Name, len(f.versions[ck.Name])+1),
+			State:           kmspb.CryptoKeyVersion_ENABLED,
+			ProtectionLevel: t.ProtectionLevel,
+			Algorithm:       t.Algorithm,
+			CreateTime:      timestamppb.Now(),
+			GenerateTime:    timestamppb.Now(),
+		},
+	}
+
+	var err error
+	switch t.Algorithm {
+	case kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION:
+		v.secret = make([]byte, 32)
+		_, err = rand.Read(v.secret)
+	case kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:
+		v.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:
+		v.signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		bits := fakeRSAKeySize(t.Algorithm)
+		if bits == 0 {
+			return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "algorithm %s is not supported by the fake", t.Algorithm)
+		}
+		var key *rsa.PrivateKey
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+		if ck.Purpose == kmspb.CryptoKey_ASYMMETRIC_DECRYPT {
+			v.decrypter = key
+		} else {
+			v.signer = key
+		}
+	}
+	if err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "failed to generate key material: %s", err)
+	}
+
+	if t.ProtectionLevel == kmspb.ProtectionLevel_HSM {
+		if v.ckv.Attestation, err = fakeAttestation(); err != nil {
+			return nil, grpcstatus.Errorf(grpccodes.Internal, "failed to attest key material: %s", err)
+		}
+	}
+
+	f.versions[ck.Name] = append(f.versions[ck.Name], v)
+	return v, nil
+}
+
+// fakeRSAKeySize returns the modulus size of the RSA algorithm, or 0 if it is
+// not an RSA algorithm supported by the fake.
+func fakeRSAKeySize(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) int {
+	name := a.String()
+	if !strings.HasPrefix(name, "RSA_SIGN_P") && !strings.HasPrefix(name, "RSA_DECRYPT_OAEP_") {
+		return 0
+	}
+	for _, bits := range []int{2048, 3072, 4096} {
+		if strings.Contains(name, fmt.Sprintf("_%d_", bits)) {
+			return bits
+		}
+	}
+	return 0
+}
+
+// GetCryptoKeyVersion implements keyManagementClient.
+func (f *fakeKMSClient) GetCryptoKeyVersion(_ context.Context, req *kmspb.GetCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.version(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(v.ckv).(*kmspb.CryptoKeyVersion), nil
+}
+
+// CreateCryptoKeyVersion implements keyManagementClient.
+func (f *fakeKMSClient) CreateCryptoKeyVersion(_ context.Context, req *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ck, ok := f.cryptoKeys[req.Parent]
+	if !ok {
+		return nil, fakeNotFound(req.Parent)
+	}
+	v, err := f.createVersion(ck)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(v.ckv).(*kmspb.CryptoKeyVersion), nil
+}
+
+// ImportCryptoKeyVersion implements keyManagementClient.
+func (f *fakeKMSClient) ImportCryptoKeyVersion(context.Context, *kmspb.ImportCryptoKeyVersionRequest, ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	return nil, fakeUnimplemented("ImportCryptoKeyVersion")
+}
+
+// UpdateCryptoKeyVersion implements keyManagementClient. Only the state can be
+// updated, between enabled and disabled.
+func (f *fakeKMSClient) UpdateCryptoKeyVersion(_ context.Context, req *kmspb.UpdateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.version(req.CryptoKeyVersion.GetName())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range req.UpdateMask.GetPaths() {
+		if p != "state" {
+			return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "update_mask path %q is not supported by the fake", p)
+		}
+		switch v.ckv.State {
+		case kmspb.CryptoKeyVersion_ENABLED, kmspb.CryptoKeyVersion_DISABLED:
+		default:
+			return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s is in state %s.", v.ckv.Name, v.ckv.State)
+		}
+		switch s := req.CryptoKeyVersion.State; s {
+		case kmspb.CryptoKeyVersion_ENABLED, kmspb.CryptoKeyVersion_DISABLED:
+			v.ckv.State = s
+		default:
+			return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "cannot update state to %s", s)
+		}
+	}
+	return proto.Clone(v.ckv).(*kmspb.CryptoKeyVersion), nil
+}
+
+// DestroyCryptoKeyVersion implements keyManagementClient.
+func (f *fakeKMSClient) DestroyCryptoKeyVersion(_ context.Context, req *kmspb.DestroyCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.version(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	switch v.ckv.State {
+	case kmspb.CryptoKeyVersion_ENABLED, kmspb.CryptoKeyVersion_DISABLED:
+	default:
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s is in state %s.", v.ckv.Name, v.ckv.State)
+	}
+	v.ckv.State = kmspb.CryptoKeyVersion_DESTROY_SCHEDULED
+	v.ckv.DestroyTime = timestamppb.New(time.Now().Add(24 * time.Hour))
+	return proto.Clone(v.ckv).(*kmspb.CryptoKeyVersion), nil
+}
+
+// RestoreCryptoKeyVersion implements keyManagementClient.
+func (f *fakeKMSClient) RestoreCryptoKeyVersion(_ context.Context, req *kmspb.RestoreCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.version(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if v.ckv.State != kmspb.CryptoKeyVersion_DESTROY_SCHEDULED {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s is in state %s.", v.ckv.Name, v.ckv.State)
+	}
+	v.ckv.State = kmspb.CryptoKeyVersion_DISABLED
+	v.ckv.DestroyTime = nil
+	return proto.Clone(v.ckv).(*kmspb.CryptoKeyVersion), nil
+}
+
+// ListCryptoKeyVersions implements keyManagementClient. Filters are ignored.
+func (f *fakeKMSClient) ListCryptoKeyVersions(_ context.Context, req *kmspb.ListCryptoKeyVersionsRequest, _ ...gax.CallOption) cryptoKeyVersionIterator {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.cryptoKeys[req.Parent]; !ok {
+		return &fakeIterator[*kmspb.CryptoKeyVersion]{err: fakeNotFound(req.Parent)}
+	}
+	it := new(fakeIterator[*kmspb.CryptoKeyVersion])
+	for _, v := range f.versions[req.Parent] {
+		it.items = append(it.items, proto.Clone(v.ckv).(*kmspb.CryptoKeyVersion))
+	}
+	return it
+}
+
+// enabledVersion returns the crypto key version, which must be enabled and
+// have the purpose. The caller must hold the lock.
+func (f *fakeKMSClient) enabledVersion(name string, purpose kmspb.CryptoKey_CryptoKeyPurpose) (*fakeCryptoKeyVersion, error) {
+	v, err := f.version(name)
+	if err != nil {
+		return nil, err
+	}
+	if v.ckv.State != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s is not enabled, current state is: %s.",
+			v.ckv.Name, v.ckv.State)
+	}
+	if algorithmPurpose(v.ckv.Algorithm) != purpose {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s has algorithm %s which is not valid for %s.",
+			v.ckv.Name, v.ckv.Algorithm, purpose)
+	}
+	return v, nil
+}
+
+// GetPublicKey implements keyManagementClient.
+func (f *fakeKMSClient) GetPublicKey(_ context.Context, req *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.version(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if v.ckv.State != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s is not enabled, current state is: %s.",
+			v.ckv.Name, v.ckv.State)
+	}
+
+	var pub crypto.PublicKey
+	switch {
+	case v.signer != nil:
+		pub = v.signer.Public()
+	case v.decrypter != nil:
+		pub = v.decrypter.Public()
+	default:
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s is not an asymmetric key.", v.ckv.Name)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "failed to marshal public key: %s", err)
+	}
+
+	return &kmspb.PublicKey{
+		Name:            v.ckv.Name,
+		Pem:             string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Algorithm:       v.ckv.Algorithm,
+		ProtectionLevel: v.ckv.ProtectionLevel,
+	}, nil
+}
+
+// Encrypt implements keyManagementClient. The ciphertext is the version number,
+// followed by the nonce and the AES-GCM sealed plaintext.
+func (f *fakeKMSClient) Encrypt(_ context.Context, req *kmspb.EncryptRequest, _ ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	name := req.Name
+	if !strings.Contains(name, "/cryptoKeyVersions/") {
+		ck, err := f.cryptoKey(name)
+		if err != nil {
+			return nil, err
+		}
+		if ck.Primary == nil {
+			return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "%s has no primary version.", name)
+		}
+		name = ck.Primary.Name
+	}
+	v, err := f.enabledVersion(name, kmspb.CryptoKey_ENCRYPT_DECRYPT)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := fakeGCM(v.secret)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := make([]byte, 4+gcm.NonceSize())
+	binary.BigEndian.PutUint32(ciphertext, uint32(versionNumber(v.ckv.Name)))
+	if _, err := rand.Read(ciphertext[4:]); err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "failed to generate nonce: %s", err)
+	}
+	ciphertext = gcm.Seal(ciphertext, ciphertext[4:], req.Plaintext, req.AdditionalAuthenticatedData)
+
+	return &kmspb.EncryptResponse{
+		Name:            v.ckv.Name,
+		Ciphertext:      ciphertext,
+		ProtectionLevel: v.ckv.ProtectionLevel,
+	}, nil
+}
+
+// Decrypt implements keyManagementClient.
+func (f *fakeKMSClient) Decrypt(_ context.Context, req *kmspb.DecryptRequest, _ ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ck, err := f.cryptoKey(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Ciphertext) < 4 {
+		return nil, grpcstatus.Error(grpccodes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	version := binary.BigEndian.Uint32(req.Ciphertext)
+	v, err := f.version(fmt.Sprintf("%s/cryptoKeyVersions/%d", req.Name, version))
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	if v, err = f.enabledVersion(v.ckv.Name, kmspb.CryptoKey_ENCRYPT_DECRYPT); err != nil {
+		return nil, err
+	}
+
+	gcm, err := fakeGCM(v.secret)
+	if err != nil {
+		return nil, err
+	}
+	rest := req.Ciphertext[4:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, grpcstatus.Error(grpccodes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], req.AdditionalAuthenticatedData)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.InvalidArgument, "Decryption failed: verify that 'name' refers "+
+			"to the correct CryptoKey.")
+	}
+
+	return &kmspb.DecryptResponse{
+		Plaintext:       plaintext,
+		UsedPrimary:     ck.Primary != nil && ck.Primary.Name == v.ckv.Name,
+		ProtectionLevel: v.ckv.ProtectionLevel,
+	}, nil
+}
+
+func fakeGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "invalid key material: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "invalid key material: %s", err)
+	}
+	return gcm, nil
+}
+
+// AsymmetricSign implements keyManagementClient.
+func (f *fakeKMSClient) AsymmetricSign(_ context.Context, req *kmspb.AsymmetricSignRequest, _ ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.enabledVersion(req.Name, kmspb.CryptoKey_ASYMMETRIC_SIGN)
+	if err != nil {
+		return nil, err
+	}
+
+	var h crypto.Hash
+	var digest []byte
+	switch name := v.ckv.Algorithm.String(); {
+	case strings.HasSuffix(name, "_SHA384"):
+		h, digest = crypto.SHA384, req.Digest.GetSha384()
+	case strings.HasSuffix(name, "_SHA512"):
+		h, digest = crypto.SHA512, req.Digest.GetSha512()
+	default:
+		h, digest = crypto.SHA256, req.Digest.GetSha256()
+	}
+	if len(digest) != h.Size() {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "the digest must be %s for algorithm %s",
+			h, v.ckv.Algorithm)
+	}
+
+	var opts crypto.SignerOpts = h
+	if strings.HasPrefix(v.ckv.Algorithm.String(), "RSA_SIGN_PSS_") {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: h}
+	}
+	signature, err := v.signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "failed to sign: %s", err)
+	}
+
+	return &kmspb.AsymmetricSignResponse{
+		Signature:       signature,
+		Name:            v.ckv.Name,
+		ProtectionLevel: v.ckv.ProtectionLevel,
+	}, nil
+}
+
+// AsymmetricDecrypt implements keyManagementClient.
+func (f *fakeKMSClient) AsymmetricDecrypt(_ context.Context, req *kmspb.AsymmetricDecryptRequest, _ ...gax.CallOption) (*kmspb.AsymmetricDecryptResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.enabledVersion(req.Name, kmspb.CryptoKey_ASYMMETRIC_DECRYPT)
+	if err != nil {
+		return nil, err
+	}
+
+	var h hash.Hash
+	switch name := v.ckv.Algorithm.String(); {
+	case strings.HasSuffix(name, "_SHA1"):
+		h = sha1.New()
+	case strings.HasSuffix(name, "_SHA512"):
+		h = sha512.New()
+	default:
+		h = sha256.New()
+	}
+	plaintext, err := rsa.DecryptOAEP(h, nil, v.decrypter, req.Ciphertext, nil)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.InvalidArgument, "Decryption failed.")
+	}
+
+	return &kmspb.AsymmetricDecryptResponse{
+		Plaintext:       plaintext,
+		ProtectionLevel: v.ckv.ProtectionLevel,
+	}, nil
+}
+
+// resource returns an error if the resource of an IAM request is not a key
+// ring or crypto key. The caller must hold the lock.
+func (f *fakeKMSClient) resource(name string) error {
+	if _, ok := f.keyRings[name]; ok {
+		return nil
+	}
+	if _, ok := f.cryptoKeys[name]; ok {
+		return nil
+	}
+	return fakeNotFound(name)
+}
+
+// GetIamPolicy implements keyManagementClient.
+func (f *fakeKMSClient) GetIamPolicy(_ context.Context, req *iampb.GetIamPolicyRequest, _ ...gax.CallOption) (*iampb.Policy, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.resource(req.Resource); err != nil {
+		return nil, err
+	}
+	policy, ok := f.policies[req.Resource]
+	if !ok {
+		return &iampb.Policy{Etag: []byte("0")}, nil
+	}
+	return proto.Clone(policy).(*iampb.Policy), nil
+}
+
+// SetIamPolicy implements keyManagementClient. Like KMS, it aborts if the etag
+// of the policy is set and is not the etag of the current policy.
+func (f *fakeKMSClient) SetIamPolicy(_ context.Context, req *iampb.SetIamPolicyRequest, _ ...gax.CallOption) (*iampb.Policy, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.resource(req.Resource); err != nil {
+		return nil, err
+	}
+	current := []byte("0")
+	if policy, ok := f.policies[req.Resource]; ok {
+		current = policy.Etag
+	}
+	if len(req.Policy.GetEtag()) > 0 && string(req.Policy.Etag) != string(current) {
+		return nil, grpcstatus.Error(grpccodes.Aborted, "There were concurrent policy changes.")
+	}
+
+	f.etag++
+	policy := &iampb.Policy{}
+	if req.Policy != nil {
+		policy = proto.Clone(req.Policy).(*iampb.Policy)
+	}
+	policy.Etag = []byte(fmt.Sprintf("%d", f.etag))
+	f.policies[req.Resource] = policy
+	return proto.Clone(policy).(*iampb.Policy), nil
+}
+
+// TestIamPermissions implements keyManagementClient. The caller has every
+// permission.
+func (f *fakeKMSClient) TestIamPermissions(_ context.Context, req *iampb.TestIamPermissionsRequest, _ ...gax.CallOption) (*iampb.TestIamPermissionsResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.resource(req.Resource); err != nil {
+		return nil, err
+	}
+	return &iampb.TestIamPermissionsResponse{
+		Permissions: append([]string(nil), req.Permissions...),
+	}, nil
+}
+
+// ListLocations implements keyManagementClient. The locations are global and
+// the locations of the project's key rings.
+func (f *fakeKMSClient) ListLocations(_ context.Context, req *locationpb.ListLocationsRequest, _ ...gax.CallOption) locationIterator {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ids := map[string]bool{"global": true}
+	for name := range f.keyRings {
+		if location := path.Dir(path.Dir(name)); path.Dir(path.Dir(location)) == req.Name {
+			ids[path.Base(location)] = true
+		}
+	}
+
+	it := new(fakeIterator[*locationpb.Location])
+	for id := range ids {
+		it.items = append(it.items, &locationpb.Location{
+			Name:       req.Name + "/locations/" + id,
+			LocationId: id,
+		})
+	}
+	sort.Slice(it.items, func(i, j int) bool { return it.items[i].Name < it.items[j].Name })
+	return it
+}
+
+// Close implements keyManagementClient.
+func (f *fakeKMSClient) Close() error {
+	return nil
+}
+
+func TestFakeKMSClient_EncryptDecrypt(t *testing.T) {
+
+	b, storage := testBackend(t)
+	f := testFakeKMSClient(t, b)
+	cryptoKey := testFakeCryptoKey(t, f, kmspb.CryptoKey_ENCRYPT_DECRYPT,
+		kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+
+	ctx := context.Background()
+	if err := b.putKey(ctx, storage, &Key{
+		Name:        "my-key",
+		CryptoKeyID: cryptoKey,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "encrypt/my-key",
+		Data: map[string]interface{}{
+			"plaintext":                     "hello world",
+			"additional_authenticated_data": "context",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema.ValidateResponse(t, schema.GetResponseSchema(t, b.Route("encrypt/my-key"), logical.UpdateOperation), resp, true)
+	if v, exp := resp.Data["key_version"], "1"; v != exp {
+		t.Errorf("expected %v to be %q", v, exp)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "decrypt/my-key",
+		Data: map[string]interface{}{
+			"ciphertext":                    resp.Data["ciphertext"],
+			"additional_authenticated_data": "context",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema.ValidateResponse(t, schema.GetResponseSchema(t, b.Route("decrypt/my-key"), logical.UpdateOperation), resp, true)
+	if v, exp := resp.Data["plaintext"], "hello world"; v != exp {
+		t.Errorf("expected %v to be %q", v, exp)
+	}
+}
+
+func TestFakeKMSClient_SignVerify(t *testing.T) {
+
+	algorithms := []kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
+		kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+	}
+
+	for _, algo := range algorithms {
+		algo := algo
+
+		t.Run(strings.ToLower(algo.String()), func(t *testing.T) {
+
+			b, storage := testBackend(t)
+			f := testFakeKMSClient(t, b)
+			cryptoKey := testFakeCryptoKey(t, f, kmspb.CryptoKey_ASYMMETRIC_SIGN, algo)
+
+			ctx := context.Background()
+			if err := b.putKey(ctx, storage, &Key{
+				Name:        "my-key",
+				CryptoKeyID: cryptoKey,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			sum := sha256.Sum256([]byte("hello world"))
+			digest := base64.StdEncoding.EncodeToString(sum[:])
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "sign/my-key",
+				Data: map[string]interface{}{
+					"digest":      digest,
+					"key_version": 1,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema.ValidateResponse(t, schema.GetResponseSchema(t, b.Route("sign/my-key"), logical.UpdateOperation), resp, true)
+
+			resp, err = b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "verify/my-key",
+				Data: map[string]interface{}{
+					"digest":      digest,
+					"signature":   resp.Data["signature"],
+					"key_version": 1,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema.ValidateResponse(t, schema.GetResponseSchema(t, b.Route("verify/my-key"), logical.UpdateOperation), resp, true)
+			if v, exp := resp.Data["valid"], true; v != exp {
+				t.Errorf("expected %v to be %v", v, exp)
+			}
+		})
+	}
+}
+
+func TestLatestKeyVersion(t *testing.T) {
+
+	f := newFakeKMSClient()
+	cryptoKey := testFakeCryptoKey(t, f, kmspb.CryptoKey_ENCRYPT_DECRYPT,
+		kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := f.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+			Parent: cryptoKey,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.UpdateCryptoKeyVersion(ctx, &kmspb.UpdateCryptoKeyVersionRequest{
+		CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+			Name:  cryptoKey + "/cryptoKeyVersions/4",
+			State: kmspb.CryptoKeyVersion_DISABLED,
+		},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"state"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		key  *Key
+		exp  int
+		err  bool
+	}{
+		{"newest_enabled", &Key{CryptoKeyID: cryptoKey}, 3, false},
+		{"max_version", &Key{CryptoKeyID: cryptoKey, MaxVersion: 2}, 2, false},
+		{"min_version", &Key{CryptoKeyID: cryptoKey, MinVersion: 4}, 0, true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			v, err := latestKeyVersion(ctx, f, tc.key)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if v != tc.exp {
+				t.Errorf("expected %d to be %d", v, tc.exp)
+			}
+		})
+	}
+}
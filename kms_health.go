@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// kmsHealth records the outcome of the most recent calls to KMS, so the status
+// endpoint can report whether KMS is reachable.
+type kmsHealth struct {
+	lock        sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+// observe records the result of a call to KMS.
+func (h *kmsHealth) observe(err error) {
+	now := time.Now().UTC()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if err == nil {
+		h.lastSuccess = now
+		return
+	}
+	h.lastFailure = now
+	h.lastError = err.Error()
+}
+
+// status returns the times of the last successful and failed calls to KMS,
+// and the error of the last failed call.
+func (h *kmsHealth) status() (time.Time, time.Time, string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.lastSuccess, h.lastFailure, h.lastError
+}
+
+// unaryInterceptor is a gRPC interceptor which records the result of every
+// call made by the KMS client.
+func (h *kmsHealth) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	h.observe(err)
+	return err
+}
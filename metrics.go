@@ -0,0 +1,140 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/grpc"
+
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// metricsPrefix is the prefix of every metric emitted by the plugin.
+const metricsPrefix = "gcpkms"
+
+// instrumentPaths wraps the callbacks of the paths so each request emits a
+// count and a latency, labelled with the path, operation, and response code,
+// and with the key name if metrics_key_labels is configured.
+func (b *backend) instrumentPaths(paths []*framework.Path) {
+	wrapPathCallbacks(paths, func(p *framework.Path, f framework.OperationFunc) framework.OperationFunc {
+		return b.withMetrics(metricsPathName(p.Pattern), f)
+	})
+}
+
+// withMetrics wraps an OperationFunc and emits metrics for each request.
+func (b *backend) withMetrics(name string, f framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		start := time.Now()
+		resp, err := f(ctx, req, d)
+
+		labels := []metrics.Label{
+			{Name: "path", Value: name},
+			{Name: "operation", Value: string(req.Operation)},
+			{Name: "code", Value: strconv.Itoa(metricsResponseCode(resp, err))},
+		}
+		if l, ok := b.metricsKeyLabel(ctx, req, d); ok {
+			labels = append(labels, l)
+		}
+
+		metrics.IncrCounterWithLabels([]string{metricsPrefix, "request"}, 1, labels)
+		metrics.MeasureSinceWithLabels([]string{metricsPrefix, "request", "duration"}, start, labels)
+		return resp, err
+	}
+}
+
+// metricsKeyLabel returns the label of the key the request is for, if the path
+// takes a key and metrics_key_labels is configured. Key names are unbounded,
+// so the label is opt-in to keep the cardinality of metrics down.
+func (b *backend) metricsKeyLabel(ctx context.Context, req *logical.Request, d *framework.FieldData) (metrics.Label, bool) {
+	if _, ok := d.Schema["key"]; !ok {
+		return metrics.Label{}, false
+	}
+	key, ok := d.Raw["key"].(string)
+	if !ok {
+		return metrics.Label{}, false
+	}
+
+	c, err := b.Config(ctx, req.Storage)
+	if err != nil || !c.MetricsKeyLabels {
+		return metrics.Label{}, false
+	}
+	return metrics.Label{Name: "key", Value: key}, true
+}
+
+// metricsResponseCode returns the HTTP status code Vault responds with for the
+// response and error of a callback.
+func metricsResponseCode(resp *logical.Response, err error) int {
+	if err != nil {
+		if v, ok := err.(logical.HTTPCodedError); ok {
+			return v.Code()
+		}
+		switch err {
+		case logical.ErrInvalidRequest:
+			return 400
+		case logical.ErrPermissionDenied:
+			return 403
+		}
+		return 500
+	}
+	if resp != nil && resp.IsError() {
+		return 400
+	}
+	return 200
+}
+
+// metricsPathName returns a stable name for the path pattern, with each named
+// capture group replaced by its name, such as "decrypt/:key".
+func metricsPathName(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if !strings.HasPrefix(pattern[i:], "(?P<") {
+			b.WriteByte(pattern[i])
+			continue
+		}
+
+		end := strings.IndexByte(pattern[i:], '>')
+		if end < 0 {
+			b.WriteString(pattern[i:])
+			break
+		}
+		b.WriteString(":" + pattern[i+len("(?P<"):i+end])
+
+		// Skip to the end of the group
+		depth := 0
+		for ; i < len(pattern); i++ {
+			switch pattern[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+		}
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(b.String(), "$"), "/?")
+}
+
+// metricsInterceptor is a gRPC interceptor which emits the latency of each
+// call to KMS, labelled with the method and gRPC code, separately from the
+// latency of the requests which make them.
+func metricsInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+
+	metrics.MeasureSinceWithLabels([]string{metricsPrefix, "kms", "rpc"}, start, []metrics.Label{
+		{Name: "method", Value: path.Base(method)},
+		{Name: "code", Value: grpcstatus.Code(err).String()},
+	})
+	return err
+}
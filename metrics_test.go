@@ -0,0 +1,112 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMetricsPathName(t *testing.T) {
+
+	cases := []struct {
+		pattern string
+		exp     string
+	}{
+		{"config", "config"},
+		{"keys/?$", "keys"},
+		{"keys/rotate-all$", "keys/rotate-all"},
+		{"decrypt/" + framework.GenericNameRegex("key"), "decrypt/:key"},
+		{"keys/" + framework.GenericNameRegex("key") + "/iam", "keys/:key/iam"},
+		{
+			"keyrings/" + framework.GenericNameRegex("location") + "/" +
+				framework.GenericNameRegex("keyring") + "/keys/?$",
+			"keyrings/:location/:keyring/keys",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.exp, func(t *testing.T) {
+			if v := metricsPathName(tc.pattern); v != tc.exp {
+				t.Errorf("expected %q to be %q", v, tc.exp)
+			}
+		})
+	}
+}
+
+func TestMetricsResponseCode(t *testing.T) {
+
+	cases := []struct {
+		name string
+		resp *logical.Response
+		err  error
+		exp  int
+	}{
+		{"success", nil, nil, 200},
+		{"error_response", logical.ErrorResponse("bad"), logical.ErrInvalidRequest, 400},
+		{"coded", nil, logical.CodedError(429, "slow down"), 429},
+		{"internal", nil, errors.New("boom"), 500},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			if v := metricsResponseCode(tc.resp, tc.err); v != tc.exp {
+				t.Errorf("expected %d to be %d", v, tc.exp)
+			}
+		})
+	}
+}
+
+func TestBackend_MetricsKeyLabel(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	req := &logical.Request{Storage: storage}
+	d := &framework.FieldData{
+		Raw: map[string]interface{}{"key": "my-key"},
+		Schema: map[string]*framework.FieldSchema{
+			"key": {Type: framework.TypeString},
+		},
+	}
+
+	if l, ok := b.metricsKeyLabel(ctx, req, d); ok {
+		t.Errorf("expected no key label by default, got %#v", l)
+	}
+
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"metrics_key_labels": true,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	l, ok := b.metricsKeyLabel(ctx, req, d)
+	if !ok {
+		t.Fatal("expected a key label")
+	}
+	if v, exp := l.Value, "my-key"; v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+
+	// Paths which do not take a key have no key label
+	if l, ok := b.metricsKeyLabel(ctx, req, &framework.FieldData{
+		Raw:    map[string]interface{}{"key": "my-key"},
+		Schema: map[string]*framework.FieldSchema{},
+	}); ok {
+		t.Errorf("expected no key label, got %#v", l)
+	}
+}
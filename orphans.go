@@ -0,0 +1,261 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iterator"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// orphanReportStoragePath is the storage path of the result of the last
+// orphan check. It is outside "keys/" so it cannot collide with a key.
+const orphanReportStoragePath = "reports/orphans"
+
+// orphanReport is the result of an orphan check. MissingCryptoKeys maps the
+// keys whose crypto keys no longer exist to their crypto key IDs, and
+// UnregisteredCryptoKeys are the crypto keys with the mount label which have
+// no key in Vault. Errors are the checks which could not be made.
+type orphanReport struct {
+	CheckTime              time.Time         `json:"check_time"`
+	MissingCryptoKeys      map[string]string `json:"missing_crypto_keys"`
+	UnregisteredCryptoKeys []string          `json:"unregistered_crypto_keys"`
+	Errors                 []string          `json:"errors,omitempty"`
+}
+
+// parseMountLabel returns the key and value of a mount label given as
+// "key=value".
+func parseMountLabel(s string) (string, string, error) {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", "", fmt.Errorf("mount_label must be given as key=value, got %q", s)
+	}
+	if err := validateLabels(map[string]string{k: v}); err != nil {
+		return "", "", errwrap.Wrapf("invalid mount_label: {{err}}", err)
+	}
+	return k, v, nil
+}
+
+// orphanCheckDue returns true if the interval has passed since the last
+// orphan check, and records now as the last run if so. A zero interval
+// disables the check.
+func (b *backend) orphanCheckDue(now time.Time, interval time.Duration) bool {
+	if interval <= 0 {
+		return false
+	}
+
+	b.orphanCheckLock.Lock()
+	defer b.orphanCheckLock.Unlock()
+
+	if now.Sub(b.orphanCheckLastRun) < interval {
+		return false
+	}
+	b.orphanCheckLastRun = now
+	return true
+}
+
+// autoOrphanCheck checks keys for orphaned crypto keys if the configured
+// orphan check interval has passed.
+func (b *backend) autoOrphanCheck(ctx context.Context, s logical.Storage, now time.Time) error {
+	c, err := b.Config(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !b.orphanCheckDue(now, c.OrphanCheckInterval) {
+		return nil
+	}
+
+	r, err := b.orphanCheck(ctx, s, now)
+	if err != nil {
+		return err
+	}
+	for _, e := range r.Errors {
+		b.Logger().Warn("failed to check for orphaned crypto keys", "error", e)
+	}
+	return nil
+}
+
+// orphanCheck reads the crypto key of every key to find those which no longer
+// exist and, if the config sets a mount label, lists the crypto keys with the
+// label in the key rings of the keys to find those with no key in Vault. The
+// report is saved and returned. Failures to reach KMS are recorded in the
+// report rather than returned.
+func (b *backend) orphanCheck(ctx context.Context, s logical.Storage, now time.Time) (*orphanReport, error) {
+	c, err := b.Config(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := b.keyIndex(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &orphanReport{
+		CheckTime:              now,
+		MissingCryptoKeys:      make(map[string]string),
+		UnregisteredCryptoKeys: []string{},
+	}
+
+	registered := make(map[string]bool, len(index))
+	keyRings := make(map[string]*Key)
+	for _, name := range keyIndexNames(index) {
+		k, err := b.Key(ctx, s, name)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				continue
+			}
+			return nil, err
+		}
+		registered[k.CryptoKeyID] = true
+		if kr := path.Dir(path.Dir(k.CryptoKeyID)); keyRings[kr] == nil {
+			keyRings[kr] = k
+		}
+
+		missing, err := b.cryptoKeyMissing(ctx, s, k)
+		if err != nil {
+			r.Errors = append(r.Errors, fmt.Sprintf("key %s: %s", k.Name, err))
+			continue
+		}
+		if missing {
+			r.MissingCryptoKeys[k.Name] = k.CryptoKeyID
+			b.Logger().Warn("crypto key of key no longer exists",
+				"key", k.Name, "crypto_key", k.CryptoKeyID)
+		}
+	}
+
+	if c.MountLabel != "" && len(keyRings) > 0 {
+		unregistered, errs := b.unregisteredCryptoKeys(ctx, s, c.MountLabel, keyRings, registered)
+		r.UnregisteredCryptoKeys = append(r.UnregisteredCryptoKeys, unregistered...)
+		r.Errors = append(r.Errors, errs...)
+		for _, ck := range unregistered {
+			b.Logger().Warn("crypto key created by this mount has no key in Vault",
+				"crypto_key", ck)
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(orphanReportStoragePath, r)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return nil, errwrap.Wrapf("failed to write to storage: {{err}}", err)
+	}
+	return r, nil
+}
+
+// cryptoKeyMissing returns true if the key's crypto key no longer exists.
+func (b *backend) cryptoKeyMissing(ctx context.Context, s logical.Storage, k *Key) (bool, error) {
+	kmsClient, closer, err := b.KeyKMSClient(ctx, s, k)
+	if err != nil {
+		return false, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	if _, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: k.CryptoKeyID,
+	}); err != nil {
+		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+			return true, nil
+		}
+		return false, wrapKMSError("failed to read crypto key: {{err}}", err)
+	}
+	return false, nil
+}
+
+// unregisteredCryptoKeys returns the sorted IDs of the crypto keys in the key
+// rings which have the mount label and are not registered, and the errors of
+// the key rings which could not be listed. Each key ring is listed with the
+// client of a key in it, since keys may use their own endpoint or identity.
+func (b *backend) unregisteredCryptoKeys(ctx context.Context, s logical.Storage, mountLabel string, keyRings map[string]*Key, registered map[string]bool) ([]string, []string) {
+	label, value, err := parseMountLabel(mountLabel)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+
+	names := make([]string, 0, len(keyRings))
+	for kr := range keyRings {
+		names = append(names, kr)
+	}
+	sort.Strings(names)
+
+	var unregistered, errs []string
+	for _, kr := range names {
+		found, err := b.labeledCryptoKeys(ctx, s, keyRings[kr], kr, label, value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("key ring %s: %s", kr, err))
+			continue
+		}
+		for _, ck := range found {
+			if !registered[ck] {
+				unregistered = append(unregistered, ck)
+			}
+		}
+	}
+	sort.Strings(unregistered)
+	return unregistered, errs
+}
+
+// labeledCryptoKeys lists the crypto keys in the key ring which have the
+// label, using the client of the given key in the key ring.
+func (b *backend) labeledCryptoKeys(ctx context.Context, s logical.Storage, k *Key, keyRing, label, value string) ([]string, error) {
+	kmsClient, closer, err := b.KeyKMSClient(ctx, s, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	var names []string
+	it := kmsClient.ListCryptoKeys(ctx, &kmspb.ListCryptoKeysRequest{
+		Parent: keyRing,
+		Filter: fmt.Sprintf("labels.%s=%s", label, value),
+	})
+	for {
+		ck, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				return names, nil
+			}
+			return nil, wrapKMSError("failed to list crypto keys: {{err}}", err)
+		}
+		if labelsMatch(ck.Labels, map[string]string{label: value}) {
+			names = append(names, ck.Name)
+		}
+	}
+}
+
+// lastOrphanReport returns the result of the last orphan check, or nil if
+// keys have not been checked.
+func (b *backend) lastOrphanReport(ctx context.Context, s logical.Storage) (*orphanReport, error) {
+	entry, err := s.Get(ctx, orphanReportStoragePath)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read orphan report: {{err}}", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var r orphanReport
+	if err := entry.DecodeJSON(&r); err != nil {
+		return nil, errwrap.Wrapf("failed to decode orphan report: {{err}}", err)
+	}
+	return &r, nil
+}
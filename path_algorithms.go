@@ -0,0 +1,132 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func (b *backend) pathAlgorithms() *framework.Path {
+	return &framework.Path{
+		Pattern: "algorithms",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "read",
+			OperationSuffix: "algorithms",
+		},
+
+		HelpSynopsis: "List the key algorithms and whether each may be created",
+		HelpDescription: `
+List every combination of key purpose, algorithm, and protection level which
+may be given when creating a key at keys/:key, so UIs and automation can build
+valid requests. Each entry includes the digest algorithm signatures require,
+whether the algorithm is FIPS-approved, and whether the mount's allowed
+protection levels, allowed algorithms, and FIPS enforcement permit creating
+such a key, with the reason if not. Reading the algorithms does not call KMS.
+
+    $ vault read gcpkms/algorithms
+`,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathAlgorithmsRead),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"purposes": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Key purposes.",
+								Required:    true,
+							},
+							"protection_levels": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Key protection levels.",
+								Required:    true,
+							},
+							"algorithms": &framework.FieldSchema{
+								Type: framework.TypeSlice,
+								Description: "Combinations of purpose, algorithm, and protection " +
+									"level, and whether each is enabled.",
+								Required: true,
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// pathAlgorithmsRead corresponds to GET gcpkms/algorithms and is used to list
+// the key algorithms and whether the config allows creating keys using them.
+func (b *backend) pathAlgorithmsRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	c, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	protectionLevels := keyProtectionLevelNames()
+	algorithms := make([]map[string]interface{}, 0, len(keyAlgorithms)*len(protectionLevels))
+	for _, name := range keyAlgorithmNames() {
+		a := keyAlgorithms[name]
+		for _, pl := range protectionLevels {
+			entry := map[string]interface{}{
+				"purpose":          purposeToString(algorithmPurpose(a)),
+				"algorithm":        name,
+				"protection_level": pl,
+				"fips_approved":    fipsAlgorithms[a],
+				"enabled":          true,
+			}
+			if digest := signDigestAlgorithm(a); digest != "" {
+				entry["digest_algorithm"] = digest
+				entry["digest_bits"] = digestAlgorithms[digest]
+			}
+
+			// Creating a key checks its version template against the config
+			if err := c.checkCryptoKey(&kmspb.CryptoKey{
+				VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+					ProtectionLevel: keyProtectionLevels[pl],
+					Algorithm:       a,
+				},
+			}); err != nil {
+				entry["enabled"] = false
+				entry["reason"] = err.Error()
+			}
+			algorithms = append(algorithms, entry)
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"purposes":          keyPurposeNames(),
+			"protection_levels": protectionLevels,
+			"algorithms":        algorithms,
+		},
+	}, nil
+}
+
+// signDigestAlgorithm returns the name of the digest algorithm which digests
+// signed by the algorithm must use, or the empty string if the algorithm is
+// not a signing algorithm.
+func signDigestAlgorithm(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) string {
+	if algorithmPurpose(a) != kmspb.CryptoKey_ASYMMETRIC_SIGN {
+		return ""
+	}
+	name := strings.ToLower(a.String())
+	for digest := range digestAlgorithms {
+		if strings.HasSuffix(name, "_"+digest) {
+			return digest
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/testhelpers/schema"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathAlgorithms_Read(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "algorithms")
+	})
+
+	read := func(t *testing.T, b *backend, storage logical.Storage) map[string]map[string]interface{} {
+		t.Helper()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "algorithms",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema.ValidateResponse(t, schema.GetResponseSchema(t, b.Route("algorithms"), logical.ReadOperation), resp, true)
+
+		entries := make(map[string]map[string]interface{})
+		for _, v := range resp.Data["algorithms"].([]map[string]interface{}) {
+			entries[v["algorithm"].(string)+"/"+v["protection_level"].(string)] = v
+		}
+		return entries
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		entries := read(t, b, storage)
+		if v, exp := len(entries), len(keyAlgorithms)*len(keyProtectionLevels); v != exp {
+			t.Errorf("expected %d entries, got %d", exp, v)
+		}
+		for k, v := range entries {
+			if v["enabled"] != true {
+				t.Errorf("expected %s to be enabled, got %#v", k, v)
+			}
+		}
+
+		sign := entries["ec_sign_p384_sha384/hsm"]
+		if v, exp := sign["purpose"], "asymmetric_sign"; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+		if v, exp := sign["digest_algorithm"], "sha384"; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+		if v, exp := sign["digest_bits"], 384; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+
+		decrypt := entries["rsa_decrypt_oaep_2048_sha1/software"]
+		if v, exp := decrypt["fips_approved"], false; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+		if _, ok := decrypt["digest_algorithm"]; ok {
+			t.Errorf("expected no digest algorithm, got %#v", decrypt)
+		}
+	})
+
+	t.Run("guardrails", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		if _, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"allowed_algorithms": []string{"symmetric_encryption", "rsa_decrypt_oaep_2048_sha1"},
+				"fips_enforcement":   true,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		entries := read(t, b, storage)
+
+		cases := []struct {
+			name    string
+			enabled bool
+		}{
+			{"symmetric_encryption/hsm", true},
+			{"symmetric_encryption/software", false},
+			{"rsa_decrypt_oaep_2048_sha1/hsm", false},
+			{"ec_sign_p256_sha256/hsm", false},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+
+				entry := entries[tc.name]
+				if v := entry["enabled"]; v != tc.enabled {
+					t.Errorf("expected enabled to be %t, got %v", tc.enabled, v)
+				}
+				if _, ok := entry["reason"]; ok == tc.enabled {
+					t.Errorf("expected a reason only when disabled, got %#v", entry)
+				}
+			})
+		}
+	})
+}
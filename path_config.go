@@ -5,6 +5,9 @@ package gcpkms
 
 import (
 	"context"
+	"net/http"
+	"reflect"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -33,11 +36,436 @@ use the Default Application Credentials or instance metadata authentication.
 `,
 			},
 
+			"access_token": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+OAuth access token to use for authenticating to Google Cloud, for environments
+which broker tokens outside of Vault. The token is not refreshed, so it must be
+rewritten before it expires. This cannot be set with credentials.
+`,
+			},
+
+			"access_token_file": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Path of a file on the Vault server holding an OAuth access token to use for
+authenticating to Google Cloud. The file is read again whenever the token
+expires or is rejected, so an external broker can keep it up to date. The file
+must be in the directory named by the plugin's GCPKMS_ACCESS_TOKEN_DIR
+environment variable, which is set when registering the plugin, must not be
+writable by group or others, and must hold only the token. This cannot be set
+with credentials or access_token.
+`,
+			},
+
 			"scopes": &framework.FieldSchema{
 				Type: framework.TypeCommaStringSlice,
 				Description: `
 The list of full-URL scopes to request when authenticating. By default, this
-requests https://www.googleapis.com/auth/cloudkms.
+requests https://www.googleapis.com/auth/cloudkms. This cannot be set with
+access_token or access_token_file, since the scopes of those tokens are chosen
+when they are issued.
+`,
+			},
+
+			"impersonate_service_account": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Email of a service account to impersonate when calling Google Cloud KMS. The
+credentials mint short-lived tokens for this service account with the IAM
+Credentials API, so they need the "roles/iam.serviceAccountTokenCreator" role
+on it, or on the first of the delegates. Leave this blank to call Google Cloud
+KMS with the credentials directly.
+`,
+			},
+
+			"delegates": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+Ordered list of service account emails in the delegation chain from the
+credentials to impersonate_service_account. Each service account needs the
+"roles/iam.serviceAccountTokenCreator" role on the next one in the chain.
+`,
+			},
+
+			"api_endpoint": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Host and port of the Google Cloud KMS API, such as
+"cloudkms.p.googleapis.com:443" for Private Service Connect or
+"localhost:9010" for an emulator. Leave this blank to use the default
+endpoint. Keys may override this with keys/config.
+`,
+			},
+
+			"annotate_requests": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, the Vault request ID is sent with each call to Google Cloud KMS as the
+request reason, which Cloud Audit Logs records, so audit log entries can be
+joined with the Vault audit log. The default is false.
+`,
+			},
+
+			"annotate_entity_id": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, the ID of the Vault entity making the request is also sent with each
+call to Google Cloud KMS when annotate_requests is enabled. The default is
+false.
+`,
+			},
+
+			"regional_endpoints": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, calls for each key are sent to the regional Google Cloud KMS endpoint
+of the key's location, such as "us-east1-cloudkms.googleapis.com", to reduce
+cross-region latency and keep requests in the key's region. Calls for global
+keys and calls which are not for a key use the default endpoint. This cannot
+be set with api_endpoint. The default is false.
+`,
+			},
+
+			"proxy_url": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+URL of an HTTP or HTTPS proxy through which Google Cloud KMS and the Google
+token endpoints are reached, such as "http://proxy.example.com:3128". Proxy
+credentials may be given in the URL. Leave this blank to connect directly.
+`,
+			},
+
+			"ca_certificate": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+PEM-encoded bundle of CA certificates to trust when connecting to Google Cloud
+KMS, the Google token endpoints, and an HTTPS proxy, for networks which
+intercept TLS. Leave this blank to trust the system roots.
+`,
+			},
+
+			"universe_domain": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Domain of the Google Cloud universe in which Google Cloud KMS is reached, for
+Trusted Partner Cloud and sovereign cloud deployments. The credentials must be
+for the same universe. Leave this blank to use "googleapis.com".
+`,
+			},
+
+			"quota_project": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Project which calls to Google Cloud KMS are billed and counted against, sent
+as the user project header. The credentials need the
+"serviceusage.services.use" permission on this project. Leave this blank to
+use the project of the credentials.
+`,
+			},
+
+			"client_lifetime": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Amount of time the Google Cloud KMS client is cached before it is recreated.
+The client refreshes its own access token, so this only needs to be lowered to
+pick up changes to the default credentials sooner. The default is 24h.
+`,
+			},
+
+			"grpc_conn_pool_size": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Number of gRPC connections the Google Cloud KMS client spreads calls over.
+High-throughput deployments may need more connections. Set to 0 to use the
+client library default.
+`,
+			},
+
+			"request_timeout": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Maximum amount of time all the calls to Google Cloud KMS made for a single
+request may take. Set to 0 for no overall limit. The default is 0.
+`,
+			},
+
+			"crypto_operation_timeout": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Maximum amount of time a single encrypt, decrypt, sign, or public key call to
+Google Cloud KMS may take, including retries. Set to 0 to use the client
+library default. The default is 30s.
+`,
+			},
+
+			"admin_operation_timeout": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Maximum amount of time any other single call to Google Cloud KMS, such as
+creating or rotating a key, may take, including retries. Set to 0 to use the
+client library default. The default is 60s.
+`,
+			},
+
+			"retry_max_attempts": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Maximum number of attempts of each call to Google Cloud KMS, including the
+first. Set to 1 to disable retries, or to 0 to use the client library default
+retry policy. The default is 5.
+`,
+			},
+
+			"retry_initial_backoff": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Backoff before the first retry of a failed call to Google Cloud KMS, such as
+"100ms". The backoff doubles with each retry, and each wait is randomized
+between 0 and the backoff. The default is 100ms.
+`,
+			},
+
+			"retry_max_backoff": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Maximum backoff between retries of a failed call to Google Cloud KMS, such as
+"5s". The default is 5s.
+`,
+			},
+
+			"retry_codes": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+List of gRPC codes on which calls to Google Cloud KMS are retried. Options are
+"aborted", "deadline_exceeded", "internal", "resource_exhausted",
+"unavailable", and "unknown". The default is "aborted", "resource_exhausted",
+and "unavailable". Calls which create resources are never retried.
+`,
+			},
+
+			"rate_limit": &framework.FieldSchema{
+				Type: framework.TypeFloat,
+				Description: `
+Maximum number of encrypt, decrypt, reencrypt, sign, verify, pubkey, and
+wrapkey operations per second across all keys. Operations over the limit fail
+with a 429. Set to 0 for no limit. The default is 0.
+`,
+			},
+
+			"key_rate_limit": &framework.FieldSchema{
+				Type: framework.TypeFloat,
+				Description: `
+Maximum number of encrypt, decrypt, reencrypt, sign, verify, pubkey, and
+wrapkey operations per second on each key. Operations over the limit fail with
+a 429. Set to 0 for no limit. The default is 0.
+`,
+			},
+
+			"key_max_concurrency": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Maximum number of concurrent encrypt, decrypt, reencrypt, sign, verify,
+pubkey, and wrapkey operations on each key. Operations over the limit fail with
+a 429. Set to 0 for no limit. The default is 0.
+`,
+			},
+
+			"rotation_period": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Period at which the service account key in credentials is automatically
+rotated, as with config/rotate-root, specified as a duration like "720h". The
+minimum is 1 hour. Set to 0 to disable automatic rotation. The default is 0.
+Rotation is checked by the plugin's periodic function, not Vault's rotation
+manager, which the Vault SDK this plugin is built with does not provide, so
+rotation_schedule and rotation_window are not supported and a rotation may
+run up to a minute after it is due.
+`,
+			},
+
+			"include_hmac": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, responses of encrypt, decrypt, and reencrypt include plaintext_hmac
+and ciphertext_hmac, HMACs of the data keyed per mount. These correlate
+operations on the same data across audit logs without logging the data. Add
+them to the mount's audit_non_hmac_response_keys so the audit log records them
+as is. The default is false.
+`,
+			},
+
+			"metrics_key_labels": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, request metrics are labelled with the name of the key. Each key adds
+its own series to every metric, so only enable this with a bounded number of
+keys. The default is false.
+`,
+			},
+
+			"forward_usage": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, encrypt, decrypt, reencrypt, sign, and verify requests sent to
+performance standbys are forwarded to the active node, so their usage is saved
+in keys/:key/stats. Standbys then do no crypto work, so only enable this if
+exact usage is needed. The default is false, which counts usage on each node.
+`,
+			},
+
+			"response_wrapping": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Policy for responses which return plaintext, such as from decrypt, for keys
+which do not set their own. Options are "none", "warn" to add a warning to
+responses which are not wrapped, or "force" to always wrap them in a cubbyhole
+response-wrapping token. The default is "none".
+`,
+			},
+
+			"response_wrap_ttl": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+TTL of the wrapping token for responses which are wrapped by force, specified
+as a duration like "5m". The default is 5 minutes.
+`,
+			},
+
+			"allowed_locations": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+List of Google Cloud locations (e.g. "us-east1" or "europe") in which keys may
+be created or registered, such as to keep keys within approved regions for
+data residency. Keys which are already registered are not affected. The default
+is to allow any location.
+`,
+			},
+
+			"allowed_projects": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+List of Google Cloud project IDs in which keys may be created or registered,
+checked against the project of the key ring or crypto key resource ID. This
+guards against binding the mount to a key in an unexpected project. Keys which
+are already registered are not affected. The default is to allow any project.
+`,
+			},
+
+			"allowed_protection_levels": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+List of protection levels (e.g. "hsm") of keys which may be created or
+registered. Keys which are already registered are not affected. The default is
+to allow any protection level.
+`,
+			},
+
+			"allowed_algorithms": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+List of algorithms (e.g. "symmetric_encryption" or
+"rsa_decrypt_oaep_3072_sha256") of keys which may be created or registered.
+Algorithms which Vault cannot create may be given by their lowercase Google
+Cloud KMS name, such as "rsa_decrypt_oaep_2048_sha1". Keys which are already
+registered are not affected. The default is to allow any algorithm.
+`,
+			},
+
+			"fips_enforcement": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+Only allow keys which use the "hsm" protection level and a FIPS-approved
+algorithm to be created, registered, or used for cryptographic operations.
+Raw PKCS#1 signing, the secp256k1 and Ed25519 curves, and SHA-1 digests are
+not FIPS-approved. The default is false.
+`,
+			},
+
+			"crypto_key_name_regex": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Regular expression which the names of crypto keys must match when keys are
+created, such as "^team-[a-z]+-". The default is to allow any name.
+`,
+			},
+
+			"key_ring_name_regex": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Regular expression which the names of key rings must match when keys are
+created. The default is to allow any name.
+`,
+			},
+
+			"crypto_key_name_template": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Template for the name of the crypto key when a key is created without
+crypto_key, such as "{{mount}}-{{name}}-{{uuid}}". The variables are {{mount}},
+the path of this mount with other characters replaced by hyphens, {{name}},
+the name of the key in Vault, and {{uuid}}, a random UUID. The default is to
+name the crypto key after the key in Vault.
+`,
+			},
+
+			"drift_check_interval": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Period at which keys are checked for changes made to their crypto keys outside
+of Vault, such as with gcloud or Terraform, specified as a duration like "24h".
+Each change is logged as a warning. Set to 0 to only check keys on request
+with keys/:key/drift. The default is 0.
+`,
+			},
+
+			"orphan_check_interval": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Period at which keys are checked for crypto keys which no longer exist in
+Google Cloud KMS, specified as a duration like "24h". If mount_label is set,
+crypto keys with the label which have no key in Vault are also reported. The
+results are saved to keys/orphans. Set to 0 to only check keys on request
+with keys/orphans. The default is 0.
+`,
+			},
+
+			"mount_label": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Label, given as "key=value", which is applied to crypto keys created by this
+mount, such as "vault-mount=gcpkms". Orphan checks report crypto keys with the
+label in the key rings of this mount's keys which have no key in Vault. The
+default is to not label crypto keys.
+`,
+			},
+
+			"attestation_google_roots": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+PEM-encoded Google Cloud HSM root certificates which anchor the Google card
+and partition certificate chains of HSM attestations, trusted in addition to
+those built into the plugin. Download them from the Google Cloud KMS
+documentation and check them out-of-band.
+`,
+			},
+
+			"attestation_manufacturer_roots": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+PEM-encoded HSM manufacturer (Cavium or Marvell) root certificates which
+anchor the manufacturer certificate chain of HSM attestations, trusted in
+addition to those built into the plugin.
+`,
+			},
+
+			"throttle_queue_depth": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+When Google Cloud KMS reports the project's quota is exhausted, calls to KMS
+are held back with an increasing backoff. This is the maximum number of calls
+which wait for the backoff to pass - further calls fail immediately with a 429.
+Set to 0 to fail every call while backing off. The default is 100.
 `,
 			},
 		},
@@ -47,18 +475,287 @@ requests https://www.googleapis.com/auth/cloudkms.
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.CreateOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathConfigWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationVerb: "configure",
 				},
 			},
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathConfigWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationVerb: "configure",
 				},
 			},
+			logical.PatchOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathConfigPatch),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb:   "patch",
+					OperationSuffix: "configuration",
+				},
+			},
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathConfigRead),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"credential_type": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Kind of the configured credentials.",
+								Required:    true,
+							},
+							"client_email": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Email of the service account of the credentials, if any.",
+								Required:    true,
+							},
+							"project_id": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Project of the credentials, if any.",
+								Required:    true,
+							},
+							"private_key_id": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "ID of the service account key of the credentials, if any.",
+								Required:    true,
+							},
+							"access_token_file": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Path of the file the access token is read from.",
+								Required:    true,
+							},
+							"scopes": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "OAuth scopes of the credentials.",
+								Required:    true,
+							},
+							"impersonate_service_account": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Service account the credentials impersonate.",
+								Required:    true,
+							},
+							"delegates": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Delegate service accounts of the impersonation.",
+								Required:    true,
+							},
+							"api_endpoint": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Cloud KMS API endpoint.",
+								Required:    true,
+							},
+							"regional_endpoints": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether keys use the endpoint of their location.",
+								Required:    true,
+							},
+							"annotate_requests": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether KMS requests are annotated with Vault request metadata.",
+								Required:    true,
+							},
+							"annotate_entity_id": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether annotations include the Vault entity ID.",
+								Required:    true,
+							},
+							"proxy_url": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "URL of the proxy for KMS, without credentials.",
+								Required:    true,
+							},
+							"ca_certificate": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "PEM-encoded CA certificates trusted for KMS.",
+								Required:    true,
+							},
+							"universe_domain": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Universe domain of Cloud KMS.",
+								Required:    true,
+							},
+							"quota_project": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Project billed for KMS quota.",
+								Required:    true,
+							},
+							"client_lifetime": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Lifetime of KMS clients in seconds.",
+								Required:    true,
+							},
+							"grpc_conn_pool_size": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Number of gRPC connections of each KMS client.",
+								Required:    true,
+							},
+							"request_timeout": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Timeout of KMS requests in seconds.",
+								Required:    true,
+							},
+							"crypto_operation_timeout": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Timeout of cryptographic KMS requests in seconds.",
+								Required:    true,
+							},
+							"admin_operation_timeout": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Timeout of administrative KMS requests in seconds.",
+								Required:    true,
+							},
+							"retry_max_attempts": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Maximum attempts of retried KMS requests.",
+								Required:    true,
+							},
+							"retry_initial_backoff": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Initial backoff between retries.",
+								Required:    true,
+							},
+							"retry_max_backoff": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Maximum backoff between retries.",
+								Required:    true,
+							},
+							"retry_codes": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "gRPC codes of KMS requests which are retried.",
+								Required:    true,
+							},
+							"rate_limit": &framework.FieldSchema{
+								Type:        framework.TypeFloat,
+								Description: "Maximum cryptographic operations per second across all keys.",
+								Required:    true,
+							},
+							"key_rate_limit": &framework.FieldSchema{
+								Type:        framework.TypeFloat,
+								Description: "Maximum cryptographic operations per second on each key.",
+								Required:    true,
+							},
+							"key_max_concurrency": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Maximum concurrent operations on each key.",
+								Required:    true,
+							},
+							"throttle_queue_depth": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Maximum KMS requests waiting for quota.",
+								Required:    true,
+							},
+							"rotation_period": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Period of automatic rotation of the credentials in seconds.",
+								Required:    true,
+							},
+							"response_wrapping": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Default response wrapping of plaintext.",
+								Required:    true,
+							},
+							"include_hmac": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether responses include HMACs of plaintext and ciphertext.",
+								Required:    true,
+							},
+							"metrics_key_labels": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether request metrics are labelled with the key name.",
+								Required:    true,
+							},
+							"forward_usage": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether counted operations are forwarded from performance standbys.",
+								Required:    true,
+							},
+							"response_wrap_ttl": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "TTL of wrapped plaintext responses in seconds.",
+								Required:    true,
+							},
+							"allowed_locations": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Locations of keys which may be created or registered.",
+								Required:    true,
+							},
+							"allowed_projects": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Projects of keys which may be created or registered.",
+								Required:    true,
+							},
+							"allowed_protection_levels": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Protection levels of keys which may be created or registered.",
+								Required:    true,
+							},
+							"allowed_algorithms": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Algorithms of keys which may be created or registered.",
+								Required:    true,
+							},
+							"fips_enforcement": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether keys must use FIPS-approved algorithms and protection levels.",
+								Required:    true,
+							},
+							"crypto_key_name_regex": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Pattern crypto key names must match.",
+								Required:    true,
+							},
+							"key_ring_name_regex": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Pattern key ring names must match.",
+								Required:    true,
+							},
+							"crypto_key_name_template": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Template of the names of new crypto keys.",
+								Required:    true,
+							},
+							"drift_check_interval": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Interval of periodic drift checks in seconds.",
+								Required:    true,
+							},
+							"orphan_check_interval": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Interval of periodic orphan checks in seconds.",
+								Required:    true,
+							},
+							"mount_label": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Label applied to crypto keys created by this mount.",
+								Required:    true,
+							},
+							"attestation_google_roots": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "PEM-encoded Google roots trusted for HSM attestations.",
+								Required:    true,
+							},
+							"attestation_manufacturer_roots": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "PEM-encoded manufacturer roots trusted for HSM attestations.",
+								Required:    true,
+							},
+						},
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationVerb:   "read",
 					OperationSuffix: "configuration",
@@ -66,6 +763,11 @@ requests https://www.googleapis.com/auth/cloudkms.
 			},
 			logical.DeleteOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathConfigDelete),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationVerb:   "delete",
 					OperationSuffix: "configuration",
@@ -94,10 +796,58 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, _ *f
 	if err != nil {
 		return nil, err
 	}
+	id := c.identity()
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"scopes": c.Scopes,
+			"credential_type":                id.Type,
+			"client_email":                   id.ClientEmail,
+			"project_id":                     id.ProjectID,
+			"private_key_id":                 id.PrivateKeyID,
+			"access_token_file":              c.AccessTokenFile,
+			"scopes":                         c.Scopes,
+			"impersonate_service_account":    c.ImpersonateServiceAccount,
+			"delegates":                      c.Delegates,
+			"api_endpoint":                   c.APIEndpoint,
+			"regional_endpoints":             c.RegionalEndpoints,
+			"annotate_requests":              c.AnnotateRequests,
+			"annotate_entity_id":             c.AnnotateEntityID,
+			"proxy_url":                      redactProxyURL(c.ProxyURL),
+			"ca_certificate":                 c.CACertificate,
+			"universe_domain":                c.universeDomain(),
+			"quota_project":                  c.QuotaProject,
+			"client_lifetime":                int64(c.ClientLifetime.Seconds()),
+			"grpc_conn_pool_size":            c.GRPCConnPoolSize,
+			"request_timeout":                int64(c.RequestTimeout.Seconds()),
+			"crypto_operation_timeout":       int64(c.CryptoOperationTimeout.Seconds()),
+			"admin_operation_timeout":        int64(c.AdminOperationTimeout.Seconds()),
+			"retry_max_attempts":             c.RetryMaxAttempts,
+			"retry_initial_backoff":          c.RetryInitialBackoff.String(),
+			"retry_max_backoff":              c.RetryMaxBackoff.String(),
+			"retry_codes":                    c.RetryCodes,
+			"rate_limit":                     c.RateLimit,
+			"key_rate_limit":                 c.KeyRateLimit,
+			"key_max_concurrency":            c.KeyMaxConcurrency,
+			"throttle_queue_depth":           c.ThrottleQueueDepth,
+			"rotation_period":                int64(c.RotationPeriod.Seconds()),
+			"response_wrapping":              c.ResponseWrapping,
+			"include_hmac":                   c.IncludeHMAC,
+			"metrics_key_labels":             c.MetricsKeyLabels,
+			"forward_usage":                  c.ForwardUsage,
+			"response_wrap_ttl":              int64(c.ResponseWrapTTL.Seconds()),
+			"allowed_locations":              c.AllowedLocations,
+			"allowed_projects":               c.AllowedProjects,
+			"allowed_protection_levels":      c.AllowedProtectionLevels,
+			"allowed_algorithms":             c.AllowedAlgorithms,
+			"fips_enforcement":               c.FIPSEnforcement,
+			"crypto_key_name_regex":          c.CryptoKeyNameRegex,
+			"key_ring_name_regex":            c.KeyRingNameRegex,
+			"crypto_key_name_template":       c.CryptoKeyNameTemplate,
+			"drift_check_interval":           int64(c.DriftCheckInterval.Seconds()),
+			"orphan_check_interval":          int64(c.OrphanCheckInterval.Seconds()),
+			"mount_label":                    c.MountLabel,
+			"attestation_google_roots":       c.AttestationGoogleRoots,
+			"attestation_manufacturer_roots": c.AttestationManufacturerRoots,
 		},
 	}, nil
 }
@@ -105,11 +855,15 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, _ *f
 // pathConfigWrite corresponds to both CREATE and UPDATE gcpkms/config and is
 // used to create or update the current configuration.
 func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
 	// Get the current configuration, if it exists
 	c, err := b.Config(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
+	old := *c
 
 	// Update the configuration
 	changed, err := c.Update(d)
@@ -117,6 +871,21 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, d *
 		return nil, logical.CodedError(400, err.Error())
 	}
 
+	// Start the rotation period from when the credentials were written or
+	// automatic rotation was enabled, rather than rotating them right away.
+	if c.Credentials != old.Credentials || (old.RotationPeriod == 0 && c.RotationPeriod > 0) {
+		c.LastRotated = time.Now().UTC()
+		changed = true
+	}
+
+	// Generate the HMAC key now, since requests which use it may be handled
+	// by standbys which cannot write it.
+	if c.IncludeHMAC {
+		if _, err := b.responseHMACKey(ctx, req.Storage); err != nil {
+			return nil, err
+		}
+	}
+
 	// Only do the following if the config is different
 	if changed {
 		// Generate a new storage entry
@@ -130,16 +899,65 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, d *
 			return nil, errwrap.Wrapf("failed to persist configuration to storage: {{err}}", err)
 		}
 
-		// Invalidate existing client so it reads the new configuration
-		b.ResetClient()
+		// Invalidate existing client so it reads the new configuration, unless
+		// only settings which the client does not use have changed
+		if clientConfigChanged(&old, c) {
+			b.ResetClient()
+		}
 	}
 
 	return nil, nil
 }
 
+// pathConfigPatch corresponds to PATCH gcpkms/config and is used to update
+// individual fields of the existing configuration. Fields which are not given
+// keep their current values, so credentials need not be resubmitted.
+func (b *backend) pathConfigPatch(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	exists, err := b.pathConfigExists(ctx, req, d)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, logical.CodedError(404, "no configuration exists to patch, "+
+			"write the configuration first")
+	}
+
+	return b.pathConfigWrite(ctx, req, d)
+}
+
+// clientConfigChanged returns true if the settings used to create KMS clients
+// differ between the configs. Only the automatic rotation of the credentials,
+// the settings for responses, and the key policy are not used by the clients.
+func clientConfigChanged(old, cur *Config) bool {
+	o, n := *old, *cur
+	o.RotationPeriod, n.RotationPeriod = 0, 0
+	o.LastRotated, n.LastRotated = time.Time{}, time.Time{}
+	o.ResponseWrapping, n.ResponseWrapping = "", ""
+	o.ResponseWrapTTL, n.ResponseWrapTTL = 0, 0
+	o.IncludeHMAC, n.IncludeHMAC = false, false
+	o.MetricsKeyLabels, n.MetricsKeyLabels = false, false
+	o.ForwardUsage, n.ForwardUsage = false, false
+	o.AllowedLocations, n.AllowedLocations = nil, nil
+	o.AllowedProjects, n.AllowedProjects = nil, nil
+	o.AllowedProtectionLevels, n.AllowedProtectionLevels = nil, nil
+	o.AllowedAlgorithms, n.AllowedAlgorithms = nil, nil
+	o.CryptoKeyNameRegex, n.CryptoKeyNameRegex = "", ""
+	o.KeyRingNameRegex, n.KeyRingNameRegex = "", ""
+	o.CryptoKeyNameTemplate, n.CryptoKeyNameTemplate = "", ""
+	o.DriftCheckInterval, n.DriftCheckInterval = 0, 0
+	o.OrphanCheckInterval, n.OrphanCheckInterval = 0, 0
+	o.MountLabel, n.MountLabel = "", ""
+	o.AttestationGoogleRoots, n.AttestationGoogleRoots = "", ""
+	o.AttestationManufacturerRoots, n.AttestationManufacturerRoots = "", ""
+	return !reflect.DeepEqual(o, n)
+}
+
 // pathConfigDelete corresponds to DELETE gcpkms/config and is used to delete
 // all the configuration.
 func (b *backend) pathConfigDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
 	if err := req.Storage.Delete(ctx, "config"); err != nil {
 		return nil, errwrap.Wrapf("failed to delete from storage: {{err}}", err)
 	}
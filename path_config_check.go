@@ -0,0 +1,277 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/iterator"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	// configCheckTimeout bounds each step of testing the config, so a
+	// blocked connection is reported instead of hanging the request.
+	configCheckTimeout = 30 * time.Second
+
+	// configCheckDefaultLocation is the location in which key rings are
+	// listed when testing the config, if none is given.
+	configCheckDefaultLocation = "global"
+)
+
+func (b *backend) pathConfigCheck() *framework.Path {
+	return &framework.Path{
+		Pattern: "config/test",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "test",
+			OperationSuffix: "configuration",
+		},
+
+		HelpSynopsis: "Test the credentials and connectivity of the config",
+		HelpDescription: `
+Test the config by resolving its credentials, fetching an oauth token, and
+listing key rings in Google Cloud KMS. The response reports each step, whether
+it succeeded, and for a failed step the error and a hint at the likely cause,
+such as malformed credentials, missing scopes, blocked egress, or a missing
+IAM role.
+
+    $ vault write -f gcpkms/config/test
+
+Key rings are listed in the "global" location of the credentials' project by
+default. To test another project or location, pass them as parameters:
+
+    $ vault write gcpkms/config/test project=my-project location=us-east1
+
+Listing key rings requires the "cloudkms.keyRings.list" permission, such as
+from the "roles/cloudkms.viewer" role.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"project": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud project in which to list key rings. This defaults to the project
+of the configured credentials.
+`,
+			},
+
+			"location": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud location in which to list key rings. The default is "global".
+`,
+				Default: configCheckDefaultLocation,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathConfigCheckWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"success": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether every check succeeded.",
+								Required:    true,
+							},
+							"checks": &framework.FieldSchema{
+								Type:        framework.TypeSlice,
+								Description: "Name, success, and any error and hint of each check, in order.",
+								Required:    true,
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// configCheck is the result of one step of testing the config.
+type configCheck struct {
+	name string
+	err  error
+	hint string
+}
+
+// data returns the check as response data.
+func (c *configCheck) data() map[string]interface{} {
+	d := map[string]interface{}{
+		"name":    c.name,
+		"success": c.err == nil,
+	}
+	if c.err != nil {
+		d["error"] = c.err.Error()
+		d["hint"] = c.hint
+	}
+	return d
+}
+
+// pathConfigCheckWrite corresponds to PUT/POST gcpkms/config/test and is used
+// to test the credentials and connectivity of the config.
+func (b *backend) pathConfigCheckWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	checks := b.checkConfig(ctx, req.Storage, d.Get("project").(string), d.Get("location").(string))
+
+	success := true
+	results := make([]map[string]interface{}, 0, len(checks))
+	for _, c := range checks {
+		if c.err != nil {
+			success = false
+		}
+		results = append(results, c.data())
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"success": success,
+			"checks":  results,
+		},
+	}, nil
+}
+
+// checkConfig tests the config one step at a time, stopping at the first step
+// which fails, and returns the result of each step taken.
+func (b *backend) checkConfig(ctx context.Context, s logical.Storage, project, location string) []*configCheck {
+	var checks []*configCheck
+	check := func(name string, err error, hint string) bool {
+		checks = append(checks, &configCheck{name: name, err: err, hint: hint})
+		return err == nil
+	}
+
+	config, err := b.Config(ctx, s)
+	if !check("config", err, "the stored config could not be read") {
+		return checks
+	}
+
+	t, err := newTransport(config)
+	if !check("transport", err, "check proxy_url and ca_certificate") {
+		return checks
+	}
+
+	credsCtx, cancel := context.WithTimeout(t.context(ctx), configCheckTimeout)
+	defer cancel()
+
+	creds, err := b.credentials(credsCtx, config)
+	if !check("credentials", err, "check that credentials is a valid JSON "+
+		"credentials file for the configured universe_domain, or that "+
+		"Application Default Credentials are available to Vault") {
+		return checks
+	}
+
+	_, err = creds.TokenSource.Token()
+	if !check("token", err, tokenErrorHint(err)) {
+		return checks
+	}
+
+	if project == "" {
+		project = creds.ProjectID
+	}
+	if project == "" {
+		check("kms", errors.New("the configured credentials are not associated with a project"),
+			"specify the project explicitly")
+		return checks
+	}
+
+	kmsClient, closer, err := b.KMSClient(ctx, s)
+	if !check("kms_client", err, "check api_endpoint and universe_domain") {
+		return checks
+	}
+	defer closer()
+
+	kmsCtx, kmsCancel := b.kmsContext(ctx)
+	defer kmsCancel()
+	kmsCtx, kmsCancel = context.WithTimeout(kmsCtx, configCheckTimeout)
+	defer kmsCancel()
+
+	it := kmsClient.ListKeyRings(kmsCtx, &kmspb.ListKeyRingsRequest{
+		Parent:   fmt.Sprintf("projects/%s/locations/%s", project, location),
+		PageSize: 1,
+	})
+	if _, err = it.Next(); err == iterator.Done {
+		err = nil
+	}
+	if err != nil {
+		err = wrapKMSError(fmt.Sprintf("failed to list key rings in %s: {{err}}", location), err)
+	}
+	check("kms", err, kmsErrorHint(err))
+
+	return checks
+}
+
+// tokenErrorHint returns a hint at the likely cause of an error fetching an
+// oauth token.
+func tokenErrorHint(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) {
+		switch rerr.ErrorCode {
+		case "invalid_scope":
+			return "check that scopes are valid OAuth scopes which include " +
+				"access to Cloud KMS, such as " + cloudPlatformScope
+		case "invalid_grant":
+			return "the credentials were rejected, the service account or " +
+				"its key may have been disabled or deleted"
+		}
+		return "the token endpoint rejected the credentials"
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) || errors.Is(err, context.DeadlineExceeded) {
+		return "the token endpoint could not be reached, check egress rules " +
+			"and proxy_url"
+	}
+
+	return "an oauth token could not be fetched with the credentials"
+}
+
+// kmsErrorHint returns a hint at the likely cause of an error calling KMS.
+func kmsErrorHint(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	if v, ok := err.(logical.HTTPCodedError); ok {
+		switch v.Code() {
+		case 403:
+			return "the credentials are missing an IAM role on the project, " +
+				"such as roles/cloudkms.viewer"
+		case 404:
+			return "check the project and location"
+		case 429:
+			return "the KMS quota is exhausted, retry later"
+		case 504:
+			return "KMS could not be reached, check egress rules, proxy_url, " +
+				"and api_endpoint"
+		}
+	}
+
+	switch grpcstatus.Code(err) {
+	case grpccodes.Unauthenticated:
+		return "KMS rejected the oauth token, check that scopes include " +
+			"access to Cloud KMS, such as " + cloudPlatformScope
+	case grpccodes.Unavailable, grpccodes.DeadlineExceeded:
+		return "KMS could not be reached, check egress rules, proxy_url, " +
+			"and api_endpoint"
+	}
+
+	return "the call to KMS failed"
+}
@@ -0,0 +1,167 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestPathConfigCheck_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "config/test")
+	})
+
+	cases := []struct {
+		name   string
+		config string
+		failed string
+	}{
+		{
+			"bad_proxy_url",
+			`{"proxy_url":"ftp://proxy"}`,
+			"transport",
+		},
+		{
+			"bad_credentials",
+			`{"credentials":"{not json"}`,
+			"credentials",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			if err := storage.Put(ctx, &logical.StorageEntry{
+				Key:   "config",
+				Value: []byte(tc.config),
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "config/test",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if v, exp := resp.Data["success"], false; v != exp {
+				t.Errorf("expected %v to be %v", v, exp)
+			}
+
+			checks := resp.Data["checks"].([]map[string]interface{})
+			last := checks[len(checks)-1]
+			if v, exp := last["name"], tc.failed; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+			if v, exp := last["success"], false; v != exp {
+				t.Errorf("expected %v to be %v", v, exp)
+			}
+			if last["hint"] == "" {
+				t.Errorf("expected a hint")
+			}
+			for _, c := range checks[:len(checks)-1] {
+				if v, exp := c["success"], true; v != exp {
+					t.Errorf("expected %q to succeed", c["name"])
+				}
+			}
+
+			// The path is not a config profile
+			p, err := b.ConfigProfile(ctx, storage, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != nil {
+				t.Errorf("expected %#v to be nil", p)
+			}
+		})
+	}
+}
+
+func TestTokenErrorHint(t *testing.T) {
+
+	cases := []struct {
+		name string
+		err  error
+		exp  string
+	}{
+		{
+			"nil",
+			nil,
+			"",
+		},
+		{
+			"invalid_scope",
+			fmt.Errorf("oauth2: %w", &oauth2.RetrieveError{ErrorCode: "invalid_scope"}),
+			"check that scopes are valid OAuth scopes which include access to Cloud KMS, such as " + cloudPlatformScope,
+		},
+		{
+			"deadline",
+			fmt.Errorf("oauth2: %w", context.DeadlineExceeded),
+			"the token endpoint could not be reached, check egress rules and proxy_url",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			if v := tokenErrorHint(tc.err); v != tc.exp {
+				t.Errorf("expected %q to be %q", v, tc.exp)
+			}
+		})
+	}
+}
+
+func TestKMSErrorHint(t *testing.T) {
+
+	cases := []struct {
+		name string
+		err  error
+		exp  string
+	}{
+		{
+			"nil",
+			nil,
+			"",
+		},
+		{
+			"permission_denied",
+			wrapKMSError("failed: {{err}}", grpcstatus.Error(grpccodes.PermissionDenied, "denied")),
+			"the credentials are missing an IAM role on the project, such as roles/cloudkms.viewer",
+		},
+		{
+			"unavailable",
+			wrapKMSError("failed: {{err}}", grpcstatus.Error(grpccodes.Unavailable, "unreachable")),
+			"KMS could not be reached, check egress rules, proxy_url, and api_endpoint",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			if v := kmsErrorHint(tc.err); v != tc.exp {
+				t.Errorf("expected %q to be %q", v, tc.exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathConfigProfiles() *framework.Path {
+	return &framework.Path{
+		Pattern: "config/$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "list",
+			OperationSuffix: "config-profiles",
+		},
+
+		HelpSynopsis: "List config profiles",
+		HelpDescription: `
+List the names of the config profiles.
+
+    $ vault list gcpkms/config
+`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: withFieldValidator(b.pathConfigProfilesList),
+		},
+	}
+}
+
+// pathConfigProfilesList corresponds to LIST gcpkms/config and is used to list
+// the config profiles.
+func (b *backend) pathConfigProfilesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	profiles, err := req.Storage.List(ctx, "config/")
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list config profiles: {{err}}", err)
+	}
+	return logical.ListResponse(profiles), nil
+}
+
+func (b *backend) pathConfigProfile() *framework.Path {
+	return &framework.Path{
+		Pattern: "config/" + framework.GenericNameRegex("name"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationSuffix: "config-profile",
+		},
+
+		HelpSynopsis: "Manage config profiles",
+		HelpDescription: `
+Manage a named config profile. A profile holds credentials and connection
+settings which keys can use in place of the ones in the config, so keys in
+separate projects or environments can be served by a single mount.
+
+    $ vault write gcpkms/config/dr \
+        credentials=@dr-credentials.json \
+        quota_project="my-dr-project"
+
+    $ vault write gcpkms/keys/config/my-key config_name=dr
+
+Fields which are not set in the profile are taken from the config. A profile
+cannot be deleted while keys use it.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the config profile.
+`,
+			},
+
+			"credentials": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+The credentials to use for authenticating to Google Cloud. These replace the
+credentials, access token, and impersonation settings in the config. Leave
+this blank to use the credentials in the config.
+`,
+			},
+
+			"scopes": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The list of full-URL scopes to request when authenticating. Leave this blank
+to use the scopes in the config.
+`,
+			},
+
+			"api_endpoint": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Host and port of the Google Cloud KMS API. This takes precedence over
+regional_endpoints in the config, but not over the api_endpoint of a key.
+Leave this blank to use the endpoint in the config.
+`,
+			},
+
+			"quota_project": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Project which calls to Google Cloud KMS are billed and counted against. Leave
+this blank to use the quota project in the config.
+`,
+			},
+		},
+
+		ExistenceCheck: b.pathConfigProfileExists,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathConfigProfileWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "configure",
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathConfigProfileWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "configure",
+				},
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathConfigProfileRead),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"name": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Name of the config profile.",
+								Required:    true,
+							},
+							"scopes": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "OAuth scopes of the config profile.",
+								Required:    true,
+							},
+							"api_endpoint": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Cloud KMS API endpoint of the config profile.",
+								Required:    true,
+							},
+							"quota_project": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Project billed for KMS quota.",
+								Required:    true,
+							},
+						},
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathConfigProfileDelete),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "delete",
+				},
+			},
+		},
+	}
+}
+
+// pathConfigProfileExists checks if the config profile exists.
+func (b *backend) pathConfigProfileExists(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	p, err := b.ConfigProfile(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+	return p != nil, nil
+}
+
+// pathConfigProfileRead corresponds to READ gcpkms/config/:name and is used to
+// read a config profile.
+func (b *backend) pathConfigProfileRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	p, err := b.ConfigProfile(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":          p.Name,
+			"scopes":        p.Scopes,
+			"api_endpoint":  p.APIEndpoint,
+			"quota_project": p.QuotaProject,
+		},
+	}, nil
+}
+
+// pathConfigProfileWrite corresponds to PUT/POST gcpkms/config/:name and is
+// used to create or update a config profile.
+func (b *backend) pathConfigProfileWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if err := checkProfileName(name); err != nil {
+		return nil, err
+	}
+
+	p, err := b.ConfigProfile(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		p = &ConfigProfile{Name: name}
+	}
+
+	changed, err := p.Update(d)
+	if err != nil {
+		return nil, logical.CodedError(400, err.Error())
+	}
+
+	if changed || req.Operation == logical.CreateOperation {
+		entry, err := logical.StorageEntryJSON("config/"+name, p)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+		}
+		if err := req.Storage.Put(ctx, entry); err != nil {
+			return nil, errwrap.Wrapf("failed to write to storage: {{err}}", err)
+		}
+
+		// Invalidate existing clients so they read the new profile
+		b.ResetClient()
+	}
+
+	return nil, nil
+}
+
+// pathConfigProfileDelete corresponds to DELETE gcpkms/config/:name and is
+// used to delete a config profile which no key uses.
+func (b *backend) pathConfigProfileDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	index, err := b.keyIndex(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	for _, key := range keyIndexNames(index) {
+		if index[key].ConfigName == name {
+			users = append(users, key)
+		}
+	}
+	if len(users) > 0 {
+		return nil, logical.CodedError(400, fmt.Sprintf("config profile %q is "+
+			"used by keys: %s", name, strings.Join(users, ", ")))
+	}
+
+	if err := req.Storage.Delete(ctx, "config/"+name); err != nil {
+		return nil, errwrap.Wrapf("failed to delete from storage: {{err}}", err)
+	}
+
+	// Invalidate existing clients so none keep using the deleted profile
+	b.ResetClient()
+
+	return nil, nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathConfigProfiles_List(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ListOperation, "config/")
+	})
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	for _, name := range []string{"dev", "dr"} {
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "config/" + name,
+			Value: []byte(`{"name":"` + name + `"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ListOperation,
+		Path:      "config/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, exp := resp.Data["keys"].([]string), []string{"dev", "dr"}; !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+}
+
+func TestPathConfigProfile_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "config/dr")
+		testFieldValidation(t, logical.CreateOperation, "config/dr")
+		testFieldValidation(t, logical.UpdateOperation, "config/dr")
+		testFieldValidation(t, logical.DeleteOperation, "config/dr")
+	})
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.CreateOperation,
+		Path:      "config/dr",
+		Data: map[string]interface{}{
+			"credentials":   "creds",
+			"quota_project": "my-dr-project",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "config/dr",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, exp := resp.Data["quota_project"], "my-dr-project"; v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+
+	// The credentials are never returned
+	if _, ok := resp.Data["credentials"]; ok {
+		t.Errorf("expected %q to not include %q", resp.Data, "credentials")
+	}
+
+	// The profile does not change the config
+	c, err := b.Config(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := c.Credentials, ""; v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+
+	// The names of the other config paths cannot be used, since requests to
+	// them never reach the profile
+	for _, name := range []string{"rotate-root", "test"} {
+		_, err := b.pathConfigProfileWrite(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "config/" + name,
+		}, &framework.FieldData{
+			Raw:    map[string]interface{}{"name": name},
+			Schema: b.pathConfigProfile().Fields,
+		})
+		if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 400 {
+			t.Errorf("expected 400 error for %q, got %#v", name, err)
+		}
+	}
+}
+
+func TestPathConfigProfile_Delete(t *testing.T) {
+
+	cases := []struct {
+		name string
+		key  string
+		err  bool
+	}{
+		{
+			"unused",
+			`{"name":"my-key", "crypto_key_id":"foo"}`,
+			false,
+		},
+		{
+			"used",
+			`{"name":"my-key", "crypto_key_id":"foo", "config_name":"dr"}`,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			for k, v := range map[string]string{
+				"config/dr":   `{"name":"dr"}`,
+				"keys/my-key": tc.key,
+			} {
+				if err := storage.Put(ctx, &logical.StorageEntry{
+					Key:   k,
+					Value: []byte(v),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.DeleteOperation,
+				Path:      "config/dr",
+			})
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			p, err := b.ConfigProfile(ctx, storage, "dr")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists := p != nil; exists != tc.err {
+				t.Errorf("expected exists to be %t", tc.err)
+			}
+		})
+	}
+}
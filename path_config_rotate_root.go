@@ -0,0 +1,258 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/useragent"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+
+	iamadmin "cloud.google.com/go/iam/admin/apiv1"
+	adminpb "cloud.google.com/go/iam/admin/apiv1/adminpb"
+)
+
+const (
+	// rotateRootVerifyTimeout is how long to wait for a new service account
+	// key to be accepted, and rotateRootVerifyInterval is how often it is
+	// tried. New keys can take a short while to be usable everywhere.
+	rotateRootVerifyTimeout  = 60 * time.Second
+	rotateRootVerifyInterval = 2 * time.Second
+)
+
+func (b *backend) pathConfigRotateRoot() *framework.Path {
+	return &framework.Path{
+		Pattern: "config/rotate-root",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "rotate",
+			OperationSuffix: "root-credentials",
+		},
+
+		HelpSynopsis: "Rotate the service account key in the config",
+		HelpDescription: `
+Rotate the service account key in the config. Vault creates a new key for the
+service account with the IAM API, checks that it can authenticate with it,
+saves it in the config, and then deletes the old key. The service account
+needs the "iam.serviceAccountKeys.create" and "iam.serviceAccountKeys.delete"
+permissions on itself, such as from the "roles/iam.serviceAccountKeyAdmin"
+role.
+
+    $ vault write -f gcpkms/config/rotate-root
+
+If the old key cannot be deleted, the new key is still saved and the response
+includes a warning naming the old key, which should then be deleted manually.
+
+To rotate the key automatically, set rotation_period on the config.
+`,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathConfigRotateRootWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"private_key_id": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "ID of the new service account key.",
+								Required:    true,
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// pathConfigRotateRootWrite corresponds to PUT/POST gcpkms/config/rotate-root
+// and is used to rotate the service account key in the config.
+func (b *backend) pathConfigRotateRootWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keyID, warnings, err := b.rotateRoot(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Warnings: warnings,
+		Data: map[string]interface{}{
+			"private_key_id": keyID,
+		},
+	}, nil
+}
+
+// serviceAccountKey is the part of a service account key file needed to
+// rotate it.
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+}
+
+// parseServiceAccountKey parses the credentials as a service account key file.
+func parseServiceAccountKey(creds string) (*serviceAccountKey, error) {
+	var k serviceAccountKey
+	if err := json.Unmarshal([]byte(creds), &k); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	if k.Type != "service_account" {
+		return nil, fmt.Errorf("credentials must be a service account key, not %q", k.Type)
+	}
+	if k.ClientEmail == "" || k.PrivateKeyID == "" {
+		return nil, fmt.Errorf("credentials are missing client_email or private_key_id")
+	}
+	return &k, nil
+}
+
+// rotateRoot replaces the service account key in the config with a new key
+// for the same service account, and deletes the old key. It returns the ID of
+// the new key, and warnings for any cleanup which failed.
+func (b *backend) rotateRoot(ctx context.Context, s logical.Storage) (string, []string, error) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	c, err := b.Config(ctx, s)
+	if err != nil {
+		return "", nil, err
+	}
+	if c.Credentials == "" {
+		return "", nil, logical.CodedError(400, "no credentials are configured, "+
+			"rotating requires a service account key in the config")
+	}
+	oldKey, err := parseServiceAccountKey(c.Credentials)
+	if err != nil {
+		return "", nil, logical.CodedError(400, err.Error())
+	}
+
+	t, err := newTransport(c)
+	if err != nil {
+		return "", nil, err
+	}
+	credsCtx := t.context(ctx)
+
+	iamClient, err := b.iamClient(credsCtx, c, t)
+	if err != nil {
+		return "", nil, err
+	}
+	defer iamClient.Close()
+
+	saName := "projects/-/serviceAccounts/" + oldKey.ClientEmail
+	resp, err := iamClient.CreateServiceAccountKey(ctx, &adminpb.CreateServiceAccountKeyRequest{
+		Name:           saName,
+		PrivateKeyType: adminpb.ServiceAccountPrivateKeyType_TYPE_GOOGLE_CREDENTIALS_FILE,
+	})
+	if err != nil {
+		return "", nil, errwrap.Wrapf("failed to create service account key: {{err}}", err)
+	}
+
+	newCreds := resp.PrivateKeyData
+	newKey, err := parseServiceAccountKey(string(newCreds))
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Only save the new key once it works, and clean it up if it never does
+	if err := verifyCredentials(credsCtx, c, newCreds); err != nil {
+		if derr := iamClient.DeleteServiceAccountKey(ctx, &adminpb.DeleteServiceAccountKeyRequest{
+			Name: resp.Name,
+		}); derr != nil {
+			b.Logger().Error("failed to delete unusable service account key",
+				"key", resp.Name, "error", derr)
+		}
+		return "", nil, errwrap.Wrapf("new service account key did not work: {{err}}", err)
+	}
+
+	c.Credentials = string(newCreds)
+	c.LastRotated = time.Now().UTC()
+	entry, err := logical.StorageEntryJSON("config", c)
+	if err != nil {
+		return "", nil, errwrap.Wrapf("failed to generate JSON configuration: {{err}}", err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return "", nil, errwrap.Wrapf("failed to persist configuration to storage: {{err}}", err)
+	}
+
+	// Invalidate existing client so it uses the new key
+	b.ResetClient()
+
+	var warnings []string
+	oldName := saName + "/keys/" + oldKey.PrivateKeyID
+	if err := iamClient.DeleteServiceAccountKey(ctx, &adminpb.DeleteServiceAccountKeyRequest{
+		Name: oldName,
+	}); err != nil {
+		warnings = append(warnings, fmt.Sprintf("failed to delete the old service "+
+			"account key %q, it should be deleted manually: %s", oldName, err))
+	}
+
+	return newKey.PrivateKeyID, warnings, nil
+}
+
+// iamClient creates a client for the IAM API authenticated with the
+// credentials in the config. The client is only used for a single request, so
+// unlike the KMS client it is not cached.
+func (b *backend) iamClient(ctx context.Context, c *Config, t *transport) (*iamadmin.IamClient, error) {
+	creds, err := google.CredentialsFromJSONWithParams(ctx, []byte(c.Credentials), google.CredentialsParams{
+		Scopes:         []string{cloudPlatformScope},
+		UniverseDomain: c.UniverseDomain,
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to parse credentials: {{err}}", err)
+	}
+
+	opts := []option.ClientOption{
+		option.WithTokenSource(creds.TokenSource),
+		option.WithUserAgent(useragent.PluginString(b.pluginEnv, userAgentPluginName)),
+	}
+	if c.UniverseDomain != "" {
+		opts = append(opts, option.WithUniverseDomain(c.UniverseDomain))
+	}
+	opts = append(opts, t.clientOptions()...)
+
+	client, err := iamadmin.NewIamClient(b.ctx, opts...)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create IAM client: {{err}}", err)
+	}
+	return client, nil
+}
+
+// verifyCredentials polls until an oauth token can be fetched with the
+// credentials, the timeout passes, or the context is cancelled.
+func verifyCredentials(ctx context.Context, c *Config, creds []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, rotateRootVerifyTimeout)
+	defer cancel()
+
+	gcreds, err := google.CredentialsFromJSONWithParams(ctx, creds, google.CredentialsParams{
+		Scopes:         c.Scopes,
+		UniverseDomain: c.UniverseDomain,
+	})
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(rotateRootVerifyInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := gcreds.TokenSource.Token()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
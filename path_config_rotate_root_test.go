@@ -0,0 +1,133 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestParseServiceAccountKey(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		creds string
+		email string
+		id    string
+		err   bool
+	}{
+		{
+			"service_account",
+			`{"type":"service_account", "client_email":"vault@p.iam.gserviceaccount.com", "private_key_id":"abc123"}`,
+			"vault@p.iam.gserviceaccount.com",
+			"abc123",
+			false,
+		},
+		{
+			"authorized_user",
+			`{"type":"authorized_user", "client_id":"id", "refresh_token":"token"}`,
+			"",
+			"",
+			true,
+		},
+		{
+			"missing_key_id",
+			`{"type":"service_account", "client_email":"vault@p.iam.gserviceaccount.com"}`,
+			"",
+			"",
+			true,
+		},
+		{
+			"invalid_json",
+			`not json`,
+			"",
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			k, err := parseServiceAccountKey(tc.creds)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if tc.err {
+				return
+			}
+
+			if v, exp := k.ClientEmail, tc.email; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+			if v, exp := k.PrivateKeyID, tc.id; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+		})
+	}
+}
+
+func TestPathConfigRotateRoot_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "config/rotate-root")
+	})
+
+	cases := []struct {
+		name   string
+		config string
+	}{
+		{
+			"no_credentials",
+			`{}`,
+		},
+		{
+			"not_service_account",
+			`{"credentials":"{\"type\":\"authorized_user\"}"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			if err := storage.Put(ctx, &logical.StorageEntry{
+				Key:   "config",
+				Value: []byte(tc.config),
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "config/rotate-root",
+			})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+				t.Errorf("expected %q to be a 400", err)
+			}
+
+			// The path is not a config profile
+			p, err := b.ConfigProfile(ctx, storage, "rotate-root")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != nil {
+				t.Errorf("expected %#v to be nil", p)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -34,6 +35,22 @@ func TestBackend_PathConfigRead(t *testing.T) {
 		if _, ok := resp.Data["scopes"]; !ok {
 			t.Errorf("expected %q to include %q", resp.Data, "scopes")
 		}
+
+		if v, exp := resp.Data["crypto_operation_timeout"], int64(30); v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+
+		if v, exp := resp.Data["admin_operation_timeout"], int64(60); v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+
+		if v, exp := resp.Data["client_lifetime"], int64(86400); v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+
+		if v, exp := resp.Data["universe_domain"], "googleapis.com"; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
 	})
 
 	t.Run("exist", func(t *testing.T) {
@@ -68,6 +85,10 @@ func TestBackend_PathConfigRead(t *testing.T) {
 		if _, ok := resp.Data["credentials"]; ok {
 			t.Errorf("should not return credentials")
 		}
+
+		if v, exp := resp.Data["credential_type"], "unknown"; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
 	})
 }
 
@@ -149,6 +170,97 @@ func TestBackend_PathConfigUpdate(t *testing.T) {
 	})
 }
 
+func TestBackend_PathConfigPatch(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.PatchOperation, "config")
+	})
+
+	t.Run("not_exist", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.PatchOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"scopes": "foo",
+			},
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 404 {
+			t.Errorf("expected %q to be a 404", err)
+		}
+	})
+
+	t.Run("exist", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		entry, err := logical.StorageEntryJSON("config", &Config{
+			Scopes:      []string{"foo"},
+			Credentials: "creds",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+
+		patch := func(data map[string]interface{}) {
+			t.Helper()
+
+			if _, err := b.settings(context.Background(), storage); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.HandleRequest(context.Background(), &logical.Request{
+				Storage:   storage,
+				Operation: logical.PatchOperation,
+				Path:      "config",
+				Data:      data,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Settings which the client does not use keep the client
+		patch(map[string]interface{}{
+			"rotation_period": "24h",
+		})
+		if b.kmsSettings == nil {
+			t.Errorf("expected the client to be kept")
+		}
+
+		patch(map[string]interface{}{
+			"scopes": "foo,bar",
+		})
+		if b.kmsSettings != nil {
+			t.Errorf("expected the client to be reset")
+		}
+
+		config, err := b.Config(context.Background(), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v, exp := config.Credentials, "creds"; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+
+		if v, exp := config.Scopes, []string{"bar", "foo"}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+
+		if v, exp := config.RotationPeriod, 24*time.Hour; v != exp {
+			t.Errorf("expected %s to be %s", v, exp)
+		}
+	})
+}
+
 func TestBackend_PathConfigDelete(t *testing.T) {
 
 	t.Run("field_validation", func(t *testing.T) {
@@ -5,14 +5,19 @@ package gcpkms
 
 import (
 	"context"
+	"crypto"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
-	"github.com/patrickmn/go-cache"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	grpccodes "google.golang.org/grpc/codes"
 )
 
 func (b *backend) pathDecrypt() *framework.Path {
@@ -38,6 +43,7 @@ encrypted with the same Google Cloud KMS key outside of Vault.
 				Description: `
 Name of the key in Vault to use for decryption. This key must already exist in
 Vault and must map back to a Google Cloud KMS key.
+This may also be the name of a key alias.
 `,
 			},
 
@@ -53,22 +59,69 @@ Optional data that was specified during encryption of this payload.
 				Description: `
 Ciphertext to decrypt as previously returned from an encrypt operation. This
 must be base64-encoded ciphertext as previously returned from an encrypt
-operation.
+operation, or multi-key ciphertext encrypted with additional_keys, which any
+of its keys can decrypt.
 `,
 			},
 
 			"key_version": &framework.FieldSchema{
 				Type: framework.TypeInt,
 				Description: `
-Integer version of the crypto key version to use for decryption. This is
-required for asymmetric keys. For symmetric keys, Cloud KMS will choose the
-correct version automatically.
+Integer version of the crypto key version to use for decryption. For
+asymmetric keys, if unspecified, the newest enabled version within the key's
+min_version and max_version is used. For symmetric keys, Cloud KMS will choose
+the correct version automatically.
+`,
+			},
+
+			"oaep_hash": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+OAEP hash function with which the ciphertext was encrypted, for asymmetric keys:
+"sha1", "sha256", or "sha512". If specified, it must match the hash function of
+the crypto key version's algorithm, which is otherwise detected automatically.
 `,
 			},
 		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: withFieldValidator(b.pathDecryptWrite),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.withUsageForwarding(b.pathDecryptWrite)),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"plaintext": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Decrypted plaintext.",
+								Required:    true,
+							},
+							"protection_level": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Protection level of the crypto key.",
+								Required:    true,
+							},
+							"algorithm": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Algorithm of the crypto key.",
+								Required:    true,
+							},
+							"crypto_key_version": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Resource ID of the crypto key version used for asymmetric decryption.",
+							},
+							"plaintext_hmac": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "HMAC of the plaintext, if include_hmac is configured.",
+							},
+							"ciphertext_hmac": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "HMAC of the ciphertext, if include_hmac is configured.",
+							},
+						},
+					}},
+				},
+			},
 		},
 	}
 }
@@ -80,7 +133,17 @@ func (b *backend) pathDecryptWrite(ctx context.Context, req *logical.Request, d
 	aad := d.Get("additional_authenticated_data").(string)
 	keyVersion := d.Get("key_version").(int)
 
-	k, err := b.Key(ctx, req.Storage, key)
+	var oaepHash crypto.Hash
+	if v := d.Get("oaep_hash").(string); v != "" {
+		h, ok := oaepHashNames[strings.ToLower(v)]
+		if !ok {
+			return nil, logical.CodedError(400, fmt.Sprintf(
+				"unknown oaep_hash %q, valid hashes are %q", v, []string{"sha1", "sha256", "sha512"}))
+		}
+		oaepHash = h
+	}
+
+	k, err := b.resolveKey(ctx, req.Storage, key)
 	if err != nil {
 		if err == ErrKeyNotFound {
 			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -88,89 +151,231 @@ func (b *backend) pathDecryptWrite(ctx context.Context, req *logical.Request, d
 		return nil, err
 	}
 
-	// We gave the user back base64-encoded ciphertext in the /encrypt payload
-	ciphertext, err := base64.StdEncoding.DecodeString(d.Get("ciphertext").(string))
-	if err != nil {
-		return nil, errwrap.Wrapf("failed to base64 decode ciphtertext: {{err}}", err)
+	// Multi-key ciphertext is decrypted by decrypting the data key of the
+	// envelope with the key, and then the envelope with the data key.
+	raw := d.Get("ciphertext").(string)
+	var env *envelope
+	var ciphertext []byte
+	if strings.HasPrefix(raw, envelopePrefix) {
+		if keyVersion > 0 {
+			return nil, logical.CodedError(400, "key_version cannot be used with multi-key ciphertext")
+		}
+		env, err = decodeEnvelope(raw)
+		if err != nil {
+			return nil, logical.CodedError(400, err.Error())
+		}
+		r := env.recipient(k)
+		if r == nil {
+			return nil, logical.CodedError(400, fmt.Sprintf("ciphertext was not "+
+				"encrypted with key %q", key))
+		}
+		ciphertext = r.EncryptedKey
+	} else {
+		// We gave the user back base64-encoded ciphertext in the /encrypt payload
+		ciphertext, err = base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to base64 decode ciphtertext: {{err}}", err)
+		}
 	}
 
-	cryptoKey := k.CryptoKeyID
 	if keyVersion > 0 {
-		if k.MinVersion > 0 && keyVersion < k.MinVersion {
-			resp := fmt.Sprintf("requested version %d is less than minimum allowed version of %d",
-				keyVersion, k.MinVersion)
-			return logical.ErrorResponse(resp), logical.ErrPermissionDenied
-		}
-
-		if k.MaxVersion > 0 && keyVersion > k.MaxVersion {
-			resp := fmt.Sprintf("requested version %d is greater than maximum allowed version of %d",
-				keyVersion, k.MaxVersion)
-			return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+		if resp, err := k.checkVersion(keyVersion); err != nil {
+			return resp, err
 		}
-
-		cryptoKey = fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKey, keyVersion)
 	}
 
-	kmsClient, closer, err := b.KMSClient(req.Storage)
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	release, err := b.acquireKey(k.Name)
 	if err != nil {
 		return nil, err
 	}
-	defer closer()
-
-	// Lookup the key so we can determine the type of decryption (symmetric or
-	// asymmetric).
-	var ck *kmspb.CryptoKey
-	cachedCk, found := b.keysCache.Get(k.CryptoKeyID)
-	if !found {
-		fetchedCk, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
-			Name: k.CryptoKeyID,
-		})
-		if err != nil {
-			return nil, errwrap.Wrapf("failed to get underlying crypto key: {{err}}", err)
-		}
-		_ = b.keysCache.Add(k.CryptoKeyID, fetchedCk, cache.DefaultExpiration)
-		ck = fetchedCk
-	} else {
-		castedCk, ok := cachedCk.(*kmspb.CryptoKey)
-		if !ok {
-			return nil, fmt.Errorf("failed to get underlying crypto key: %q", err)
-		}
-		ck = castedCk
-	}
+	defer release()
 
+	// Ciphertext encrypted during a failover can only be decrypted by the
+	// failover crypto key which encrypted it, so each is tried in order.
 	var plaintext string
+	var errResp *logical.Response
+	var usedVersion string
+	var protectionLevel kmspb.ProtectionLevel
+	var algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	_, err = b.cryptoKeyFailover(ctx, req.Storage, k, keyVersion > 0, isKMSWrongKey, func(kmsClient keyManagementClient, cryptoKeyID string) error {
+		fk := k.forCryptoKey(cryptoKeyID)
 
-	switch ck.Purpose {
-	case kmspb.CryptoKey_ASYMMETRIC_DECRYPT:
-		if keyVersion == 0 {
-			return nil, errMissingFields("key_version")
+		cryptoKey := fk.CryptoKeyID
+		if keyVersion > 0 {
+			cryptoKey = fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKey, keyVersion)
 		}
 
-		resp, err := kmsClient.AsymmetricDecrypt(ctx, &kmspb.AsymmetricDecryptRequest{
-			Name:       cryptoKey,
-			Ciphertext: ciphertext,
-		})
+		// Lookup the key so we can determine the type of decryption (symmetric
+		// or asymmetric).
+		ck, err := b.cryptoKey(ctx, kmsClient, fk.CryptoKeyID)
 		if err != nil {
-			return nil, errwrap.Wrapf("failed to decrypt ciphertext (asymmetric): {{err}}", err)
+			return err
+		}
+		if err := checkKeyPurpose(key, ck, "decrypt"); err != nil {
+			return err
+		}
+		if err := b.checkKeyFIPS(ctx, req.Storage, key, ck); err != nil {
+			return err
 		}
-		plaintext = string(resp.Plaintext)
-	case kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKey_CRYPTO_KEY_PURPOSE_UNSPECIFIED:
-		resp, err := kmsClient.Decrypt(ctx, &kmspb.DecryptRequest{
-			Name:                        cryptoKey,
-			Ciphertext:                  ciphertext,
-			AdditionalAuthenticatedData: []byte(aad),
-		})
+
+		switch ck.Purpose {
+		case kmspb.CryptoKey_ASYMMETRIC_DECRYPT:
+			if keyVersion == 0 {
+				version, err := latestKeyVersion(ctx, kmsClient, fk)
+				if err != nil {
+					return err
+				}
+				cryptoKey = fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKey, version)
+			}
+
+			ckv, err := b.cryptoKeyVersion(ctx, kmsClient, cryptoKey)
+			if err != nil {
+				return err
+			}
+			if err := b.checkKeyVersionFIPS(ctx, req.Storage, key, ckv.ProtectionLevel, ckv.Algorithm); err != nil {
+				return err
+			}
+
+			if oaepHash != 0 {
+				versionHash, err := b.cryptoKeyVersionOAEPHash(ctx, kmsClient, cryptoKey)
+				if err != nil {
+					return err
+				}
+				if versionHash != oaepHash {
+					return logical.CodedError(400, fmt.Sprintf("ciphertext was encrypted "+
+						"with oaep_hash %q, but version %d of key %q uses %q",
+						oaepHashToString(oaepHash), versionNumber(cryptoKey), key,
+						oaepHashToString(versionHash)))
+				}
+			}
+
+			resp, err := kmsClient.AsymmetricDecrypt(ctx, &kmspb.AsymmetricDecryptRequest{
+				Name:       cryptoKey,
+				Ciphertext: ciphertext,
+			})
+			if err != nil {
+				if kmsErrorCode(err) == grpccodes.InvalidArgument {
+					return b.errOAEPMismatch(ctx, kmsClient, key, cryptoKey, err)
+				}
+				return wrapKMSVersionError(ctx, kmsClient, key, cryptoKey, "failed to decrypt ciphertext (asymmetric): {{err}}", err)
+			}
+			plaintext = string(resp.Plaintext)
+			usedVersion = cryptoKey
+			protectionLevel = resp.ProtectionLevel
+			algorithm = ckv.Algorithm
+		case kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKey_CRYPTO_KEY_PURPOSE_UNSPECIFIED:
+			if oaepHash != 0 {
+				return logical.CodedError(400, "oaep_hash is only valid for asymmetric keys")
+			}
+			if resp, err := k.checkAAD(aad); err != nil {
+				errResp = resp
+				return err
+			}
+
+			resp, err := kmsClient.Decrypt(ctx, &kmspb.DecryptRequest{
+				Name:                        cryptoKey,
+				Ciphertext:                  ciphertext,
+				AdditionalAuthenticatedData: []byte(aad),
+			})
+			if err != nil {
+				return wrapKMSVersionError(ctx, kmsClient, key, cryptoKey, "failed to decrypt ciphertext (symmetric): {{err}}", err)
+			}
+			// The ciphertext names the version, which may predate the
+			// version template.
+			if err := b.checkKeyVersionFIPS(ctx, req.Storage, key, resp.ProtectionLevel, cryptoKeyAlgorithm(ck)); err != nil {
+				return err
+			}
+			plaintext = string(resp.Plaintext)
+			protectionLevel = resp.ProtectionLevel
+			algorithm = cryptoKeyAlgorithm(ck)
+		}
+		return nil
+	})
+	if err != nil {
+		var merr *oaepMismatchError
+		if errors.As(err, &merr) {
+			return nil, logical.CodedError(400, merr.Error())
+		}
+		return errResp, err
+	}
+
+	if env != nil {
+		pt, err := env.open([]byte(plaintext), []byte(aad))
 		if err != nil {
-			return nil, errwrap.Wrapf("failed to decrypt ciphertext (symmetric): {{err}}", err)
+			return nil, logical.CodedError(400, "failed to decrypt multi-key ciphertext")
 		}
-		plaintext = string(resp.Plaintext)
-	case kmspb.CryptoKey_ASYMMETRIC_SIGN:
-		return nil, logical.ErrUnsupportedOperation
+		plaintext = string(pt)
+		ciphertext = []byte(raw)
 	}
 
-	return &logical.Response{
+	b.recordUsage(k.Name, "decrypt")
+
+	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"plaintext": plaintext,
+			"plaintext":        plaintext,
+			"protection_level": protectionLevelToString(protectionLevel),
+			"algorithm":        algorithmToString(algorithm),
 		},
-	}, nil
+	}
+	// Symmetric decryption does not report the crypto key version used
+	if usedVersion != "" {
+		resp.Data["crypto_key_version"] = usedVersion
+	}
+	if err := b.addResponseHMACs(ctx, req.Storage, resp, map[string][]byte{
+		"plaintext":  []byte(plaintext),
+		"ciphertext": ciphertext,
+	}); err != nil {
+		return nil, err
+	}
+	if err := b.wrapPlaintextResponse(ctx, req, k, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// oaepMismatchError is returned when KMS rejects asymmetric ciphertext, which
+// happens when it was encrypted with a different OAEP hash function, key, or
+// key version. It wraps the KMS error so a failover crypto key is still tried.
+type oaepMismatchError struct {
+	msg string
+	err error
+}
+
+func (e *oaepMismatchError) Error() string {
+	return e.msg
+}
+
+func (e *oaepMismatchError) Unwrap() error {
+	return e.err
+}
+
+// errOAEPMismatch returns an error describing why KMS rejected the asymmetric
+// ciphertext, naming the OAEP hash function of the crypto key version.
+func (b *backend) errOAEPMismatch(ctx context.Context, kmsClient keyManagementClient, key, cryptoKeyVersion string, err error) error {
+	msg := fmt.Sprintf("failed to decrypt ciphertext with version %d of key %q",
+		versionNumber(cryptoKeyVersion), key)
+	if h, herr := b.cryptoKeyVersionOAEPHash(ctx, kmsClient, cryptoKeyVersion); herr == nil {
+		msg += fmt.Sprintf(", which uses oaep_hash %q - the ciphertext may have "+
+			"been encrypted with a different OAEP hash, key, or key version",
+			oaepHashToString(h))
+	}
+	return &oaepMismatchError{msg: msg, err: err}
+}
+
+// cryptoKeyVersionOAEPHash returns the OAEP hash function of the algorithm of
+// the crypto key version.
+func (b *backend) cryptoKeyVersionOAEPHash(ctx context.Context, kmsClient keyManagementClient, cryptoKeyVersion string) (crypto.Hash, error) {
+	ckv, err := b.cryptoKeyVersion(ctx, kmsClient, cryptoKeyVersion)
+	if err != nil {
+		return 0, err
+	}
+	h, ok := oaepHashes[ckv.Algorithm]
+	if !ok {
+		return 0, fmt.Errorf("crypto key version %q has algorithm %q which is not "+
+			"an RSA decryption algorithm", cryptoKeyVersion, algorithmToString(ckv.Algorithm))
+	}
+	return h, nil
 }
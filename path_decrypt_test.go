@@ -16,7 +16,7 @@ import (
 
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func TestPathDecrypt_Write(t *testing.T) {
@@ -25,6 +25,28 @@ func TestPathDecrypt_Write(t *testing.T) {
 		testFieldValidation(t, logical.UpdateOperation, "decrypt/my-key")
 	})
 
+	t.Run("invalid_oaep_hash", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "decrypt/my-key",
+			Data: map[string]interface{}{
+				"ciphertext": "AAAA",
+				"oaep_hash":  "md5",
+			},
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 400 {
+			t.Errorf("expected 400 error, got %#v", err)
+		}
+	})
+
 	t.Run("asymmetric", func(t *testing.T) {
 
 		algorithms := []kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
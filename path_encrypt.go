@@ -7,13 +7,14 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"path"
 
-	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func (b *backend) pathEncrypt() *framework.Path {
@@ -29,6 +30,19 @@ func (b *backend) pathEncrypt() *framework.Path {
 		HelpDescription: `
 Use the named encryption key to encrypt an arbitrary plaintext string. The
 response will be the base64-encoded encrypted value (ciphertext).
+
+To keep the data decryptable if a project or location is lost, encrypt it
+under additional keys as well. The plaintext is encrypted once with a random
+data key, which is encrypted by each key, and the resulting ciphertext can be
+decrypted with any of them. The additional keys must be listed in the
+envelope_recipients of the key in keys/config/:key:
+
+    $ vault write gcpkms/keys/config/my-key \
+        envelope_recipients="my-key-us-west1,my-key-other-project"
+
+    $ vault write gcpkms/encrypt/my-key \
+        plaintext="hello" \
+        additional_keys="my-key-us-west1,my-key-other-project"
 `,
 
 		Fields: map[string]*framework.FieldSchema{
@@ -37,6 +51,7 @@ response will be the base64-encoded encrypted value (ciphertext).
 				Description: `
 Name of the key in Vault to use for encryption. This key must already exist in
 Vault and must map back to a Google Cloud KMS key.
+This may also be the name of a key alias.
 `,
 			},
 
@@ -52,7 +67,18 @@ decrypt this payload.
 				Type: framework.TypeInt,
 				Description: `
 Integer version of the crypto key version to use for encryption. If unspecified,
-this defaults to the latest active crypto key version.
+this defaults to the crypto key's primary version, which must be within the
+key's min_version and max_version.
+`,
+			},
+
+			"additional_keys": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+Names of other keys in Vault to also encrypt the plaintext under, such as keys
+in other projects or locations. The ciphertext can be decrypted with any of the
+keys. Each key must be listed in the envelope_recipients of the key. This
+cannot be used with key_version.
 `,
 			},
 
@@ -66,8 +92,54 @@ limitations by key types.
 			},
 		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: withFieldValidator(b.pathEncryptWrite),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.withUsageForwarding(b.pathEncryptWrite)),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"ciphertext": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Base64-encoded ciphertext, or multi-key ciphertext if additional_keys is given.",
+								Required:    true,
+							},
+							"key_version": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Version of the crypto key version used for encryption.",
+							},
+							"crypto_key_version": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Resource ID of the crypto key version used for encryption.",
+							},
+							"protection_level": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Protection level of the crypto key.",
+							},
+							"algorithm": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Algorithm of the crypto key.",
+							},
+							"recipients": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Names of the keys which can decrypt multi-key ciphertext.",
+							},
+							"failover_crypto_key": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Resource ID of the failover crypto key used, if the key's location was unavailable.",
+							},
+							"plaintext_hmac": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "HMAC of the plaintext, if include_hmac is configured.",
+							},
+							"ciphertext_hmac": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "HMAC of the ciphertext, if include_hmac is configured.",
+							},
+						},
+					}},
+				},
+			},
 		},
 	}
 }
@@ -80,7 +152,7 @@ func (b *backend) pathEncryptWrite(ctx context.Context, req *logical.Request, d
 	plaintext := d.Get("plaintext").(string)
 	keyVersion := d.Get("key_version").(int)
 
-	k, err := b.Key(ctx, req.Storage, key)
+	k, err := b.resolveKey(ctx, req.Storage, key)
 	if err != nil {
 		if err == ErrKeyNotFound {
 			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -88,42 +160,141 @@ func (b *backend) pathEncryptWrite(ctx context.Context, req *logical.Request, d
 		return nil, err
 	}
 
-	cryptoKey := k.CryptoKeyID
+	if resp, err := k.checkAAD(aad); err != nil {
+		return resp, err
+	}
+
+	if additional := d.Get("additional_keys").([]string); len(additional) > 0 {
+		return b.pathEncryptEnvelope(ctx, req, k, additional, plaintext, aad, keyVersion)
+	}
+
 	if keyVersion > 0 {
-		if k.MinVersion > 0 && keyVersion < k.MinVersion {
-			resp := fmt.Sprintf("requested version %d is less than minimum allowed version of %d",
-				keyVersion, k.MinVersion)
-			return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+		if resp, err := k.checkVersion(keyVersion); err != nil {
+			return resp, err
 		}
+	}
 
-		if k.MaxVersion > 0 && keyVersion > k.MaxVersion {
-			resp := fmt.Sprintf("requested version %d is greater than maximum allowed version of %d",
-				keyVersion, k.MaxVersion)
-			return logical.ErrorResponse(resp), logical.ErrPermissionDenied
-		}
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
 
-		cryptoKey = fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKey, keyVersion)
+	release, err := b.acquireKey(k.Name)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
+
+	var resp *kmspb.EncryptResponse
+	var algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	used, err := b.cryptoKeyFailover(ctx, req.Storage, k, keyVersion > 0, isKMSUnavailable, func(kmsClient keyManagementClient, cryptoKeyID string) error {
+		ck, err := b.cryptoKey(ctx, kmsClient, cryptoKeyID)
+		if err != nil {
+			return err
+		}
+		algorithm = cryptoKeyAlgorithm(ck)
+		if err := checkKeyPurpose(key, ck, "encrypt"); err != nil {
+			return err
+		}
+		if err := b.checkKeyFIPS(ctx, req.Storage, key, ck); err != nil {
+			return err
+		}
+
+		cryptoKey := fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKeyID, keyVersion)
+		if keyVersion <= 0 {
+			if cryptoKey, err = k.encryptCryptoKey(cryptoKeyID, ck); err != nil {
+				return err
+			}
+		}
 
-	kmsClient, closer, err := b.KMSClient(req.Storage)
+		resp, err = kmsClient.Encrypt(ctx, &kmspb.EncryptRequest{
+			Name:                        cryptoKey,
+			Plaintext:                   []byte(plaintext),
+			AdditionalAuthenticatedData: []byte(aad),
+		})
+		if err != nil {
+			return wrapKMSVersionError(ctx, kmsClient, key, cryptoKey, "failed to encrypt plaintext: {{err}}", err)
+		}
+		return b.checkKeyVersionFIPS(ctx, req.Storage, key, resp.ProtectionLevel, algorithm)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer closer()
+	b.recordUsage(k.Name, "encrypt")
 
-	resp, err := kmsClient.Encrypt(ctx, &kmspb.EncryptRequest{
-		Name:                        cryptoKey,
-		Plaintext:                   []byte(plaintext),
-		AdditionalAuthenticatedData: []byte(aad),
-	})
+	r := &logical.Response{
+		Data: map[string]interface{}{
+			"key_version":        path.Base(resp.Name),
+			"crypto_key_version": resp.Name,
+			"ciphertext":         base64.StdEncoding.EncodeToString(resp.Ciphertext),
+			"protection_level":   protectionLevelToString(resp.ProtectionLevel),
+			"algorithm":          algorithmToString(algorithm),
+		},
+	}
+	if used != k.CryptoKeyID {
+		r.Data["failover_crypto_key"] = used
+		r.AddWarning(fmt.Sprintf("the location of crypto key %q is unavailable, "+
+			"encrypted with failover crypto key %q", k.CryptoKeyID, used))
+	}
+	if err := b.addResponseHMACs(ctx, req.Storage, r, map[string][]byte{
+		"plaintext":  []byte(plaintext),
+		"ciphertext": resp.Ciphertext,
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// pathEncryptEnvelope encrypts the plaintext under the key and each of the
+// additional keys, so any one of them can decrypt it.
+func (b *backend) pathEncryptEnvelope(ctx context.Context, req *logical.Request, k *Key, additional []string, plaintext, aad string, keyVersion int) (*logical.Response, error) {
+	if keyVersion > 0 {
+		return nil, logical.CodedError(400, "key_version cannot be used with additional_keys")
+	}
+
+	keys := []*Key{k}
+	seen := map[string]bool{k.Name: true}
+	for _, name := range additional {
+		ak, err := b.resolveKey(ctx, req.Storage, name)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				return nil, logical.CodedError(400, fmt.Sprintf("additional key %q does not exist", name))
+			}
+			return nil, err
+		}
+		if seen[ak.Name] {
+			return nil, logical.CodedError(400, fmt.Sprintf("key %q is given more than once", ak.Name))
+		}
+		seen[ak.Name] = true
+
+		// Encrypting under a key lets anyone who can decrypt with it read the
+		// plaintext, so the recipients are limited to those the operator
+		// allowed on the key.
+		if !strutil.StrListContains(k.EnvelopeRecipients, name) && !strutil.StrListContains(k.EnvelopeRecipients, ak.Name) {
+			return nil, logical.CodedError(403, fmt.Sprintf("key %q is not in the "+
+				"envelope_recipients of key %q", name, k.Name))
+		}
+
+		if resp, err := ak.checkAAD(aad); err != nil {
+			return resp, err
+		}
+		keys = append(keys, ak)
+	}
+
+	ciphertext, recipients, err := b.encryptEnvelope(ctx, req.Storage, keys, plaintext, aad)
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to encrypt plaintext: {{err}}", err)
+		return nil, err
 	}
 
-	return &logical.Response{
+	r := &logical.Response{
 		Data: map[string]interface{}{
-			"key_version": path.Base(resp.Name),
-			"ciphertext":  base64.StdEncoding.EncodeToString(resp.Ciphertext),
+			"ciphertext": ciphertext,
+			"recipients": recipients,
 		},
-	}, nil
+	}
+	if err := b.addResponseHMACs(ctx, req.Storage, r, map[string][]byte{
+		"plaintext":  []byte(plaintext),
+		"ciphertext": []byte(ciphertext),
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
@@ -10,7 +10,7 @@ import (
 
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func TestPathEncrypt_Write(t *testing.T) {
@@ -19,6 +19,46 @@ func TestPathEncrypt_Write(t *testing.T) {
 		testFieldValidation(t, logical.UpdateOperation, "encrypt/my-key")
 	})
 
+	t.Run("aad_policy", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key", "crypto_key_id":"foo", "require_aad":true, "allowed_aad_regex":"^tenant/"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			name string
+			aad  string
+		}{
+			{"missing", ""},
+			{"not_allowed", "other/a"},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+
+				if _, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "encrypt/my-key",
+					Data: map[string]interface{}{
+						"plaintext":                     "hello world",
+						"additional_authenticated_data": tc.aad,
+					},
+				}); err != logical.ErrInvalidRequest {
+					t.Errorf("expected invalid request, got %v", err)
+				}
+			})
+		}
+	})
+
 	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
 	defer cleanup()
 
@@ -127,6 +167,23 @@ func TestPathEncrypt_Write(t *testing.T) {
 		}
 	})
 
+	t.Run("primary_less_min_version", func(t *testing.T) {
+
+		// The primary version 1 is below the key's min_version
+		ctx := context.Background()
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "encrypt/my-versioned-key",
+			Data: map[string]interface{}{
+				"plaintext": "hello world",
+			},
+		})
+		if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 403 {
+			t.Errorf("expected 403 error, got %#v", err)
+		}
+	})
+
 	t.Run("greater_max_version", func(t *testing.T) {
 
 		ctx := context.Background()
@@ -0,0 +1,281 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iterator"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
+)
+
+func (b *backend) pathKeyRings() *framework.Path {
+	return &framework.Path{
+		Pattern: "keyrings/?$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "list",
+			OperationSuffix: "key-rings",
+		},
+
+		HelpSynopsis: "List key rings in Google Cloud KMS",
+		HelpDescription: `
+List the Google Cloud KMS key rings visible to the configured credentials. The
+returned keys are full key ring resource IDs, suitable for use as the key_ring
+parameter when creating keys.
+
+    $ vault list gcpkms/keyrings
+
+To filter by location, pass it as a query parameter:
+
+    $ curl \
+        --header "X-Vault-Token: ..." \
+        --request LIST \
+        "${VAULT_ADDR}/v1/gcpkms/keyrings?location=us-east1"
+
+If location is unspecified, key rings in all Cloud KMS locations are listed.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"project": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud project in which to list key rings. This defaults to the project
+of the configured credentials.
+`,
+			},
+
+			"location": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud location in which to list key rings (e.g. "global" or
+"us-east1"). If unspecified, key rings in all locations are listed.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: withFieldValidator(b.pathKeyRingsList),
+		},
+	}
+}
+
+// pathKeyRingsList corresponds to LIST gcpkms/keyrings and is used to list
+// the key rings in Google Cloud KMS.
+func (b *backend) pathKeyRingsList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	project, err := b.projectOrDefault(ctx, req.Storage, d.Get("project").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KMSClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	locations := []string{d.Get("location").(string)}
+	if locations[0] == "" {
+		locations, err = listLocations(ctx, kmsClient, project)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var keys []string
+	keyInfo := make(map[string]interface{})
+	for _, location := range locations {
+		it := kmsClient.ListKeyRings(ctx, &kmspb.ListKeyRingsRequest{
+			Parent: fmt.Sprintf("projects/%s/locations/%s", project, location),
+		})
+		for {
+			kr, err := it.Next()
+			if err != nil {
+				if err == iterator.Done {
+					break
+				}
+				return nil, wrapKMSError(fmt.Sprintf("failed to list key rings in %s: {{err}}", location), err)
+			}
+
+			info := map[string]interface{}{
+				"project":  project,
+				"location": location,
+				"name":     path.Base(kr.Name),
+			}
+			if kr.CreateTime != nil {
+				info["create_time_seconds"] = kr.CreateTime.Seconds
+			}
+
+			keys = append(keys, kr.Name)
+			keyInfo[kr.Name] = info
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// projectOrDefault returns the given project, or the project associated with
+// the configured credentials if the given project is empty.
+func (b *backend) projectOrDefault(ctx context.Context, s logical.Storage, project string) (string, error) {
+	if project != "" {
+		return project, nil
+	}
+
+	config, err := b.Config(ctx, s)
+	if err != nil {
+		return "", err
+	}
+
+	creds, err := b.credentials(ctx, config)
+	if err != nil {
+		return "", err
+	}
+
+	if creds.ProjectID == "" {
+		return "", logical.CodedError(400, "the configured credentials are not "+
+			"associated with a project - specify the project explicitly")
+	}
+	return creds.ProjectID, nil
+}
+
+// listLocations returns the IDs of the Cloud KMS locations available in the
+// project.
+func listLocations(ctx context.Context, kmsClient keyManagementClient, project string) ([]string, error) {
+	var locations []string
+	it := kmsClient.ListLocations(ctx, &locationpb.ListLocationsRequest{
+		Name: "projects/" + project,
+	})
+	for {
+		loc, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			return nil, wrapKMSError("failed to list locations: {{err}}", err)
+		}
+		locations = append(locations, loc.LocationId)
+	}
+	return locations, nil
+}
+
+func (b *backend) pathKeyRingKeys() *framework.Path {
+	return &framework.Path{
+		Pattern: "keyrings/" + framework.GenericNameRegex("location") + "/" +
+			framework.GenericNameRegex("keyring") + "/keys/?$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "list",
+			OperationSuffix: "key-ring-crypto-keys",
+		},
+
+		HelpSynopsis: "List crypto keys in a Google Cloud KMS key ring",
+		HelpDescription: `
+List the names of the crypto keys in the given Google Cloud KMS key ring. The
+key_info of each crypto key has its full resource ID as crypto_key_id, along
+with its purpose, algorithm, and protection level. The crypto key IDs can be
+registered with Vault using the "keys/register/:key" endpoint.
+
+    $ vault list gcpkms/keyrings/us-east1/my-keyring/keys
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"location": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud location of the key ring (e.g. "global" or "us-east1").
+`,
+			},
+
+			"keyring": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key ring (e.g. "my-keyring").
+`,
+			},
+
+			"project": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud project of the key ring. This defaults to the project of the
+configured credentials.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: withFieldValidator(b.pathKeyRingKeysList),
+		},
+	}
+}
+
+// pathKeyRingKeysList corresponds to LIST
+// gcpkms/keyrings/:location/:keyring/keys and is used to list the crypto keys
+// in a key ring.
+func (b *backend) pathKeyRingKeysList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	location := d.Get("location").(string)
+	keyRingName := d.Get("keyring").(string)
+
+	project, err := b.projectOrDefault(ctx, req.Storage, d.Get("project").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KMSClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	keyRing := fmt.Sprintf("projects/%s/locations/%s/keyRings/%s", project, location, keyRingName)
+
+	var keys []string
+	keyInfo := make(map[string]interface{})
+	it := kmsClient.ListCryptoKeys(ctx, &kmspb.ListCryptoKeysRequest{
+		Parent: keyRing,
+	})
+	for {
+		ck, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			return nil, wrapKMSError(fmt.Sprintf("failed to list crypto keys in %s: {{err}}", keyRing), err)
+		}
+
+		info := map[string]interface{}{
+			"crypto_key_id": ck.Name,
+			"purpose":       purposeToString(ck.Purpose),
+		}
+		if vt := ck.VersionTemplate; vt != nil {
+			info["algorithm"] = algorithmToString(vt.Algorithm)
+			info["protection_level"] = protectionLevelToString(vt.ProtectionLevel)
+		}
+		if ck.Primary != nil {
+			info["primary_version"] = path.Base(ck.Primary.Name)
+		}
+		if len(ck.Labels) > 0 {
+			info["labels"] = ck.Labels
+		}
+
+		name := path.Base(ck.Name)
+		keys = append(keys, name)
+		keyInfo[name] = info
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathKeyRings_List(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ListOperation, "keyrings")
+	})
+
+	t.Run("list", func(t *testing.T) {
+
+		keyRing, cleanup := testCreateKMSKeyRing(t, "")
+		defer cleanup()
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keyrings",
+			Data: map[string]interface{}{
+				"project":  os.Getenv("GOOGLE_CLOUD_PROJECT"),
+				"location": "us-east1",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keyInfo, ok := resp.Data["key_info"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("missing key_info: %#v", resp.Data)
+		}
+		if _, ok := keyInfo[keyRing]; !ok {
+			t.Errorf("expected %q to be in %#v", keyRing, resp.Data["keys"])
+		}
+	})
+}
+
+func TestPathKeyRingKeys_List(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ListOperation, "keyrings/us-east1/my-keyring/keys")
+	})
+
+	t.Run("list", func(t *testing.T) {
+
+		cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+		defer cleanup()
+
+		ckn, err := parseCryptoKeyName(cryptoKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keyrings/" + ckn.Location + "/" + ckn.KeyRing + "/keys",
+			Data: map[string]interface{}{
+				"project": ckn.Project,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Keys are the crypto key names, not their resource IDs
+		if v, exp := resp.Data["keys"], []string{ckn.CryptoKey}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+
+		keyInfo, ok := resp.Data["key_info"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("missing key_info: %#v", resp.Data)
+		}
+
+		info, ok := keyInfo[ckn.CryptoKey].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected %q to be in %#v", ckn.CryptoKey, resp.Data["keys"])
+		}
+		if v, exp := info["purpose"], "encrypt_decrypt"; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+		if v, exp := info["crypto_key_id"], cryptoKey; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+	})
+}
@@ -5,8 +5,11 @@ package gcpkms
 
 import (
 	"context"
+	"crypto"
 	"fmt"
+	"net/http"
 	"path"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -17,16 +20,29 @@ import (
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"google.golang.org/api/iterator"
-	"google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
+	"cloud.google.com/go/kms/apiv1/kmspb"
 	multierror "github.com/hashicorp/go-multierror"
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
 )
 
+const (
+	// minDestroyScheduledDuration and maxDestroyScheduledDuration are the
+	// bounds Google Cloud KMS enforces on a crypto key's destroy scheduled
+	// duration.
+	minDestroyScheduledDuration = 24 * time.Hour
+	maxDestroyScheduledDuration = 120 * 24 * time.Hour
+
+	// keysListDetailedMaxLimit is the most keys a detailed list returns, since
+	// the usage of each listed key is read from storage.
+	keysListDetailedMaxLimit = 1000
+)
+
 func (b *backend) pathKeys() *framework.Path {
 	return &framework.Path{
 		Pattern: "keys/?$",
@@ -37,11 +53,93 @@ func (b *backend) pathKeys() *framework.Path {
 			OperationSuffix: "keys",
 		},
 
-		HelpSynopsis:    "List named keys",
-		HelpDescription: "List the named keys available for use.",
+		HelpSynopsis: "List named keys",
+		HelpDescription: `
+List the named keys available for use.
+
+    $ vault list gcpkms/keys
+
+To also return the crypto key, purpose, min and max versions, and last use of
+each key in key_info, set detailed to true:
+
+    $ vault list -detailed gcpkms/keys?detailed=true
+
+Large lists may be filtered by name prefix or by labels, and returned in pages
+of at most limit keys ordered by name. To read the next page, set after to the
+last key of the previous page:
+
+    $ vault list gcpkms/keys?prefix=team-&limit=100
+    $ vault list gcpkms/keys?prefix=team-&limit=100&after=team-payments
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"detailed": &framework.FieldSchema{
+				Type:  framework.TypeBool,
+				Query: true,
+				Description: `
+Return the details of each key in key_info. The purpose is only returned for
+keys whose crypto key state Vault has recorded. Detailed lists return at most
+1000 keys, with a warning naming the after to list the next page with.
+`,
+			},
+
+			"prefix": &framework.FieldSchema{
+				Type:  framework.TypeString,
+				Query: true,
+				Description: `
+Only list keys whose name starts with this prefix.
+`,
+			},
+
+			"labels": &framework.FieldSchema{
+				Type:  framework.TypeKVPairs,
+				Query: true,
+				Description: `
+Only list keys whose crypto key has all of these labels, as recorded by Vault
+when the key was last created, registered, or updated. Keys whose crypto key
+state Vault has not recorded are never listed when this is set.
+`,
+			},
+
+			"after": &framework.FieldSchema{
+				Type:  framework.TypeString,
+				Query: true,
+				Description: `
+Only list keys whose name sorts after this name, such as the last key of the
+previous page.
+`,
+			},
+
+			"limit": &framework.FieldSchema{
+				Type:  framework.TypeInt,
+				Query: true,
+				Description: `
+Maximum number of keys to list. If unset or zero, all keys are listed, except
+by detailed lists, which list at most 1000 keys.
+`,
+			},
+		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ListOperation: withFieldValidator(b.pathKeysList),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysList),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"keys": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Names of the keys.",
+								Required:    true,
+							},
+							"key_info": &framework.FieldSchema{
+								Type:        framework.TypeMap,
+								Description: "Crypto key ID, versions, purpose, and last use of each key, if detailed is set.",
+							},
+						},
+					}},
+				},
+			},
 		},
 	}
 }
@@ -62,15 +160,33 @@ This endpoint is used for the CRUD operations for keys in Vault.
 To create a new key or to update an existing key, perform a write operation with
 the name of the key and the configured parameters below. Vault will also create
 or modify the underlying Google Cloud KMS crypto key and store a reference to
-it.
+it. The response contains the same crypto key details as a read.
 
     $ vault write gcpkms/keys/my-key \
         key_ring="projects/my-project/locations/global/keyRings/vault" \
         rotation_period="72h" \
         labels="test=true"
 
-To read data about a Google Cloud KMS crypto key, including the key status and
-current primary key version, read from the path:
+The key ring may also be given as separate fields, in which case the project
+defaults to the project of the configured credentials:
+
+    $ vault write gcpkms/keys/my-key \
+        project="my-project" \
+        location="global" \
+        key_ring="vault"
+
+To update the labels or rotation period of an existing key, perform a write
+operation with only the fields to change. The key ring and crypto key are
+inferred from the existing key:
+
+    $ vault write gcpkms/keys/my-key \
+        labels="team=security" \
+        labels="cost-center=1234"
+
+To read data about a Google Cloud KMS crypto key, including the key status,
+current primary key version, labels, rotation schedule, version template, and
+the project, location, and key ring the crypto key belongs to, read from the
+path:
 
     $ vault read gcpkms/keys/my-key
 
@@ -118,6 +234,10 @@ For a key purpose of "asymmetric_decrypt", valid values are:
 	- rsa_decrypt_oaep_2048_sha256
 	- rsa_decrypt_oaep_3072_sha256
 	- rsa_decrypt_oaep_4096_sha256
+	- rsa_decrypt_oaep_4096_sha512
+	- rsa_decrypt_oaep_2048_sha1
+	- rsa_decrypt_oaep_3072_sha1
+	- rsa_decrypt_oaep_4096_sha1
 `,
 			},
 
@@ -125,8 +245,34 @@ For a key purpose of "asymmetric_decrypt", valid values are:
 				Type: framework.TypeString,
 				Description: `
 Full Google Cloud resource ID of the key ring with the project and location
-(e.g. projects/my-project/locations/global/keyRings/my-keyring). If the given
-key ring does not exist, Vault will try to create it during a create operation.
+(e.g. projects/my-project/locations/global/keyRings/my-keyring), or the name of
+the key ring (e.g. my-keyring) when location is also given. If the given key
+ring does not exist and create_key_ring is true, Vault will try to create it.
+`,
+			},
+
+			"project": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud project of the key ring. This is only used when key_ring is a key
+ring name, and defaults to the project of the configured credentials.
+`,
+			},
+
+			"location": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud location of the key ring (e.g. "global" or "us-east1"). This is
+required when key_ring is a key ring name.
+`,
+			},
+
+			"create_key_ring": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `
+Create the key ring if it does not exist. If false, the write fails when the
+key ring does not exist. The default value is true.
 `,
 			},
 
@@ -135,7 +281,8 @@ key ring does not exist, Vault will try to create it during a create operation.
 				Description: `
 Name of the crypto key to use. If the given crypto key does not exist, Vault
 will try to create it. This defaults to the name of the key given to Vault as
-the parameter if unspecified.
+the parameter if unspecified, or to the name generated by the
+crypto_key_name_template in the config if one is set.
 `,
 			},
 
@@ -166,31 +313,133 @@ value only applies to keys with a purpose of "encrypt_decrypt".
 `,
 			},
 
+			"destroy_scheduled_duration": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Amount of time crypto key versions of this key spend in the
+"destroy_scheduled" state before transitioning to "destroyed". This is
+specified as a time duration value like 720h (30 days). The value must be
+between 24h and 120 days. If unspecified, Google Cloud KMS uses its default of
+30 days. The value cannot be changed after creation.
+`,
+			},
+
+			"adopt": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If the crypto key already exists in Google Cloud KMS, register it in Vault
+instead of returning an error. The existing crypto key's purpose, algorithm,
+and protection level must match the request. Other fields such as labels and
+rotation period are not applied to the existing crypto key. This only applies
+when creating a key.
+`,
+			},
+
+			"config_name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the config profile to use for all calls on this key, in place of the
+credentials and settings in the config. The profile must already exist. This
+is only supported when creating a key - use keys/config to change it later.
+`,
+			},
+
+			"impersonate_service_account": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Email of a service account to impersonate for all calls on this key, such as
+one in the key's own project. The configured credentials need the
+"roles/iam.serviceAccountTokenCreator" role on it. This is only supported
+when creating a key - use keys/config to change it later.
+`,
+			},
+
+			"dry_run": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If true, validate the request without creating anything in Google Cloud KMS or
+Vault, and return the crypto key which would be created. This checks that the
+key ring exists (or can be created), that the crypto key does not already
+exist (or can be adopted), that the algorithm is valid for the purpose, and
+that the configured credentials can create crypto keys in the key ring. This is
+only supported when creating a key.
+`,
+			},
+
 			"labels": &framework.FieldSchema{
 				Type: framework.TypeKVPairs,
 				Description: `
 Arbitrary key=value label to apply to the crypto key. To specify multiple
 labels, specify this argument multiple times (e.g. labels="a=b" labels="c=d").
+On update, the given labels replace all existing labels on the crypto key. If
+unspecified on update, the existing labels are left unchanged.
+`,
+			},
+
+			"cas": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Check-and-set version. If set, the write only succeeds if it matches the
+cas_version of the key returned by reads, so concurrent changes are not
+silently overwritten. Set to 0 to only allow creating a new key.
 `,
 			},
 		},
 
 		ExistenceCheck: b.pathKeysExistenceCheck,
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation:   withFieldValidator(b.pathKeysRead),
-			logical.CreateOperation: withFieldValidator(b.pathKeysWrite),
-			logical.UpdateOperation: withFieldValidator(b.pathKeysWrite),
-			logical.DeleteOperation: withFieldValidator(b.pathKeysDelete),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysRead),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields:      keysReadResponseFields(),
+					}},
+				},
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields:      keysWriteResponseFields(),
+					}},
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields:      keysWriteResponseFields(),
+					}},
+				},
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysDelete),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+			},
 		},
 	}
 }
 
-// pathKeysExistenceCheck is used to check if a given key exists.
+// pathKeysExistenceCheck is used to check if a given key exists, so Vault
+// treats writes to a new key as create operations and writes to an existing
+// key as update operations.
 func (b *backend) pathKeysExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
 	key := d.Get("key").(string)
-	if k, err := b.Key(ctx, req.Storage, key); err != nil || k == nil {
-		return false, nil
+	if _, err := b.Key(ctx, req.Storage, key); err != nil {
+		if err == ErrKeyNotFound {
+			return false, nil
+		}
+		return false, err
 	}
 	return true, nil
 }
@@ -208,27 +457,165 @@ func (b *backend) pathKeysRead(ctx context.Context, req *logical.Request, d *fra
 		return nil, err
 	}
 
-	kmsClient, closer, err := b.KMSClient(req.Storage)
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
 	if err != nil {
 		return nil, err
 	}
 	defer closer()
 
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
 	cryptoKey, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
 		Name: k.CryptoKeyID,
 	})
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to read crypto key: {{err}}", err)
+		return nil, wrapKMSError("failed to read crypto key: {{err}}", err)
 	}
 
+	data := cryptoKeyToMap(cryptoKey)
+	data["cas_version"] = k.Version
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+// cryptoKeyResponseFields returns the response schema of cryptoKeyToMap.
+func cryptoKeyResponseFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Resource ID of the crypto key.",
+			Required:    true,
+		},
+		"purpose": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Purpose of the crypto key.",
+			Required:    true,
+		},
+		"project": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Project of the crypto key.",
+		},
+		"location": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Location of the crypto key.",
+		},
+		"key_ring": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Key ring of the crypto key.",
+		},
+		"crypto_key": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Name of the crypto key in its key ring.",
+		},
+		"labels": &framework.FieldSchema{
+			Type:        framework.TypeKVPairs,
+			Description: "Labels of the crypto key.",
+		},
+		"create_time_seconds": &framework.FieldSchema{
+			Type:        framework.TypeInt64,
+			Description: "Unix time the crypto key was created.",
+		},
+		"next_rotation_time_seconds": &framework.FieldSchema{
+			Type:        framework.TypeInt64,
+			Description: "Unix time KMS next rotates the crypto key.",
+		},
+		"rotation_schedule_seconds": &framework.FieldSchema{
+			Type:        framework.TypeInt64,
+			Description: "Rotation period of the crypto key in seconds.",
+		},
+		"destroy_scheduled_duration_seconds": &framework.FieldSchema{
+			Type:        framework.TypeInt64,
+			Description: "Seconds crypto key versions stay scheduled for destruction.",
+		},
+		"primary_version": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Version of the primary crypto key version, for symmetric keys.",
+		},
+		"state": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "State of the primary crypto key version, for symmetric keys.",
+		},
+		"protection_level": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Protection level of new crypto key versions.",
+		},
+		"algorithm": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "Algorithm of new crypto key versions.",
+		},
+		"version_template": &framework.FieldSchema{
+			Type:        framework.TypeMap,
+			Description: "Protection level and algorithm of new crypto key versions.",
+		},
+	}
+}
+
+// keysReadResponseFields returns the response schema of reading a key.
+func keysReadResponseFields() map[string]*framework.FieldSchema {
+	fields := cryptoKeyResponseFields()
+	fields["cas_version"] = &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: "Version of the key in Vault, for check-and-set writes.",
+		Required:    true,
+	}
+	return fields
+}
+
+// keysWriteResponseFields returns the response schema of writing a key, which
+// is the crypto key, or the crypto key which would be created for a dry run.
+func keysWriteResponseFields() map[string]*framework.FieldSchema {
+	fields := cryptoKeyResponseFields()
+	fields["id"].Required = false
+	fields["purpose"].Required = false
+	fields["dry_run"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Whether the write was a dry run which changed nothing.",
+	}
+	fields["crypto_key_id"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Resource ID of the crypto key which would be created, for a dry run.",
+	}
+	fields["key_ring_exists"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Whether the key ring exists, for a dry run.",
+	}
+	fields["adopted"] = &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: "Whether the existing crypto key would be adopted, for a dry run.",
+	}
+	fields["rotation_period"] = &framework.FieldSchema{
+		Type:        framework.TypeInt64,
+		Description: "Rotation period of the crypto key in seconds, for a dry run.",
+	}
+	fields["destroy_scheduled_duration"] = &framework.FieldSchema{
+		Type:        framework.TypeInt64,
+		Description: "Seconds crypto key versions stay scheduled for destruction, for a dry run.",
+	}
+	return fields
+}
+
+// cryptoKeyToMap converts the crypto key into a user-facing response.
+func cryptoKeyToMap(cryptoKey *kmspb.CryptoKey) map[string]interface{} {
 	data := map[string]interface{}{
 		"id":      cryptoKey.Name,
 		"purpose": purposeToString(cryptoKey.Purpose),
 	}
 
+	if n, err := parseCryptoKeyName(cryptoKey.Name); err == nil {
+		data["project"] = n.Project
+		data["location"] = n.Location
+		data["key_ring"] = n.KeyRing
+		data["crypto_key"] = n.CryptoKey
+	}
 	if len(cryptoKey.Labels) > 0 {
 		data["labels"] = cryptoKey.Labels
 	}
+	if cryptoKey.CreateTime != nil {
+		data["create_time_seconds"] = cryptoKey.CreateTime.Seconds
+	}
 	if cryptoKey.NextRotationTime != nil {
 		data["next_rotation_time_seconds"] = cryptoKey.NextRotationTime.Seconds
 	}
@@ -237,6 +624,9 @@ func (b *backend) pathKeysRead(ctx context.Context, req *logical.Request, d *fra
 			data["rotation_schedule_seconds"] = t.RotationPeriod.Seconds
 		}
 	}
+	if cryptoKey.DestroyScheduledDuration != nil {
+		data["destroy_scheduled_duration_seconds"] = cryptoKey.DestroyScheduledDuration.Seconds
+	}
 	if cryptoKey.Primary != nil {
 		data["primary_version"] = path.Base(cryptoKey.Primary.Name)
 		data["state"] = strings.ToLower(cryptoKey.Primary.State.String())
@@ -244,48 +634,252 @@ func (b *backend) pathKeysRead(ctx context.Context, req *logical.Request, d *fra
 	if vt := cryptoKey.VersionTemplate; vt != nil {
 		data["protection_level"] = protectionLevelToString(vt.ProtectionLevel)
 		data["algorithm"] = algorithmToString(vt.Algorithm)
+		data["version_template"] = map[string]interface{}{
+			"protection_level": protectionLevelToString(vt.ProtectionLevel),
+			"algorithm":        algorithmToString(vt.Algorithm),
+		}
 	}
 
-	return &logical.Response{
-		Data: data,
-	}, nil
+	return data
 }
 
 // pathKeysList corresponds to LIST gcpkms/keys and is used to list all keys
 // in the system.
 func (b *backend) pathKeysList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	keys, err := b.Keys(ctx, req.Storage)
+	prefix := d.Get("prefix").(string)
+	labels := d.Get("labels").(map[string]string)
+	after := d.Get("after").(string)
+	limit := d.Get("limit").(int)
+	detailed := d.Get("detailed").(bool)
+
+	if limit < 0 {
+		return nil, logical.CodedError(400, "limit cannot be negative")
+	}
+
+	index, err := b.keyIndex(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
-	return logical.ListResponse(keys), nil
+
+	// The usage of each key in a detailed list is read from storage, so
+	// detailed lists are paged even without a limit
+	capped := false
+	if detailed && (limit == 0 || limit > keysListDetailedMaxLimit) {
+		limit = keysListDetailedMaxLimit
+		capped = true
+	}
+
+	keys := make([]string, 0, len(index))
+	keyInfo := make(map[string]interface{})
+	truncated := false
+	for _, key := range keyIndexNames(index) {
+		if !strings.HasPrefix(key, prefix) || (after != "" && key <= after) {
+			continue
+		}
+
+		e := index[key]
+		if len(labels) > 0 && (!e.HasExpected || !e.hasLabels(labels)) {
+			continue
+		}
+		if limit > 0 && len(keys) >= limit {
+			truncated = true
+			break
+		}
+		keys = append(keys, key)
+		if !detailed {
+			continue
+		}
+
+		u, err := b.KeyUsage(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+
+		info := map[string]interface{}{
+			"crypto_key_id": e.CryptoKeyID,
+			"min_version":   e.MinVersion,
+			"max_version":   e.MaxVersion,
+		}
+		if e.HasExpected {
+			info["purpose"] = e.Purpose
+		}
+		if t := u.lastUsed(); !t.IsZero() {
+			info["last_used_time"] = t.Format(time.RFC3339)
+		}
+		keyInfo[key] = info
+	}
+
+	if !detailed {
+		return logical.ListResponse(keys), nil
+	}
+
+	resp := logical.ListResponseWithInfo(keys, keyInfo)
+	if capped && truncated {
+		resp.AddWarning(fmt.Sprintf("detailed lists return at most %d keys, "+
+			"list the next page with after=%q", keysListDetailedMaxLimit, keys[len(keys)-1]))
+	}
+	return resp, nil
 }
 
 // pathKeysWrite corresponds to PUT/POST gcpkms/keys/create/:key and creates a
 // new GCP KMS key and registers it for use in Vault.
 func (b *backend) pathKeysWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	kmsClient, closer, err := b.KMSClient(req.Storage)
+	key := d.Get("key").(string)
+	cryptoKey := d.Get("crypto_key").(string)
+	createKeyRing := d.Get("create_key_ring").(bool)
+	adopt := d.Get("adopt").(bool)
+	dryRun := d.Get("dry_run").(bool)
+
+	serviceAccount := strings.TrimSpace(d.Get("impersonate_service_account").(string))
+	configName := d.Get("config_name").(string)
+
+	if dryRun && req.Operation == logical.UpdateOperation {
+		return nil, logical.CodedError(400, "dry_run is only supported when creating a key")
+	}
+	if req.Operation == logical.CreateOperation {
+		if err := b.checkNewKeyName(ctx, req.Storage, key); err != nil {
+			return nil, err
+		}
+	}
+	if serviceAccount != "" && req.Operation == logical.UpdateOperation {
+		return nil, logical.CodedError(400, "impersonate_service_account is only "+
+			"supported when creating a key, use keys/config to change it")
+	}
+	if configName != "" {
+		if req.Operation == logical.UpdateOperation {
+			return nil, logical.CodedError(400, "config_name is only supported "+
+				"when creating a key, use keys/config to change it")
+		}
+		if err := b.checkConfigProfile(ctx, req.Storage, configName); err != nil {
+			return nil, err
+		}
+	}
+
+	keyRing, err := b.keyRingFromFields(ctx, req.Storage, d)
+	if err != nil {
+		return nil, err
+	}
+	if req.Operation == logical.CreateOperation && keyRing != "" {
+		if err := b.checkKeyPolicy(ctx, req.Storage, keyRing); err != nil {
+			return nil, err
+		}
+	}
+
+	// Hold the key's lock until it is saved, so a concurrent write cannot
+	// change the key between the check-and-set and the save.
+	lock := locksutil.LockForKey(b.keyLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// On update, load the existing entry so the key ring and crypto key can be
+	// inferred and any Vault-side configuration is preserved.
+	var k *Key
+	if req.Operation == logical.UpdateOperation {
+		k, err = b.Key(ctx, req.Storage, key)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+			return nil, err
+		}
+
+		if keyRing == "" {
+			keyRing = path.Dir(path.Dir(k.CryptoKeyID))
+		}
+		if cryptoKey == "" {
+			cryptoKey = path.Base(k.CryptoKeyID)
+		}
+	}
+	if err := checkCAS(k, d); err != nil {
+		return nil, err
+	}
+
+	target := clientKey{
+		serviceAccount: serviceAccount,
+		profile:        configName,
+	}
+	if k != nil {
+		target.endpoint = k.APIEndpoint
+		target.serviceAccount = k.ImpersonateServiceAccount
+		target.profile = k.ConfigName
+	}
+
+	kmsClient, closer, err := b.keyedKMSClient(ctx, req.Storage, target)
 	if err != nil {
 		return nil, err
 	}
 	defer closer()
 
-	key := d.Get("key").(string)
-	keyRing := d.Get("key_ring").(string)
-	labels := d.Get("labels").(map[string]string)
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
 
-	// Default crypto key name to the key name if unspecified
-	cryptoKey := d.Get("crypto_key").(string)
+	config, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Default crypto key name to the key name, or to the name generated by
+	// the configured template, if unspecified
 	if cryptoKey == "" {
 		cryptoKey = key
+		if config.CryptoKeyNameTemplate != "" {
+			cryptoKey, err = expandKeyNameTemplate(config.CryptoKeyNameTemplate, req.MountPoint, key)
+			if err != nil {
+				return nil, errwrap.Wrapf("failed to generate crypto key name: {{err}}", err)
+			}
+			if !resourceIDRegex.MatchString(cryptoKey) {
+				return nil, logical.CodedError(400, fmt.Sprintf("crypto_key_name_template "+
+					"generated the invalid crypto key name %q, names must be 1-63 "+
+					"letters, numbers, underscores, or hyphens", cryptoKey))
+			}
+		}
+	}
+
+	if req.Operation == logical.CreateOperation {
+		if keyRing == "" {
+			return nil, errMissingFields("key_ring")
+		}
+		if !resourceIDRegex.MatchString(cryptoKey) {
+			return nil, logical.CodedError(400, fmt.Sprintf("invalid crypto key "+
+				"name %q, must be 1-63 letters, numbers, underscores, or hyphens", cryptoKey))
+		}
+		if err := config.checkKeyNames(path.Base(keyRing), cryptoKey); err != nil {
+			return nil, err
+		}
 	}
 
 	// Base key
 	ck := &kmspb.CryptoKey{
-		Labels:          labels,
 		VersionTemplate: new(kmspb.CryptoKeyVersionTemplate),
 	}
 
+	// Set labels if given. Labels are only sent on update when they were
+	// explicitly provided, so existing labels are not cleared by an update
+	// that only changes the rotation period.
+	if v, ok := d.GetOk("labels"); ok {
+		labels := v.(map[string]string)
+		if err := validateLabels(labels); err != nil {
+			return nil, logical.CodedError(400, err.Error())
+		}
+		ck.Labels = labels
+	}
+
+	// Label crypto keys created by this mount, so orphan checks can find
+	// them once they have no key in Vault. Updating labels replaces all of
+	// them, so the mount label is added again on update.
+	if config.MountLabel != "" && (req.Operation == logical.CreateOperation || ck.Labels != nil) {
+		name, value, err := parseMountLabel(config.MountLabel)
+		if err != nil {
+			return nil, err
+		}
+		labels := make(map[string]string, len(ck.Labels)+1)
+		for k, v := range ck.Labels {
+			labels[k] = v
+		}
+		labels[name] = value
+		ck.Labels = labels
+	}
+
 	// Set purpose if given
 	if v, ok := d.GetOk("purpose"); ok {
 		if req.Operation == logical.UpdateOperation {
@@ -318,6 +912,16 @@ func (b *backend) pathKeysWrite(ctx context.Context, req *logical.Request, d *fr
 		}
 	}
 
+	// The purpose cannot be changed on update, so only check the algorithm is
+	// valid for it on create.
+	if req.Operation == logical.CreateOperation {
+		if p := algorithmPurpose(ck.VersionTemplate.Algorithm); p != kmspb.CryptoKey_CRYPTO_KEY_PURPOSE_UNSPECIFIED && p != ck.Purpose {
+			return nil, logical.CodedError(400, fmt.Sprintf(
+				"algorithm %q is not valid for purpose %q",
+				algorithmToString(ck.VersionTemplate.Algorithm), purposeToString(ck.Purpose)))
+		}
+	}
+
 	// Set the protection level
 	if v, ok := d.GetOk("protection_level"); ok {
 		if req.Operation == logical.UpdateOperation {
@@ -349,22 +953,78 @@ func (b *backend) pathKeysWrite(ctx context.Context, req *logical.Request, d *fr
 		}
 	}
 
+	// Set the scheduled destruction duration
+	if v, ok := d.GetOk("destroy_scheduled_duration"); ok {
+		if req.Operation == logical.UpdateOperation {
+			return nil, errImmutable("destroy scheduled duration")
+		}
+
+		t := time.Duration(v.(int)) * time.Second
+		if t < minDestroyScheduledDuration || t > maxDestroyScheduledDuration {
+			return nil, logical.CodedError(400, fmt.Sprintf(
+				"destroy scheduled duration must be between %s and %s",
+				minDestroyScheduledDuration, maxDestroyScheduledDuration))
+		}
+
+		ck.DestroyScheduledDuration = &duration.Duration{
+			Seconds: int64(v.(int)),
+		}
+	}
+
+	// Check the key policy. The protection level cannot be changed on
+	// update, so only a new algorithm is checked then.
+	if req.Operation == logical.CreateOperation {
+		if err := config.checkCryptoKey(ck); err != nil {
+			return nil, err
+		}
+	} else if _, ok := d.GetOk("algorithm"); ok {
+		if err := config.checkAlgorithm(ck.VersionTemplate.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return keysCreateDryRun(ctx, kmsClient, keyRing, cryptoKey, createKeyRing, adopt, ck)
+	}
+
 	// Check if the key ring exists
 	kr, err := kmsClient.GetKeyRing(ctx, &kmspb.GetKeyRingRequest{
 		Name: keyRing,
 	})
 	if err != nil {
 		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+			if !createKeyRing {
+				return nil, logical.CodedError(400, fmt.Sprintf(
+					"key ring %q does not exist - create it first or set "+
+						"create_key_ring to true", keyRing))
+			}
+
 			// Key ring does not exist, try to create it
 			kr, err = kmsClient.CreateKeyRing(ctx, &kmspb.CreateKeyRingRequest{
 				Parent:    path.Dir(path.Dir(keyRing)),
 				KeyRingId: path.Base(keyRing),
 			})
 			if err != nil {
-				return nil, errwrap.Wrapf("failed to create key ring: {{err}}", err)
+				return nil, wrapKMSError("failed to create key ring: {{err}}", err)
 			}
 		} else {
-			return nil, errwrap.Wrapf("failed to check if key ring exists: {{err}}", err)
+			return nil, wrapKMSError("failed to check if key ring exists: {{err}}", err)
+		}
+	}
+
+	// Record the create before making the crypto key, so the rollback handler
+	// can recover the crypto key if Vault stops before the key is saved.
+	var walID string
+	if req.Operation == logical.CreateOperation {
+		walID, err = framework.PutWAL(ctx, req.Storage, walTypeCryptoKey, &walCryptoKey{
+			Key:                       key,
+			CryptoKeyID:               fmt.Sprintf("%s/cryptoKeys/%s", kr.Name, cryptoKey),
+			ImpersonateServiceAccount: serviceAccount,
+			ConfigName:                configName,
+			StartTime:                 time.Now().Unix(),
+		})
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to write WAL entry: {{err}}", err)
 		}
 	}
 
@@ -376,68 +1036,198 @@ func (b *backend) pathKeysWrite(ctx context.Context, req *logical.Request, d *fr
 	if err != nil {
 		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.AlreadyExists {
 			if req.Operation != logical.UpdateOperation {
-				resp := logical.ErrorResponse(
-					"cannot update a key that is not already registered - register the " +
-						"key first using the /keys/register endpoint, and then update any " +
-						"configuration fields.")
-				return resp, logical.ErrPermissionDenied
+				if !adopt {
+					resp := logical.ErrorResponse(
+						"cannot update a key that is not already registered - register the " +
+							"key first using the /keys/register endpoint, and then update any " +
+							"configuration fields. To register the existing crypto key " +
+							"when creating a key, set adopt to true.")
+					return resp, logical.ErrPermissionDenied
+				}
+
+				// Register the existing crypto key if it matches the request
+				resp, err = adoptCryptoKey(ctx, kmsClient, fmt.Sprintf("%s/cryptoKeys/%s", kr.Name, cryptoKey), ck)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				var paths []string
+				ck.Name = fmt.Sprintf("%s/cryptoKeys/%s", kr.Name, cryptoKey)
+
+				if ck.Labels != nil {
+					paths = append(paths, "labels")
+				}
+
+				if ck.RotationSchedule != nil {
+					paths = append(paths, "rotation_period")
+				}
+
+				if ck.NextRotationTime != nil {
+					paths = append(paths, "next_rotation_time")
+				}
+
+				resp, err = kmsClient.UpdateCryptoKey(ctx, &kmspb.UpdateCryptoKeyRequest{
+					CryptoKey: ck,
+					UpdateMask: &fieldmaskpb.FieldMask{
+						Paths: paths,
+					},
+				})
+				if err != nil {
+					return nil, wrapKMSError("failed to update crypto key: {{err}}", err)
+				}
 			}
+		} else {
+			return nil, wrapKMSError("failed to create crypto key: {{err}}", err)
+		}
+	}
 
-			var paths []string
-			ck.Name = fmt.Sprintf("%s/cryptoKeys/%s", kr.Name, cryptoKey)
+	// Save it
+	if k == nil {
+		k = &Key{
+			Name:                      key,
+			ImpersonateServiceAccount: serviceAccount,
+			ConfigName:                configName,
+		}
+	}
+	k.CryptoKeyID = resp.Name
+	k.Expected = newKeyExpectation(resp)
+	b.invalidateCryptoKey(resp.Name)
 
-			if ck.Labels != nil {
-				paths = append(paths, "labels")
-			}
+	if err := b.putKey(ctx, req.Storage, k); err != nil {
+		return nil, err
+	}
+
+	if walID != "" {
+		if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+			b.Logger().Warn("failed to delete WAL entry", "id", walID, "error", err)
+		}
+	}
+
+	return &logical.Response{
+		Data: cryptoKeyToMap(resp),
+	}, nil
+}
+
+// adoptCryptoKey reads the existing crypto key and returns it if its purpose,
+// algorithm, and protection level match the requested crypto key.
+func adoptCryptoKey(ctx context.Context, kmsClient keyManagementClient, cryptoKeyID string, want *kmspb.CryptoKey) (*kmspb.CryptoKey, error) {
+	ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: cryptoKeyID,
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to read existing crypto key: {{err}}", err)
+	}
+
+	if v, exp := ck.Purpose, want.Purpose; v != exp {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"cannot adopt crypto key %q: purpose %q does not match requested purpose %q",
+			cryptoKeyID, purposeToString(v), purposeToString(exp)))
+	}
+
+	if v, exp := ck.VersionTemplate.GetAlgorithm(), want.VersionTemplate.GetAlgorithm(); v != exp {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"cannot adopt crypto key %q: algorithm %q does not match requested algorithm %q",
+			cryptoKeyID, algorithmToString(v), algorithmToString(exp)))
+	}
+
+	if v, exp := ck.VersionTemplate.GetProtectionLevel(), want.VersionTemplate.GetProtectionLevel(); v != exp {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"cannot adopt crypto key %q: protection level %q does not match requested protection level %q",
+			cryptoKeyID, protectionLevelToString(v), protectionLevelToString(exp)))
+	}
+
+	return ck, nil
+}
 
-			if ck.RotationSchedule != nil {
-				paths = append(paths, "rotation_period")
+// keysCreateDryRun validates that the crypto key could be created in the key
+// ring without creating anything, and returns the crypto key which would be
+// created.
+func keysCreateDryRun(ctx context.Context, kmsClient keyManagementClient, keyRing, cryptoKey string, createKeyRing, adopt bool, ck *kmspb.CryptoKey) (*logical.Response, error) {
+	cryptoKeyID := fmt.Sprintf("%s/cryptoKeys/%s", keyRing, cryptoKey)
+
+	var warnings []string
+	keyRingExists, adopted := true, false
+	if _, err := kmsClient.GetKeyRing(ctx, &kmspb.GetKeyRingRequest{
+		Name: keyRing,
+	}); err != nil {
+		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+			if !createKeyRing {
+				return nil, logical.CodedError(400, fmt.Sprintf(
+					"key ring %q does not exist - create it first or set "+
+						"create_key_ring to true", keyRing))
 			}
+			keyRingExists = false
+			warnings = append(warnings, fmt.Sprintf("key ring %q does not exist "+
+				"and will be created - permissions cannot be checked until it exists", keyRing))
+		} else {
+			return nil, errwrap.Wrapf("failed to check if key ring exists: {{err}}", err)
+		}
+	}
 
-			if ck.NextRotationTime != nil {
-				paths = append(paths, "next_rotation_time")
+	if keyRingExists {
+		_, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+			Name: cryptoKeyID,
+		})
+		switch terr, ok := grpcstatus.FromError(err); {
+		case err == nil && adopt:
+			if _, err := adoptCryptoKey(ctx, kmsClient, cryptoKeyID, ck); err != nil {
+				return nil, err
 			}
+			adopted = true
+		case err == nil:
+			resp := logical.ErrorResponse(
+				"cannot update a key that is not already registered - register the " +
+					"key first using the /keys/register endpoint, and then update any " +
+					"configuration fields. To register the existing crypto key " +
+					"when creating a key, set adopt to true.")
+			return resp, logical.ErrPermissionDenied
+		case !ok || terr.Code() != grpccodes.NotFound:
+			return nil, errwrap.Wrapf("failed to check if crypto key exists: {{err}}", err)
+		}
 
-			resp, err = kmsClient.UpdateCryptoKey(ctx, &kmspb.UpdateCryptoKeyRequest{
-				CryptoKey: ck,
-				UpdateMask: &field_mask.FieldMask{
-					Paths: paths,
-				},
+		if !adopted {
+			r, err := testKeyPermissions(ctx, kmsClient, keyRing, map[string][]string{
+				"create": {"cloudkms.cryptoKeys.create"},
 			})
 			if err != nil {
-				return nil, errwrap.Wrapf("failed to update crypto key: {{err}}", err)
+				return nil, err
 			}
-		} else {
-			return nil, errwrap.Wrapf("failed to create crypto key: {{err}}", err)
+			warnings = append(warnings, r.Warnings()...)
 		}
 	}
 
-	// Save it
-	entry, err := logical.StorageEntryJSON("keys/"+key, &Key{
-		Name:        key,
-		CryptoKeyID: resp.Name,
-	})
-	if err != nil {
-		return nil, errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+	data := map[string]interface{}{
+		"dry_run":          true,
+		"crypto_key_id":    cryptoKeyID,
+		"key_ring":         keyRing,
+		"key_ring_exists":  keyRingExists,
+		"adopted":          adopted,
+		"purpose":          purposeToString(ck.Purpose),
+		"algorithm":        algorithmToString(ck.VersionTemplate.Algorithm),
+		"protection_level": protectionLevelToString(ck.VersionTemplate.ProtectionLevel),
+		"labels":           ck.Labels,
+	}
+	if rp := ck.GetRotationPeriod(); rp != nil {
+		data["rotation_period"] = rp.Seconds
 	}
-	if err := req.Storage.Put(ctx, entry); err != nil {
-		return nil, errwrap.Wrapf("failed to write to storage: {{err}}", err)
+	if ck.DestroyScheduledDuration != nil {
+		data["destroy_scheduled_duration"] = ck.DestroyScheduledDuration.Seconds
 	}
 
-	return nil, nil
+	return &logical.Response{
+		Data:     data,
+		Warnings: warnings,
+	}, nil
 }
 
 // pathKeysDelete corresponds to PUT/POST gcpkms/keys/delete/:key and deletes an
 // existing GCP KMS key and deregisters it from Vault.
 func (b *backend) pathKeysDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	kmsClient, closer, err := b.KMSClient(req.Storage)
-	if err != nil {
-		return nil, err
-	}
-	defer closer()
-
 	key := d.Get("key").(string)
 
+	unlock := b.lockKey(key)
+	defer unlock()
+
 	k, err := b.Key(ctx, req.Storage, key)
 	if err != nil {
 		if err == ErrKeyNotFound {
@@ -446,24 +1236,58 @@ func (b *backend) pathKeysDelete(ctx context.Context, req *logical.Request, d *f
 		return nil, err
 	}
 
+	if k.DeletionProtection {
+		return nil, errDeletionProtected(key)
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	err = destroyCryptoKey(ctx, kmsClient, k.CryptoKeyID)
+	b.invalidateCryptoKey(k.CryptoKeyID)
+	b.evictPublicKeys(k.CryptoKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete the key from our storage
+	if err := b.deleteKey(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+	if err := b.deleteUsage(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// destroyCryptoKey disables automatic rotation of the crypto key and schedules
+// destruction of all of its crypto key versions which are not already
+// destroyed or scheduled for destruction.
+func destroyCryptoKey(ctx context.Context, kmsClient keyManagementClient, cryptoKeyID string) error {
 	// Disable automatic key rotation
 	if _, err := kmsClient.UpdateCryptoKey(ctx, &kmspb.UpdateCryptoKeyRequest{
 		CryptoKey: &kmspb.CryptoKey{
-			Name:             k.CryptoKeyID,
+			Name:             cryptoKeyID,
 			NextRotationTime: nil,
 			RotationSchedule: nil,
 		},
-		UpdateMask: &field_mask.FieldMask{
+		UpdateMask: &fieldmaskpb.FieldMask{
 			Paths: []string{"next_rotation_time", "rotation_period"},
 		},
 	}); err != nil {
-		return nil, errwrap.Wrapf("failed to disable rotation on crypto key: {{err}}", err)
+		return errwrap.Wrapf("failed to disable rotation on crypto key: {{err}}", err)
 	}
 
 	// Collect the list of all key versions
 	var ckvs []string
 	it := kmsClient.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
-		Parent: k.CryptoKeyID,
+		Parent: cryptoKeyID,
 	})
 	for {
 		resp, err := it.Next()
@@ -471,7 +1295,7 @@ func (b *backend) pathKeysDelete(ctx context.Context, req *logical.Request, d *f
 			if err == iterator.Done {
 				break
 			}
-			return nil, errwrap.Wrapf("failed to list crypto key versions: {{err}}", err)
+			return errwrap.Wrapf("failed to list crypto key versions: {{err}}", err)
 		}
 
 		if resp.State != kmspb.CryptoKeyVersion_DESTROYED &&
@@ -504,15 +1328,7 @@ func (b *backend) pathKeysDelete(ctx context.Context, req *logical.Request, d *f
 	wp.StopWait()
 
 	// Return errors if any happened
-	if err := errs.ErrorOrNil(); err != nil {
-		return nil, err
-	}
-
-	// Delete the key from our storage
-	if err := req.Storage.Delete(ctx, "keys/"+key); err != nil {
-		return nil, errwrap.Wrapf("failed to delete from storage: {{err}}", err)
-	}
-	return nil, nil
+	return errs.ErrorOrNil()
 }
 
 // keyPurposes is the list of purposes to key types
@@ -545,6 +1361,39 @@ func purposeToString(p kmspb.CryptoKey_CryptoKeyPurpose) string {
 	return "unspecified"
 }
 
+// keyPurposeOperations is the list of operations supported by crypto keys of
+// each purpose.
+var keyPurposeOperations = map[kmspb.CryptoKey_CryptoKeyPurpose][]string{
+	kmspb.CryptoKey_ASYMMETRIC_DECRYPT: {"decrypt", "pubkey", "wrapkey"},
+	kmspb.CryptoKey_ASYMMETRIC_SIGN:    {"pubkey", "sign", "verify"},
+	kmspb.CryptoKey_ENCRYPT_DECRYPT:    {"decrypt", "encrypt", "reencrypt"},
+}
+
+// checkKeyPurpose returns a descriptive error if the crypto key's purpose does
+// not support the operation. Crypto keys with an unknown purpose are allowed
+// through so KMS can make the decision.
+func checkKeyPurpose(key string, ck *kmspb.CryptoKey, operation string) error {
+	ops, ok := keyPurposeOperations[ck.Purpose]
+	if !ok {
+		return nil
+	}
+	for _, op := range ops {
+		if op == operation {
+			return nil
+		}
+	}
+
+	algorithm := "unspecified"
+	if ck.VersionTemplate != nil {
+		algorithm = algorithmToString(ck.VersionTemplate.Algorithm)
+	}
+
+	return logical.CodedError(400, fmt.Sprintf(
+		"key %q has purpose %q (algorithm %q) which does not support %s, "+
+			"valid operations for this key are %q",
+		key, purposeToString(ck.Purpose), algorithm, operation, ops))
+}
+
 // keyAlgorithms is the list of key algorithms.
 var keyAlgorithms = map[string]kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
 	"symmetric_encryption":         kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
@@ -557,10 +1406,57 @@ var keyAlgorithms = map[string]kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
 	"rsa_decrypt_oaep_2048_sha256": kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256,
 	"rsa_decrypt_oaep_3072_sha256": kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256,
 	"rsa_decrypt_oaep_4096_sha256": kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256,
+	"rsa_decrypt_oaep_4096_sha512": kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512,
+	"rsa_decrypt_oaep_2048_sha1":   kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA1,
+	"rsa_decrypt_oaep_3072_sha1":   kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA1,
+	"rsa_decrypt_oaep_4096_sha1":   kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA1,
 	"ec_sign_p256_sha256":          kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
 	"ec_sign_p384_sha384":          kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384,
 }
 
+// oaepHashes are the OAEP hash functions of the RSA decryption algorithms.
+var oaepHashes = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]crypto.Hash{
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512: crypto.SHA512,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA1:   crypto.SHA1,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA1:   crypto.SHA1,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA1:   crypto.SHA1,
+}
+
+// oaepHashNames are the names of the OAEP hash functions accepted by Vault.
+var oaepHashNames = map[string]crypto.Hash{
+	"sha1":   crypto.SHA1,
+	"sha256": crypto.SHA256,
+	"sha512": crypto.SHA512,
+}
+
+// oaepHashToString returns the user readable name of the OAEP hash function.
+func oaepHashToString(h crypto.Hash) string {
+	for k, v := range oaepHashNames {
+		if h == v {
+			return k
+		}
+	}
+	return "unspecified"
+}
+
+// algorithmPurpose returns the crypto key purpose the algorithm is valid for,
+// or unspecified if it is unknown.
+func algorithmPurpose(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) kmspb.CryptoKey_CryptoKeyPurpose {
+	name := a.String()
+	switch {
+	case a == kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION:
+		return kmspb.CryptoKey_ENCRYPT_DECRYPT
+	case strings.Contains(name, "_SIGN_"):
+		return kmspb.CryptoKey_ASYMMETRIC_SIGN
+	case strings.Contains(name, "_DECRYPT_"):
+		return kmspb.CryptoKey_ASYMMETRIC_DECRYPT
+	}
+	return kmspb.CryptoKey_CRYPTO_KEY_PURPOSE_UNSPECIFIED
+}
+
 // keyAlgorithmNames returns the list of key algorithms.
 func keyAlgorithmNames() []string {
 	list := make([]string, 0, len(keyAlgorithms))
@@ -611,8 +1507,56 @@ func protectionLevelToString(p kmspb.ProtectionLevel) string {
 	return "unknown"
 }
 
+// cryptoKeyAlgorithm returns the algorithm of the crypto key's primary version,
+// or of its version template if it has no primary version.
+func cryptoKeyAlgorithm(ck *kmspb.CryptoKey) kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm {
+	if ck.Primary != nil {
+		return ck.Primary.Algorithm
+	}
+	if ck.VersionTemplate != nil {
+		return ck.VersionTemplate.Algorithm
+	}
+	return kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED
+}
+
+// errDeletionProtected is a logical coded error that is returned when the user
+// tries to delete, deregister, or trim a key with deletion protection enabled.
+func errDeletionProtected(key string) error {
+	return logical.CodedError(400, fmt.Sprintf("key %q has deletion protection "+
+		"enabled - set deletion_protection to false on keys/config/%s first", key, key))
+}
+
 // errImmutable is a logical coded error that is returned when the user tries to
 // modfiy an immutable field.
 func errImmutable(s string) error {
 	return logical.CodedError(400, fmt.Sprintf("cannot change %s after key creation", s))
 }
+
+// labelKeyRegex and labelValueRegex match valid Google Cloud label keys and values. Keys must start
+// with a lowercase letter; values may be empty.
+var (
+	labelKeyRegex   = regexp.MustCompile(`^[\p{Ll}\p{Lo}][\p{Ll}\p{Lo}\p{N}_-]{0,62}$`)
+	labelValueRegex = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{N}_-]{0,63}$`)
+)
+
+// validateLabels verifies the given labels conform to the Google Cloud label
+// restrictions. This catches errors before making an API call.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > 64 {
+		return fmt.Errorf("too many labels: %d, the maximum is 64", len(labels))
+	}
+
+	for k, v := range labels {
+		if !labelKeyRegex.MatchString(k) {
+			return fmt.Errorf("invalid label key %q: keys must start with a "+
+				"lowercase letter and contain only lowercase letters, numbers, "+
+				"underscores, and dashes (max 63 characters)", k)
+		}
+		if !labelValueRegex.MatchString(v) {
+			return fmt.Errorf("invalid label value %q for key %q: values must "+
+				"contain only lowercase letters, numbers, underscores, and dashes "+
+				"(max 63 characters)", v, k)
+		}
+	}
+	return nil
+}
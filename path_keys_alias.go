@@ -0,0 +1,271 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathKeysAliases() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/alias/?$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "list",
+			OperationSuffix: "key-aliases",
+		},
+
+		HelpSynopsis: "List key aliases",
+		HelpDescription: `
+List the key aliases and the keys they point to.
+
+    $ vault list gcpkms/keys/alias
+`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: withFieldValidator(b.pathKeysAliasesList),
+		},
+	}
+}
+
+// pathKeysAliasesList corresponds to LIST gcpkms/keys/alias and is used to
+// list the key aliases.
+func (b *backend) pathKeysAliasesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	aliases, err := req.Storage.List(ctx, "aliases/")
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list key aliases: {{err}}", err)
+	}
+
+	keyInfo := make(map[string]interface{}, len(aliases))
+	for _, alias := range aliases {
+		a, err := b.keyAlias(ctx, req.Storage, alias)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil {
+			continue
+		}
+
+		keyInfo[alias] = map[string]interface{}{
+			"key": a.Key,
+		}
+	}
+
+	return logical.ListResponseWithInfo(aliases, keyInfo), nil
+}
+
+func (b *backend) pathKeysAlias() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/alias/" + framework.GenericNameRegex("alias"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationSuffix: "key-alias",
+		},
+
+		HelpSynopsis: "Manage key aliases",
+		HelpDescription: `
+Manage an alias for a key in Vault. An alias is an additional name for a key
+which can be used in place of the key name on the encrypt, decrypt, reencrypt,
+sign, verify, and pubkey endpoints. Aliases allow keys to be renamed, or to be
+exposed under environment-specific names, without registering the crypto key
+again.
+
+    $ vault write gcpkms/keys/alias/payments-prod key=my-key
+
+Multiple aliases may point to the same key. An alias cannot have the name of a
+key, and a key cannot be created or registered with the name of an alias.
+Deleting or deregistering a key deletes the aliases which point to it. Key
+management endpoints, such as keys/config and keys/rotate, only accept key
+names.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"alias": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the alias.
+`,
+			},
+
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault which the alias points to. The key must already be
+registered in Vault. This field is required.
+`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysAliasRead),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"alias": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Name of the alias.",
+								Required:    true,
+							},
+							"key": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Name of the key the alias refers to.",
+								Required:    true,
+							},
+						},
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysAliasWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "write",
+				},
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysAliasDelete),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "delete",
+				},
+			},
+		},
+	}
+}
+
+// pathKeysAliasRead corresponds to GET gcpkms/keys/alias/:alias and is used to
+// read the key an alias points to.
+func (b *backend) pathKeysAliasRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	alias := d.Get("alias").(string)
+
+	a, err := b.keyAlias(ctx, req.Storage, alias)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"alias": a.Alias,
+			"key":   a.Key,
+		},
+	}, nil
+}
+
+// pathKeysAliasWrite corresponds to PUT/POST gcpkms/keys/alias/:alias and is
+// used to create or update an alias.
+func (b *backend) pathKeysAliasWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	alias := d.Get("alias").(string)
+	key := d.Get("key").(string)
+
+	if key == "" {
+		return nil, errMissingFields("key")
+	}
+
+	// Hold the key's lock so it cannot be deleted before the alias is saved,
+	// which would leave the alias dangling.
+	unlock := b.lockKey(key)
+	defer unlock()
+
+	if _, err := b.Key(ctx, req.Storage, key); err != nil {
+		if err == ErrKeyNotFound {
+			return nil, logical.CodedError(400, fmt.Sprintf("key %q is not registered", key))
+		}
+		return nil, err
+	}
+
+	if _, err := b.Key(ctx, req.Storage, alias); err != ErrKeyNotFound {
+		if err != nil {
+			return nil, err
+		}
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"a key named %q is already registered, aliases cannot shadow keys", alias))
+	}
+
+	entry, err := logical.StorageEntryJSON("aliases/"+alias, &KeyAlias{
+		Alias: alias,
+		Key:   key,
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, errwrap.Wrapf("failed to write to storage: {{err}}", err)
+	}
+
+	return nil, nil
+}
+
+// pathKeysAliasDelete corresponds to DELETE gcpkms/keys/alias/:alias and is
+// used to delete an alias. The key it points to is not changed.
+func (b *backend) pathKeysAliasDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	alias := d.Get("alias").(string)
+
+	if err := req.Storage.Delete(ctx, "aliases/"+alias); err != nil {
+		return nil, errwrap.Wrapf("failed to delete from storage: {{err}}", err)
+	}
+	return nil, nil
+}
+
+// deleteKeyAliases deletes the aliases which point to the key.
+func (b *backend) deleteKeyAliases(ctx context.Context, s logical.Storage, key string) error {
+	aliases, err := s.List(ctx, "aliases/")
+	if err != nil {
+		return errwrap.Wrapf("failed to list key aliases: {{err}}", err)
+	}
+	for _, alias := range aliases {
+		a, err := b.keyAlias(ctx, s, alias)
+		if err != nil {
+			return err
+		}
+		if a == nil || a.Key != key {
+			continue
+		}
+		if err := s.Delete(ctx, "aliases/"+alias); err != nil {
+			return errwrap.Wrapf("failed to delete from storage: {{err}}", err)
+		}
+	}
+	return nil
+}
+
+// keyAlias retrieves the named alias from the storage backend, or nil if one
+// does not exist.
+func (b *backend) keyAlias(ctx context.Context, s logical.Storage, alias string) (*KeyAlias, error) {
+	entry, err := s.Get(ctx, "aliases/"+alias)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to retrieve alias %q: {{err}}", alias), err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result KeyAlias
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to decode entry for %q: {{err}}", alias), err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,244 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathKeysAliases_List(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ListOperation, "keys/alias/")
+	})
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if err := storage.Put(ctx, &logical.StorageEntry{
+		Key:   "aliases/my-alias",
+		Value: []byte(`{"alias":"my-alias", "key":"my-key"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ListOperation,
+		Path:      "keys/alias/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, exp := resp.Data["keys"].([]string), []string{"my-alias"}; !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+}
+
+func TestPathKeysAlias_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "keys/alias/my-alias")
+		testFieldValidation(t, logical.UpdateOperation, "keys/alias/my-alias")
+		testFieldValidation(t, logical.DeleteOperation, "keys/alias/my-alias")
+	})
+
+	cases := []struct {
+		name  string
+		alias string
+		data  map[string]interface{}
+		err   bool
+	}{
+		{
+			"success",
+			"my-alias",
+			map[string]interface{}{
+				"key": "my-key",
+			},
+			false,
+		},
+		{
+			"missing_key",
+			"my-alias",
+			nil,
+			true,
+		},
+		{
+			"key_not_registered",
+			"my-alias",
+			map[string]interface{}{
+				"key": "not-a-real-key",
+			},
+			true,
+		},
+		{
+			"shadows_key",
+			"my-other-key",
+			map[string]interface{}{
+				"key": "my-key",
+			},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			for _, name := range []string{"my-key", "my-other-key"} {
+				if err := storage.Put(ctx, &logical.StorageEntry{
+					Key:   "keys/" + name,
+					Value: []byte(`{"name":"` + name + `", "crypto_key_id":"foo"}`),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "keys/alias/" + tc.alias,
+				Data:      tc.data,
+			})
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if tc.err {
+				return
+			}
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.ReadOperation,
+				Path:      "keys/alias/" + tc.alias,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, exp := resp.Data["key"], tc.data["key"]; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			k, err := b.resolveKey(ctx, storage, tc.alias)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, exp := k.Name, tc.data["key"]; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+		})
+	}
+}
+
+func TestPathKeysAlias_Delete(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if err := storage.Put(ctx, &logical.StorageEntry{
+		Key:   "aliases/my-alias",
+		Value: []byte(`{"alias":"my-alias", "key":"my-key"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.DeleteOperation,
+		Path:      "keys/alias/my-alias",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := b.keyAlias(ctx, storage, "my-alias")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != nil {
+		t.Errorf("expected alias to be deleted: %#v", a)
+	}
+}
+
+func TestPathKeysAlias_KeyNames(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	for _, name := range []string{"my-key", "my-other-key"} {
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/" + name,
+			Value: []byte(`{"name":"` + name + `", "crypto_key_id":"foo"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, alias := range []string{"my-alias", "my-other-alias"} {
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/alias/" + alias,
+			Data: map[string]interface{}{
+				"key": "my-key",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/alias/unrelated-alias",
+		Data: map[string]interface{}{
+			"key": "my-other-key",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("register_shadows_alias", func(t *testing.T) {
+
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/register/my-alias",
+			Data: map[string]interface{}{
+				"crypto_key": "projects/p/locations/global/keyRings/r/cryptoKeys/my-alias",
+				"verify":     false,
+			},
+		})
+		if herr, ok := err.(logical.HTTPCodedError); !ok || herr.Code() != 400 {
+			t.Fatalf("expected 400 error, got %#v", err)
+		}
+	})
+
+	t.Run("delete_removes_aliases", func(t *testing.T) {
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/deregister/my-key",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		aliases, err := storage.List(ctx, "aliases/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := []string{"unrelated-alias"}; !reflect.DeepEqual(aliases, exp) {
+			t.Errorf("expected %q to be %q", aliases, exp)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func (b *backend) pathKeysAttestation() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key") + "/attestation",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "read",
+			OperationSuffix: "key-attestation",
+		},
+
+		HelpSynopsis: "Retrieve the HSM attestation for a crypto key version",
+		HelpDescription: `
+Retrieve the attestation statement and certificate chains generated by the
+Hardware Security Module for a crypto key version. The attestation can be used
+to prove that the key material was generated in, and never left, an HSM.
+
+Only crypto key versions with a protection level of "hsm" have an attestation.
+
+    $ vault read gcpkms/keys/my-key/attestation key_version=3
+
+If key_version is unspecified, the attestation for the crypto key's primary
+version is returned.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault. This key must already exist in Vault and Google Cloud
+KMS.
+`,
+			},
+
+			"key_version": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Integer version of the crypto key version for which to retrieve the
+attestation. If unspecified, this defaults to the crypto key's primary version.
+This field is required for asymmetric keys, which have no primary version.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: withFieldValidator(b.pathKeysAttestationRead),
+		},
+	}
+}
+
+// pathKeysAttestationRead corresponds to GET gcpkms/keys/:key/attestation and
+// is used to read the HSM attestation of a crypto key version.
+func (b *backend) pathKeysAttestationRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+	keyVersion := d.Get("key_version").(int)
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	ckv, err := attestedCryptoKeyVersion(ctx, kmsClient, k, keyVersion)
+	if err != nil {
+		return nil, err
+	}
+	att := ckv.Attestation
+
+	data := map[string]interface{}{
+		"key_version": path.Base(ckv.Name),
+		"format":      strings.ToLower(att.Format.String()),
+		"content":     base64.StdEncoding.EncodeToString(att.Content),
+	}
+
+	if cc := att.CertChains; cc != nil {
+		data["cert_chains"] = map[string]interface{}{
+			"cavium_certs":           cc.CaviumCerts,
+			"google_card_certs":      cc.GoogleCardCerts,
+			"google_partition_certs": cc.GooglePartitionCerts,
+		}
+	}
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+func (b *backend) pathKeysAttestationVerify() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key") + "/verify-attestation",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "verify",
+			OperationSuffix: "key-attestation",
+		},
+
+		HelpSynopsis: "Verify the HSM attestation for a crypto key version",
+		HelpDescription: `
+Retrieve and verify the attestation statement generated by the Hardware
+Security Module for a crypto key version. Vault verifies that:
+
+  - the attestation content can be decoded
+  - the manufacturer (Cavium) certificate chain terminates in a trusted
+    manufacturer root certificate
+  - the Google card and partition certificate chains terminate in a trusted
+    Google root certificate
+  - the manufacturer and Google partition certificates certify the same key
+  - the attestation is signed by that partition key
+  - the key was generated in the HSM, unless the version was imported
+  - the key cannot be extracted from the HSM
+
+The trusted roots are those built into the plugin and those set in the config
+with attestation_google_roots and attestation_manufacturer_roots. The roots
+are published by Google and the HSM manufacturer; see the Google Cloud KMS
+documentation on verifying attestations. Download and inspect them
+out-of-band, then add them to the config:
+
+    $ vault write gcpkms/config \
+        attestation_google_roots=@google-roots.pem \
+        attestation_manufacturer_roots=@manufacturer-roots.pem
+
+    $ vault write gcpkms/keys/my-key/verify-attestation key_version=1
+
+The response always succeeds if the attestation could be retrieved; check the
+"valid" field and the individual "checks" for the result.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault. This key must already exist in Vault and Google Cloud
+KMS.
+`,
+			},
+
+			"key_version": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Integer version of the crypto key version for which to verify the attestation.
+If unspecified, this defaults to the crypto key's primary version. This field
+is required for asymmetric keys, which have no primary version.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: withFieldValidator(b.pathKeysAttestationVerifyWrite),
+		},
+	}
+}
+
+// pathKeysAttestationVerifyWrite corresponds to PUT/POST
+// gcpkms/keys/:key/verify-attestation and is used to verify the HSM
+// attestation of a crypto key version against the trusted roots.
+func (b *backend) pathKeysAttestationVerifyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+	keyVersion := d.Get("key_version").(int)
+
+	c, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := configAttestationRoots(c)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case roots.Owner == nil:
+		return nil, logical.CodedError(400, "no Google attestation roots are trusted, "+
+			"set attestation_google_roots in the config")
+	case roots.Manufacturer == nil:
+		return nil, logical.CodedError(400, "no manufacturer attestation roots are trusted, "+
+			"set attestation_manufacturer_roots in the config")
+	}
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	ckv, err := attestedCryptoKeyVersion(ctx, kmsClient, k, keyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	report := verifyAttestation(ckv.Attestation, roots, ckv.ImportJob != "")
+
+	data := map[string]interface{}{
+		"key_version":    path.Base(ckv.Name),
+		"format":         strings.ToLower(ckv.Attestation.Format.String()),
+		"valid":          report.Valid,
+		"checks":         report.Checks,
+		"certificates":   report.Certificates,
+		"content_length": report.ContentLength,
+	}
+	if report.KeyOrigin != "" {
+		data["key_origin"] = report.KeyOrigin
+		data["extractable"] = report.Extractable
+	}
+	if len(report.Errors) > 0 {
+		data["errors"] = report.Errors
+	}
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+// attestedCryptoKeyVersion returns the crypto key version for the given key
+// and version, or the primary version if keyVersion is 0. It returns a coded
+// error if the version is not HSM-protected or has no attestation.
+func attestedCryptoKeyVersion(ctx context.Context, kmsClient keyManagementClient, k *Key, keyVersion int) (*kmspb.CryptoKeyVersion, error) {
+	var ckv *kmspb.CryptoKeyVersion
+	if keyVersion > 0 {
+		var err error
+		ckv, err = kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, keyVersion),
+		})
+		if err != nil {
+			return nil, wrapKMSError("failed to get crypto key version: {{err}}", err)
+		}
+	} else {
+		ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+			Name: k.CryptoKeyID,
+		})
+		if err != nil {
+			return nil, wrapKMSError("failed to read crypto key: {{err}}", err)
+		}
+		if ck.Primary == nil {
+			return nil, errMissingFields("key_version")
+		}
+		ckv = ck.Primary
+	}
+
+	if ckv.ProtectionLevel != kmspb.ProtectionLevel_HSM {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"crypto key version %s has protection level %q, attestations are only "+
+				"available for %q keys", path.Base(ckv.Name),
+			protectionLevelToString(ckv.ProtectionLevel), "hsm"))
+	}
+
+	if ckv.Attestation == nil {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"crypto key version %s does not have an attestation yet, the version "+
+				"may still be generating", path.Base(ckv.Name)))
+	}
+
+	return ckv, nil
+}
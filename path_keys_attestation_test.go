@@ -0,0 +1,182 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathKeysAttestation_Read(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "keys/my-key/attestation")
+	})
+
+	t.Run("software", func(t *testing.T) {
+
+		cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+		defer cleanup()
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key", "crypto_key_id":"` + cryptoKey + `"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/my-key/attestation",
+		}); err == nil {
+			t.Errorf("expected error for software key")
+		}
+	})
+
+	t.Run("hsm", func(t *testing.T) {
+
+		keyRing, cleanup := testCreateKMSKeyRing(t, "")
+		defer cleanup()
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "keys/my-key",
+			Data: map[string]interface{}{
+				"key_ring":         keyRing,
+				"protection_level": "hsm",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The attestation is not available until the version finishes
+		// generating.
+		var resp *logical.Response
+		if err := retryFib(func() error {
+			var err error
+			resp, err = b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.ReadOperation,
+				Path:      "keys/my-key/attestation",
+				Data: map[string]interface{}{
+					"key_version": 1,
+				},
+			})
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range []string{
+			"key_version",
+			"format",
+			"content",
+			"cert_chains",
+		} {
+			if _, ok := resp.Data[v]; !ok {
+				t.Errorf("missing %q", v)
+			}
+		}
+	})
+}
+
+func TestPathKeysAttestation_Verify(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/my-key/verify-attestation")
+	})
+
+	t.Run("missing_roots", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/my-key/verify-attestation",
+		}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		testFakeKMSClient(t, b)
+
+		ctx := context.Background()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "keys/my-key",
+			Data: map[string]interface{}{
+				"key_ring":         "projects/p/locations/global/keyRings/r",
+				"protection_level": "hsm",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		manufacturerRoots, googleRoots, err := fakeAttestationRoots()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Roots given with the request are not trusted
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/my-key/verify-attestation",
+			Data: map[string]interface{}{
+				"google_root_certificates":       googleRoots,
+				"manufacturer_root_certificates": manufacturerRoots,
+			},
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"attestation_google_roots":       googleRoots,
+				"attestation_manufacturer_roots": manufacturerRoots,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/my-key/verify-attestation",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := resp.Data["valid"]; v != true {
+			t.Errorf("expected attestation to be valid, got %#v", resp.Data)
+		}
+		if v, exp := resp.Data["key_origin"], "generated"; v != exp {
+			t.Errorf("expected %v to be %q", v, exp)
+		}
+		if v, exp := resp.Data["extractable"], false; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+	})
+}
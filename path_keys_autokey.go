@@ -0,0 +1,267 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	kmsapi "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	autokeypb "cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// autokeyCryptoKey describes the crypto keys which Autokey provisions, which
+// are always HSM keys for symmetric encryption.
+var autokeyCryptoKey = &kmspb.CryptoKey{
+	Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+	VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+		ProtectionLevel: kmspb.ProtectionLevel_HSM,
+		Algorithm:       kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+	},
+}
+
+func (b *backend) pathKeysAutokey() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/autokey/" + framework.GenericNameRegex("key"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "create",
+			OperationSuffix: "autokey-key",
+		},
+
+		HelpSynopsis: "Provision a crypto key with Cloud KMS Autokey",
+		HelpDescription: `
+Creates a key handle with Cloud KMS Autokey, which provisions a crypto key for
+the given type of resource in the Autokey key project of the resource project's
+folder, and registers the crypto key in Vault. This allows customer-managed
+encryption keys (CMEK) to be provisioned on demand through Vault for services
+which consume Autokey.
+
+    $ vault write gcpkms/keys/autokey/my-key \
+        project="my-project" \
+        location="us-east1" \
+        resource_type_selector="compute.googleapis.com/Disk"
+
+Autokey must be enabled on a folder containing the project, and the
+configured credentials need the "cloudkms.keyHandles.create" permission in the
+project. Autokey may reuse a crypto key it already provisioned for the same
+project, location, and resource type. Since Autokey names the crypto keys it
+provisions, this endpoint cannot be used when the config sets a naming policy.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key to create in Vault. This will be the named used to refer to
+the provisioned crypto key when encrypting or decrypting data.
+`,
+			},
+
+			"project": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud project of the resources the crypto key will protect, in a folder
+with Autokey enabled. Defaults to the project of the configured credentials.
+`,
+			},
+
+			"location": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud location of the resources the crypto key will protect (e.g.
+"us-east1"). The crypto key is created in the same location.
+`,
+			},
+
+			"resource_type_selector": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Type of resource the crypto key will protect, such as
+"compute.googleapis.com/Disk" or "storage.googleapis.com/Bucket".
+`,
+			},
+
+			"key_handle_id": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+ID of the key handle to create. If unspecified, Google Cloud generates one.
+`,
+			},
+
+			"config_name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the config profile to use for creating the key handle and for all calls
+on this key, in place of the credentials and settings in the config. The
+profile must already exist.
+`,
+			},
+
+			"impersonate_service_account": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Email of a service account to impersonate for creating the key handle and for
+all calls on this key. The configured credentials need the
+"roles/iam.serviceAccountTokenCreator" role on it.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: withFieldValidator(b.pathKeysAutokeyWrite),
+		},
+	}
+}
+
+// pathKeysAutokeyWrite corresponds to PUT/POST gcpkms/keys/autokey/:key and
+// provisions a crypto key with Autokey and registers it for use in Vault.
+func (b *backend) pathKeysAutokeyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+	location := d.Get("location").(string)
+	selector := d.Get("resource_type_selector").(string)
+	keyHandleID := d.Get("key_handle_id").(string)
+
+	if err := b.checkNewKeyName(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+	if location == "" {
+		return nil, errMissingFields("location")
+	}
+	if selector == "" {
+		return nil, errMissingFields("resource_type_selector")
+	}
+	if keyHandleID != "" && !resourceIDRegex.MatchString(keyHandleID) {
+		return nil, logical.CodedError(400, fmt.Sprintf("invalid key handle ID "+
+			"%q, must be 1-63 letters, numbers, underscores, or hyphens", keyHandleID))
+	}
+
+	// Autokey is not idempotent, so refuse to provision a crypto key for a
+	// name which is already taken.
+	if _, err := b.Key(ctx, req.Storage, key); err != ErrKeyNotFound {
+		if err != nil {
+			return nil, err
+		}
+		return nil, logical.CodedError(400, fmt.Sprintf("key %q already exists", key))
+	}
+
+	k := &Key{
+		Name:                      key,
+		ImpersonateServiceAccount: strings.TrimSpace(d.Get("impersonate_service_account").(string)),
+		ConfigName:                d.Get("config_name").(string),
+	}
+	if k.ConfigName != "" {
+		if err := b.checkConfigProfile(ctx, req.Storage, k.ConfigName); err != nil {
+			return nil, err
+		}
+	}
+
+	project, err := b.projectOrDefault(ctx, req.Storage, d.Get("project").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	// The crypto key is created in the Autokey key project, which is only
+	// known once it is provisioned, so only its location and kind are checked
+	// against the key policy beforehand. Autokey also names the key ring and
+	// crypto key itself, so a naming policy could only be checked once the
+	// crypto key exists and is refused up front instead.
+	config, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.checkLocation(location); err != nil {
+		return nil, err
+	}
+	if err := config.checkCryptoKey(autokeyCryptoKey); err != nil {
+		return nil, err
+	}
+	if config.CryptoKeyNameTemplate != "" || config.CryptoKeyNameRegex != "" || config.KeyRingNameRegex != "" {
+		return nil, logical.CodedError(400, "Autokey chooses the names of the "+
+			"crypto keys it provisions, which is not allowed when the config sets "+
+			"crypto_key_name_template, crypto_key_name_regex, or key_ring_name_regex")
+	}
+
+	client, err := b.autokeyClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// The Autokey client is not cached, but the request timeout still bounds
+	// the time spent waiting for the key handle.
+	if _, err := b.settings(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	op, err := client.CreateKeyHandle(ctx, &autokeypb.CreateKeyHandleRequest{
+		Parent:      fmt.Sprintf("projects/%s/locations/%s", project, location),
+		KeyHandleId: keyHandleID,
+		KeyHandle: &autokeypb.KeyHandle{
+			ResourceTypeSelector: selector,
+		},
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to create key handle: {{err}}", err)
+	}
+
+	kh, err := op.Wait(ctx)
+	if err != nil {
+		return nil, wrapKMSError("failed to provision crypto key: {{err}}", err)
+	}
+
+	if err := b.checkKeyPolicy(ctx, req.Storage, kh.KmsKey); err != nil {
+		return nil, logical.CodedError(400, fmt.Sprintf("key handle %q provisioned "+
+			"crypto key %q, which was not registered: %s", kh.Name, kh.KmsKey, err))
+	}
+
+	k.CryptoKeyID = kh.KmsKey
+	k.KeyHandle = kh.Name
+
+	ok, err := b.putNewKey(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, logical.CodedError(400, fmt.Sprintf("key handle %q provisioned "+
+			"crypto key %q, which was not registered: key %q was created "+
+			"concurrently", kh.Name, kh.KmsKey, key))
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_handle":             kh.Name,
+			"crypto_key":             kh.KmsKey,
+			"resource_type_selector": kh.ResourceTypeSelector,
+		},
+	}, nil
+}
+
+// autokeyClient creates a client for the Cloud KMS Autokey API with the same
+// credentials as the key's KMS client. Like the inventory client, it is only
+// used for a single request and is not cached.
+func (b *backend) autokeyClient(ctx context.Context, s logical.Storage, k *Key) (*kmsapi.AutokeyClient, error) {
+	opts, err := b.apiClientOptions(ctx, s, clientKey{
+		serviceAccount: k.ImpersonateServiceAccount,
+		profile:        k.ConfigName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kmsapi.NewAutokeyClient(b.ctx, opts...)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create KMS Autokey client: {{err}}", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,140 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathKeysAutokey_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/autokey/my-key")
+	})
+
+	cases := []struct {
+		name string
+		data map[string]interface{}
+	}{
+		{
+			"missing_location",
+			map[string]interface{}{
+				"project":                "my-project",
+				"resource_type_selector": "compute.googleapis.com/Disk",
+			},
+		},
+		{
+			"missing_resource_type_selector",
+			map[string]interface{}{
+				"project":  "my-project",
+				"location": "us-east1",
+			},
+		},
+		{
+			"invalid_key_handle_id",
+			map[string]interface{}{
+				"project":                "my-project",
+				"location":               "us-east1",
+				"resource_type_selector": "compute.googleapis.com/Disk",
+				"key_handle_id":          "not/valid",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			_, err := b.HandleRequest(context.Background(), &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "keys/autokey/my-key",
+				Data:      tc.data,
+			})
+			if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+				t.Errorf("expected %q to be a 400", err)
+			}
+		})
+	}
+
+	t.Run("naming_policy", func(t *testing.T) {
+
+		for _, field := range []string{"crypto_key_name_template", "crypto_key_name_regex", "key_ring_name_regex"} {
+			field := field
+
+			t.Run(field, func(t *testing.T) {
+
+				b, storage := testBackend(t)
+
+				ctx := context.Background()
+				if _, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "config",
+					Data: map[string]interface{}{
+						field: "vault-{{name}}",
+					},
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				_, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "keys/autokey/my-key",
+					Data: map[string]interface{}{
+						"project":                "my-project",
+						"location":               "us-east1",
+						"resource_type_selector": "compute.googleapis.com/Disk",
+					},
+				})
+				if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+					t.Fatalf("expected %q to be a 400", err)
+				}
+				if !strings.Contains(err.Error(), field) {
+					t.Errorf("expected %q to contain %q", err, field)
+				}
+			})
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		entry, err := logical.StorageEntryJSON("keys/my-key", &Key{
+			Name:        "my-key",
+			CryptoKeyID: "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/autokey/my-key",
+			Data: map[string]interface{}{
+				"project":                "my-project",
+				"location":               "us-east1",
+				"resource_type_selector": "compute.googleapis.com/Disk",
+			},
+		})
+		if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+			t.Errorf("expected %q to be a 400", err)
+		}
+	})
+}
@@ -5,9 +5,15 @@ package gcpkms
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
-	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -25,6 +31,87 @@ func (b *backend) pathKeysConfigCRUD() *framework.Path {
 		HelpDescription: `
 Update the Vault's configuration of this key such as the minimum allowed key
 version and other metadata.
+
+To protect the key from accidental deletion, deregistration, or trimming:
+
+    $ vault write gcpkms/keys/config/my-key deletion_protection=true
+
+To periodically trim old crypto key versions, enable auto_trim with a retention
+policy. For example, to keep the five newest versions and disable versions
+older than 90 days:
+
+    $ vault write gcpkms/keys/config/my-key \
+        auto_trim=true \
+        auto_trim_action=disable \
+        keep_versions=5 \
+        max_version_age=2160h
+
+To have Vault rotate the crypto key on a schedule, for example for asymmetric
+keys which do not support automatic rotation in Google Cloud KMS:
+
+    $ vault write gcpkms/keys/config/my-key \
+        rotation_schedule=720h \
+        rotation_window=1h
+
+To require data to be re-encrypted with recent crypto key versions, raise
+min_version automatically after each rotation. For example, to allow only the
+newest two versions after each rotation:
+
+    $ vault write gcpkms/keys/config/my-key \
+        auto_bump_min_version=true \
+        min_version_lag=1
+
+To require that data encrypted with the key is bound to a context, require
+additional authenticated data and optionally restrict its format:
+
+    $ vault write gcpkms/keys/config/my-key \
+        require_aad=true \
+        allowed_aad_regex="^tenant/[a-z0-9-]+$"
+
+To restrict a key designated for release signing to strong digests, limit the
+digest algorithms and lengths accepted by sign. Keys with a digest policy only
+sign input messages, which Vault hashes itself, and reject digests:
+
+    $ vault write gcpkms/keys/config/my-key \
+        allowed_digest_algorithms=sha256,sha384 \
+        min_digest_length=256
+
+To reach the crypto key through a different Google Cloud KMS endpoint than the
+one in the config, such as a Private Service Connect endpoint in the key's
+region:
+
+    $ vault write gcpkms/keys/config/my-key \
+        api_endpoint="cloudkms-us-east1.p.googleapis.com:443"
+
+To keep plaintext returned by decrypt out of client logs, always wrap it in a
+response-wrapping token:
+
+    $ vault write gcpkms/keys/config/my-key response_wrapping=force
+
+To call Google Cloud KMS for the key as a service account in the key's own
+project, impersonated by the configured credentials:
+
+    $ vault write gcpkms/keys/config/my-key \
+        impersonate_service_account="vault-kms@other-project.iam.gserviceaccount.com"
+
+To keep encrypting and signing when the location of the crypto key is
+unavailable, list crypto keys in other locations to fail over to, in order.
+Decrypt also tries these crypto keys, so ciphertext encrypted during a failover
+can be decrypted:
+
+    $ vault write gcpkms/keys/config/my-key \
+        failover_crypto_keys="projects/my-project/locations/us-west1/keyRings/vault/cryptoKeys/my-key"
+
+To change some settings and unset others without knowing their zero values,
+send a JSON merge patch. Fields set to null are unset and fields which are not
+given keep their current values:
+
+    $ curl \
+        --header "X-Vault-Token: ..." \
+        --header "Content-Type: application/merge-patch+json" \
+        --request PATCH \
+        --data '{"min_version": 3, "max_version": null}' \
+        "${VAULT_ADDR}/v1/gcpkms/keys/config/my-key"
 `,
 
 		Fields: map[string]*framework.FieldSchema{
@@ -35,6 +122,15 @@ Name of the key in Vault.
 `,
 			},
 
+			"cas": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Check-and-set version. If set, the write only succeeds if it matches the
+cas_version of the key returned by reads, so concurrent changes are not
+silently overwritten.
+`,
+			},
+
 			"min_version": &framework.FieldSchema{
 				Type: framework.TypeInt,
 				Description: `
@@ -42,7 +138,8 @@ Minimum allowed crypto key version. If set to a positive value, key versions
 less than the given value are not permitted to be used. If set to 0 or a
 negative value, there is no minimum key version. This value only affects
 encryption/re-encryption, not decryption. To restrict old values from being
-decrypted, increase this value and then perform a trim operation.
+decrypted, increase this value and then perform a trim operation. It cannot
+be greater than max_version.
 `,
 			},
 
@@ -52,6 +149,215 @@ decrypted, increase this value and then perform a trim operation.
 Maximum allowed crypto key version. If set to a positive value, key versions
 greater than the given value are not permitted to be used. If set to 0 or a
 negative value, there is no maximum key version.
+`,
+			},
+
+			"auto_bump_min_version": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, min_version is raised after each rotation through Vault to the new
+crypto key version less min_version_lag. The min_version is never lowered,
+never raised past max_version, and never raised to a version which is still
+being generated.
+`,
+			},
+
+			"min_version_lag": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Number of crypto key versions before the newest version which remain allowed
+when auto_bump_min_version is enabled. If set to 0, only the newest version is
+allowed after a rotation.
+`,
+			},
+
+			"require_aad": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, additional_authenticated_data is required on encrypt, decrypt, and
+reencrypt operations with this key.
+`,
+			},
+
+			"allowed_aad_regex": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Regular expression which additional_authenticated_data must match on encrypt,
+decrypt, and reencrypt operations with this key. To require a prefix, anchor
+the expression like "^prefix/". If set to the empty string, any additional
+authenticated data is allowed.
+`,
+			},
+
+			"allowed_digest_algorithms": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+Digest algorithms accepted by sign with this key: "sha1", "sha256", "sha384",
+or "sha512". If set, sign only accepts input messages, which Vault hashes with
+the crypto key version's digest algorithm, and rejects the version if its
+algorithm is not allowed. Set to the empty list to allow any digest algorithm.
+`,
+			},
+
+			"min_digest_length": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Minimum length in bits of digests accepted by sign with this key, such as 256.
+If set, sign only accepts input messages, which Vault hashes itself. Set to 0
+for no minimum.
+`,
+			},
+
+			"deletion_protection": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, the key cannot be deleted, deregistered, or trimmed until this is set
+to false.
+`,
+			},
+
+			"auto_trim": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, crypto key versions which fall outside of keep_versions and
+max_version_age are periodically trimmed. At least one of keep_versions or
+max_version_age must be set. The primary crypto key version is never trimmed.
+`,
+			},
+
+			"auto_trim_action": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Action to take on crypto key versions when automatically trimming. Options are
+"destroy_schedule" to schedule the versions for destruction, or "disable" to
+disable the versions without destroying them. The default is
+"destroy_schedule".
+`,
+			},
+
+			"keep_versions": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Number of newest crypto key versions to retain when automatically trimming. If
+set to 0 or a negative value, versions are not retained by count.
+`,
+			},
+
+			"max_version_age": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Age after which crypto key versions are trimmed when automatically trimming,
+specified as a duration like "2160h". Age is measured from when the key
+material was generated or imported, with a few minutes of allowance for clock
+skew. If keep_versions is also set, only versions which are older than this and
+not among the newest keep_versions are trimmed. If set to 0, versions are not
+trimmed by age.
+`,
+			},
+
+			"rotation_schedule": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Period at which Vault rotates the crypto key, specified as a duration like
+"720h". The first rotation occurs one period after this is set, or after the
+last rotation. If set to 0, Vault does not rotate the crypto key on a schedule.
+For symmetric keys, prefer the rotation_period of the crypto key, which is
+enforced by Google Cloud KMS.
+`,
+			},
+
+			"rotation_window": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+Amount of time after a scheduled rotation is due in which it may still occur,
+specified as a duration like "1h". If Vault is unable to rotate the crypto key
+within this window, for example because it was sealed, the rotation is skipped
+until the next period. If set to 0, a missed rotation occurs as soon as
+possible.
+`,
+			},
+
+			"api_endpoint": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Host and port of the Google Cloud KMS API to use for this key, overriding the
+api_endpoint in the config. The host must be in the configured universe domain,
+such as a regional or private endpoint, unless it is the api_endpoint in the
+config. If set to the empty string, the endpoint in the config is used.
+`,
+			},
+
+			"config_name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the config profile to use for all calls on this key, in place of the
+credentials and settings in the config. The profile must already exist. If set
+to the empty string, the config is used.
+`,
+			},
+
+			"impersonate_service_account": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Email of a service account to impersonate for all calls on this key, such as
+one in the key's own project. The configured credentials need the
+"roles/iam.serviceAccountTokenCreator" role on it. If set to the empty string,
+the credentials in the config are used.
+`,
+			},
+
+			"failover_crypto_keys": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+Ordered list of full resource IDs of crypto keys in other locations to use when
+the location of the key's crypto key returns UNAVAILABLE. Encrypt and sign fail
+over to the next crypto key unless key_version is given, and decrypt tries each
+crypto key in order. The crypto keys should have the same purpose and algorithm
+as the key's crypto key, and either share imported key material or be
+independent keys used only for new operations during an outage. Set to the
+empty list to disable failover.
+`,
+			},
+
+			"signer_certificate": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+PEM-encoded certificate of the public key of the key's signing crypto key
+version, which is included in CMS signatures from sign with format=cms to
+identify the signer. If set to the empty string, CMS signatures identify the
+signer by the subject key identifier of the public key instead.
+`,
+			},
+
+			"envelope_recipients": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+Names of the keys which encrypt may also encrypt plaintext under, with
+additional_keys, when this key is the key being encrypted with. Since anyone
+able to decrypt with a recipient can read the plaintext, additional_keys is
+rejected unless every key given is listed here. Set to the empty list to
+disallow additional_keys.
+`,
+			},
+
+			"offline_verification": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+If true, verify falls back to the cached public key of the crypto key version
+when Google Cloud KMS is unreachable, and the response includes
+verified_offline=true. Public keys are cached in memory on each Vault node the
+first time they are used, so a version must have been used for verification on
+the node before the outage.
+`,
+			},
+
+			"response_wrapping": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Policy for responses which return plaintext from this key, such as from
+decrypt. Options are "none", "warn" to add a warning to responses which are not
+wrapped, or "force" to always wrap them in a cubbyhole response-wrapping token.
+If set to the empty string, the policy in the config is used.
 `,
 			},
 		},
@@ -61,6 +367,132 @@ negative value, there is no maximum key version.
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathKeysConfigRead),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"name": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Name of the key.",
+								Required:    true,
+							},
+							"crypto_key": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Resource ID of the crypto key.",
+								Required:    true,
+							},
+							"cas_version": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Version of the key in Vault, for check-and-set writes.",
+								Required:    true,
+							},
+							"min_version": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Minimum crypto key version which may be used.",
+							},
+							"max_version": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Maximum crypto key version which may be used.",
+							},
+							"auto_bump_min_version": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether min_version is raised after each rotation.",
+							},
+							"min_version_lag": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Versions min_version lags behind the newest version.",
+							},
+							"require_aad": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether additional authenticated data is required.",
+							},
+							"allowed_aad_regex": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Pattern additional authenticated data must match.",
+							},
+							"allowed_digest_algorithms": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Digest algorithms which may be signed.",
+							},
+							"min_digest_length": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Minimum length of signed digests in bits.",
+							},
+							"deletion_protection": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether the key is protected from deletion.",
+							},
+							"auto_trim": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether old crypto key versions are trimmed automatically.",
+							},
+							"auto_trim_action": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Action of automatic trimming.",
+							},
+							"keep_versions": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Number of newest crypto key versions kept by trimming.",
+							},
+							"max_version_age": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Age in seconds after which trimming removes crypto key versions.",
+							},
+							"rotation_schedule": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Period of rotation by Vault in seconds.",
+							},
+							"next_rotation": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Time of the next rotation by Vault, in RFC 3339 format.",
+							},
+							"rotation_window": &framework.FieldSchema{
+								Type:        framework.TypeDurationSecond,
+								Description: "Window in seconds within which rotations by Vault may happen.",
+							},
+							"last_rotated": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Time of the last rotation by Vault, in RFC 3339 format.",
+							},
+							"api_endpoint": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Cloud KMS API endpoint of the key.",
+							},
+							"impersonate_service_account": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Service account impersonated for the key.",
+							},
+							"config_name": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Config profile of the key.",
+							},
+							"failover_crypto_keys": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Resource IDs of the failover crypto keys.",
+							},
+							"signer_certificate": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "PEM-encoded certificate of CMS signatures.",
+							},
+							"offline_verification": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether verify falls back to cached public keys.",
+							},
+							"envelope_recipients": &framework.FieldSchema{
+								Type:        framework.TypeStringSlice,
+								Description: "Keys which additional_keys may name.",
+							},
+							"response_wrapping": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Response wrapping of plaintext from the key.",
+							},
+							"key_handle": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Resource ID of the Autokey key handle of the key.",
+							},
+						},
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationVerb:   "read",
 					OperationSuffix: "key-configuration",
@@ -68,6 +500,11 @@ negative value, there is no maximum key version.
 			},
 			logical.CreateOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathKeysConfigWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationVerb:   "configure",
 					OperationSuffix: "key",
@@ -75,11 +512,28 @@ negative value, there is no maximum key version.
 			},
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathKeysConfigWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationVerb:   "configure",
 					OperationSuffix: "key",
 				},
 			},
+			logical.PatchOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysConfigPatch),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb:   "patch",
+					OperationSuffix: "key-configuration",
+				},
+			},
 		},
 	}
 }
@@ -98,8 +552,9 @@ func (b *backend) pathKeysConfigRead(ctx context.Context, req *logical.Request,
 	}
 
 	data := map[string]interface{}{
-		"name":       k.Name,
-		"crypto_key": k.CryptoKeyID,
+		"name":        k.Name,
+		"crypto_key":  k.CryptoKeyID,
+		"cas_version": k.Version,
 	}
 
 	if k.MinVersion > 0 {
@@ -110,6 +565,93 @@ func (b *backend) pathKeysConfigRead(ctx context.Context, req *logical.Request,
 		data["max_version"] = k.MaxVersion
 	}
 
+	if k.AutoBumpMinVersion {
+		data["auto_bump_min_version"] = true
+		data["min_version_lag"] = k.MinVersionLag
+	}
+
+	if k.RequireAAD {
+		data["require_aad"] = true
+	}
+
+	if k.AllowedAADRegex != "" {
+		data["allowed_aad_regex"] = k.AllowedAADRegex
+	}
+
+	if len(k.AllowedDigestAlgorithms) > 0 {
+		data["allowed_digest_algorithms"] = k.AllowedDigestAlgorithms
+	}
+
+	if k.MinDigestLength > 0 {
+		data["min_digest_length"] = k.MinDigestLength
+	}
+
+	if k.DeletionProtection {
+		data["deletion_protection"] = true
+	}
+
+	if k.AutoTrim {
+		data["auto_trim"] = true
+		data["auto_trim_action"] = k.autoTrimAction()
+	}
+
+	if k.KeepVersions > 0 {
+		data["keep_versions"] = k.KeepVersions
+	}
+
+	if k.MaxVersionAge > 0 {
+		data["max_version_age"] = int64(k.MaxVersionAge.Seconds())
+	}
+
+	if k.RotationSchedule > 0 {
+		data["rotation_schedule"] = int64(k.RotationSchedule.Seconds())
+		data["next_rotation"] = k.NextRotation.Format(time.RFC3339)
+	}
+
+	if k.RotationWindow > 0 {
+		data["rotation_window"] = int64(k.RotationWindow.Seconds())
+	}
+
+	if !k.LastRotated.IsZero() {
+		data["last_rotated"] = k.LastRotated.Format(time.RFC3339)
+	}
+
+	if k.APIEndpoint != "" {
+		data["api_endpoint"] = k.APIEndpoint
+	}
+
+	if k.ImpersonateServiceAccount != "" {
+		data["impersonate_service_account"] = k.ImpersonateServiceAccount
+	}
+
+	if k.ConfigName != "" {
+		data["config_name"] = k.ConfigName
+	}
+
+	if len(k.FailoverCryptoKeys) > 0 {
+		data["failover_crypto_keys"] = k.FailoverCryptoKeys
+	}
+
+	if k.SignerCertificate != "" {
+		data["signer_certificate"] = k.SignerCertificate
+	}
+
+	if k.OfflineVerification {
+		data["offline_verification"] = true
+	}
+
+	if len(k.EnvelopeRecipients) > 0 {
+		data["envelope_recipients"] = k.EnvelopeRecipients
+	}
+
+	if k.ResponseWrapping != "" {
+		data["response_wrapping"] = k.ResponseWrapping
+	}
+
+	if k.KeyHandle != "" {
+		data["key_handle"] = k.KeyHandle
+	}
+
 	return &logical.Response{
 		Data: data,
 	}, nil
@@ -120,6 +662,37 @@ func (b *backend) pathKeysConfigRead(ctx context.Context, req *logical.Request,
 func (b *backend) pathKeysConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	key := d.Get("key").(string)
 
+	lock := locksutil.LockForKey(b.keyLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+	if err := checkCAS(k, d); err != nil {
+		return nil, err
+	}
+
+	if err := b.updateKeyConfig(ctx, req.Storage, k, d); err != nil {
+		return nil, err
+	}
+	return nil, b.putKeyConfig(ctx, req.Storage, k)
+}
+
+// pathKeysConfigPatch corresponds to PATCH gcpkms/keys/config/:key and updates
+// individual fields of the key configuration in Vault. Fields set to null are
+// unset, and fields which are not given keep their current values.
+func (b *backend) pathKeysConfigPatch(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+
+	lock := locksutil.LockForKey(b.keyLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
 	k, err := b.Key(ctx, req.Storage, key)
 	if err != nil {
 		if err == ErrKeyNotFound {
@@ -127,7 +700,101 @@ func (b *backend) pathKeysConfigWrite(ctx context.Context, req *logical.Request,
 		}
 		return nil, err
 	}
+	if err := checkCAS(k, d); err != nil {
+		return nil, err
+	}
 
+	raw := make(map[string]interface{}, len(d.Raw))
+	for name, v := range d.Raw {
+		if name == "cas" {
+			continue
+		}
+		if v == nil {
+			unsetKeyConfigField(k, name)
+			continue
+		}
+		raw[name] = v
+	}
+
+	if err := b.updateKeyConfig(ctx, req.Storage, k, &framework.FieldData{
+		Raw:    raw,
+		Schema: d.Schema,
+	}); err != nil {
+		return nil, err
+	}
+	return nil, b.putKeyConfig(ctx, req.Storage, k)
+}
+
+// checkKeyAPIEndpoint returns an error unless the endpoint is the configured
+// api_endpoint or a host in the configured universe domain. The key's client
+// sends the mount's credentials to the endpoint, so a key writer must not be
+// able to point it at any other host.
+func checkKeyAPIEndpoint(c *Config, endpoint string) error {
+	ud := c.universeDomain()
+	if endpoint == c.APIEndpoint || endpointInUniverse(endpoint, ud) {
+		return nil
+	}
+	return logical.CodedError(400, fmt.Sprintf("api_endpoint %q must be a host "+
+		"in the %q universe domain or the api_endpoint in the config", endpoint, ud))
+}
+
+// unsetKeyConfigField resets the setting for the field of keys/config/:key to
+// its default.
+func unsetKeyConfigField(k *Key, name string) {
+	switch name {
+	case "min_version":
+		k.MinVersion = 0
+	case "max_version":
+		k.MaxVersion = 0
+	case "auto_bump_min_version":
+		k.AutoBumpMinVersion = false
+	case "min_version_lag":
+		k.MinVersionLag = 0
+	case "require_aad":
+		k.RequireAAD = false
+	case "allowed_aad_regex":
+		k.AllowedAADRegex = ""
+	case "allowed_digest_algorithms":
+		k.AllowedDigestAlgorithms = nil
+	case "min_digest_length":
+		k.MinDigestLength = 0
+	case "deletion_protection":
+		k.DeletionProtection = false
+	case "auto_trim":
+		k.AutoTrim = false
+	case "auto_trim_action":
+		k.AutoTrimAction = ""
+	case "keep_versions":
+		k.KeepVersions = 0
+	case "max_version_age":
+		k.MaxVersionAge = 0
+	case "rotation_schedule":
+		k.RotationSchedule = 0
+		k.NextRotation = time.Time{}
+	case "rotation_window":
+		k.RotationWindow = 0
+	case "api_endpoint":
+		k.APIEndpoint = ""
+	case "impersonate_service_account":
+		k.ImpersonateServiceAccount = ""
+	case "config_name":
+		k.ConfigName = ""
+	case "response_wrapping":
+		k.ResponseWrapping = ""
+	case "failover_crypto_keys":
+		k.FailoverCryptoKeys = nil
+	case "signer_certificate":
+		k.SignerCertificate = ""
+	case "offline_verification":
+		k.OfflineVerification = false
+	case "envelope_recipients":
+		k.EnvelopeRecipients = nil
+	}
+}
+
+// updateKeyConfig updates the key configuration from the given fields of
+// keys/config/:key. Fields which are not given are left unchanged.
+func (b *backend) updateKeyConfig(ctx context.Context, s logical.Storage, k *Key, d *framework.FieldData) error {
 	if v, ok := d.GetOk("min_version"); ok {
 		if v.(int) <= 0 {
 			k.MinVersion = 0
@@ -144,14 +811,192 @@ func (b *backend) pathKeysConfigWrite(ctx context.Context, req *logical.Request,
 		}
 	}
 
-	// Save it
-	entry, err := logical.StorageEntryJSON("keys/"+key, k)
-	if err != nil {
-		return nil, errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+	if k.MinVersion > 0 && k.MaxVersion > 0 && k.MinVersion > k.MaxVersion {
+		return logical.CodedError(400, fmt.Sprintf("min_version %d cannot be "+
+			"greater than max_version %d", k.MinVersion, k.MaxVersion))
 	}
-	if err := req.Storage.Put(ctx, entry); err != nil {
-		return nil, errwrap.Wrapf("failed to write to storage: {{err}}", err)
+
+	if v, ok := d.GetOk("auto_bump_min_version"); ok {
+		k.AutoBumpMinVersion = v.(bool)
 	}
 
-	return nil, nil
+	if v, ok := d.GetOk("min_version_lag"); ok {
+		if v.(int) < 0 {
+			return logical.CodedError(400, "min_version_lag cannot be negative")
+		}
+		k.MinVersionLag = v.(int)
+	}
+
+	if v, ok := d.GetOk("require_aad"); ok {
+		k.RequireAAD = v.(bool)
+	}
+
+	if v, ok := d.GetOk("allowed_aad_regex"); ok {
+		if _, err := regexp.Compile(v.(string)); err != nil {
+			return logical.CodedError(400, fmt.Sprintf("invalid allowed_aad_regex: %s", err))
+		}
+		k.AllowedAADRegex = v.(string)
+	}
+
+	if v, ok := d.GetOk("allowed_digest_algorithms"); ok {
+		algorithms := make([]string, 0, len(v.([]string)))
+		for _, a := range v.([]string) {
+			a = strings.ToLower(strings.TrimSpace(a))
+			if _, ok := digestAlgorithms[a]; !ok {
+				return logical.CodedError(400, fmt.Sprintf("invalid digest algorithm %q, "+
+					"valid algorithms are %q", a, digestAlgorithmNames()))
+			}
+			algorithms = append(algorithms, a)
+		}
+		k.AllowedDigestAlgorithms = nil
+		if len(algorithms) > 0 {
+			k.AllowedDigestAlgorithms = strutil.RemoveDuplicates(algorithms, false)
+		}
+	}
+
+	if v, ok := d.GetOk("min_digest_length"); ok {
+		if v.(int) < 0 {
+			return logical.CodedError(400, "min_digest_length cannot be negative")
+		}
+		k.MinDigestLength = v.(int)
+	}
+
+	if v, ok := d.GetOk("deletion_protection"); ok {
+		k.DeletionProtection = v.(bool)
+	}
+
+	if v, ok := d.GetOk("auto_trim"); ok {
+		k.AutoTrim = v.(bool)
+	}
+
+	if v, ok := d.GetOk("auto_trim_action"); ok {
+		if err := validateTrimAction(v.(string)); err != nil {
+			return err
+		}
+		k.AutoTrimAction = v.(string)
+	}
+
+	if v, ok := d.GetOk("keep_versions"); ok {
+		if v.(int) <= 0 {
+			k.KeepVersions = 0
+		} else {
+			k.KeepVersions = v.(int)
+		}
+	}
+
+	if v, ok := d.GetOk("max_version_age"); ok {
+		if v.(int) <= 0 {
+			k.MaxVersionAge = 0
+		} else {
+			k.MaxVersionAge = time.Duration(v.(int)) * time.Second
+		}
+	}
+
+	if v, ok := d.GetOk("rotation_schedule"); ok {
+		if v.(int) <= 0 {
+			k.RotationSchedule = 0
+			k.NextRotation = time.Time{}
+		} else {
+			k.RotationSchedule = time.Duration(v.(int)) * time.Second
+
+			from := k.LastRotated
+			if from.IsZero() {
+				from = time.Now().UTC()
+			}
+			k.NextRotation = from.Add(k.RotationSchedule)
+		}
+	}
+
+	if v, ok := d.GetOk("rotation_window"); ok {
+		if v.(int) <= 0 {
+			k.RotationWindow = 0
+		} else {
+			k.RotationWindow = time.Duration(v.(int)) * time.Second
+		}
+	}
+
+	if v, ok := d.GetOk("api_endpoint"); ok {
+		endpoint := strings.TrimSpace(v.(string))
+		if endpoint != "" {
+			c, err := b.Config(ctx, s)
+			if err != nil {
+				return err
+			}
+			if err := checkKeyAPIEndpoint(c, endpoint); err != nil {
+				return err
+			}
+		}
+		k.APIEndpoint = endpoint
+	}
+
+	if v, ok := d.GetOk("impersonate_service_account"); ok {
+		k.ImpersonateServiceAccount = strings.TrimSpace(v.(string))
+	}
+
+	if v, ok := d.GetOk("config_name"); ok {
+		k.ConfigName = v.(string)
+		if k.ConfigName != "" {
+			if err := b.checkConfigProfile(ctx, s, k.ConfigName); err != nil {
+				return err
+			}
+		}
+	}
+
+	if v, ok := d.GetOk("failover_crypto_keys"); ok {
+		ids := v.([]string)
+		if err := validateFailoverCryptoKeys(k.CryptoKeyID, ids); err != nil {
+			return logical.CodedError(400, err.Error())
+		}
+		for _, id := range ids {
+			if err := b.checkKeyPolicy(ctx, s, id); err != nil {
+				return err
+			}
+		}
+		k.FailoverCryptoKeys = nil
+		if len(ids) > 0 {
+			k.FailoverCryptoKeys = ids
+		}
+	}
+
+	if v, ok := d.GetOk("signer_certificate"); ok {
+		cert := strings.TrimSpace(v.(string))
+		if cert != "" {
+			if _, err := parseSignerCertificate(cert); err != nil {
+				return logical.CodedError(400, err.Error())
+			}
+		}
+		k.SignerCertificate = cert
+	}
+
+	if v, ok := d.GetOk("offline_verification"); ok {
+		k.OfflineVerification = v.(bool)
+	}
+
+	if v, ok := d.GetOk("envelope_recipients"); ok {
+		k.EnvelopeRecipients = nil
+		if names := v.([]string); len(names) > 0 {
+			k.EnvelopeRecipients = names
+		}
+	}
+
+	if v, ok := d.GetOk("response_wrapping"); ok {
+		if v.(string) != "" {
+			if err := validateResponseWrapping(v.(string)); err != nil {
+				return err
+			}
+		}
+		k.ResponseWrapping = v.(string)
+	}
+
+	if k.AutoTrim && k.KeepVersions == 0 && k.MaxVersionAge == 0 {
+		return logical.CodedError(400, "auto_trim requires keep_versions "+
+			"or max_version_age to be set")
+	}
+
+	return nil
+}
+
+// putKeyConfig saves the key configuration.
+func (b *backend) putKeyConfig(ctx context.Context, s logical.Storage, k *Key) error {
+	return b.putKey(ctx, s, k)
 }
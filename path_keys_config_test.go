@@ -7,6 +7,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -28,8 +29,9 @@ func TestPathKeysConfig_Read(t *testing.T) {
 			"key_exist",
 			`{"name":"my-key", "crypto_key_id":"example"}`,
 			map[string]interface{}{
-				"name":       "my-key",
-				"crypto_key": "example",
+				"name":        "my-key",
+				"crypto_key":  "example",
+				"cas_version": 0,
 			},
 			false,
 		},
@@ -39,11 +41,59 @@ func TestPathKeysConfig_Read(t *testing.T) {
 			map[string]interface{}{
 				"name":        "my-key",
 				"crypto_key":  "example",
+				"cas_version": 0,
 				"min_version": 3,
 				"max_version": 5,
 			},
 			false,
 		},
+		{
+			"key_exist_deletion_protection",
+			`{"name":"my-key", "crypto_key_id":"example", "deletion_protection":true}`,
+			map[string]interface{}{
+				"name":                "my-key",
+				"crypto_key":          "example",
+				"cas_version":         0,
+				"deletion_protection": true,
+			},
+			false,
+		},
+		{
+			"key_exist_auto_trim",
+			`{"name":"my-key", "crypto_key_id":"example", "auto_trim":true, "keep_versions":5, "max_version_age":3600000000000}`,
+			map[string]interface{}{
+				"name":             "my-key",
+				"crypto_key":       "example",
+				"cas_version":      0,
+				"auto_trim":        true,
+				"auto_trim_action": "destroy_schedule",
+				"keep_versions":    5,
+				"max_version_age":  int64(3600),
+			},
+			false,
+		},
+		{
+			"key_exist_api_endpoint",
+			`{"name":"my-key", "crypto_key_id":"example", "api_endpoint":"localhost:9010"}`,
+			map[string]interface{}{
+				"name":         "my-key",
+				"crypto_key":   "example",
+				"cas_version":  0,
+				"api_endpoint": "localhost:9010",
+			},
+			false,
+		},
+		{
+			"key_exist_impersonate_service_account",
+			`{"name":"my-key", "crypto_key_id":"example", "impersonate_service_account":"kms@p.iam.gserviceaccount.com"}`,
+			map[string]interface{}{
+				"name":                        "my-key",
+				"crypto_key":                  "example",
+				"cas_version":                 0,
+				"impersonate_service_account": "kms@p.iam.gserviceaccount.com",
+			},
+			false,
+		},
 		{
 			"key_not_exist",
 			"",
@@ -113,6 +163,7 @@ func TestPathKeysConfig_Write(t *testing.T) {
 			},
 			&Key{
 				Name:       "my-key",
+				Version:    1,
 				MinVersion: 50,
 				MaxVersion: 100,
 			},
@@ -133,6 +184,7 @@ func TestPathKeysConfig_Write(t *testing.T) {
 			},
 			&Key{
 				Name:       "my-key",
+				Version:    1,
 				MinVersion: 0,
 			},
 			false,
@@ -145,6 +197,7 @@ func TestPathKeysConfig_Write(t *testing.T) {
 			},
 			&Key{
 				Name:       "my-key",
+				Version:    1,
 				MaxVersion: 0,
 			},
 			false,
@@ -157,6 +210,7 @@ func TestPathKeysConfig_Write(t *testing.T) {
 			},
 			&Key{
 				Name:       "my-key",
+				Version:    1,
 				MinVersion: 0,
 			},
 			false,
@@ -169,10 +223,172 @@ func TestPathKeysConfig_Write(t *testing.T) {
 			},
 			&Key{
 				Name:       "my-key",
+				Version:    1,
 				MaxVersion: 0,
 			},
 			false,
 		},
+		{
+			"deletion_protection",
+			"my-key",
+			map[string]interface{}{
+				"deletion_protection": true,
+			},
+			&Key{
+				Name:               "my-key",
+				Version:            1,
+				DeletionProtection: true,
+			},
+			false,
+		},
+		{
+			"auto_trim",
+			"my-key",
+			map[string]interface{}{
+				"auto_trim":        true,
+				"auto_trim_action": "disable",
+				"keep_versions":    5,
+				"max_version_age":  "1h",
+			},
+			&Key{
+				Name:           "my-key",
+				Version:        1,
+				AutoTrim:       true,
+				AutoTrimAction: "disable",
+				KeepVersions:   5,
+				MaxVersionAge:  time.Hour,
+			},
+			false,
+		},
+		{
+			"auto_bump_min_version",
+			"my-key",
+			map[string]interface{}{
+				"auto_bump_min_version": true,
+				"min_version_lag":       2,
+			},
+			&Key{
+				Name:               "my-key",
+				Version:            1,
+				AutoBumpMinVersion: true,
+				MinVersionLag:      2,
+			},
+			false,
+		},
+		{
+			"min_greater_than_max",
+			"my-key",
+			map[string]interface{}{
+				"min_version": 5,
+				"max_version": 3,
+			},
+			nil,
+			true,
+		},
+		{
+			"negative_min_version_lag",
+			"my-key",
+			map[string]interface{}{
+				"min_version_lag": -1,
+			},
+			nil,
+			true,
+		},
+		{
+			"aad_policy",
+			"my-key",
+			map[string]interface{}{
+				"require_aad":       true,
+				"allowed_aad_regex": "^tenant/",
+			},
+			&Key{
+				Name:            "my-key",
+				Version:         1,
+				RequireAAD:      true,
+				AllowedAADRegex: "^tenant/",
+			},
+			false,
+		},
+		{
+			"invalid_allowed_aad_regex",
+			"my-key",
+			map[string]interface{}{
+				"allowed_aad_regex": "(",
+			},
+			nil,
+			true,
+		},
+		{
+			"auto_trim_no_retention",
+			"my-key",
+			map[string]interface{}{
+				"auto_trim": true,
+			},
+			nil,
+			true,
+		},
+		{
+			"api_endpoint",
+			"my-key",
+			map[string]interface{}{
+				"api_endpoint": "cloudkms-us-east1.p.googleapis.com:443",
+			},
+			&Key{
+				Name:        "my-key",
+				Version:     1,
+				APIEndpoint: "cloudkms-us-east1.p.googleapis.com:443",
+			},
+			false,
+		},
+		{
+			"api_endpoint_other_host",
+			"my-key",
+			map[string]interface{}{
+				"api_endpoint": "attacker.example.com:443",
+			},
+			nil,
+			true,
+		},
+		{
+			"api_endpoint_lookalike_host",
+			"my-key",
+			map[string]interface{}{
+				"api_endpoint": "evilgoogleapis.com:443",
+			},
+			nil,
+			true,
+		},
+		{
+			"impersonate_service_account",
+			"my-key",
+			map[string]interface{}{
+				"impersonate_service_account": " kms@p.iam.gserviceaccount.com ",
+			},
+			&Key{
+				Name:                      "my-key",
+				Version:                   1,
+				ImpersonateServiceAccount: "kms@p.iam.gserviceaccount.com",
+			},
+			false,
+		},
+		{
+			"config_name_not_exist",
+			"my-key",
+			map[string]interface{}{
+				"config_name": "dr",
+			},
+			nil,
+			true,
+		},
+		{
+			"auto_trim_invalid_action",
+			"my-key",
+			map[string]interface{}{
+				"auto_trim_action": "delete",
+			},
+			nil,
+			true,
+		},
 	}
 
 	t.Run("group", func(t *testing.T) {
@@ -216,6 +432,62 @@ func TestPathKeysConfig_Write(t *testing.T) {
 		}
 	})
 
+	t.Run("rotation_schedule", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/config/my-key",
+			Data: map[string]interface{}{
+				"rotation_schedule": "24h",
+				"rotation_window":   "1h",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := b.Key(ctx, storage, "my-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.RotationSchedule != 24*time.Hour || k.RotationWindow != time.Hour {
+			t.Errorf("unexpected rotation config: %#v", k)
+		}
+		if k.NextRotation.Before(start.Add(24 * time.Hour)) {
+			t.Errorf("expected next rotation %s to be a day after %s", k.NextRotation, start)
+		}
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/config/my-key",
+			Data: map[string]interface{}{
+				"rotation_schedule": 0,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		k, err = b.Key(ctx, storage, "my-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.RotationSchedule != 0 || !k.NextRotation.IsZero() {
+			t.Errorf("expected rotation schedule to be cleared: %#v", k)
+		}
+	})
+
 	t.Run("ignores_if_not_specified", func(t *testing.T) {
 
 		b, storage := testBackend(t)
@@ -246,10 +518,133 @@ func TestPathKeysConfig_Write(t *testing.T) {
 			Name:       "my-key",
 			MinVersion: 3,
 			MaxVersion: 5,
+			Version:    1,
+		}
+
+		if !reflect.DeepEqual(exp, k) {
+			t.Errorf("expected %#v to equal %#v", exp, k)
+		}
+	})
+}
+
+func TestPathKeysConfig_Patch(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.PatchOperation, "keys/config/my-key")
+	})
+
+	t.Run("not_exist", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.PatchOperation,
+			Path:      "keys/config/my-key",
+			Data: map[string]interface{}{
+				"min_version": 3,
+			},
+		})
+		if err != logical.ErrInvalidRequest {
+			t.Errorf("expected %q to be %q", err, logical.ErrInvalidRequest)
 		}
+	})
+
+	t.Run("sets_and_unsets", func(t *testing.T) {
+
+		b, storage := testBackend(t)
 
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key: "keys/my-key",
+			Value: []byte(`{"name":"my-key", "min_version":3, "max_version":5, ` +
+				`"deletion_protection":true, "rotation_schedule":86400000000000, ` +
+				`"next_rotation":"2030-01-01T00:00:00Z"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.PatchOperation,
+			Path:      "keys/config/my-key",
+			Data: map[string]interface{}{
+				"min_version":       4,
+				"max_version":       nil,
+				"rotation_schedule": nil,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := b.Key(ctx, storage, "my-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := &Key{
+			Name:               "my-key",
+			MinVersion:         4,
+			DeletionProtection: true,
+			Version:            1,
+		}
 		if !reflect.DeepEqual(exp, k) {
 			t.Errorf("expected %#v to equal %#v", exp, k)
 		}
 	})
 }
+
+func TestPathKeysConfig_CAS(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if err := b.putKey(ctx, storage, &Key{Name: "my-key", CryptoKeyID: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		cas  interface{}
+		err  bool
+	}{
+		{"unset", nil, false},
+		{"stale", 1, true},
+		{"current", 2, false},
+	}
+
+	for _, tc := range cases {
+		data := map[string]interface{}{
+			"min_version": 2,
+		}
+		if tc.cas != nil {
+			data["cas"] = tc.cas
+		}
+
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/config/my-key",
+			Data:      data,
+		})
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error to be %t, got %v", tc.name, tc.err, err)
+		}
+		if v, ok := err.(logical.HTTPCodedError); err != nil && (!ok || v.Code() != 400) {
+			t.Errorf("%s: expected %q to be a 400", tc.name, err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/config/my-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := resp.Data["cas_version"], 3; v != exp {
+		t.Errorf("expected %v to be %v", v, exp)
+	}
+}
@@ -5,6 +5,9 @@ package gcpkms
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -23,7 +26,18 @@ func (b *backend) pathKeysDeregister() *framework.Path {
 		HelpSynopsis: "Deregister an existing key in Vault",
 		HelpDescription: `
 This endpoint deregisters an existing reference Vault has to a crypto key in
-Google Cloud KMS. The underlying Google Cloud KMS key remains unchanged.
+Google Cloud KMS. By default, the underlying Google Cloud KMS key remains
+unchanged.
+
+To also disable automatic rotation and schedule destruction of all crypto key
+versions, set destroy_versions to true:
+
+    $ vault write gcpkms/keys/deregister/my-key destroy_versions=true
+
+To keep a tombstone of the key which can later be restored with the
+"keys/restore/:key" endpoint, set soft_delete to true:
+
+    $ vault write gcpkms/keys/deregister/my-key soft_delete=true
 `,
 
 		Fields: map[string]*framework.FieldSchema{
@@ -31,7 +45,28 @@ Google Cloud KMS. The underlying Google Cloud KMS key remains unchanged.
 				Type: framework.TypeString,
 				Description: `
 Name of the key to deregister in Vault. If the key exists in Google Cloud KMS,
-it will be left untouched.
+it will be left untouched unless destroy_versions is true.
+`,
+			},
+
+			"destroy_versions": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If true, disable automatic rotation of the crypto key and schedule destruction
+of all crypto key versions which are not already destroyed before deregistering
+the key. The default value is false.
+`,
+			},
+
+			"soft_delete": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If true, keep a tombstone of the key which is listed under
+"keys/deregistered" and can be restored with "keys/restore/:key". This cannot
+be used with destroy_versions, or when a tombstone of a key with the same name
+already exists. The default value is false.
 `,
 			},
 		},
@@ -39,12 +74,22 @@ it will be left untouched.
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathKeysDeregisterWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationSuffix: "key",
 				},
 			},
 			logical.DeleteOperation: &framework.PathOperation{
 				Callback: withFieldValidator(b.pathKeysDeregisterWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationSuffix: "key2",
 				},
@@ -55,12 +100,75 @@ it will be left untouched.
 
 // pathKeysDeregisterWrite corresponds to POST gcpkms/keys/deregister/:key
 // and deregisters a key for use in Vault. It does not delete or disable the
-// underlying GCP KMS keys.
+// underlying GCP KMS keys unless destroy_versions is set.
 func (b *backend) pathKeysDeregisterWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	key := d.Get("key").(string)
+	destroyVersions := d.Get("destroy_versions").(bool)
+	softDelete := d.Get("soft_delete").(bool)
 
-	if err := req.Storage.Delete(ctx, "keys/"+key); err != nil {
-		return nil, errwrap.Wrapf("failed to delete from storage: {{err}}", err)
+	if softDelete && destroyVersions {
+		return nil, logical.CodedError(400, "soft_delete cannot be used with "+
+			"destroy_versions, since the key could not be used after it is restored")
+	}
+
+	unlock := b.lockKey(key)
+	defer unlock()
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	if k != nil && k.DeletionProtection {
+		return nil, errDeletionProtected(key)
+	}
+
+	if k != nil && softDelete {
+		dk, err := b.deregisteredKey(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if dk != nil {
+			return nil, logical.CodedError(400, fmt.Sprintf("a tombstone of a key "+
+				"named %q already exists and would be overwritten, deregister the "+
+				"key without soft_delete", key))
+		}
+	}
+
+	if k != nil && destroyVersions {
+		kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+		if err != nil {
+			return nil, err
+		}
+		defer closer()
+
+		ctx, cancel := b.kmsContext(ctx)
+		defer cancel()
+
+		err = destroyCryptoKey(ctx, kmsClient, k.CryptoKeyID)
+		b.invalidateCryptoKey(k.CryptoKeyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if k != nil && softDelete {
+		entry, err := logical.StorageEntryJSON("deregistered/"+key, &DeregisteredKey{
+			Key:            k,
+			DeregisteredAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+		}
+		if err := req.Storage.Put(ctx, entry); err != nil {
+			return nil, errwrap.Wrapf("failed to write to storage: {{err}}", err)
+		}
+	}
+
+	if err := b.deleteKey(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+	if k != nil {
+		b.evictPublicKeys(k.CryptoKeyID)
 	}
 	return nil, nil
 }
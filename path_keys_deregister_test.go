@@ -31,8 +31,46 @@ func TestPathKeysDeregister_Write(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"key_deletion_protection",
+			[]byte(`{"name":"my-key", "crypto_key_id":"foo", "deletion_protection":true}`),
+			true,
+		},
 	}
 
+	t.Run("destroy_versions", func(t *testing.T) {
+
+		cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+		defer cleanup()
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key", "crypto_key_id":"` + cryptoKey + `"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/deregister/my-key",
+			Data: map[string]interface{}{
+				"destroy_versions": true,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.Key(ctx, storage, "my-key"); err != ErrKeyNotFound {
+			t.Errorf("expected key to be deregistered: %v", err)
+		}
+
+		testCryptoKeyVersionsDestroyed(t, cryptoKey)
+	})
+
 	t.Run("group", func(t *testing.T) {
 		for _, tc := range cases {
 			tc := tc
@@ -0,0 +1,184 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func (b *backend) pathKeysDrift() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key") + "/drift",
+
+		HelpSynopsis: "Detect changes made to the crypto key outside of Vault",
+		HelpDescription: `
+Compare the purpose, algorithm, protection level, rotation period, and labels
+of the crypto key with the state Vault recorded when the key was last created,
+registered, or updated through Vault. Differences mean the crypto key was
+changed outside of Vault, such as with gcloud or Terraform, and are returned
+as warnings.
+
+    $ vault read gcpkms/keys/my-key/drift
+
+To accept the current state of the crypto key as expected, such as after an
+intended change or for keys registered without verification, write to this
+endpoint:
+
+    $ vault write -f gcpkms/keys/my-key/drift
+
+Keys can also be checked periodically by setting drift_check_interval in the
+config.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault.
+`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysDriftRead),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"baseline": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether the expected state of the crypto key is recorded.",
+								Required:    true,
+							},
+							"drifted": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether the crypto key differs from its expected state.",
+								Required:    true,
+							},
+							"differences": &framework.FieldSchema{
+								Type:        framework.TypeMap,
+								Description: "Expected and actual values of each field which differs.",
+							},
+						},
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationPrefix: operationPrefixGoogleCloudKMS,
+					OperationVerb:   "read",
+					OperationSuffix: "key-drift",
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysDriftWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{
+						Description: "No Content",
+					}},
+				},
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationPrefix: operationPrefixGoogleCloudKMS,
+					OperationVerb:   "accept",
+					OperationSuffix: "key-drift",
+				},
+			},
+		},
+	}
+}
+
+// pathKeysDriftRead corresponds to GET gcpkms/keys/:key/drift and is used to
+// compare the crypto key with the state Vault expects.
+func (b *backend) pathKeysDriftRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	if k.Expected == nil {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"baseline": false,
+				"drifted":  false,
+			},
+			Warnings: []string{"Vault has not recorded the expected state of " +
+				"this crypto key - write to this endpoint to record its current state"},
+		}, nil
+	}
+
+	drifts, err := b.keyCryptoKeyDrift(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+
+	differences := make(map[string]interface{}, len(drifts))
+	warnings := make([]string, 0, len(drifts))
+	for _, drift := range drifts {
+		differences[drift.Field] = map[string]interface{}{
+			"expected": drift.Expected,
+			"actual":   drift.Actual,
+		}
+		warnings = append(warnings, drift.driftWarning())
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"baseline":    true,
+			"drifted":     len(drifts) > 0,
+			"differences": differences,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// pathKeysDriftWrite corresponds to PUT/POST gcpkms/keys/:key/drift and is
+// used to record the current state of the crypto key as expected.
+func (b *backend) pathKeysDriftWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+
+	unlock := b.lockKey(key)
+	defer unlock()
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: k.CryptoKeyID,
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to read crypto key: {{err}}", err)
+	}
+
+	k.Expected = newKeyExpectation(ck)
+
+	if err := b.putKey(ctx, req.Storage, k); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathKeysDrift_Read(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "keys/my-key/drift")
+	})
+
+	t.Run("not_exist", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/my-key/drift",
+		})
+		if err != logical.ErrInvalidRequest {
+			t.Errorf("expected %q to be %q", err, logical.ErrInvalidRequest)
+		}
+	})
+
+	t.Run("no_baseline", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		entry, err := logical.StorageEntryJSON("keys/my-key", &Key{
+			Name:        "my-key",
+			CryptoKeyID: "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/my-key/drift",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := resp.Data["baseline"].(bool); !ok || v {
+			t.Errorf("expected baseline to be false: %#v", resp.Data)
+		}
+		if len(resp.Warnings) != 1 {
+			t.Errorf("expected a warning: %#v", resp.Warnings)
+		}
+	})
+}
@@ -0,0 +1,375 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	// iamPolicyVersion is the IAM policy version requested when reading
+	// policies. Version 3 is required to return conditional role bindings.
+	iamPolicyVersion = 3
+
+	// iamPolicyMaxAttempts is the number of times a read-modify-write of the
+	// IAM policy is attempted when a concurrent modification is detected and
+	// the caller did not supply an etag.
+	iamPolicyMaxAttempts = 5
+)
+
+var (
+	errIAMPolicyConflict = logical.CodedError(409, "the IAM policy was modified "+
+		"since the given etag was read - read the policy again and retry")
+)
+
+func (b *backend) pathKeysIAM() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key") + "/iam",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationSuffix: "key-iam-policy",
+		},
+
+		HelpSynopsis: "Manage the IAM policy of the crypto key",
+		HelpDescription: `
+Read or modify the Google Cloud IAM policy attached to the crypto key
+referenced by the named key.
+
+To read the policy's role bindings and etag:
+
+    $ vault read gcpkms/keys/my-key/iam
+
+To grant a role to one or more members:
+
+    $ vault write gcpkms/keys/my-key/iam \
+        action=grant \
+        role=roles/cloudkms.cryptoKeyEncrypterDecrypter \
+        members=serviceAccount:my-sa@my-project.iam.gserviceaccount.com
+
+To revoke a role from one or more members, use action=revoke. Only
+unconditional role bindings are modified.
+
+If etag is given, the change is only applied if the policy has not been
+modified since that etag was read. Otherwise, Vault retries the change on
+concurrent modification.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault. This key must already exist in Vault and Google Cloud
+KMS.
+`,
+			},
+
+			"action": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "grant",
+				Description: `
+Action to perform on the role binding. Valid values are "grant" and "revoke".
+The default value is "grant".
+`,
+			},
+
+			"role": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+IAM role to grant or revoke (e.g. roles/cloudkms.cryptoKeyEncrypterDecrypter).
+This field is required.
+`,
+			},
+
+			"members": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+List of IAM members (e.g. serviceAccount:sa@my-project.iam.gserviceaccount.com,
+user:alice@example.com, group:team@example.com) to grant or revoke the role.
+This field is required.
+`,
+			},
+
+			"etag": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Base64-encoded etag as previously returned from a read of this path. If given,
+the change is rejected if the policy was modified since the etag was read.
+`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  withFieldValidator(b.pathKeysIAMRead),
+				Responses: keysIAMResponses,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "read",
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:  withFieldValidator(b.pathKeysIAMWrite),
+				Responses: keysIAMResponses,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationVerb: "update",
+				},
+			},
+		},
+	}
+}
+
+// keysIAMResponses are the responses of reading and writing the IAM policy of a
+// key, which are the policy in the form of iamPolicyToMap.
+var keysIAMResponses = map[int][]framework.Response{
+	http.StatusOK: {{
+		Description: "OK",
+		Fields: map[string]*framework.FieldSchema{
+			"version": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Version of the IAM policy.",
+				Required:    true,
+			},
+			"etag": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Base64-encoded etag of the IAM policy.",
+				Required:    true,
+			},
+			"bindings": &framework.FieldSchema{
+				Type:        framework.TypeSlice,
+				Description: "Role, members, and any condition of each binding.",
+				Required:    true,
+			},
+		},
+	}},
+}
+
+// pathKeysIAMRead corresponds to GET gcpkms/keys/:key/iam and is used to read
+// the IAM policy of the crypto key.
+func (b *backend) pathKeysIAMRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	policy, err := kmsClient.GetIamPolicy(ctx, &iampb.GetIamPolicyRequest{
+		Resource: k.CryptoKeyID,
+		Options: &iampb.GetPolicyOptions{
+			RequestedPolicyVersion: iamPolicyVersion,
+		},
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to get IAM policy: {{err}}", err)
+	}
+
+	return &logical.Response{
+		Data: iamPolicyToMap(policy),
+	}, nil
+}
+
+// pathKeysIAMWrite corresponds to PUT/POST gcpkms/keys/:key/iam and is used to
+// grant or revoke a role on the crypto key.
+func (b *backend) pathKeysIAMWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+	action := strings.ToLower(d.Get("action").(string))
+	role := strings.TrimSpace(d.Get("role").(string))
+	members := strutil.RemoveDuplicates(d.Get("members").([]string), false)
+
+	if role == "" {
+		return nil, errMissingFields("role")
+	}
+	if len(members) == 0 {
+		return nil, errMissingFields("members")
+	}
+	if action != "grant" && action != "revoke" {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"unknown action %q, valid actions are %q", action, []string{"grant", "revoke"}))
+	}
+
+	var etag []byte
+	if v := d.Get("etag").(string); v != "" {
+		var err error
+		etag, err = base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, logical.CodedError(400, fmt.Sprintf("failed to base64 decode etag: %s", err))
+		}
+	}
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		policy, err := kmsClient.GetIamPolicy(ctx, &iampb.GetIamPolicyRequest{
+			Resource: k.CryptoKeyID,
+			Options: &iampb.GetPolicyOptions{
+				RequestedPolicyVersion: iamPolicyVersion,
+			},
+		})
+		if err != nil {
+			return nil, wrapKMSError("failed to get IAM policy: {{err}}", err)
+		}
+
+		if etag != nil && !bytes.Equal(etag, policy.Etag) {
+			return nil, errIAMPolicyConflict
+		}
+
+		var changed bool
+		if action == "grant" {
+			changed = grantIAMRole(policy, role, members)
+		} else {
+			changed = revokeIAMRole(policy, role, members)
+		}
+
+		if !changed {
+			return &logical.Response{
+				Data: iamPolicyToMap(policy),
+			}, nil
+		}
+
+		// Conditional bindings require policy version 3
+		policy.Version = iamPolicyVersion
+
+		newPolicy, err := kmsClient.SetIamPolicy(ctx, &iampb.SetIamPolicyRequest{
+			Resource: k.CryptoKeyID,
+			Policy:   policy,
+		})
+		if err != nil {
+			if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.Aborted {
+				if etag != nil {
+					return nil, errIAMPolicyConflict
+				}
+				if attempt < iamPolicyMaxAttempts {
+					continue
+				}
+			}
+			return nil, errwrap.Wrapf("failed to set IAM policy: {{err}}", err)
+		}
+
+		return &logical.Response{
+			Data: iamPolicyToMap(newPolicy),
+		}, nil
+	}
+}
+
+// grantIAMRole adds the members to the unconditional binding for the role,
+// creating the binding if it does not exist. It returns true if the policy was
+// modified.
+func grantIAMRole(policy *iampb.Policy, role string, members []string) bool {
+	for _, binding := range policy.Bindings {
+		if binding.Role != role || binding.Condition != nil {
+			continue
+		}
+
+		changed := false
+		for _, m := range members {
+			if !strutil.StrListContains(binding.Members, m) {
+				binding.Members = append(binding.Members, m)
+				changed = true
+			}
+		}
+		return changed
+	}
+
+	policy.Bindings = append(policy.Bindings, &iampb.Binding{
+		Role:    role,
+		Members: members,
+	})
+	return true
+}
+
+// revokeIAMRole removes the members from the unconditional binding for the
+// role, removing the binding entirely if it has no remaining members. It
+// returns true if the policy was modified.
+func revokeIAMRole(policy *iampb.Policy, role string, members []string) bool {
+	changed := false
+	bindings := policy.Bindings[:0]
+	for _, binding := range policy.Bindings {
+		if binding.Role == role && binding.Condition == nil {
+			kept := binding.Members[:0]
+			for _, m := range binding.Members {
+				if strutil.StrListContains(members, m) {
+					changed = true
+					continue
+				}
+				kept = append(kept, m)
+			}
+			binding.Members = kept
+
+			if len(binding.Members) == 0 {
+				continue
+			}
+		}
+		bindings = append(bindings, binding)
+	}
+	policy.Bindings = bindings
+	return changed
+}
+
+// iamPolicyToMap converts the IAM policy into a user-facing response.
+func iamPolicyToMap(policy *iampb.Policy) map[string]interface{} {
+	bindings := make([]map[string]interface{}, 0, len(policy.Bindings))
+	for _, binding := range policy.Bindings {
+		m := map[string]interface{}{
+			"role":    binding.Role,
+			"members": binding.Members,
+		}
+
+		if c := binding.Condition; c != nil {
+			m["condition"] = map[string]interface{}{
+				"title":       c.Title,
+				"description": c.Description,
+				"expression":  c.Expression,
+			}
+		}
+
+		bindings = append(bindings, m)
+	}
+
+	return map[string]interface{}{
+		"version":  policy.Version,
+		"etag":     base64.StdEncoding.EncodeToString(policy.Etag),
+		"bindings": bindings,
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	expr "google.golang.org/genproto/googleapis/type/expr"
+)
+
+func TestPathKeysIAM_Read(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "keys/my-key/iam")
+	})
+
+	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+	defer cleanup()
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if err := storage.Put(ctx, &logical.StorageEntry{
+		Key:   "keys/my-key",
+		Value: []byte(`{"name":"my-key", "crypto_key_id":"` + cryptoKey + `"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/my-key/iam",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{
+		"version",
+		"etag",
+		"bindings",
+	} {
+		if _, ok := resp.Data[v]; !ok {
+			t.Errorf("missing %q", v)
+		}
+	}
+}
+
+func TestPathKeysIAM_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/my-key/iam")
+	})
+
+	t.Run("missing_role", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/my-key/iam",
+			Data: map[string]interface{}{
+				"members": "user:foo@example.com",
+			},
+		}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+	defer cleanup()
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if err := storage.Put(ctx, &logical.StorageEntry{
+		Key:   "keys/my-key",
+		Value: []byte(`{"name":"my-key", "crypto_key_id":"` + cryptoKey + `"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	role := "roles/cloudkms.viewer"
+	member := "domain:example.com"
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/my-key/iam",
+		Data: map[string]interface{}{
+			"role":    role,
+			"members": member,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A stale etag is rejected
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/my-key/iam",
+		Data: map[string]interface{}{
+			"action":  "revoke",
+			"role":    role,
+			"members": member,
+			"etag":    "c3RhbGU=",
+		},
+	}); err == nil {
+		t.Errorf("expected etag conflict")
+	}
+
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/my-key/iam",
+		Data: map[string]interface{}{
+			"action":  "revoke",
+			"role":    role,
+			"members": member,
+			"etag":    resp.Data["etag"],
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGrantRevokeIAMRole(t *testing.T) {
+
+	condition := &expr.Expr{Expression: "true"}
+
+	t.Run("grant_new_binding", func(t *testing.T) {
+
+		policy := &iampb.Policy{}
+		if !grantIAMRole(policy, "roles/a", []string{"user:a"}) {
+			t.Errorf("expected change")
+		}
+		if v, exp := policy.Bindings[0].Members, []string{"user:a"}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+	})
+
+	t.Run("grant_existing_binding", func(t *testing.T) {
+
+		policy := &iampb.Policy{
+			Bindings: []*iampb.Binding{
+				{Role: "roles/a", Members: []string{"user:c"}, Condition: condition},
+				{Role: "roles/a", Members: []string{"user:a"}},
+			},
+		}
+		if !grantIAMRole(policy, "roles/a", []string{"user:a", "user:b"}) {
+			t.Errorf("expected change")
+		}
+		if v, exp := policy.Bindings[1].Members, []string{"user:a", "user:b"}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+		if v, exp := policy.Bindings[0].Members, []string{"user:c"}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected conditional binding to be untouched: %q", v)
+		}
+	})
+
+	t.Run("grant_no_change", func(t *testing.T) {
+
+		policy := &iampb.Policy{
+			Bindings: []*iampb.Binding{
+				{Role: "roles/a", Members: []string{"user:a"}},
+			},
+		}
+		if grantIAMRole(policy, "roles/a", []string{"user:a"}) {
+			t.Errorf("expected no change")
+		}
+	})
+
+	t.Run("revoke_removes_empty_binding", func(t *testing.T) {
+
+		policy := &iampb.Policy{
+			Bindings: []*iampb.Binding{
+				{Role: "roles/a", Members: []string{"user:a"}},
+				{Role: "roles/b", Members: []string{"user:a"}},
+			},
+		}
+		if !revokeIAMRole(policy, "roles/a", []string{"user:a"}) {
+			t.Errorf("expected change")
+		}
+		if len(policy.Bindings) != 1 || policy.Bindings[0].Role != "roles/b" {
+			t.Errorf("expected only roles/b to remain: %v", policy.Bindings)
+		}
+	})
+
+	t.Run("revoke_no_change", func(t *testing.T) {
+
+		policy := &iampb.Policy{
+			Bindings: []*iampb.Binding{
+				{Role: "roles/a", Members: []string{"user:a"}, Condition: condition},
+			},
+		}
+		if revokeIAMRole(policy, "roles/a", []string{"user:a"}) {
+			t.Errorf("expected no change")
+		}
+	})
+}
@@ -0,0 +1,262 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// importJobNameRegex matches the full resource ID of an import job.
+var importJobNameRegex = regexp.MustCompile(
+	`^projects/([^/]+)/locations/([^/]+)/keyRings/([^/]+)/importJobs/([^/]+)$`)
+
+func (b *backend) pathKeysImport() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/import/" + framework.GenericNameRegex("key"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "import",
+			OperationSuffix: "key-version",
+		},
+
+		HelpSynopsis: "Import key material as a new crypto key version",
+		HelpDescription: `
+This endpoint imports externally generated key material into the Google Cloud
+KMS crypto key of a registered key as a new crypto key version, so the key
+material can be refreshed in place without changing the Vault key name.
+
+The key material must be wrapped with the public key of a Google Cloud KMS
+import job, using the import job's wrapping method. The import job must be
+active and have the same protection level as the crypto key.
+
+For symmetric keys, the new crypto key version is made the primary once it is
+enabled, so new data is encrypted with the imported key material. Previous
+crypto key versions are not disabled, so existing ciphertext can still be
+decrypted until they are trimmed.
+
+Imported crypto key versions are pending until Google Cloud KMS has unwrapped
+the key material. By default this endpoint waits for the new version to be
+enabled:
+
+    $ vault write gcpkms/keys/import/my-key \
+        import_job=projects/my-project/locations/global/keyRings/my-keyring/importJobs/my-job \
+        wrapped_key=@wrapped.b64
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key into which to import. This key must already be registered with
+Vault and point to a valid Google Cloud KMS crypto key.
+`,
+			},
+
+			"import_job": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Full resource ID of the import job whose public key wrapped the key material.
+This field is required.
+`,
+			},
+
+			"wrapped_key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Base64-encoded key material wrapped with the public key of the import job. This
+field is required.
+`,
+			},
+
+			"algorithm": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Algorithm of the imported key material. If unspecified, this defaults to the
+algorithm of the crypto key's version template.
+`,
+			},
+
+			"wait": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `
+If true, wait until the new crypto key version is enabled before returning. For
+symmetric keys the new version is only made the primary once it is enabled. The
+default is true.
+`,
+			},
+
+			"wait_timeout": &framework.FieldSchema{
+				Type:    framework.TypeDurationSecond,
+				Default: 60,
+				Description: `
+Maximum amount of time to wait for the new crypto key version to be enabled
+when wait is true. If the version is not enabled in time, the response includes
+its current state and a warning. The default is 60 seconds.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: withFieldValidator(b.pathKeysImportWrite),
+		},
+	}
+}
+
+// pathKeysImportWrite corresponds to PUT/POST gcpkms/keys/import/:key and is
+// used to import wrapped key material as a new crypto key version.
+func (b *backend) pathKeysImportWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+	importJob := d.Get("import_job").(string)
+	wait := d.Get("wait").(bool)
+	waitTimeout := time.Duration(d.Get("wait_timeout").(int)) * time.Second
+
+	var missing []string
+	if importJob == "" {
+		missing = append(missing, "import_job")
+	}
+	wrappedKey := d.Get("wrapped_key").(string)
+	if wrappedKey == "" {
+		missing = append(missing, "wrapped_key")
+	}
+	if len(missing) > 0 {
+		return nil, errMissingFields(missing...)
+	}
+
+	if !importJobNameRegex.MatchString(importJob) {
+		return nil, logical.CodedError(400, fmt.Sprintf("invalid import job resource "+
+			"ID %q, expected projects/<project>/locations/<location>/keyRings/<key_ring>/importJobs/<import_job>",
+			importJob))
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, logical.CodedError(400, fmt.Sprintf("failed to base64 decode wrapped_key: %s", err))
+	}
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	ck, err := b.cryptoKey(ctx, kmsClient, k.CryptoKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	if v, ok := d.GetOk("algorithm"); ok {
+		algorithm, ok = keyAlgorithms[strings.ToLower(v.(string))]
+		if !ok {
+			return nil, logical.CodedError(400, fmt.Sprintf(
+				"unknown algorithm %q, valid algorithms are %q", v, keyAlgorithmNames()))
+		}
+	} else if ck.VersionTemplate != nil {
+		algorithm = ck.VersionTemplate.Algorithm
+	}
+	if algorithm == kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED {
+		return nil, errMissingFields("algorithm")
+	}
+	if p := algorithmPurpose(algorithm); p != ck.Purpose {
+		return nil, logical.CodedError(400, fmt.Sprintf("algorithm %q is not valid "+
+			"for key %q with purpose %q", algorithmToString(algorithm), key, purposeToString(ck.Purpose)))
+	}
+
+	// The imported version takes the protection level of the crypto key, so
+	// it is checked against the key policy like a new key would be.
+	config, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	protectionLevel := ck.GetVersionTemplate().GetProtectionLevel()
+	if config.FIPSEnforcement {
+		if err := checkFIPS(protectionLevel, algorithm); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.checkProtectionLevel(protectionLevel); err != nil {
+		return nil, err
+	}
+	if err := config.checkAlgorithm(algorithm); err != nil {
+		return nil, err
+	}
+
+	ckv, err := kmsClient.ImportCryptoKeyVersion(ctx, &kmspb.ImportCryptoKeyVersionRequest{
+		Parent:     k.CryptoKeyID,
+		ImportJob:  importJob,
+		Algorithm:  algorithm,
+		WrappedKey: wrapped,
+	})
+	b.invalidateCryptoKey(k.CryptoKeyID)
+	if err != nil {
+		return nil, wrapKMSError("failed to import crypto key version: {{err}}", err)
+	}
+
+	if err := b.recordRotation(ctx, req.Storage, k, ckv, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	if wait && ckv.State != kmspb.CryptoKeyVersion_ENABLED {
+		ckv, err = waitForCryptoKeyVersion(ctx, kmsClient, ckv.Name, waitTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if ckv.State != kmspb.CryptoKeyVersion_ENABLED {
+			warnings = append(warnings, fmt.Sprintf("The crypto key version was "+
+				"not enabled within %s.", waitTimeout))
+		}
+	}
+
+	// Make the imported version the primary, only valid for symmetric keys
+	if algorithm == kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION {
+		if ckv.State == kmspb.CryptoKeyVersion_ENABLED {
+			if _, err := kmsClient.UpdateCryptoKeyPrimaryVersion(ctx, &kmspb.UpdateCryptoKeyPrimaryVersionRequest{
+				Name:               k.CryptoKeyID,
+				CryptoKeyVersionId: path.Base(ckv.Name),
+			}); err != nil {
+				return nil, wrapKMSError("failed to update crypto key primary version: {{err}}", err)
+			}
+			b.invalidateCryptoKey(k.CryptoKeyID)
+			warnings = append(warnings, primaryVersionWarning)
+		} else {
+			warnings = append(warnings, "The imported crypto key version is not "+
+				"enabled, so it was not made the primary. Set it as the primary "+
+				"version of the crypto key once it is enabled.")
+		}
+	}
+
+	return &logical.Response{
+		Warnings: warnings,
+		Data: map[string]interface{}{
+			"key_version":        path.Base(ckv.Name),
+			"crypto_key_version": ckv.Name,
+			"state":              strings.ToLower(ckv.State.String()),
+		},
+	}, nil
+}
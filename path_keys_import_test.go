@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestPathKeysImport_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/import/my-key")
+	})
+
+	t.Run("invalid_request", func(t *testing.T) {
+
+		importJob := "projects/p/locations/global/keyRings/r/importJobs/j"
+
+		cases := []struct {
+			name string
+			data map[string]interface{}
+		}{
+			{
+				"missing_import_job",
+				map[string]interface{}{"wrapped_key": "AAAA"},
+			},
+			{
+				"missing_wrapped_key",
+				map[string]interface{}{"import_job": importJob},
+			},
+			{
+				"invalid_import_job",
+				map[string]interface{}{"import_job": "my-job", "wrapped_key": "AAAA"},
+			},
+			{
+				"invalid_wrapped_key",
+				map[string]interface{}{"import_job": importJob, "wrapped_key": "not base64!"},
+			},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+
+				b, storage := testBackend(t)
+
+				ctx := context.Background()
+				_, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "keys/import/my-key",
+					Data:      tc.data,
+				})
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 400 {
+					t.Errorf("expected 400 error, got %#v", err)
+				}
+			})
+		}
+	})
+
+	t.Run("key_policy", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		f := testFakeKMSClient(t, b)
+
+		cryptoKey := testFakeCryptoKey(t, f, kmspb.CryptoKey_ENCRYPT_DECRYPT,
+			kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+
+		ctx := context.Background()
+		if err := b.putKey(ctx, storage, &Key{Name: "my-key", CryptoKeyID: cryptoKey}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"allowed_protection_levels": "hsm",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The software crypto key cannot have key material imported into it
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/import/my-key",
+			Data: map[string]interface{}{
+				"import_job":  "projects/p/locations/global/keyRings/r/importJobs/j",
+				"wrapped_key": "AAAA",
+			},
+		})
+		if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 400 {
+			t.Errorf("expected 400 error, got %#v", err)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// keysOrphansResponses are the responses of reading and running orphan checks.
+var keysOrphansResponses = map[int][]framework.Response{
+	http.StatusOK: {{
+		Description: "OK",
+		Fields: map[string]*framework.FieldSchema{
+			"check_time": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Time of the orphan check in RFC 3339 format.",
+				Required:    true,
+			},
+			"missing_crypto_keys": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "Crypto key IDs of the keys whose crypto keys no longer exist.",
+				Required:    true,
+			},
+			"unregistered_crypto_keys": &framework.FieldSchema{
+				Type:        framework.TypeStringSlice,
+				Description: "Crypto keys with the mount label which have no key in Vault.",
+				Required:    true,
+			},
+			"errors": &framework.FieldSchema{
+				Type:        framework.TypeStringSlice,
+				Description: "Checks which could not be made.",
+			},
+		},
+	}},
+}
+
+func (b *backend) pathKeysOrphans() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/orphans$",
+
+		HelpSynopsis: "Report keys and crypto keys which are out of sync",
+		HelpDescription: `
+Report the keys in Vault whose crypto keys no longer exist in Google Cloud KMS
+and, if mount_label is set in the config, the crypto keys created by this mount
+which have no key in Vault. Crypto keys are only searched for in the key rings
+of this mount's keys. Reading returns the result of the last check:
+
+    $ vault read gcpkms/keys/orphans
+
+Keys are checked periodically if orphan_check_interval is set in the config.
+To check keys now, write to this endpoint:
+
+    $ vault write -f gcpkms/keys/orphans
+
+Keys whose crypto keys no longer exist may be deleted from Vault with
+keys/deregister/:key, and crypto keys with no key in Vault may be registered
+with keys/register/:key.
+`,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  withFieldValidator(b.pathKeysOrphansRead),
+				Responses: keysOrphansResponses,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationPrefix: operationPrefixGoogleCloudKMS,
+					OperationVerb:   "read",
+					OperationSuffix: "key-orphans",
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:  withFieldValidator(b.pathKeysOrphansWrite),
+				Responses: keysOrphansResponses,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationPrefix: operationPrefixGoogleCloudKMS,
+					OperationVerb:   "check",
+					OperationSuffix: "key-orphans",
+				},
+			},
+		},
+	}
+}
+
+// pathKeysOrphansRead corresponds to GET gcpkms/keys/orphans and is used to
+// read the result of the last orphan check.
+func (b *backend) pathKeysOrphansRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	r, err := b.lastOrphanReport(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, nil
+	}
+	return orphanReportResponse(r), nil
+}
+
+// pathKeysOrphansWrite corresponds to PUT/POST gcpkms/keys/orphans and is
+// used to check keys for orphaned crypto keys now.
+func (b *backend) pathKeysOrphansWrite(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	r, err := b.orphanCheck(ctx, req.Storage, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return orphanReportResponse(r), nil
+}
+
+// orphanReportResponse returns the response describing the orphan report, with
+// a warning for each check which could not be made.
+func orphanReportResponse(r *orphanReport) *logical.Response {
+	missing := make(map[string]interface{}, len(r.MissingCryptoKeys))
+	for k, v := range r.MissingCryptoKeys {
+		missing[k] = v
+	}
+
+	data := map[string]interface{}{
+		"check_time":               r.CheckTime.Format(time.RFC3339),
+		"missing_crypto_keys":      missing,
+		"unregistered_crypto_keys": r.UnregisteredCryptoKeys,
+	}
+	if len(r.Errors) > 0 {
+		data["errors"] = r.Errors
+	}
+
+	return &logical.Response{
+		Data:     data,
+		Warnings: r.Errors,
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/testhelpers/schema"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestPathKeysOrphans(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "keys/orphans")
+		testFieldValidation(t, logical.UpdateOperation, "keys/orphans")
+	})
+
+	t.Run("not_checked", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/orphans",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp != nil {
+			t.Errorf("expected no response, got %#v", resp)
+		}
+	})
+
+	t.Run("reserved_name", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/register/orphans",
+			Data: map[string]interface{}{
+				"crypto_key": "projects/p/locations/global/keyRings/r/cryptoKeys/orphans",
+				"verify":     false,
+			},
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("orphans", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		f := testFakeKMSClient(t, b)
+
+		ctx := context.Background()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"mount_label": "vault-mount=test",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Keys created by the mount have the mount label
+		created := testFakeCryptoKey(t, f, kmspb.CryptoKey_ENCRYPT_DECRYPT,
+			kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+		keyRing := "projects/p/locations/global/keyRings/r"
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "keys/my-key",
+			Data: map[string]interface{}{
+				"key_ring": keyRing,
+				"labels":   "team=security",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		ck, err := f.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{Name: keyRing + "/cryptoKeys/my-key"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := ck.Labels, map[string]string{"team": "security", "vault-mount": "test"}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+
+		// A key whose crypto key was deleted outside of Vault
+		if err := b.putKey(ctx, storage, &Key{
+			Name:        "missing-key",
+			CryptoKeyID: created,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		f.lock.Lock()
+		delete(f.cryptoKeys, created)
+		f.lock.Unlock()
+
+		// Crypto keys with no key in Vault, only one of which has the label
+		for _, labels := range []map[string]string{{"vault-mount": "test"}, {"vault-mount": "other"}} {
+			if _, err := f.CreateCryptoKey(ctx, &kmspb.CreateCryptoKeyRequest{
+				Parent:      keyRing,
+				CryptoKeyId: "unregistered-" + labels["vault-mount"],
+				CryptoKey: &kmspb.CryptoKey{
+					Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+					Labels:  labels,
+					VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+						Algorithm: kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+					},
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/orphans",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema.ValidateResponse(t, schema.GetResponseSchema(t, b.Route("keys/orphans"), logical.UpdateOperation), resp, true)
+
+		missing := map[string]interface{}{"missing-key": created}
+		if v := resp.Data["missing_crypto_keys"]; !reflect.DeepEqual(v, missing) {
+			t.Errorf("expected %v to be %v", v, missing)
+		}
+		unregistered := []string{keyRing + "/cryptoKeys/unregistered-test"}
+		if v := resp.Data["unregistered_crypto_keys"]; !reflect.DeepEqual(v, unregistered) {
+			t.Errorf("expected %v to be %v", v, unregistered)
+		}
+
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/orphans",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := resp.Data["missing_crypto_keys"]; !reflect.DeepEqual(v, missing) {
+			t.Errorf("expected %v to be %v", v, missing)
+		}
+		if v := resp.Data["unregistered_crypto_keys"]; !reflect.DeepEqual(v, unregistered) {
+			t.Errorf("expected %v to be %v", v, unregistered)
+		}
+	})
+}
+
+func TestPathKeysOrphans_UpdateLabels(t *testing.T) {
+
+	b, storage := testBackend(t)
+	f := testFakeKMSClient(t, b)
+
+	ctx := context.Background()
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"mount_label": "vault-mount=test",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	keyRing := "projects/p/locations/global/keyRings/r"
+	if _, err := f.CreateKeyRing(ctx, &kmspb.CreateKeyRingRequest{
+		Parent:    "projects/p/locations/global",
+		KeyRingId: "r",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"my-key", "other-key"} {
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "keys/" + key,
+			Data: map[string]interface{}{
+				"key_ring": keyRing,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Replacing the labels keeps the mount label
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/my-key",
+		Data: map[string]interface{}{
+			"labels": "team=payments",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cryptoKey := keyRing + "/cryptoKeys/my-key"
+	ck, err := f.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{Name: cryptoKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := ck.Labels, map[string]string{"team": "payments", "vault-mount": "test"}; !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %v to be %v", v, exp)
+	}
+
+	// Once deregistered, the crypto key is still found by the orphan check
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/deregister/my-key",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/orphans",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := resp.Data["unregistered_crypto_keys"], []string{cryptoKey}; !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %v to be %v", v, exp)
+	}
+}
+
+func TestBackend_OrphanCheckDue(t *testing.T) {
+
+	b, _ := testBackend(t)
+
+	now := time.Now().UTC()
+	if b.orphanCheckDue(now, 0) {
+		t.Error("expected no check with a zero interval")
+	}
+	if !b.orphanCheckDue(now, time.Hour) {
+		t.Error("expected the first check to be due")
+	}
+	if b.orphanCheckDue(now.Add(time.Minute), time.Hour) {
+		t.Error("expected no check before the interval passed")
+	}
+	if !b.orphanCheckDue(now.Add(time.Hour), time.Hour) {
+		t.Error("expected a check after the interval passed")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	iampb "cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// keyManagementPermissions are the IAM permissions required by Vault's key
+// management operations, regardless of the crypto key purpose.
+var keyManagementPermissions = map[string][]string{
+	"read":       {"cloudkms.cryptoKeys.get"},
+	"update":     {"cloudkms.cryptoKeys.update"},
+	"rotate":     {"cloudkms.cryptoKeyVersions.create", "cloudkms.cryptoKeys.update"},
+	"import":     {"cloudkms.cryptoKeyVersions.create", "cloudkms.cryptoKeys.update"},
+	"trim":       {"cloudkms.cryptoKeyVersions.list", "cloudkms.cryptoKeyVersions.destroy"},
+	"delete":     {"cloudkms.cryptoKeys.update", "cloudkms.cryptoKeyVersions.list", "cloudkms.cryptoKeyVersions.destroy"},
+	"iam_read":   {"cloudkms.cryptoKeys.getIamPolicy"},
+	"iam_update": {"cloudkms.cryptoKeys.getIamPolicy", "cloudkms.cryptoKeys.setIamPolicy"},
+}
+
+// keyUsePermissions are the IAM permissions required by Vault's cryptographic
+// operations, keyed by crypto key purpose.
+var keyUsePermissions = map[kmspb.CryptoKey_CryptoKeyPurpose]map[string][]string{
+	kmspb.CryptoKey_ENCRYPT_DECRYPT: {
+		"encrypt":   {"cloudkms.cryptoKeyVersions.useToEncrypt"},
+		"decrypt":   {"cloudkms.cryptoKeyVersions.useToDecrypt"},
+		"reencrypt": {"cloudkms.cryptoKeyVersions.useToEncrypt", "cloudkms.cryptoKeyVersions.useToDecrypt"},
+	},
+	kmspb.CryptoKey_ASYMMETRIC_DECRYPT: {
+		"decrypt": {"cloudkms.cryptoKeyVersions.useToDecrypt"},
+		"pubkey":  {"cloudkms.cryptoKeyVersions.viewPublicKey"},
+		"wrapkey": {"cloudkms.cryptoKeyVersions.viewPublicKey"},
+	},
+	kmspb.CryptoKey_ASYMMETRIC_SIGN: {
+		"sign":   {"cloudkms.cryptoKeyVersions.get", "cloudkms.cryptoKeyVersions.useToSign"},
+		"verify": {"cloudkms.cryptoKeyVersions.viewPublicKey"},
+		"pubkey": {"cloudkms.cryptoKeyVersions.viewPublicKey"},
+	},
+}
+
+// PermissionsReport is the result of checking the plugin's permissions on a
+// crypto key.
+type PermissionsReport struct {
+	// Granted is the sorted list of required permissions the caller has.
+	Granted []string
+
+	// Missing is the sorted list of required permissions the caller lacks.
+	Missing []string
+
+	// MissingByOperation maps each Vault operation that cannot be performed to
+	// the permissions it is missing.
+	MissingByOperation map[string][]string
+}
+
+// keyOperationPermissions returns the permissions required by each Vault
+// operation for a crypto key with the given purpose. If useOnly is true, only
+// the cryptographic operations are returned.
+func keyOperationPermissions(purpose kmspb.CryptoKey_CryptoKeyPurpose, useOnly bool) map[string][]string {
+	ops := make(map[string][]string)
+	if !useOnly {
+		for op, perms := range keyManagementPermissions {
+			ops[op] = perms
+		}
+	}
+	for op, perms := range keyUsePermissions[purpose] {
+		ops[op] = perms
+	}
+	return ops
+}
+
+// testKeyPermissions checks which of the permissions required by the given
+// operations the configured credentials hold on the crypto key.
+func testKeyPermissions(ctx context.Context, kmsClient keyManagementClient, cryptoKeyID string, ops map[string][]string) (*PermissionsReport, error) {
+	var required []string
+	for _, perms := range ops {
+		required = append(required, perms...)
+	}
+	required = strutil.RemoveDuplicates(required, false)
+
+	resp, err := kmsClient.TestIamPermissions(ctx, &iampb.TestIamPermissionsRequest{
+		Resource:    cryptoKeyID,
+		Permissions: required,
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to test IAM permissions: {{err}}", err)
+	}
+
+	r := &PermissionsReport{
+		Granted:            strutil.RemoveDuplicates(resp.Permissions, false),
+		MissingByOperation: make(map[string][]string),
+	}
+
+	for _, p := range required {
+		if !strutil.StrListContains(r.Granted, p) {
+			r.Missing = append(r.Missing, p)
+		}
+	}
+	sort.Strings(r.Missing)
+
+	for op, perms := range ops {
+		for _, p := range perms {
+			if strutil.StrListContains(r.Missing, p) {
+				r.MissingByOperation[op] = append(r.MissingByOperation[op], p)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// Warnings returns a warning for each operation which is missing
+// permissions.
+func (r *PermissionsReport) Warnings() []string {
+	ops := make([]string, 0, len(r.MissingByOperation))
+	for op := range r.MissingByOperation {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	warnings := make([]string, 0, len(ops))
+	for _, op := range ops {
+		warnings = append(warnings, fmt.Sprintf(
+			"the configured credentials cannot perform %q operations on this key, missing permission(s): %s",
+			op, strings.Join(r.MissingByOperation[op], ", ")))
+	}
+	return warnings
+}
+
+func (b *backend) pathKeysPermissions() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key") + "/permissions",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "check",
+			OperationSuffix: "key-permissions",
+		},
+
+		HelpSynopsis: "Check the permissions Vault has on the crypto key",
+		HelpDescription: `
+Check which Google Cloud KMS permissions the configured credentials hold on the
+crypto key referenced by the named key. The response lists the granted and
+missing permissions, and the Vault operations which will fail as a result.
+
+    $ vault read gcpkms/keys/my-key/permissions
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault. This key must already exist in Vault and Google Cloud
+KMS.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: withFieldValidator(b.pathKeysPermissionsRead),
+		},
+	}
+}
+
+// pathKeysPermissionsRead corresponds to GET gcpkms/keys/:key/permissions and
+// is used to report the permissions the plugin has on the crypto key.
+func (b *backend) pathKeysPermissionsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	// The purpose determines which cryptographic permissions are required. If
+	// the key cannot be read, only report on the management permissions.
+	purpose := kmspb.CryptoKey_CRYPTO_KEY_PURPOSE_UNSPECIFIED
+	if ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: k.CryptoKeyID,
+	}); err == nil {
+		purpose = ck.Purpose
+	}
+
+	r, err := testKeyPermissions(ctx, kmsClient, k.CryptoKeyID, keyOperationPermissions(purpose, false))
+	if err != nil {
+		return nil, err
+	}
+
+	missing := r.Missing
+	if missing == nil {
+		missing = []string{}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"purpose":              purposeToString(purpose),
+			"granted":              r.Granted,
+			"missing":              missing,
+			"missing_by_operation": r.MissingByOperation,
+		},
+		Warnings: r.Warnings(),
+	}, nil
+}
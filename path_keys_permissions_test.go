@@ -0,0 +1,81 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestKeyOperationPermissions(t *testing.T) {
+
+	t.Run("use_only", func(t *testing.T) {
+
+		ops := keyOperationPermissions(kmspb.CryptoKey_ASYMMETRIC_SIGN, true)
+		if _, ok := ops["sign"]; !ok {
+			t.Errorf("expected sign operation: %v", ops)
+		}
+		if _, ok := ops["rotate"]; ok {
+			t.Errorf("expected no management operations: %v", ops)
+		}
+	})
+
+	t.Run("all", func(t *testing.T) {
+
+		ops := keyOperationPermissions(kmspb.CryptoKey_ENCRYPT_DECRYPT, false)
+		for _, op := range []string{"encrypt", "decrypt", "rotate", "trim"} {
+			if _, ok := ops[op]; !ok {
+				t.Errorf("missing %q: %v", op, ops)
+			}
+		}
+	})
+}
+
+func TestPathKeysPermissions_Read(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "keys/my-key/permissions")
+	})
+
+	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+	defer cleanup()
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if err := storage.Put(ctx, &logical.StorageEntry{
+		Key:   "keys/my-key",
+		Value: []byte(`{"name":"my-key", "crypto_key_id":"` + cryptoKey + `"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/my-key/permissions",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, exp := resp.Data["purpose"], "encrypt_decrypt"; v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+
+	for _, v := range []string{
+		"granted",
+		"missing",
+		"missing_by_operation",
+	} {
+		if _, ok := resp.Data[v]; !ok {
+			t.Errorf("missing %q", v)
+		}
+	}
+}
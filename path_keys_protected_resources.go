@@ -0,0 +1,200 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iterator"
+
+	inventory "cloud.google.com/go/kms/inventory/apiv1"
+	inventorypb "cloud.google.com/go/kms/inventory/apiv1/inventorypb"
+)
+
+// defaultProtectedResourcesMaxResults is the default maximum number of
+// protected resources listed.
+const defaultProtectedResourcesMaxResults = 100
+
+func (b *backend) pathKeysProtectedResources() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key") + "/protected-resources",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "read",
+			OperationSuffix: "key-protected-resources",
+		},
+
+		HelpSynopsis: "List the Google Cloud resources protected by the crypto key",
+		HelpDescription: `
+Read which Google Cloud resources are protected by the crypto key referenced by
+the named key, using the Cloud KMS Inventory API, to assess the impact of
+rotating, trimming, or deregistering the key. The response summarizes the
+number of protected resources by project, resource type, product, and
+location.
+
+    $ vault read gcpkms/keys/my-key/protected-resources
+
+To also list the individual resources, give the organization in which to
+search. This requires the "cloudkms.protectedResources.search" permission on
+the organization:
+
+    $ vault read gcpkms/keys/my-key/protected-resources organization=123456789
+
+The summary requires the "cloudkms.cryptoKeys.get" permission and the Cloud KMS
+Inventory API to be enabled in the key's project.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault. This key must already exist in Vault and Google Cloud
+KMS.
+`,
+			},
+
+			"organization": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+ID of the Google Cloud organization in which to list the protected resources.
+If unspecified, only the summary is returned.
+`,
+			},
+
+			"max_results": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Maximum number of protected resources to list. The default is 100.
+`,
+				Default: defaultProtectedResourcesMaxResults,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: withFieldValidator(b.pathKeysProtectedResourcesRead),
+		},
+	}
+}
+
+// pathKeysProtectedResourcesRead corresponds to GET
+// gcpkms/keys/:key/protected-resources and is used to read the Google Cloud
+// resources protected by the crypto key.
+func (b *backend) pathKeysProtectedResourcesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+	organization := d.Get("organization").(string)
+	maxResults := d.Get("max_results").(int)
+
+	if maxResults <= 0 {
+		return nil, logical.CodedError(400, "max_results must be positive")
+	}
+
+	k, err := b.resolveKey(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	client, err := b.inventoryClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// The inventory client is not cached, but the request timeout still
+	// bounds the calls it makes.
+	if _, err := b.settings(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	summary, err := client.GetProtectedResourcesSummary(ctx, &inventorypb.GetProtectedResourcesSummaryRequest{
+		Name: k.CryptoKeyID + "/protectedResourcesSummary",
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to get protected resources summary: {{err}}", err)
+	}
+
+	data := map[string]interface{}{
+		"crypto_key_id":  k.CryptoKeyID,
+		"resource_count": summary.ResourceCount,
+		"project_count":  summary.ProjectCount,
+		"resource_types": summary.ResourceTypes,
+		"cloud_products": summary.CloudProducts,
+		"locations":      summary.Locations,
+	}
+
+	if organization != "" {
+		resources, truncated, err := searchProtectedResources(ctx, client, organization, k.CryptoKeyID, maxResults)
+		if err != nil {
+			return nil, err
+		}
+		data["resources"] = resources
+		data["truncated"] = truncated
+	}
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+// searchProtectedResources lists up to limit resources in the organization
+// which are protected by the crypto key, and returns whether there were more.
+func searchProtectedResources(ctx context.Context, client *inventory.KeyTrackingClient, organization, cryptoKeyID string, limit int) ([]map[string]interface{}, bool, error) {
+	resources := make([]map[string]interface{}, 0)
+	it := client.SearchProtectedResources(ctx, &inventorypb.SearchProtectedResourcesRequest{
+		Scope:     "organizations/" + organization,
+		CryptoKey: cryptoKeyID,
+	})
+	for {
+		r, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				return resources, false, nil
+			}
+			return nil, false, wrapKMSError("failed to search protected resources: {{err}}", err)
+		}
+		if len(resources) == limit {
+			return resources, true, nil
+		}
+
+		resource := map[string]interface{}{
+			"name":                r.Name,
+			"project":             r.ProjectId,
+			"cloud_product":       r.CloudProduct,
+			"resource_type":       r.ResourceType,
+			"location":            r.Location,
+			"crypto_key_versions": r.CryptoKeyVersions,
+		}
+		if r.CreateTime != nil {
+			resource["create_time_seconds"] = r.CreateTime.Seconds
+		}
+		resources = append(resources, resource)
+	}
+}
+
+// inventoryClient creates a client for the Cloud KMS Inventory API with the
+// same credentials as the key's KMS client. The client is only used for a
+// single request, so unlike the KMS client it is not cached.
+func (b *backend) inventoryClient(ctx context.Context, s logical.Storage, k *Key) (*inventory.KeyTrackingClient, error) {
+	opts, err := b.apiClientOptions(ctx, s, clientKey{
+		serviceAccount: k.ImpersonateServiceAccount,
+		profile:        k.ConfigName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := inventory.NewKeyTrackingClient(b.ctx, opts...)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create KMS inventory client: {{err}}", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathKeysProtectedResources_Read(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "keys/my-key/protected-resources")
+	})
+
+	t.Run("not_exist", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/my-key/protected-resources",
+		})
+		if err != logical.ErrInvalidRequest {
+			t.Errorf("expected %q to be %q", err, logical.ErrInvalidRequest)
+		}
+	})
+
+	t.Run("invalid_max_results", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/my-key/protected-resources",
+			Data: map[string]interface{}{
+				"max_results": 0,
+			},
+		})
+		if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+			t.Errorf("expected %q to be a 400", err)
+		}
+	})
+}
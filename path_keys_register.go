@@ -5,12 +5,13 @@ package gcpkms
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
-	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func (b *backend) pathKeysRegister() *framework.Path {
@@ -30,6 +31,20 @@ encryption and decryption in Vault.
 
 To have Vault create a crypto key, use the create method instead. This function
 is for existing crypto keys which you now want to manage via Vault.
+
+    $ vault write gcpkms/keys/register/my-key \
+        crypto_key="projects/my-project/locations/global/keyRings/vault/cryptoKeys/my-key"
+
+The crypto key may also be given by name along with its key ring:
+
+    $ vault write gcpkms/keys/register/my-key \
+        location="global" \
+        key_ring="vault" \
+        crypto_key="my-key"
+
+Registering a name which is already registered points it at the given crypto
+key. Because the endpoint supports existence checks, this requires the "update"
+capability on the path, while registering a new name requires "create".
 `,
 
 		Fields: map[string]*framework.FieldSchema{
@@ -45,9 +60,51 @@ the underlying crypto key when encrypting or decrypting data.
 				Type: framework.TypeString,
 				Description: `
 Full resource ID of the crypto key including the project, location, key ring,
-and crypto key like "projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s". This
-crypto key must already exist in Google Cloud KMS unless verify is set to
-"false".
+and crypto key like "projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", or
+the name of the crypto key when key_ring is also given. This crypto key must
+already exist in Google Cloud KMS unless verify is set to "false".
+`,
+			},
+
+			"key_ring": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Full Google Cloud resource ID of the key ring with the project and location, or
+the name of the key ring when location is also given. If given, crypto_key must
+be the name of the crypto key rather than its full resource ID.
+`,
+			},
+
+			"project": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud project of the key ring. This is only used when key_ring is a key
+ring name, and defaults to the project of the configured credentials.
+`,
+			},
+
+			"location": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Google Cloud location of the key ring (e.g. "global" or "us-east1"). This is
+required when key_ring is a key ring name.
+`,
+			},
+
+			"config_name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the config profile to use for all calls on this key, in place of the
+credentials and settings in the config. The profile must already exist.
+`,
+			},
+
+			"impersonate_service_account": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Email of a service account to impersonate for all calls on this key, such as
+one in the key's own project. The configured credentials need the
+"roles/iam.serviceAccountTokenCreator" role on it.
 `,
 			},
 
@@ -56,13 +113,18 @@ crypto key must already exist in Google Cloud KMS unless verify is set to
 				Default: true,
 				Description: `
 Verify that the given Google Cloud KMS crypto key exists and is accessible
-before creating the storage entry in Vault. Set this to "false" if the key will
-not exist at creation time.
+before creating the storage entry in Vault. Vault also checks that the
+configured credentials have the permissions required to use the key, and
+returns a warning naming any missing permissions. Set this to "false" if the
+key will not exist at creation time.
 `,
 			},
 		},
 
+		ExistenceCheck: b.pathKeysExistenceCheck,
+
 		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: withFieldValidator(b.pathKeysRegisterWrite),
 			logical.UpdateOperation: withFieldValidator(b.pathKeysRegisterWrite),
 		},
 	}
@@ -75,30 +137,94 @@ func (b *backend) pathKeysRegisterWrite(ctx context.Context, req *logical.Reques
 	cryptoKey := d.Get("crypto_key").(string)
 	verify := d.Get("verify").(bool)
 
+	if err := b.checkNewKeyName(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+
+	unlock := b.lockKey(key)
+	defer unlock()
+
+	keyRing, err := b.keyRingFromFields(ctx, req.Storage, d)
+	if err != nil {
+		return nil, err
+	}
+	if keyRing != "" {
+		if strings.Contains(cryptoKey, "/") {
+			return nil, logical.CodedError(400, "crypto_key must be the name of "+
+				"the crypto key, not its full resource ID, when key_ring is given")
+		}
+		if !resourceIDRegex.MatchString(cryptoKey) {
+			return nil, logical.CodedError(400, fmt.Sprintf("invalid crypto key "+
+				"name %q, must be 1-63 letters, numbers, underscores, or hyphens", cryptoKey))
+		}
+		cryptoKey = fmt.Sprintf("%s/cryptoKeys/%s", keyRing, cryptoKey)
+	}
+	if err := b.checkKeyPolicy(ctx, req.Storage, cryptoKey); err != nil {
+		return nil, err
+	}
+
+	k := &Key{
+		Name:                      key,
+		CryptoKeyID:               cryptoKey,
+		ImpersonateServiceAccount: strings.TrimSpace(d.Get("impersonate_service_account").(string)),
+		ConfigName:                d.Get("config_name").(string),
+	}
+	if k.ConfigName != "" {
+		if err := b.checkConfigProfile(ctx, req.Storage, k.ConfigName); err != nil {
+			return nil, err
+		}
+	}
+
+	// The protection level and algorithm of the crypto key can only be
+	// checked against the key policy by reading it.
+	config, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if !verify && config.restrictsCryptoKeys() {
+		return nil, logical.CodedError(400, "verify cannot be false when the "+
+			"key policy restricts protection levels or algorithms")
+	}
+
+	var warnings []string
 	if verify {
-		kmsClient, closer, err := b.KMSClient(req.Storage)
+		kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
 		if err != nil {
 			return nil, err
 		}
 		defer closer()
 
-		if _, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		ctx, cancel := b.kmsContext(ctx)
+		defer cancel()
+
+		ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
 			Name: cryptoKey,
-		}); err != nil {
-			return nil, errwrap.Wrapf("failed to read crypto key: {{err}}", err)
+		})
+		if err != nil {
+			return nil, wrapKMSError("failed to read crypto key: {{err}}", err)
 		}
-	}
+		if err := config.checkCryptoKey(ck); err != nil {
+			return nil, err
+		}
+		k.Expected = newKeyExpectation(ck)
 
-	entry, err := logical.StorageEntryJSON("keys/"+key, &Key{
-		Name:        key,
-		CryptoKeyID: cryptoKey,
-	})
-	if err != nil {
-		return nil, errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+		// Report any cryptographic operations which will fail due to missing
+		// permissions, rather than waiting for the first use to fail.
+		r, err := testKeyPermissions(ctx, kmsClient, cryptoKey, keyOperationPermissions(ck.Purpose, true))
+		if err != nil {
+			return nil, err
+		}
+		warnings = r.Warnings()
 	}
-	if err := req.Storage.Put(ctx, entry); err != nil {
-		return nil, errwrap.Wrapf("failed to write to storage: {{err}}", err)
+
+	if err := b.putKey(ctx, req.Storage, k); err != nil {
+		return nil, err
 	}
 
+	if len(warnings) > 0 {
+		return &logical.Response{
+			Warnings: warnings,
+		}, nil
+	}
 	return nil, nil
 }
@@ -0,0 +1,222 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iterator"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func (b *backend) pathKeysRegisterAll() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/register-all$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "register",
+			OperationSuffix: "all-keys",
+		},
+
+		HelpSynopsis: "Register all existing crypto keys in a key ring",
+		HelpDescription: `
+Registers every crypto key in the given Google Cloud KMS key ring which matches
+the optional name filter and label selector. Each crypto key is registered as a
+Vault key with the same name as the crypto key.
+
+    $ vault write gcpkms/keys/register-all \
+        key_ring=projects/my-project/locations/us-east1/keyRings/my-keyring \
+        name_filter="^app-" \
+        labels=env=prod
+
+Crypto keys whose name is already registered in Vault are skipped. Set dry_run
+to "true" to report which keys would be registered without changing anything.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key_ring": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Full resource ID of the key ring including the project and location like
+"projects/my-project/locations/global/keyRings/my-keyring". This field is
+required.
+`,
+			},
+
+			"name_filter": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Regular expression which the crypto key name must match to be registered. The
+expression is matched against the crypto key name only, not the full resource
+ID. If unspecified, all crypto keys are matched.
+`,
+			},
+
+			"labels": &framework.FieldSchema{
+				Type: framework.TypeKVPairs,
+				Description: `
+Labels which the crypto key must have to be registered, specified as key=value
+pairs. A crypto key matches only if it has all the given labels with the given
+values.
+`,
+			},
+
+			"dry_run": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If true, report the keys which would be registered without registering them.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: withFieldValidator(b.pathKeysRegisterAllWrite),
+		},
+	}
+}
+
+// pathKeysRegisterAllWrite corresponds to PUT/POST gcpkms/keys/register-all
+// and registers all matching crypto keys in a key ring for use in Vault.
+func (b *backend) pathKeysRegisterAllWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keyRing := d.Get("key_ring").(string)
+	labels := d.Get("labels").(map[string]string)
+	dryRun := d.Get("dry_run").(bool)
+
+	if keyRing == "" {
+		return nil, errMissingFields("key_ring")
+	}
+	if err := b.checkKeyPolicy(ctx, req.Storage, keyRing); err != nil {
+		return nil, err
+	}
+
+	var nameFilter *regexp.Regexp
+	if v := d.Get("name_filter").(string); v != "" {
+		var err error
+		nameFilter, err = regexp.Compile(v)
+		if err != nil {
+			return nil, logical.CodedError(400, fmt.Sprintf("invalid name_filter: %s", err))
+		}
+	}
+
+	existing, err := b.Keys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	registered := make(map[string]bool, len(existing))
+	for _, k := range existing {
+		registered[k] = true
+	}
+
+	aliases, err := req.Storage.List(ctx, "aliases/")
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list key aliases: {{err}}", err)
+	}
+	aliased := make(map[string]bool, len(aliases))
+	for _, a := range aliases {
+		aliased[a] = true
+	}
+
+	config, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KMSClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	var keys []*Key
+	skipped := make(map[string]string)
+	it := kmsClient.ListCryptoKeys(ctx, &kmspb.ListCryptoKeysRequest{
+		Parent: keyRing,
+	})
+	for {
+		ck, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			return nil, wrapKMSError("failed to list crypto keys: {{err}}", err)
+		}
+
+		name := path.Base(ck.Name)
+		if nameFilter != nil && !nameFilter.MatchString(name) {
+			continue
+		}
+		if !labelsMatch(ck.Labels, labels) {
+			continue
+		}
+		if registered[name] {
+			skipped[name] = "a key with this name is already registered in Vault"
+			continue
+		}
+		if reservedKeyNames[name] {
+			skipped[name] = "the name is reserved and cannot be used as a key name"
+			continue
+		}
+		if aliased[name] {
+			skipped[name] = "an alias with this name already exists in Vault"
+			continue
+		}
+		if err := config.checkCryptoKey(ck); err != nil {
+			skipped[name] = err.Error()
+			continue
+		}
+
+		keys = append(keys, &Key{
+			Name:        name,
+			CryptoKeyID: ck.Name,
+			Expected:    newKeyExpectation(ck),
+		})
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !dryRun {
+			// A key may have been registered with the name since the list
+			ok, err := b.putNewKey(ctx, req.Storage, k)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				skipped[k.Name] = "a key with this name is already registered in Vault"
+				continue
+			}
+		}
+		names = append(names, k.Name)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"registered": names,
+			"skipped":    skipped,
+			"dry_run":    dryRun,
+		},
+	}, nil
+}
+
+// labelsMatch returns true if the labels contain every key and value in the
+// selector.
+func labelsMatch(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
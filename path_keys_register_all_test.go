@@ -0,0 +1,145 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathKeysRegisterAll_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/register-all")
+	})
+
+	t.Run("missing_key_ring", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		if _, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/register-all",
+		}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+	defer cleanup()
+
+	name := path.Base(cryptoKey)
+	keyRing := path.Dir(path.Dir(cryptoKey))
+
+	cases := []struct {
+		name       string
+		data       map[string]interface{}
+		registered bool
+	}{
+		{
+			"all",
+			map[string]interface{}{},
+			true,
+		},
+		{
+			"name_filter_match",
+			map[string]interface{}{
+				"name_filter": "^" + name + "$",
+			},
+			true,
+		},
+		{
+			"name_filter_no_match",
+			map[string]interface{}{
+				"name_filter": "^not-a-real-key$",
+			},
+			false,
+		},
+		{
+			"labels_no_match",
+			map[string]interface{}{
+				"labels": map[string]interface{}{"not": "present"},
+			},
+			false,
+		},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		dryRun := dryRun
+
+		t.Run(fmt.Sprintf("dry_run_%t", dryRun), func(t *testing.T) {
+			for _, tc := range cases {
+				tc := tc
+
+				t.Run(tc.name, func(t *testing.T) {
+
+					b, storage := testBackend(t)
+
+					data := map[string]interface{}{
+						"key_ring": keyRing,
+						"dry_run":  dryRun,
+					}
+					for k, v := range tc.data {
+						data[k] = v
+					}
+
+					ctx := context.Background()
+					resp, err := b.HandleRequest(ctx, &logical.Request{
+						Storage:   storage,
+						Operation: logical.UpdateOperation,
+						Path:      "keys/register-all",
+						Data:      data,
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					registered := resp.Data["registered"].([]string)
+					if v := strutil.StrListContains(registered, name); v != tc.registered {
+						t.Errorf("expected %q registered to be %t: %q", name, tc.registered, registered)
+					}
+
+					_, err = b.Key(ctx, storage, name)
+					if exp := tc.registered && !dryRun; (err == nil) != exp {
+						t.Errorf("expected key to exist in storage to be %t: %v", exp, err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestLabelsMatch(t *testing.T) {
+
+	labels := map[string]string{"env": "prod", "team": "a"}
+
+	cases := []struct {
+		name     string
+		selector map[string]string
+		match    bool
+	}{
+		{"nil", nil, true},
+		{"subset", map[string]string{"env": "prod"}, true},
+		{"all", map[string]string{"env": "prod", "team": "a"}, true},
+		{"wrong_value", map[string]string{"env": "dev"}, false},
+		{"missing_key", map[string]string{"owner": "a"}, false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			if v := labelsMatch(labels, tc.selector); v != tc.match {
+				t.Errorf("expected %t to be %t", v, tc.match)
+			}
+		})
+	}
+}
@@ -16,6 +16,50 @@ func TestPathKeysRegister_Write(t *testing.T) {
 		testFieldValidation(t, logical.UpdateOperation, "keys/register/my-key")
 	})
 
+	t.Run("key_ring_with_full_crypto_key", func(t *testing.T) {
+		b, storage := testBackend(t)
+		if _, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/register/my-key",
+			Data: map[string]interface{}{
+				"key_ring":   "projects/my-project/locations/global/keyRings/kr",
+				"crypto_key": "projects/my-project/locations/global/keyRings/kr/cryptoKeys/ck",
+				"verify":     false,
+			},
+		}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("structured_key_ring", func(t *testing.T) {
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/register/my-key",
+			Data: map[string]interface{}{
+				"project":    "my-project",
+				"location":   "global",
+				"key_ring":   "kr",
+				"crypto_key": "ck",
+				"verify":     false,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := b.Key(ctx, storage, "my-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := k.CryptoKeyID, "projects/my-project/locations/global/keyRings/kr/cryptoKeys/ck"; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+	})
+
 	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
 	defer cleanup()
 
@@ -88,3 +132,34 @@ func TestPathKeysRegister_Write(t *testing.T) {
 		}
 	})
 }
+
+func TestPathKeysRegister_ExistenceCheck(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.CreateOperation,
+		Path:      "keys/register/my-key",
+	}
+
+	checkFound, exists, err := b.HandleExistenceCheck(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkFound || exists {
+		t.Errorf("expected check to be found and key not to exist: %t %t", checkFound, exists)
+	}
+
+	if err := b.putKey(ctx, storage, &Key{Name: "my-key", CryptoKeyID: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exists, err = b.HandleExistenceCheck(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Errorf("expected key to exist")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathKeysDeregistered() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/deregistered/?$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "list",
+			OperationSuffix: "deregistered-keys",
+		},
+
+		HelpSynopsis: "List soft-deleted keys",
+		HelpDescription: `
+List the keys which were deregistered with soft_delete set to true. These keys
+can be restored with the "keys/restore/:key" endpoint.
+
+    $ vault list gcpkms/keys/deregistered
+`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: withFieldValidator(b.pathKeysDeregisteredList),
+		},
+	}
+}
+
+// pathKeysDeregisteredList corresponds to LIST gcpkms/keys/deregistered and is
+// used to list the tombstones of soft-deleted keys.
+func (b *backend) pathKeysDeregisteredList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keys, err := req.Storage.List(ctx, "deregistered/")
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list deregistered keys: {{err}}", err)
+	}
+
+	keyInfo := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		dk, err := b.deregisteredKey(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if dk == nil {
+			continue
+		}
+
+		keyInfo[key] = map[string]interface{}{
+			"crypto_key":      dk.CryptoKeyID,
+			"deregistered_at": dk.DeregisteredAt.Unix(),
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *backend) pathKeysRestore() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/restore/" + framework.GenericNameRegex("key"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "restore",
+			OperationSuffix: "key",
+		},
+
+		HelpSynopsis: "Restore a soft-deleted key",
+		HelpDescription: `
+Restore a key which was deregistered with soft_delete set to true. The key is
+registered again with the same crypto key and configuration it had when it was
+deregistered.
+
+    $ vault write -f gcpkms/keys/restore/my-key
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the deregistered key to restore. A key with this name must not already
+be registered in Vault.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: withFieldValidator(b.pathKeysRestoreWrite),
+		},
+	}
+}
+
+// pathKeysRestoreWrite corresponds to PUT/POST gcpkms/keys/restore/:key and
+// registers a soft-deleted key again.
+func (b *backend) pathKeysRestoreWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+
+	unlock := b.lockKey(key)
+	defer unlock()
+
+	dk, err := b.deregisteredKey(ctx, req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if dk == nil {
+		return nil, logical.CodedError(404, fmt.Sprintf("no deregistered key named %q", key))
+	}
+	if err := b.checkNewKeyName(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+
+	if _, err := b.Key(ctx, req.Storage, key); err != ErrKeyNotFound {
+		if err != nil {
+			return nil, err
+		}
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"a key named %q is already registered - deregister it first", key))
+	}
+
+	if err := b.putKey(ctx, req.Storage, dk.Key); err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Delete(ctx, "deregistered/"+key); err != nil {
+		return nil, errwrap.Wrapf("failed to delete from storage: {{err}}", err)
+	}
+	return nil, nil
+}
+
+// deregisteredKey retrieves the tombstone of the named key from the storage
+// backend, or nil if one does not exist.
+func (b *backend) deregisteredKey(ctx context.Context, s logical.Storage, key string) (*DeregisteredKey, error) {
+	entry, err := s.Get(ctx, "deregistered/"+key)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to retrieve deregistered key %q: {{err}}", key), err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result DeregisteredKey
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to decode entry for %q: {{err}}", key), err)
+	}
+	return &result, nil
+}
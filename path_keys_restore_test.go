@@ -0,0 +1,178 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathKeysRestore_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/restore/my-key")
+		testFieldValidation(t, logical.ListOperation, "keys/deregistered/")
+	})
+
+	t.Run("soft_delete_and_restore", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key", "crypto_key_id":"foo", "min_version":3}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/deregister/my-key",
+			Data: map[string]interface{}{
+				"soft_delete": true,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := b.Key(ctx, storage, "my-key"); err != ErrKeyNotFound {
+			t.Fatalf("expected key to be deregistered: %v", err)
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keys/deregistered/",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := resp.Data["keys"], []string{"my-key"}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/restore/my-key",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := b.Key(ctx, storage, "my-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := (&Key{Name: "my-key", CryptoKeyID: "foo", MinVersion: 3, Version: 1}); !reflect.DeepEqual(k, exp) {
+			t.Errorf("expected %#v to be %#v", k, exp)
+		}
+
+		if dk, err := b.deregisteredKey(ctx, storage, "my-key"); err != nil || dk != nil {
+			t.Errorf("expected tombstone to be removed: %#v %v", dk, err)
+		}
+	})
+
+	t.Run("not_deregistered", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		if _, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/restore/my-key",
+		}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("already_registered", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		for _, k := range []string{"keys/my-key", "deregistered/my-key"} {
+			if err := storage.Put(ctx, &logical.StorageEntry{
+				Key:   k,
+				Value: []byte(`{"name":"my-key", "crypto_key_id":"foo"}`),
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/restore/my-key",
+		}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
+
+func TestPathKeysDeregister_SoftDelete(t *testing.T) {
+
+	cases := []struct {
+		name      string
+		data      map[string]interface{}
+		tombstone bool
+	}{
+		{
+			"destroy_versions",
+			map[string]interface{}{
+				"soft_delete":      true,
+				"destroy_versions": true,
+			},
+			false,
+		},
+		{
+			"tombstone_exists",
+			map[string]interface{}{
+				"soft_delete": true,
+			},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			entries := []string{"keys/my-key"}
+			if tc.tombstone {
+				entries = append(entries, "deregistered/my-key")
+			}
+			for _, k := range entries {
+				if err := storage.Put(ctx, &logical.StorageEntry{
+					Key:   k,
+					Value: []byte(`{"name":"my-key", "crypto_key_id":"foo"}`),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "keys/deregister/my-key",
+				Data:      tc.data,
+			})
+			if herr, ok := err.(logical.HTTPCodedError); !ok || herr.Code() != 400 {
+				t.Fatalf("expected 400 error, got %#v", err)
+			}
+
+			if _, err := b.Key(ctx, storage, "my-key"); err != nil {
+				t.Errorf("expected key to still be registered: %v", err)
+			}
+		})
+	}
+}
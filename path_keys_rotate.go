@@ -5,13 +5,21 @@ package gcpkms
 
 import (
 	"context"
+	"fmt"
 	"path"
+	"strings"
+	"time"
 
-	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+var (
+	// cryptoKeyVersionPollInterval is the time between reads of a crypto key
+	// version while waiting for it to be enabled.
+	cryptoKeyVersionPollInterval = 1 * time.Second
 )
 
 const (
@@ -42,6 +50,15 @@ encryptions.
 It can take up to 2 hours for a new crypto key version to become the primary,
 so be sure to issue a read operation if you require new data to be encrypted
 with this key.
+
+If the key has a rotation_schedule, the next scheduled rotation is moved to one
+period after this rotation. If the key has auto_bump_min_version enabled, its
+min_version is raised to the new version less min_version_lag.
+
+New crypto key versions, especially asymmetric and HSM-backed versions, can
+take time to become enabled. To wait until the new version is enabled:
+
+    $ vault write gcpkms/keys/rotate/my-key wait=true wait_timeout=2m
 `,
 
 		Fields: map[string]*framework.FieldSchema{
@@ -50,6 +67,24 @@ with this key.
 				Description: `
 Name of the key to rotate. This key must already be registered with Vault and
 point to a valid Google Cloud KMS crypto key.
+`,
+			},
+
+			"wait": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If true, wait until the new crypto key version is enabled before returning.
+`,
+			},
+
+			"wait_timeout": &framework.FieldSchema{
+				Type:    framework.TypeDurationSecond,
+				Default: 60,
+				Description: `
+Maximum amount of time to wait for the new crypto key version to be enabled
+when wait is true. If the version is not enabled in time, the response includes
+its current state and a warning. The default is 60 seconds.
 `,
 			},
 		},
@@ -65,49 +100,134 @@ point to a valid Google Cloud KMS crypto key.
 // version to the primary for future encryption.
 func (b *backend) pathKeysRotateWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	key := d.Get("key").(string)
+	wait := d.Get("wait").(bool)
+	waitTimeout := time.Duration(d.Get("wait_timeout").(int)) * time.Second
 
-	kmsClient, closer, err := b.KMSClient(req.Storage)
+	entry, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, entry)
 	if err != nil {
 		return nil, err
 	}
 	defer closer()
 
-	entry, err := b.Key(ctx, req.Storage, key)
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	ckv, err := rotateCryptoKey(ctx, kmsClient, entry.CryptoKeyID)
+	b.invalidateCryptoKey(entry.CryptoKeyID)
 	if err != nil {
-		if err == ErrKeyNotFound {
-			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
-		}
 		return nil, err
 	}
 
+	// Return JUST the version, not the full resource ID
+	cryptoKeyVersion := path.Base(ckv.Name)
+
+	if err := b.recordRotation(ctx, req.Storage, entry, ckv, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	warnings := []string{primaryVersionWarning}
+	if wait && ckv.State != kmspb.CryptoKeyVersion_ENABLED {
+		ckv, err = waitForCryptoKeyVersion(ctx, kmsClient, ckv.Name, waitTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if ckv.State != kmspb.CryptoKeyVersion_ENABLED {
+			warnings = append(warnings, fmt.Sprintf("The crypto key version was "+
+				"not enabled within %s.", waitTimeout))
+		}
+	}
+
+	return &logical.Response{
+		Warnings: warnings,
+		Data: map[string]interface{}{
+			"key_version":        cryptoKeyVersion,
+			"crypto_key_version": ckv.Name,
+			"state":              strings.ToLower(ckv.State.String()),
+		},
+	}, nil
+}
+
+// waitForCryptoKeyVersion polls the crypto key version until it is enabled,
+// the timeout passes, or the context is cancelled. It returns the last read of
+// the crypto key version, which may not be enabled.
+func waitForCryptoKeyVersion(ctx context.Context, kmsClient keyManagementClient, name string, timeout time.Duration) (*kmspb.CryptoKeyVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(cryptoKeyVersionPollInterval)
+	defer ticker.Stop()
+
+	var ckv *kmspb.CryptoKeyVersion
+	for {
+		resp, err := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: name,
+		})
+		if err != nil {
+			// Return the last read if the timeout cancelled the request
+			if ckv != nil && ctx.Err() != nil {
+				return ckv, nil
+			}
+			return nil, wrapKMSError("failed to read crypto key version: {{err}}", err)
+		}
+		ckv = resp
+
+		if ckv.State == kmspb.CryptoKeyVersion_ENABLED {
+			return ckv, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ckv, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// rotateCryptoKey creates a new crypto key version and, for symmetric keys,
+// sets it as the primary version.
+func rotateCryptoKey(ctx context.Context, kmsClient keyManagementClient, cryptoKeyID string) (*kmspb.CryptoKeyVersion, error) {
 	// Create a new cyrpto key version
 	resp, err := kmsClient.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
-		Parent: entry.CryptoKeyID,
+		Parent: cryptoKeyID,
 		CryptoKeyVersion: &kmspb.CryptoKeyVersion{
 			State: kmspb.CryptoKeyVersion_ENABLED,
 		},
 	})
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to create new crypto key version: {{err}}", err)
+		return nil, wrapKMSError("failed to create new crypto key version: {{err}}", err)
 	}
 
-	// The API expects JUST the version, not the full resource ID
-	cryptoKeyVersion := path.Base(resp.Name)
-
 	// Set the new version as primary, only valid for symmetric keys
 	if resp.Algorithm == kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION {
 		if _, err := kmsClient.UpdateCryptoKeyPrimaryVersion(ctx, &kmspb.UpdateCryptoKeyPrimaryVersionRequest{
-			Name:               entry.CryptoKeyID,
-			CryptoKeyVersionId: cryptoKeyVersion,
+			Name:               cryptoKeyID,
+			CryptoKeyVersionId: path.Base(resp.Name),
 		}); err != nil {
-			return nil, errwrap.Wrapf("failed to update crypto key primary version: {{err}}", err)
+			return nil, wrapKMSError("failed to update crypto key primary version: {{err}}", err)
 		}
 	}
 
-	return &logical.Response{
-		Warnings: []string{primaryVersionWarning},
-		Data: map[string]interface{}{
-			"key_version": cryptoKeyVersion,
-		},
-	}, nil
+	return resp, nil
+}
+
+// recordRotation applies the rotation to the key's rotation schedule and
+// min_version policy, and saves the key if either changed. The key is reread
+// under its lock, and k is updated to the saved key.
+func (b *backend) recordRotation(ctx context.Context, s logical.Storage, k *Key, ckv *kmspb.CryptoKeyVersion, now time.Time) error {
+	cur, err := b.updateKey(ctx, s, k.Name, func(cur *Key) bool {
+		return cur.applyRotation(ckv, now)
+	})
+	if err != nil {
+		return err
+	}
+	*k = *cur
+	return nil
 }
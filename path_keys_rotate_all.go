@@ -0,0 +1,205 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// rotateAllConcurrency is the maximum number of keys rotated at once by
+// keys/rotate-all.
+const rotateAllConcurrency = 10
+
+func (b *backend) pathKeysRotateAll() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/rotate-all$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "rotate",
+			OperationSuffix: "all-keys",
+		},
+
+		HelpSynopsis: "Rotate all crypto keys to a new primary version",
+		HelpDescription: `
+Creates a new crypto key version for every key in Vault, as if
+"keys/rotate/:key" were called for each key. This is useful when a compromise
+requires every key to be rotated at once.
+
+    $ vault write -f gcpkms/keys/rotate-all
+
+To rotate only keys with a given purpose:
+
+    $ vault write gcpkms/keys/rotate-all purpose=encrypt_decrypt
+
+Keys are rotated concurrently. A failure to rotate one key does not stop the
+other keys from being rotated; the error is reported in the response for that
+key.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"purpose": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Purpose of the crypto keys to rotate. Options are "asymmetric_decrypt",
+"asymmetric_sign", and "encrypt_decrypt". If unspecified, all keys are rotated.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: withFieldValidator(b.pathKeysRotateAllWrite),
+		},
+	}
+}
+
+// pathKeysRotateAllWrite corresponds to PUT/POST gcpkms/keys/rotate-all and
+// creates a new crypto key version for every key.
+func (b *backend) pathKeysRotateAllWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	var purpose *kmspb.CryptoKey_CryptoKeyPurpose
+	if v, ok := d.GetOk("purpose"); ok {
+		p, ok := keyPurposes[strings.ToLower(v.(string))]
+		if !ok {
+			return nil, logical.CodedError(400, fmt.Sprintf(
+				"unknown purpose %q, valid purposes are %q", v, keyPurposeNames()))
+		}
+		purpose = &p
+	}
+
+	names, err := b.Keys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*Key
+	for _, name := range names {
+		k, err := b.Key(ctx, req.Storage, name)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				continue
+			}
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	summary := make(map[string]interface{}, len(keys))
+	if len(keys) == 0 {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"keys": summary,
+			},
+		}, nil
+	}
+
+	// Get the default client up front, so a credentials error fails fast and
+	// the request timeout is loaded. Each key uses the client for its own
+	// endpoint.
+	_, closer, err := b.KMSClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var warnings []string
+	wp := workerpool.New(rotateAllConcurrency)
+	for _, k := range keys {
+		k := k
+
+		wp.Submit(func() {
+			ckv, err := b.rotateKeyWithPurpose(ctx, req.Storage, k, purpose)
+			if err == nil && ckv != nil {
+				err = b.recordRotation(ctx, req.Storage, k, ckv, time.Now().UTC())
+				if err != nil {
+					err = errwrap.Wrapf("rotated but failed to record the rotation: {{err}}", err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				summary[k.Name] = map[string]interface{}{
+					"error": err.Error(),
+				}
+				warnings = append(warnings, fmt.Sprintf("failed to rotate key %q: %s", k.Name, err))
+				return
+			}
+
+			// The key did not match the purpose filter
+			if ckv == nil {
+				return
+			}
+
+			summary[k.Name] = map[string]interface{}{
+				"key_version":        path.Base(ckv.Name),
+				"crypto_key_version": ckv.Name,
+			}
+		})
+	}
+
+	wp.StopWait()
+	sort.Strings(warnings)
+
+	if len(summary) > 0 {
+		warnings = append(warnings, primaryVersionWarning)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"keys": summary,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// rotateKeyWithPurpose rotates the crypto key of the key with
+// rotateCryptoKeyWithPurpose, using the client for the key's endpoint.
+func (b *backend) rotateKeyWithPurpose(ctx context.Context, s logical.Storage, k *Key, purpose *kmspb.CryptoKey_CryptoKeyPurpose) (*kmspb.CryptoKeyVersion, error) {
+	kmsClient, closer, err := b.KeyKMSClient(ctx, s, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ckv, err := rotateCryptoKeyWithPurpose(ctx, kmsClient, k.CryptoKeyID, purpose)
+	b.invalidateCryptoKey(k.CryptoKeyID)
+	return ckv, err
+}
+
+// rotateCryptoKeyWithPurpose rotates the crypto key if purpose is nil or
+// matches the crypto key's purpose. It returns a nil version if the crypto key
+// was not rotated.
+func rotateCryptoKeyWithPurpose(ctx context.Context, kmsClient keyManagementClient, cryptoKeyID string, purpose *kmspb.CryptoKey_CryptoKeyPurpose) (*kmspb.CryptoKeyVersion, error) {
+	if purpose != nil {
+		ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+			Name: cryptoKeyID,
+		})
+		if err != nil {
+			return nil, wrapKMSError("failed to read crypto key: {{err}}", err)
+		}
+		if ck.Purpose != *purpose {
+			return nil, nil
+		}
+	}
+
+	return rotateCryptoKey(ctx, kmsClient, cryptoKeyID)
+}
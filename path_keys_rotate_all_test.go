@@ -0,0 +1,128 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestPathKeysRotateAll_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/rotate-all")
+	})
+
+	t.Run("invalid_purpose", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		if _, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/rotate-all",
+			Data: map[string]interface{}{
+				"purpose": "not-a-real-purpose",
+			},
+		}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("no_keys", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/rotate-all",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if keys := resp.Data["keys"].(map[string]interface{}); len(keys) != 0 {
+			t.Errorf("expected no keys to be rotated: %#v", keys)
+		}
+	})
+
+	symmetricKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+	defer cleanup()
+
+	asymmetricKey, cleanup := testCreateKMSCryptoKeyAsymmetricSign(t,
+		kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+	defer cleanup()
+
+	cases := []struct {
+		name    string
+		purpose string
+		exp     []string
+	}{
+		{
+			"all",
+			"",
+			[]string{"my-symmetric-key", "my-asymmetric-key"},
+		},
+		{
+			"purpose",
+			"asymmetric_sign",
+			[]string{"my-asymmetric-key"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			for name, cryptoKey := range map[string]string{
+				"my-symmetric-key":  symmetricKey,
+				"my-asymmetric-key": asymmetricKey,
+			} {
+				if err := storage.Put(ctx, &logical.StorageEntry{
+					Key:   "keys/" + name,
+					Value: []byte(`{"name":"` + name + `", "crypto_key_id":"` + cryptoKey + `"}`),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data := map[string]interface{}{}
+			if tc.purpose != "" {
+				data["purpose"] = tc.purpose
+			}
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "keys/rotate-all",
+				Data:      data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := resp.Data["keys"].(map[string]interface{})
+			if len(keys) != len(tc.exp) {
+				t.Fatalf("expected %q to be rotated: %#v", tc.exp, keys)
+			}
+			for _, name := range tc.exp {
+				info, ok := keys[name].(map[string]interface{})
+				if !ok {
+					t.Fatalf("expected %q to be rotated: %#v", name, keys)
+				}
+				if _, ok := info["key_version"]; !ok {
+					t.Errorf("expected key_version for %q: %#v", name, info)
+				}
+			}
+		})
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func TestPathKeysRotate_Write(t *testing.T) {
@@ -78,7 +80,42 @@ func TestPathKeysRotate_Write(t *testing.T) {
 				if v, exp := resp.Data["key_version"].(string), "2"; v != exp {
 					t.Errorf("expected %q to be %q", v, exp)
 				}
+				if v, exp := resp.Data["crypto_key_version"].(string), cryptoKey+"/cryptoKeyVersions/2"; v != exp {
+					t.Errorf("expected %q to be %q", v, exp)
+				}
 			})
 		}
 	})
+
+	t.Run("wait", func(t *testing.T) {
+
+		asymmetricKey, cleanup := testCreateKMSCryptoKeyAsymmetricSign(t,
+			kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256)
+		defer cleanup()
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-asymmetric-key",
+			Value: []byte(`{"name":"my-asymmetric-key", "crypto_key_id":"` + asymmetricKey + `"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/rotate/my-asymmetric-key",
+			Data: map[string]interface{}{
+				"wait":         true,
+				"wait_timeout": "5m",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v, exp := resp.Data["state"].(string), "enabled"; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+	})
 }
@@ -0,0 +1,96 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathKeysStats() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key") + "/stats",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "read",
+			OperationSuffix: "key-stats",
+		},
+
+		HelpSynopsis: "Read the usage statistics of the key",
+		HelpDescription: `
+Read how many encrypt, decrypt, reencrypt, sign, and verify operations have been
+done with the named key through Vault, and when each was last done. Keys which
+have not been used in a long time may be safe to trim or deregister.
+
+    $ vault read gcpkms/keys/my-key/stats
+
+Operations are counted in memory by the node which handles them and saved
+periodically by the active node of each cluster, so the most recent operations
+may not be saved yet. Performance standbys cannot save their counts, which are
+only included when reading from the standby itself, unless forward_usage is set
+in the config to forward counted operations to the active node.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: withFieldValidator(b.pathKeysStatsRead),
+		},
+	}
+}
+
+// pathKeysStatsRead corresponds to GET gcpkms/keys/:key/stats and is used to
+// read the usage statistics of the key.
+func (b *backend) pathKeysStatsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+
+	if _, err := b.Key(ctx, req.Storage, key); err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	u, err := b.KeyUsage(ctx, req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var total uint64
+	counts := make(map[string]interface{}, len(usageOperations))
+	lastUsedOps := make(map[string]interface{})
+	for _, op := range usageOperations {
+		counts[op] = u.Counts[op]
+		total += u.Counts[op]
+
+		if t, ok := u.LastUsed[op]; ok {
+			lastUsedOps[op] = t.Format(time.RFC3339)
+		}
+	}
+
+	data := map[string]interface{}{
+		"counts":     counts,
+		"total":      total,
+		"last_used":  lastUsedOps,
+		"never_used": total == 0,
+	}
+	if lastUsed := u.lastUsed(); !lastUsed.IsZero() {
+		data["last_used_time"] = lastUsed.Format(time.RFC3339)
+	}
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
@@ -6,13 +6,14 @@ package gcpkms
 import (
 	"context"
 	"fmt"
+	"path"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func TestPathKeys_List(t *testing.T) {
@@ -44,6 +45,125 @@ func TestPathKeys_List(t *testing.T) {
 	if v, exp := resp.Data["keys"].([]string), []string{"my-key"}; !reflect.DeepEqual(v, exp) {
 		t.Errorf("expected %q to be %q", v, exp)
 	}
+	if _, ok := resp.Data["key_info"]; ok {
+		t.Errorf("expected no key_info: %#v", resp.Data)
+	}
+
+	t.Run("detailed", func(t *testing.T) {
+
+		b.recordUsage("my-key", "encrypt")
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keys",
+			Data: map[string]interface{}{
+				"detailed": true,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, ok := resp.Data["key_info"].(map[string]interface{})["my-key"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected key_info for my-key: %#v", resp.Data)
+		}
+		if v, exp := info["crypto_key_id"], "foo"; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+		if _, ok := info["last_used_time"]; !ok {
+			t.Errorf("expected last_used_time to be set: %#v", info)
+		}
+	})
+
+	t.Run("detailed_paged", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		for i := 0; i <= keysListDetailedMaxLimit; i++ {
+			if err := b.putKey(ctx, storage, &Key{Name: fmt.Sprintf("key-%04d", i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keys",
+			Data: map[string]interface{}{
+				"detailed": true,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := resp.Data["keys"].([]string)
+		if v, exp := len(keys), keysListDetailedMaxLimit; v != exp {
+			t.Fatalf("expected %d keys, got %d", exp, v)
+		}
+		if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], keys[len(keys)-1]) {
+			t.Errorf("expected a warning naming the next page, got %q", resp.Warnings)
+		}
+
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "keys",
+			Data: map[string]interface{}{
+				"detailed": true,
+				"after":    keys[len(keys)-1],
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := resp.Data["keys"].([]string), []string{fmt.Sprintf("key-%04d", keysListDetailedMaxLimit)}; !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+		if len(resp.Warnings) != 0 {
+			t.Errorf("expected no warnings on the last page, got %q", resp.Warnings)
+		}
+	})
+
+	t.Run("page_and_filter", func(t *testing.T) {
+
+		for _, k := range []*Key{
+			{Name: "team-a", Expected: &keyExpectation{Labels: map[string]string{"env": "prod"}}},
+			{Name: "team-b", Expected: &keyExpectation{Labels: map[string]string{"env": "dev"}}},
+			{Name: "team-c", Expected: &keyExpectation{Labels: map[string]string{"env": "prod"}}},
+		} {
+			if err := b.putKey(ctx, storage, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cases := []struct {
+			name string
+			data map[string]interface{}
+			exp  []string
+		}{
+			{"prefix", map[string]interface{}{"prefix": "team-"}, []string{"team-a", "team-b", "team-c"}},
+			{"limit", map[string]interface{}{"prefix": "team-", "limit": 2}, []string{"team-a", "team-b"}},
+			{"after", map[string]interface{}{"prefix": "team-", "limit": 2, "after": "team-b"}, []string{"team-c"}},
+			{"labels", map[string]interface{}{"labels": "env=prod"}, []string{"team-a", "team-c"}},
+		}
+
+		for _, tc := range cases {
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.ListOperation,
+				Path:      "keys",
+				Data:      tc.data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := resp.Data["keys"].([]string); !reflect.DeepEqual(v, tc.exp) {
+				t.Errorf("%s: expected %q to be %q", tc.name, v, tc.exp)
+			}
+		}
+	})
 }
 
 func TestPathKeys_Read(t *testing.T) {
@@ -93,6 +213,81 @@ func TestPathKeys_Read(t *testing.T) {
 		},
 	}
 
+	t.Run("adopt", func(t *testing.T) {
+
+		cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+		defer cleanup()
+
+		adoptCases := []struct {
+			name string
+			data map[string]interface{}
+			err  bool
+		}{
+			{
+				"no_adopt",
+				map[string]interface{}{},
+				true,
+			},
+			{
+				"adopt",
+				map[string]interface{}{
+					"adopt": true,
+				},
+				false,
+			},
+			{
+				"adopt_purpose_mismatch",
+				map[string]interface{}{
+					"adopt":     true,
+					"purpose":   "asymmetric_sign",
+					"algorithm": "ec_sign_p256_sha256",
+				},
+				true,
+			},
+		}
+
+		for _, tc := range adoptCases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+
+				b, storage := testBackend(t)
+
+				data := map[string]interface{}{
+					"key_ring":   path.Dir(path.Dir(cryptoKey)),
+					"crypto_key": path.Base(cryptoKey),
+				}
+				for k, v := range tc.data {
+					data[k] = v
+				}
+
+				ctx := context.Background()
+				if _, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.CreateOperation,
+					Path:      "keys/my-key",
+					Data:      data,
+				}); (err != nil) != tc.err {
+					t.Fatal(err)
+				}
+
+				k, err := b.Key(ctx, storage, "my-key")
+				if tc.err {
+					if err != ErrKeyNotFound {
+						t.Errorf("expected key to not be stored: %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v, exp := k.CryptoKeyID, cryptoKey; v != exp {
+					t.Errorf("expected %q to be %q", v, exp)
+				}
+			})
+		}
+	})
+
 	t.Run("group", func(t *testing.T) {
 		for _, tc := range cases {
 			tc := tc
@@ -116,6 +311,12 @@ func TestPathKeys_Read(t *testing.T) {
 					"id",
 					"primary_version",
 					"purpose",
+					"create_time_seconds",
+					"project",
+					"location",
+					"key_ring",
+					"crypto_key",
+					"version_template",
 				} {
 					if _, ok := resp.Data[v]; !ok {
 						t.Errorf("missing %q", v)
@@ -153,6 +354,15 @@ func TestPathKeys_Write(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"key_ring_no_exist_no_create",
+			map[string]interface{}{
+				"key_ring":        keyringNoExist,
+				"crypto_key":      "my-crypto-key",
+				"create_key_ring": false,
+			},
+			true,
+		},
 		{
 			"key_ring_exist_crypto_key_no_exist",
 			map[string]interface{}{
@@ -299,8 +509,111 @@ func TestPathKeys_Write(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"destroy_scheduled_duration",
+			map[string]interface{}{
+				"key_ring":                   keyringExist,
+				"crypto_key":                 "destroy_scheduled_duration",
+				"destroy_scheduled_duration": "720h",
+			},
+			false,
+		},
+		{
+			"algorithm_purpose_mismatch",
+			map[string]interface{}{
+				"key_ring":   keyringExist,
+				"crypto_key": "algorithm_purpose_mismatch",
+				"algorithm":  "rsa_sign_pss_2048_sha256",
+				"purpose":    "asymmetric_decrypt",
+			},
+			true,
+		},
+		{
+			"crypto_key_invalid_name",
+			map[string]interface{}{
+				"key_ring":   keyringExist,
+				"crypto_key": "not valid",
+			},
+			true,
+		},
+		{
+			"crypto_key_name_with_period",
+			map[string]interface{}{
+				"key_ring":   keyringExist,
+				"crypto_key": "name.with.period",
+			},
+			true,
+		},
+		{
+			"destroy_scheduled_duration_too_short",
+			map[string]interface{}{
+				"key_ring":                   keyringExist,
+				"crypto_key":                 "destroy_scheduled_duration_too_short",
+				"destroy_scheduled_duration": "1h",
+			},
+			true,
+		},
 	}
 
+	t.Run("dry_run", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "keys/my-key",
+			Data: map[string]interface{}{
+				"key_ring":   keyringExist,
+				"crypto_key": "dry_run",
+				"dry_run":    true,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cryptoKey := keyringExist + "/cryptoKeys/dry_run"
+		if v, exp := resp.Data["crypto_key_id"], cryptoKey; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+		if v, exp := resp.Data["key_ring_exists"], true; v != exp {
+			t.Errorf("expected %t to be %t", v, exp)
+		}
+
+		if _, err := b.Key(ctx, storage, "my-key"); err != ErrKeyNotFound {
+			t.Errorf("expected key to not be stored: %v", err)
+		}
+
+		kmsClient := testKMSClient(t)
+		if _, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+			Name: cryptoKey,
+		}); err == nil {
+			t.Errorf("expected crypto key to not be created")
+		}
+	})
+
+	t.Run("dry_run_invalid_name", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		// The crypto key name defaults to the key name, which may contain
+		// periods that crypto key IDs may not
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "keys/my.key",
+			Data: map[string]interface{}{
+				"key_ring": keyringExist,
+				"dry_run":  true,
+			},
+		})
+		if herr, ok := err.(logical.HTTPCodedError); !ok || herr.Code() != 400 {
+			t.Fatalf("expected 400 error, got %#v", err)
+		}
+	})
+
 	t.Run("group", func(t *testing.T) {
 		for _, tc := range cases {
 			tc := tc
@@ -310,12 +623,13 @@ func TestPathKeys_Write(t *testing.T) {
 				b, storage := testBackend(t)
 
 				ctx := context.Background()
-				if _, err := b.HandleRequest(ctx, &logical.Request{
+				resp, err := b.HandleRequest(ctx, &logical.Request{
 					Storage:   storage,
 					Operation: logical.CreateOperation,
 					Path:      "keys/my-key",
 					Data:      tc.data,
-				}); err != nil {
+				})
+				if err != nil {
 					if tc.err {
 						return
 					}
@@ -324,6 +638,15 @@ func TestPathKeys_Write(t *testing.T) {
 
 				kmsClient := testKMSClient(t)
 				cryptoKey := fmt.Sprintf("%s/cryptoKeys/%s", tc.data["key_ring"], tc.data["crypto_key"])
+				if v, exp := resp.Data["id"], cryptoKey; v != exp {
+					t.Errorf("expected %q to be %q", v, exp)
+				}
+				for _, v := range []string{"algorithm", "protection_level", "create_time_seconds"} {
+					if _, ok := resp.Data[v]; !ok {
+						t.Errorf("missing %q", v)
+					}
+				}
+
 				ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
 					Name: cryptoKey,
 				})
@@ -337,6 +660,12 @@ func TestPathKeys_Write(t *testing.T) {
 					}
 				}
 
+				if _, ok := tc.data["destroy_scheduled_duration"]; ok {
+					if v, exp := ck.DestroyScheduledDuration.GetSeconds(), int64(720*60*60); v != exp {
+						t.Errorf("expected %d to be %d", v, exp)
+					}
+				}
+
 				if exp, ok := tc.data["protection_level"]; ok {
 					vt := ck.VersionTemplate
 					if vt == nil {
@@ -448,3 +777,161 @@ func TestPathKeys_Delete(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateLabels(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		labels map[string]string
+		err    bool
+	}{
+		{
+			"empty",
+			map[string]string{},
+			false,
+		},
+		{
+			"valid",
+			map[string]string{"team": "security", "cost-center": "1234", "empty": ""},
+			false,
+		},
+		{
+			"uppercase_key",
+			map[string]string{"Team": "security"},
+			true,
+		},
+		{
+			"leading_number_key",
+			map[string]string{"1team": "security"},
+			true,
+		},
+		{
+			"invalid_value",
+			map[string]string{"team": "Security Team"},
+			true,
+		},
+		{
+			"key_too_long",
+			map[string]string{strings.Repeat("a", 64): ""},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			if err := validateLabels(tc.labels); (err != nil) != tc.err {
+				t.Errorf("expected error to be %t: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestAlgorithmPurpose(t *testing.T) {
+
+	for name, algorithm := range keyAlgorithms {
+		name, algorithm := name, algorithm
+
+		t.Run(name, func(t *testing.T) {
+
+			var exp kmspb.CryptoKey_CryptoKeyPurpose
+			switch {
+			case name == "symmetric_encryption":
+				exp = kmspb.CryptoKey_ENCRYPT_DECRYPT
+			case strings.Contains(name, "_sign_"):
+				exp = kmspb.CryptoKey_ASYMMETRIC_SIGN
+			case strings.Contains(name, "_decrypt_"):
+				exp = kmspb.CryptoKey_ASYMMETRIC_DECRYPT
+			}
+
+			if v := algorithmPurpose(algorithm); v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+		})
+	}
+}
+
+func TestCheckKeyPurpose(t *testing.T) {
+
+	cases := []struct {
+		name      string
+		purpose   kmspb.CryptoKey_CryptoKeyPurpose
+		operation string
+		err       bool
+	}{
+		{
+			"symmetric_encrypt",
+			kmspb.CryptoKey_ENCRYPT_DECRYPT,
+			"encrypt",
+			false,
+		},
+		{
+			"symmetric_sign",
+			kmspb.CryptoKey_ENCRYPT_DECRYPT,
+			"sign",
+			true,
+		},
+		{
+			"asymmetric_sign_encrypt",
+			kmspb.CryptoKey_ASYMMETRIC_SIGN,
+			"encrypt",
+			true,
+		},
+		{
+			"asymmetric_sign_pubkey",
+			kmspb.CryptoKey_ASYMMETRIC_SIGN,
+			"pubkey",
+			false,
+		},
+		{
+			"asymmetric_decrypt_reencrypt",
+			kmspb.CryptoKey_ASYMMETRIC_DECRYPT,
+			"reencrypt",
+			true,
+		},
+		{
+			"unspecified",
+			kmspb.CryptoKey_CRYPTO_KEY_PURPOSE_UNSPECIFIED,
+			"sign",
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			err := checkKeyPurpose("my-key", &kmspb.CryptoKey{
+				Purpose: tc.purpose,
+			}, tc.operation)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if err == nil {
+				return
+			}
+
+			if code := err.(logical.HTTPCodedError).Code(); code != 400 {
+				t.Errorf("expected %d to be %d", code, 400)
+			}
+			if !strings.Contains(err.Error(), purposeToString(tc.purpose)) {
+				t.Errorf("expected %q to name the key purpose", err)
+			}
+		})
+	}
+}
+
+func TestOAEPHashes(t *testing.T) {
+
+	for algorithm, h := range oaepHashes {
+		if v, exp := algorithmPurpose(algorithm), kmspb.CryptoKey_ASYMMETRIC_DECRYPT; v != exp {
+			t.Errorf("%s: expected %q to be %q", algorithm, v, exp)
+		}
+		if v := oaepHashToString(h); !strings.HasSuffix(strings.ToLower(algorithm.String()), v) {
+			t.Errorf("%s: expected hash %q to match the algorithm", algorithm, v)
+		}
+	}
+}
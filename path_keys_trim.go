@@ -6,6 +6,8 @@ package gcpkms
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,9 +17,19 @@ import (
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
+	"cloud.google.com/go/kms/apiv1/kmspb"
 	multierror "github.com/hashicorp/go-multierror"
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+const (
+	// trimActionDestroySchedule schedules trimmed crypto key versions for
+	// destruction.
+	trimActionDestroySchedule = "destroy_schedule"
+
+	// trimActionDisable disables trimmed crypto key versions.
+	trimActionDisable = "disable"
 )
 
 func (b *backend) pathKeysTrim() *framework.Path {
@@ -29,7 +41,7 @@ func (b *backend) pathKeysTrim() *framework.Path {
 			OperationVerb:   "trim",
 		},
 
-		HelpSynopsis: "Delete old crypto key versions from Google Cloud KMS",
+		HelpSynopsis: "Disable or delete old crypto key versions from Google Cloud KMS",
 		HelpDescription: `
 This endpoint deletes old crypto key versions from Google Cloud KMS that are
 older than the key's min_version. If min_version is unset, no keys are deleted.
@@ -48,6 +60,17 @@ This will delete all crypto key versions from Google Cloud KMS which are older
 than the specified version (version 42 in this example). Note that this will
 make it impossible to decrypt data previously encrypted with these older keys
 through conventional methods.
+
+To review the crypto key versions which would be deleted without deleting them,
+set dry_run to "true":
+
+    $ vault write gcpkms/keys/trim/my-key dry_run=true
+
+To disable the crypto key versions instead of deleting them, set action to
+"disable". Disabled versions can be re-enabled in Google Cloud KMS, and are
+deleted by a later trim with the default action:
+
+    $ vault write gcpkms/keys/trim/my-key action=disable
 `,
 
 		Fields: map[string]*framework.FieldSchema{
@@ -55,6 +78,26 @@ through conventional methods.
 				Type: framework.TypeString,
 				Description: `
 Name of the key in Vault.
+`,
+			},
+
+			"action": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: trimActionDestroySchedule,
+				Description: `
+Action to take on crypto key versions older than the key's min_version. Options
+are "destroy_schedule" to schedule the versions for destruction, or "disable"
+to disable the versions without destroying them. The default is
+"destroy_schedule".
+`,
+			},
+
+			"dry_run": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If true, return the crypto key versions which would be disabled or deleted,
+including their names, states, and create times, without changing them.
 `,
 			},
 		},
@@ -63,19 +106,22 @@ Name of the key in Vault.
 
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.CreateOperation: &framework.PathOperation{
-				Callback: withFieldValidator(b.pathKeysTrimWrite),
+				Callback:  withFieldValidator(b.pathKeysTrimWrite),
+				Responses: keysTrimResponses,
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationSuffix: "key-versions",
 				},
 			},
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: withFieldValidator(b.pathKeysTrimWrite),
+				Callback:  withFieldValidator(b.pathKeysTrimWrite),
+				Responses: keysTrimResponses,
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationSuffix: "key-versions",
 				},
 			},
 			logical.DeleteOperation: &framework.PathOperation{
-				Callback: withFieldValidator(b.pathKeysTrimWrite),
+				Callback:  withFieldValidator(b.pathKeysTrimWrite),
+				Responses: keysTrimResponses,
 				DisplayAttrs: &framework.DisplayAttributes{
 					OperationSuffix: "key-versions2",
 				},
@@ -84,17 +130,45 @@ Name of the key in Vault.
 	}
 }
 
+// keysTrimResponses are the responses of trimming, which only has response data
+// for a dry run.
+var keysTrimResponses = map[int][]framework.Response{
+	http.StatusOK: {{
+		Description: "OK",
+		Fields: map[string]*framework.FieldSchema{
+			"action": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Action the trim would take.",
+				Required:    true,
+			},
+			"dry_run": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Whether the trim was a dry run which changed nothing.",
+				Required:    true,
+			},
+			"versions": &framework.FieldSchema{
+				Type:        framework.TypeSlice,
+				Description: "Name, version, state, and create time of each crypto key version which would be trimmed.",
+				Required:    true,
+			},
+		},
+	}},
+	http.StatusNoContent: {{
+		Description: "No Content",
+	}},
+}
+
 // pathKeysTrimWrite corresponds to PUT/POST/DELETE gcpkms/keys/trim/:key and
-// deletes all crypto key versions from Google Cloud KMS which are older than
-// the key's min_version.
+// disables or deletes all crypto key versions from Google Cloud KMS which are
+// older than the key's min_version.
 func (b *backend) pathKeysTrimWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	kmsClient, closer, err := b.KMSClient(req.Storage)
-	if err != nil {
+	key := d.Get("key").(string)
+	action := d.Get("action").(string)
+	dryRun := d.Get("dry_run").(bool)
+
+	if err := validateTrimAction(action); err != nil {
 		return nil, err
 	}
-	defer closer()
-
-	key := d.Get("key").(string)
 
 	k, err := b.Key(ctx, req.Storage, key)
 	if err != nil {
@@ -104,14 +178,71 @@ func (b *backend) pathKeysTrimWrite(ctx context.Context, req *logical.Request, d
 		return nil, err
 	}
 
+	// Disabling versions is reversible, so it is allowed on protected keys
+	if k.DeletionProtection && action == trimActionDestroySchedule {
+		return nil, errDeletionProtected(key)
+	}
+
 	// If a min version was not set, there's no point in iterating
 	if k.MinVersion < 1 {
 		return nil, nil
 	}
 
-	// Collect the list of all key versions
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	ckvs, err := trimCandidates(ctx, kmsClient, k, action)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		versions := make([]map[string]interface{}, 0, len(ckvs))
+		for _, ckv := range ckvs {
+			versions = append(versions, cryptoKeyVersionToMap(ckv))
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"action":   action,
+				"dry_run":  true,
+				"versions": versions,
+			},
+		}, nil
+	}
+
+	err = trimCryptoKeyVersions(ctx, kmsClient, ckvs, action)
+	b.invalidateCryptoKey(k.CryptoKeyID)
+	b.evictPublicKeys(k.CryptoKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// validateTrimAction returns an error if the action is not a valid trim
+// action.
+func validateTrimAction(action string) error {
+	switch action {
+	case trimActionDestroySchedule, trimActionDisable:
+		return nil
+	}
+	return logical.CodedError(400, fmt.Sprintf("invalid action %q, "+
+		"valid actions are %q and %q", action, trimActionDestroySchedule, trimActionDisable))
+}
+
+// trimCandidates returns the crypto key versions of the key which are older
+// than the key's min_version and which the action would change.
+func trimCandidates(ctx context.Context, kmsClient keyManagementClient, k *Key, action string) ([]*kmspb.CryptoKeyVersion, error) {
 	var errs *multierror.Error
-	var ckvs []string
+	var ckvs []*kmspb.CryptoKeyVersion
 	it := kmsClient.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
 		Parent: k.CryptoKeyID,
 	})
@@ -121,13 +252,16 @@ func (b *backend) pathKeysTrimWrite(ctx context.Context, req *logical.Request, d
 			if err == iterator.Done {
 				break
 			}
-			return nil, errwrap.Wrapf("failed to list crypto key versions: {{err}}", err)
+			return nil, wrapKMSError("failed to list crypto key versions: {{err}}", err)
 		}
 
 		if resp.State == kmspb.CryptoKeyVersion_DESTROYED ||
 			resp.State == kmspb.CryptoKeyVersion_DESTROY_SCHEDULED {
 			continue
 		}
+		if action == trimActionDisable && resp.State == kmspb.CryptoKeyVersion_DISABLED {
+			continue
+		}
 
 		parts := strings.Split(resp.Name, "/")
 		if len(parts) < 1 {
@@ -142,17 +276,44 @@ func (b *backend) pathKeysTrimWrite(ctx context.Context, req *logical.Request, d
 		}
 
 		if v < k.MinVersion {
-			ckvs = append(ckvs, resp.Name)
+			ckvs = append(ckvs, resp)
 		}
 	}
 
-	// Iterate over each key version and schedule deletion
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+	return ckvs, nil
+}
+
+// trimCryptoKeyVersions disables or schedules destruction of each of the
+// given crypto key versions, depending on the action.
+func trimCryptoKeyVersions(ctx context.Context, kmsClient keyManagementClient, ckvs []*kmspb.CryptoKeyVersion, action string) error {
 	var mu sync.Mutex
+	var errs *multierror.Error
 	wp := workerpool.New(25)
 	for _, ckv := range ckvs {
-		ckv := ckv
+		ckv := ckv.Name
 
 		wp.Submit(func() {
+			if action == trimActionDisable {
+				if _, err := kmsClient.UpdateCryptoKeyVersion(ctx, &kmspb.UpdateCryptoKeyVersionRequest{
+					CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+						Name:  ckv,
+						State: kmspb.CryptoKeyVersion_DISABLED,
+					},
+					UpdateMask: &fieldmaskpb.FieldMask{
+						Paths: []string{"state"},
+					},
+				}); err != nil {
+					mu.Lock()
+					errs = multierror.Append(errs, errwrap.Wrapf(fmt.Sprintf(
+						"failed to disable crypto key version %s: {{err}}", ckv), err))
+					mu.Unlock()
+				}
+				return
+			}
+
 			if _, err := kmsClient.DestroyCryptoKeyVersion(ctx, &kmspb.DestroyCryptoKeyVersionRequest{
 				Name: ckv,
 			}); err != nil {
@@ -166,10 +327,19 @@ func (b *backend) pathKeysTrimWrite(ctx context.Context, req *logical.Request, d
 
 	wp.StopWait()
 
-	// Return an error if any failed
-	if err := errs.ErrorOrNil(); err != nil {
-		return nil, err
-	}
+	return errs.ErrorOrNil()
+}
 
-	return nil, nil
+// cryptoKeyVersionToMap returns the name, state, and create time of the crypto
+// key version for use in a response.
+func cryptoKeyVersionToMap(ckv *kmspb.CryptoKeyVersion) map[string]interface{} {
+	data := map[string]interface{}{
+		"name":    ckv.Name,
+		"version": path.Base(ckv.Name),
+		"state":   strings.ToLower(ckv.State.String()),
+	}
+	if ckv.CreateTime != nil {
+		data["create_time_seconds"] = ckv.CreateTime.Seconds
+	}
+	return data
 }
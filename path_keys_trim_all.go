@@ -0,0 +1,198 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gammazero/workerpool"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// trimAllConcurrency is the maximum number of keys trimmed at once by
+// keys/trim.
+const trimAllConcurrency = 10
+
+func (b *backend) pathKeysTrimAll() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/trim/?$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "trim",
+			OperationSuffix: "all-key-versions",
+		},
+
+		HelpSynopsis: "Trim old crypto key versions of all keys",
+		HelpDescription: `
+Trims every key in Vault which has a min_version set, as if "keys/trim/:key"
+were called for each key. Keys are trimmed concurrently, and the response
+contains a summary of the crypto key versions trimmed for each key.
+
+    $ vault write -f gcpkms/keys/trim
+
+Keys with deletion protection enabled are skipped unless the action is
+"disable". A failure to trim one key does not stop the other keys from being
+trimmed; the error is reported in the summary for that key.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"action": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: trimActionDestroySchedule,
+				Description: `
+Action to take on crypto key versions older than each key's min_version.
+Options are "destroy_schedule" to schedule the versions for destruction, or
+"disable" to disable the versions without destroying them. The default is
+"destroy_schedule".
+`,
+			},
+
+			"dry_run": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If true, return the crypto key versions which would be disabled or deleted for
+each key without changing them.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: withFieldValidator(b.pathKeysTrimAllWrite),
+		},
+	}
+}
+
+// pathKeysTrimAllWrite corresponds to PUT/POST gcpkms/keys/trim and trims the
+// crypto key versions of all keys which have a min_version.
+func (b *backend) pathKeysTrimAllWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	action := d.Get("action").(string)
+	dryRun := d.Get("dry_run").(bool)
+
+	if err := validateTrimAction(action); err != nil {
+		return nil, err
+	}
+
+	names, err := b.Keys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*Key
+	for _, name := range names {
+		k, err := b.Key(ctx, req.Storage, name)
+		if err != nil {
+			if err == ErrKeyNotFound {
+				continue
+			}
+			return nil, err
+		}
+		if k.MinVersion > 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	summary := make(map[string]interface{}, len(keys))
+	if len(keys) == 0 {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"action":  action,
+				"dry_run": dryRun,
+				"keys":    summary,
+			},
+		}, nil
+	}
+
+	// Get the default client up front, so a credentials error fails fast and
+	// the request timeout is loaded. Each key uses the client for its own
+	// endpoint.
+	_, closer, err := b.KMSClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var warnings []string
+	wp := workerpool.New(trimAllConcurrency)
+	for _, k := range keys {
+		k := k
+
+		wp.Submit(func() {
+			info := map[string]interface{}{
+				"crypto_key_id": k.CryptoKeyID,
+				"min_version":   k.MinVersion,
+			}
+
+			defer func() {
+				mu.Lock()
+				summary[k.Name] = info
+				mu.Unlock()
+			}()
+
+			if k.DeletionProtection && action == trimActionDestroySchedule {
+				info["skipped"] = "deletion protection is enabled"
+				return
+			}
+
+			ckvs, err := b.trimKey(ctx, req.Storage, k, action, dryRun)
+			if err != nil {
+				info["error"] = err.Error()
+				mu.Lock()
+				warnings = append(warnings, fmt.Sprintf("failed to trim key %q: %s", k.Name, err))
+				mu.Unlock()
+				return
+			}
+
+			versions := make([]string, 0, len(ckvs))
+			for _, ckv := range ckvs {
+				versions = append(versions, ckv.Name)
+			}
+			info["versions"] = versions
+		})
+	}
+
+	wp.StopWait()
+	sort.Strings(warnings)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"action":  action,
+			"dry_run": dryRun,
+			"keys":    summary,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// trimKey trims the crypto key versions of the key with the given action,
+// using the client for the key's endpoint. It returns the versions which were
+// trimmed, or which would be trimmed if dryRun is true.
+func (b *backend) trimKey(ctx context.Context, s logical.Storage, k *Key, action string, dryRun bool) ([]*kmspb.CryptoKeyVersion, error) {
+	kmsClient, closer, err := b.KeyKMSClient(ctx, s, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ckvs, err := trimCandidates(ctx, kmsClient, k, action)
+	if err != nil || dryRun {
+		return ckvs, err
+	}
+
+	err = trimCryptoKeyVersions(ctx, kmsClient, ckvs, action)
+	b.invalidateCryptoKey(k.CryptoKeyID)
+	b.evictPublicKeys(k.CryptoKeyID)
+	return ckvs, err
+}
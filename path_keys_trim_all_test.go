@@ -0,0 +1,133 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestPathKeysTrimAll_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/trim")
+	})
+
+	t.Run("invalid_action", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		if _, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/trim",
+			Data: map[string]interface{}{
+				"action": "delete",
+			},
+		}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("no_min_version", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key", "crypto_key_id":"foo"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/trim",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if keys := resp.Data["keys"].(map[string]interface{}); len(keys) != 0 {
+			t.Errorf("expected no keys to be trimmed: %#v", keys)
+		}
+	})
+
+	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+	defer cleanup()
+
+	kmsClient := testKMSClient(t)
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	for _, entry := range []*logical.StorageEntry{
+		{
+			Key:   "keys/my-versioned-key",
+			Value: []byte(`{"name":"my-versioned-key", "crypto_key_id":"` + cryptoKey + `", "min_version":3}`),
+		},
+		{
+			Key:   "keys/my-protected-key",
+			Value: []byte(`{"name":"my-protected-key", "crypto_key_id":"` + cryptoKey + `", "min_version":3, "deletion_protection":true}`),
+		},
+	} {
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 2; i <= 3; i++ {
+		if _, err := kmsClient.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+			Parent: cryptoKey,
+			CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+				State: kmspb.CryptoKeyVersion_ENABLED,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/trim",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := resp.Data["keys"].(map[string]interface{})
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys in summary: %#v", keys)
+	}
+
+	protected := keys["my-protected-key"].(map[string]interface{})
+	if _, ok := protected["skipped"]; !ok {
+		t.Errorf("expected protected key to be skipped: %#v", protected)
+	}
+
+	versioned := keys["my-versioned-key"].(map[string]interface{})
+	versions, ok := versioned["versions"].([]string)
+	if !ok || len(versions) != 2 {
+		t.Fatalf("expected 2 versions to be trimmed: %#v", versioned)
+	}
+
+	for _, v := range versions {
+		ckv, err := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: v,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ckv.State != kmspb.CryptoKeyVersion_DESTROY_SCHEDULED {
+			t.Errorf("expected %q to be scheduled for destruction, got %s", v, ckv.State)
+		}
+	}
+}
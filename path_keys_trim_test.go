@@ -10,7 +10,7 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 	"google.golang.org/api/iterator"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func TestPathKeysTrim_Write(t *testing.T) {
@@ -22,6 +22,63 @@ func TestPathKeysTrim_Write(t *testing.T) {
 		testFieldValidation(t, logical.DeleteOperation, "keys/trim/my-key")
 	})
 
+	t.Run("invalid_action", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/trim/my-key",
+			Data: map[string]interface{}{
+				"action": "delete",
+			},
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("deletion_protection", func(t *testing.T) {
+
+		cases := []struct {
+			action string
+			err    bool
+		}{
+			{trimActionDestroySchedule, true},
+			{trimActionDisable, false},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.action, func(t *testing.T) {
+
+				b, storage := testBackend(t)
+
+				ctx := context.Background()
+				if err := storage.Put(ctx, &logical.StorageEntry{
+					Key:   "keys/my-key",
+					Value: []byte(`{"name":"my-key", "crypto_key_id":"foo", "deletion_protection":true}`),
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				_, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "keys/trim/my-key",
+					Data: map[string]interface{}{
+						"action": tc.action,
+					},
+				})
+				if (err != nil) != tc.err {
+					t.Fatal(err)
+				}
+			})
+		}
+	})
+
 	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
 	defer cleanup()
 
@@ -49,6 +106,83 @@ func TestPathKeysTrim_Write(t *testing.T) {
 	}
 
 	ctx := context.Background()
+
+	t.Run("dry_run", func(t *testing.T) {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/trim/my-versioned-key",
+			Data: map[string]interface{}{
+				"dry_run": true,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		versions, ok := resp.Data["versions"].([]map[string]interface{})
+		if !ok {
+			t.Fatalf("missing versions: %#v", resp.Data)
+		}
+		if len(versions) != 2 {
+			t.Fatalf("expected 2 versions, got %d: %#v", len(versions), versions)
+		}
+		for _, v := range versions {
+			if v["state"] != "enabled" {
+				t.Errorf("expected %q to be enabled: %#v", v["name"], v)
+			}
+		}
+
+		ckv, err := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: versions[0]["name"].(string),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ckv.State != kmspb.CryptoKeyVersion_ENABLED {
+			t.Errorf("expected %q to still be enabled, got %s", ckv.Name, ckv.State)
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/trim/my-versioned-key",
+			Data: map[string]interface{}{
+				"action":  trimActionDisable,
+				"dry_run": true,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions := resp.Data["versions"].([]map[string]interface{})
+
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/trim/my-versioned-key",
+			Data: map[string]interface{}{
+				"action": trimActionDisable,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range versions {
+			ckv, err := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+				Name: v["name"].(string),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ckv.State != kmspb.CryptoKeyVersion_DISABLED {
+				t.Errorf("expected %q to be disabled, got %s", ckv.Name, ckv.State)
+			}
+		}
+	})
+
 	if _, err := b.HandleRequest(ctx, &logical.Request{
 		Storage:   storage,
 		Operation: logical.UpdateOperation,
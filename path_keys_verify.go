@@ -14,12 +14,27 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net/http"
+	"sort"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iterator"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+const (
+	// Reasons a signature is not valid, returned by verify.
+	verifyReasonSignatureMismatch    = "signature_mismatch"
+	verifyReasonWrongKeyVersion      = "wrong_key_version"
+	verifyReasonMalformedSignature   = "malformed_signature"
+	verifyReasonDigestLengthMismatch = "digest_length_mismatch"
+
+	// verifyMaxOtherVersions is the number of other versions of a key checked
+	// when a signature does not match the given version.
+	verifyMaxOtherVersions = 10
 )
 
 func (b *backend) pathVerify() *framework.Path {
@@ -33,8 +48,20 @@ func (b *backend) pathVerify() *framework.Path {
 
 		HelpSynopsis: "Verify a signature using a named key",
 		HelpDescription: `
-Use the named key to verify the given signature. The response will be the
-base64-encoded encrypted value (ciphertext).
+Use the named key to verify the given signature. The response includes whether
+the signature is valid and, if it is not, the reason:
+
+  - signature_mismatch: the signature is not for the digest and key version
+  - wrong_key_version: the signature was made by another enabled version of
+    the key, which is returned as signed_key_version. Other versions are only
+    checked if diagnose is true.
+  - malformed_signature: the signature is not well-formed for the algorithm
+  - digest_length_mismatch: the digest is not the length of the key's digest
+
+If the key has offline_verification enabled and Google Cloud KMS is
+unreachable, the signature is verified with the cached public key of the crypto
+key version, if this Vault node has one, and the response includes
+verified_offline=true.
 `,
 
 		Fields: map[string]*framework.FieldSchema{
@@ -43,6 +70,7 @@ base64-encoded encrypted value (ciphertext).
 				Description: `
 Name of the key in Vault to use for verification. This key must already exist in
 Vault and must map back to a Google Cloud KMS key.
+This may also be the name of a key alias.
 `,
 			},
 
@@ -67,12 +95,69 @@ required.
 				Type: framework.TypeString,
 				Description: `
 Base64-encoded signature to use for verification. This field is required.
+`,
+			},
+
+			"diagnose": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `
+If true and the signature does not match, check whether another enabled version
+of the key made it. This reads the newest versions of the key from Google Cloud
+KMS, so it should only be set when debugging a failed verification. The
+default value is false.
 `,
 			},
 		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: withFieldValidator(b.pathVerifyWrite),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.withUsageForwarding(b.pathVerifyWrite)),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"valid": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether the signature is valid.",
+								Required:    true,
+							},
+							"key_version": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Version of the crypto key version used for verification.",
+								Required:    true,
+							},
+							"crypto_key_version": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Resource ID of the crypto key version used for verification.",
+								Required:    true,
+							},
+							"protection_level": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Protection level of the crypto key version.",
+								Required:    true,
+							},
+							"algorithm": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Algorithm of the crypto key version.",
+								Required:    true,
+							},
+							"reason": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Reason the signature is not valid.",
+							},
+							"signed_key_version": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Version of the crypto key version which made the signature, if it is another version.",
+							},
+							"verified_offline": &framework.FieldSchema{
+								Type:        framework.TypeBool,
+								Description: "Whether the signature was verified with a cached public key because KMS was unreachable.",
+							},
+						},
+					}},
+				},
+			},
 		},
 	}
 }
@@ -84,6 +169,7 @@ func (b *backend) pathVerifyWrite(ctx context.Context, req *logical.Request, d *
 	digest := d.Get("digest").(string)
 	signature := d.Get("signature").(string)
 	keyVersion := d.Get("key_version").(int)
+	diagnose := d.Get("diagnose").(bool)
 
 	if digest == "" {
 		return nil, errMissingFields("digest")
@@ -107,7 +193,7 @@ func (b *backend) pathVerifyWrite(ctx context.Context, req *logical.Request, d *
 		return nil, errwrap.Wrapf("failed to base64 decode digest: {{err}}", err)
 	}
 
-	k, err := b.Key(ctx, req.Storage, key)
+	k, err := b.resolveKey(ctx, req.Storage, key)
 	if err != nil {
 		if err == ErrKeyNotFound {
 			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -115,30 +201,49 @@ func (b *backend) pathVerifyWrite(ctx context.Context, req *logical.Request, d *
 		return nil, err
 	}
 
-	if k.MinVersion > 0 && keyVersion < k.MinVersion {
-		resp := fmt.Sprintf("requested version %d is less than minimum allowed version of %d",
-			keyVersion, k.MinVersion)
-		return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	if resp, err := k.checkVersion(keyVersion); err != nil {
+		return resp, err
 	}
 
-	if k.MaxVersion > 0 && keyVersion > k.MaxVersion {
-		resp := fmt.Sprintf("requested version %d is greater than maximum allowed version of %d",
-			keyVersion, k.MaxVersion)
-		return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
 	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
 
-	kmsClient, closer, err := b.KMSClient(req.Storage)
+	release, err := b.acquireKey(k.Name)
 	if err != nil {
 		return nil, err
 	}
-	defer closer()
+	defer release()
 
-	// Get the public key
-	pk, err := kmsClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
-		Name: fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, keyVersion),
-	})
+	// Get the public key, falling back to the cached copy if KMS is
+	// unreachable and the key allows offline verification
+	cryptoKeyVersion := fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, keyVersion)
+	pk, err := b.verifyPublicKey(ctx, req.Storage, kmsClient, key, k, cryptoKeyVersion)
+	var offline bool
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to get public key: {{err}}", err)
+		cached, ok := b.cachedPublicKey(cryptoKeyVersion)
+		if !ok || !k.OfflineVerification || !isKMSUnreachable(err) {
+			return nil, err
+		}
+		if err := checkKeyPurpose(key, &kmspb.CryptoKey{
+			Purpose: cached.purpose,
+			VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+				Algorithm: cached.publicKey.Algorithm,
+			},
+		}, "verify"); err != nil {
+			return nil, err
+		}
+		b.Logger().Warn("KMS is unreachable, verifying with the cached public key",
+			"key", k.Name, "crypto_key_version", cryptoKeyVersion, "error", err)
+		pk, offline = cached.publicKey, true
+	}
+	if err := b.checkKeyVersionFIPS(ctx, req.Storage, key, pk.ProtectionLevel, pk.Algorithm); err != nil {
+		return nil, err
 	}
 
 	// Extract the PEM-encoded data block
@@ -153,38 +258,201 @@ func (b *backend) pathVerifyWrite(ctx context.Context, req *logical.Request, d *
 		return nil, errwrap.Wrapf("failed to parse public key: {{err}}", err)
 	}
 
-	validSig := false
+	reason, err := verifySignature(pk.Algorithm, pub, dig, sig)
+	if err != nil {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"key version %d has algorithm %q which cannot be used to verify",
+			keyVersion, algorithmToString(pk.Algorithm)))
+	}
 
-	switch pk.Algorithm {
-	case kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:
-		var parsedSig struct{ R, S *big.Int }
-		if _, err := asn1.Unmarshal(sig, &parsedSig); err != nil {
-			return nil, errwrap.Wrapf("failed to unmarshal signature: {{err}}", err)
+	// A signature which does not match may have been made by another version
+	// of the key. Finding it takes extra calls to KMS, so it is only done when
+	// the caller is debugging a failure.
+	var signedVersion int
+	if reason == verifyReasonSignatureMismatch && diagnose && !offline {
+		signedVersion = b.findSigningVersion(ctx, kmsClient, k, keyVersion, dig, sig)
+		if signedVersion > 0 {
+			reason = verifyReasonWrongKeyVersion
 		}
-		validSig = ecdsa.Verify(pub.(*ecdsa.PublicKey), dig, parsedSig.R, parsedSig.S)
-	case kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:
-		var parsedSig struct{ R, S *big.Int }
-		if _, err := asn1.Unmarshal(sig, &parsedSig); err != nil {
-			return nil, errwrap.Wrapf("failed to unmarshal signature: {{err}}", err)
+	}
+
+	b.recordUsage(k.Name, "verify")
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"valid":              reason == "",
+			"key_version":        keyVersion,
+			"crypto_key_version": cryptoKeyVersion,
+			"protection_level":   protectionLevelToString(pk.ProtectionLevel),
+			"algorithm":          algorithmToString(pk.Algorithm),
+		},
+	}
+	if reason != "" {
+		resp.Data["reason"] = reason
+	}
+	if signedVersion > 0 {
+		resp.Data["signed_key_version"] = signedVersion
+	}
+	if offline {
+		resp.Data["verified_offline"] = true
+		resp.AddWarning("Google Cloud KMS is unreachable, so the signature was " +
+			"verified with the cached public key of the crypto key version.")
+	}
+	return resp, nil
+}
+
+// verifyPublicKey returns the public key of the crypto key version from KMS,
+// after checking the key may be used to verify, and caches it for offline
+// verification. The public key is always retrieved from KMS when it is
+// reachable, so disabled and destroyed versions cannot be used.
+func (b *backend) verifyPublicKey(ctx context.Context, s logical.Storage, kmsClient keyManagementClient, key string, k *Key, cryptoKeyVersion string) (*kmspb.PublicKey, error) {
+	ck, err := b.cryptoKey(ctx, kmsClient, k.CryptoKeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPurpose(key, ck, "verify"); err != nil {
+		return nil, err
+	}
+	if err := b.checkKeyFIPS(ctx, s, key, ck); err != nil {
+		return nil, err
+	}
+
+	pk, err := kmsClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
+		Name: cryptoKeyVersion,
+	})
+	if err != nil {
+		return nil, wrapKMSVersionError(ctx, kmsClient, key, cryptoKeyVersion, "failed to get public key: {{err}}", err)
+	}
+
+	b.cachePublicKey(cryptoKeyVersion, pk, ck.Purpose)
+	return pk, nil
+}
+
+// findSigningVersion returns the enabled version of the key within its min and
+// max versions, other than the given version, whose public key verifies the
+// signature, or 0 if there is none. Only the newest versions are checked, and
+// errors are logged rather than returned because the result only explains a
+// failed verification.
+func (b *backend) findSigningVersion(ctx context.Context, kmsClient keyManagementClient, k *Key, keyVersion int, dig, sig []byte) int {
+	var versions []int
+	it := kmsClient.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
+		Parent: k.CryptoKeyID,
+	})
+	for {
+		ckv, err := it.Next()
+		if err != nil {
+			if err == iterator.Done {
+				break
+			}
+			b.Logger().Debug("failed to list crypto key versions", "key", k.Name, "error", err)
+			return 0
+		}
+
+		v := versionNumber(ckv.Name)
+		if ckv.State != kmspb.CryptoKeyVersion_ENABLED || v <= 0 || v == keyVersion {
+			continue
+		}
+		if k.MinVersion > 0 && v < k.MinVersion {
+			continue
+		}
+		if k.MaxVersion > 0 && v > k.MaxVersion {
+			continue
+		}
+		versions = append(versions, v)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	if len(versions) > verifyMaxOtherVersions {
+		versions = versions[:verifyMaxOtherVersions]
+	}
+
+	for _, v := range versions {
+		pk, err := b.publicKey(ctx, kmsClient, fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, v))
+		if err != nil {
+			b.Logger().Debug("failed to get public key", "key", k.Name, "version", v, "error", err)
+			continue
+		}
+		block, _ := pem.Decode([]byte(pk.Pem))
+		if block == nil {
+			continue
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			continue
 		}
-		validSig = ecdsa.Verify(pub.(*ecdsa.PublicKey), dig, parsedSig.R, parsedSig.S)
+		if reason, err := verifySignature(pk.Algorithm, pub, dig, sig); err == nil && reason == "" {
+			return v
+		}
+	}
+	return 0
+}
+
+// verifySignature verifies the signature of the digest with the public key of
+// a crypto key version with the given algorithm. It returns the empty string
+// if the signature is valid, or the reason it is not. It returns an error if
+// the algorithm cannot be used to verify.
+//
+// The signature and digest are public, and the comparisons of the underlying
+// verification are constant-time, so a failure reveals nothing of the key.
+func verifySignature(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm, pub crypto.PublicKey, dig, sig []byte) (string, error) {
+	var hash crypto.Hash
+	var ec bool
+	switch a {
+	case kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:
+		hash, ec = crypto.SHA384, true
+	case kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:
+		hash, ec = crypto.SHA256, true
 	case kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256,
 		kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256,
-		kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:
-		err := rsa.VerifyPSS(pub.(*rsa.PublicKey), crypto.SHA256, dig, sig, &rsa.PSSOptions{})
-		validSig = err == nil
-	case kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
 		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256,
 		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256:
-		err := rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, dig, sig)
-		validSig = err == nil
+		hash = crypto.SHA256
 	default:
-		return nil, fmt.Errorf("unknown key signing algorithm: %s", pk.Algorithm)
+		return "", fmt.Errorf("algorithm %q cannot be used to verify", algorithmToString(a))
 	}
 
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"valid": validSig,
-		},
-	}, nil
+	if len(dig) != hash.Size() {
+		return verifyReasonDigestLengthMismatch, nil
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ec {
+			return "", fmt.Errorf("algorithm %q requires an RSA public key", algorithmToString(a))
+		}
+
+		var parsedSig struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(sig, &parsedSig)
+		if err != nil || len(rest) > 0 || parsedSig.R.Sign() <= 0 || parsedSig.S.Sign() <= 0 {
+			return verifyReasonMalformedSignature, nil
+		}
+		if !ecdsa.Verify(pub, dig, parsedSig.R, parsedSig.S) {
+			return verifyReasonSignatureMismatch, nil
+		}
+	case *rsa.PublicKey:
+		if ec {
+			return "", fmt.Errorf("algorithm %q requires an EC public key", algorithmToString(a))
+		}
+		if len(sig) != pub.Size() {
+			return verifyReasonMalformedSignature, nil
+		}
+
+		var err error
+		switch a {
+		case kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256,
+			kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256,
+			kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:
+			err = rsa.VerifyPSS(pub, hash, dig, sig, &rsa.PSSOptions{})
+		default:
+			err = rsa.VerifyPKCS1v15(pub, hash, dig, sig)
+		}
+		if err != nil {
+			return verifyReasonSignatureMismatch, nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return "", nil
 }
@@ -5,15 +5,23 @@ package gcpkms
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func TestPathVerify_Write(t *testing.T) {
@@ -119,7 +127,347 @@ func TestPathVerify_Write(t *testing.T) {
 				if b, ok := valid.(bool); !ok || !b {
 					t.Errorf("expected valid %t to be %t", b, true)
 				}
+
+				// Verify a different digest of the same length
+				other := make([]byte, len(digest))
+				copy(other, digest)
+				other[0] ^= 0xff
+
+				resp, err = b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "verify/my-key",
+					Data: map[string]interface{}{
+						"digest":      base64.StdEncoding.EncodeToString(other),
+						"signature":   base64.StdEncoding.EncodeToString(signResp.Signature),
+						"key_version": 1,
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if v, exp := resp.Data["valid"], false; v != exp {
+					t.Errorf("expected valid %v to be %v", v, exp)
+				}
+				if v, exp := resp.Data["reason"], verifyReasonSignatureMismatch; v != exp {
+					t.Errorf("expected reason %v to be %q", v, exp)
+				}
+			})
+		}
+	})
+
+	t.Run("diagnose", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		f := testFakeKMSClient(t, b)
+		cryptoKey := testFakeCryptoKey(t, f, kmspb.CryptoKey_ASYMMETRIC_SIGN,
+			kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+
+		ctx := context.Background()
+		if _, err := f.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+			Parent: cryptoKey,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.putKey(ctx, storage, &Key{
+			Name:        "my-key",
+			CryptoKeyID: cryptoKey,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Sign with version 2 and verify with version 1
+		digest := sha256.Sum256([]byte("hello world"))
+		signResp, err := f.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
+			Name:   cryptoKey + "/cryptoKeyVersions/2",
+			Digest: &kmspb.Digest{Digest: &kmspb.Digest_Sha256{Sha256: digest[:]}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			name          string
+			diagnose      bool
+			reason        string
+			signedVersion interface{}
+		}{
+			{"off", false, verifyReasonSignatureMismatch, nil},
+			{"on", true, verifyReasonWrongKeyVersion, 2},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+
+				resp, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "verify/my-key",
+					Data: map[string]interface{}{
+						"digest":      base64.StdEncoding.EncodeToString(digest[:]),
+						"signature":   base64.StdEncoding.EncodeToString(signResp.Signature),
+						"key_version": 1,
+						"diagnose":    tc.diagnose,
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v := resp.Data["reason"]; v != tc.reason {
+					t.Errorf("expected reason %v to be %q", v, tc.reason)
+				}
+				if v := resp.Data["signed_key_version"]; v != tc.signedVersion {
+					t.Errorf("expected signed_key_version %v to be %v", v, tc.signedVersion)
+				}
 			})
 		}
 	})
 }
+
+func TestPathVerify_Offline(t *testing.T) {
+	t.Parallel()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("hello world"))
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cryptoKey := "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+
+	cases := []struct {
+		name    string
+		offline bool
+		cached  bool
+		purpose kmspb.CryptoKey_CryptoKeyPurpose
+		err     bool
+	}{
+		{"verified_offline", true, true, kmspb.CryptoKey_ASYMMETRIC_SIGN, false},
+		{"not_enabled", false, true, kmspb.CryptoKey_ASYMMETRIC_SIGN, true},
+		{"not_cached", true, false, kmspb.CryptoKey_ASYMMETRIC_SIGN, true},
+		{"wrong_purpose", true, true, kmspb.CryptoKey_ASYMMETRIC_DECRYPT, true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			b, storage := testBackend(t)
+
+			// The client never reaches KMS
+			b.kmsClients[clientKey{}] = &kmsClientHandle{
+				client:     testOfflineKMSClient(t),
+				createTime: time.Now().UTC(),
+				lifetime:   time.Hour,
+			}
+
+			ctx := context.Background()
+			if err := b.putKey(ctx, storage, &Key{
+				Name:                "my-key",
+				CryptoKeyID:         cryptoKey,
+				OfflineVerification: tc.offline,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.cached {
+				b.cachePublicKey(cryptoKey+"/cryptoKeyVersions/1", &kmspb.PublicKey{
+					Pem:             string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+					Algorithm:       kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+					ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+				}, tc.purpose)
+			}
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "verify/my-key",
+				Data: map[string]interface{}{
+					"digest":      base64.StdEncoding.EncodeToString(sum[:]),
+					"signature":   base64.StdEncoding.EncodeToString(sig),
+					"key_version": 1,
+				},
+			})
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if tc.err {
+				return
+			}
+
+			if v, exp := resp.Data["valid"], true; v != exp {
+				t.Errorf("expected valid %v to be %v", v, exp)
+			}
+			if v, exp := resp.Data["verified_offline"], true; v != exp {
+				t.Errorf("expected verified_offline %v to be %v", v, exp)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("hello world"))
+	digest := sum[:]
+
+	pssSig, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1Sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := make([]byte, len(pkcs1Sig))
+	copy(tampered, pkcs1Sig)
+	tampered[len(tampered)-1] ^= 0x01
+
+	cases := []struct {
+		name   string
+		alg    kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+		pub    crypto.PublicKey
+		digest []byte
+		sig    []byte
+		reason string
+		err    bool
+	}{
+		{
+			name:   "rsa_pss",
+			alg:    kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256,
+			pub:    &rsaKey.PublicKey,
+			digest: digest,
+			sig:    pssSig,
+		},
+		{
+			name:   "rsa_pkcs1",
+			alg:    kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+			pub:    &rsaKey.PublicKey,
+			digest: digest,
+			sig:    pkcs1Sig,
+		},
+		{
+			name:   "ec",
+			alg:    kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+			pub:    &ecKey.PublicKey,
+			digest: digest,
+			sig:    ecSig,
+		},
+		{
+			name:   "rsa_tampered",
+			alg:    kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+			pub:    &rsaKey.PublicKey,
+			digest: digest,
+			sig:    tampered,
+			reason: verifyReasonSignatureMismatch,
+		},
+		{
+			name:   "rsa_wrong_padding",
+			alg:    kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256,
+			pub:    &rsaKey.PublicKey,
+			digest: digest,
+			sig:    pkcs1Sig,
+			reason: verifyReasonSignatureMismatch,
+		},
+		{
+			name:   "rsa_truncated",
+			alg:    kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+			pub:    &rsaKey.PublicKey,
+			digest: digest,
+			sig:    pkcs1Sig[1:],
+			reason: verifyReasonMalformedSignature,
+		},
+		{
+			name:   "ec_not_asn1",
+			alg:    kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+			pub:    &ecKey.PublicKey,
+			digest: digest,
+			sig:    []byte("not a signature"),
+			reason: verifyReasonMalformedSignature,
+		},
+		{
+			name:   "ec_trailing_data",
+			alg:    kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+			pub:    &ecKey.PublicKey,
+			digest: digest,
+			sig:    append(append([]byte{}, ecSig...), 0x00),
+			reason: verifyReasonMalformedSignature,
+		},
+		{
+			name:   "digest_too_short",
+			alg:    kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+			pub:    &ecKey.PublicKey,
+			digest: digest[:20],
+			sig:    ecSig,
+			reason: verifyReasonDigestLengthMismatch,
+		},
+		{
+			name:   "digest_for_other_algorithm",
+			alg:    kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384,
+			pub:    &ecKey.PublicKey,
+			digest: digest,
+			sig:    ecSig,
+			reason: verifyReasonDigestLengthMismatch,
+		},
+		{
+			name:   "decrypt_algorithm",
+			alg:    kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256,
+			pub:    &rsaKey.PublicKey,
+			digest: digest,
+			sig:    pkcs1Sig,
+			err:    true,
+		},
+		{
+			name:   "wrong_key_type",
+			alg:    kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+			pub:    &rsaKey.PublicKey,
+			digest: digest,
+			sig:    ecSig,
+			err:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reason, err := verifySignature(tc.alg, tc.pub, tc.digest, tc.sig)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error to be %t, got %v", tc.err, err)
+			}
+			if reason != tc.reason {
+				t.Errorf("expected reason %q to be %q", reason, tc.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func (b *backend) pathKeysVersions() *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key") + "/versions/" + framework.GenericNameRegex("version"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "update",
+			OperationSuffix: "key-version",
+		},
+
+		HelpSynopsis: "Enable or disable a crypto key version",
+		HelpDescription: `
+Set the state of a crypto key version of the named key to enabled or disabled.
+Disabled versions cannot be used to encrypt, decrypt, sign, or verify.
+
+    $ vault write gcpkms/keys/my-key/versions/3 state=enabled
+
+Enabling a version which is scheduled for destruction restores it first.
+Destroyed versions cannot be enabled.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key in Vault.
+`,
+			},
+
+			"version": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Integer version of the crypto key version.
+`,
+			},
+
+			"state": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+State to set the crypto key version to, either "enabled" or "disabled". This
+field is required.
+`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathKeysVersionsWrite),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"key_version": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Integer version of the crypto key version.",
+								Required:    true,
+							},
+							"state": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "State of the crypto key version.",
+								Required:    true,
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// pathKeysVersionsWrite corresponds to PUT/POST
+// gcpkms/keys/:key/versions/:version and is used to enable or disable a
+// crypto key version.
+func (b *backend) pathKeysVersionsWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+	version := d.Get("version").(int)
+
+	var state kmspb.CryptoKeyVersion_CryptoKeyVersionState
+	switch s := d.Get("state").(string); s {
+	case "":
+		return nil, errMissingFields("state")
+	case "enabled":
+		state = kmspb.CryptoKeyVersion_ENABLED
+	case "disabled":
+		state = kmspb.CryptoKeyVersion_DISABLED
+	default:
+		return nil, logical.CodedError(400, fmt.Sprintf("invalid state %q, must be enabled or disabled", s))
+	}
+	if version <= 0 {
+		return nil, logical.CodedError(400, "version must be greater than 0")
+	}
+
+	k, err := b.Key(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	name := fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, version)
+	ckv, err := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+		Name: name,
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to read crypto key version: {{err}}", err)
+	}
+
+	// Versions scheduled for destruction are restored as disabled, and can
+	// then be enabled.
+	if ckv.State == kmspb.CryptoKeyVersion_DESTROY_SCHEDULED && state == kmspb.CryptoKeyVersion_ENABLED {
+		if ckv, err = kmsClient.RestoreCryptoKeyVersion(ctx, &kmspb.RestoreCryptoKeyVersionRequest{
+			Name: name,
+		}); err != nil {
+			return nil, wrapKMSError("failed to restore crypto key version: {{err}}", err)
+		}
+	}
+
+	switch ckv.State {
+	case state:
+	case kmspb.CryptoKeyVersion_ENABLED, kmspb.CryptoKeyVersion_DISABLED:
+		if ckv, err = kmsClient.UpdateCryptoKeyVersion(ctx, &kmspb.UpdateCryptoKeyVersionRequest{
+			CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+				Name:  name,
+				State: state,
+			},
+			UpdateMask: &fieldmaskpb.FieldMask{
+				Paths: []string{"state"},
+			},
+		}); err != nil {
+			return nil, wrapKMSError("failed to update crypto key version: {{err}}", err)
+		}
+	default:
+		return nil, logical.CodedError(400, fmt.Sprintf("version %d of key %q is %s and cannot be %s",
+			version, key, strings.ToLower(ckv.State.String()), d.Get("state").(string)))
+	}
+	b.invalidateCryptoKey(k.CryptoKeyID)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_version": version,
+			"state":       strings.ToLower(ckv.State.String()),
+		},
+	}, nil
+}
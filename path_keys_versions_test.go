@@ -0,0 +1,143 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/testhelpers/schema"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestPathKeysVersions_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "keys/my-key/versions/1")
+	})
+
+	cases := []struct {
+		name  string
+		from  kmspb.CryptoKeyVersion_CryptoKeyVersionState
+		state string
+		exp   string
+		err   bool
+	}{
+		{
+			"disable",
+			kmspb.CryptoKeyVersion_ENABLED,
+			"disabled",
+			"disabled",
+			false,
+		},
+		{
+			"enable",
+			kmspb.CryptoKeyVersion_DISABLED,
+			"enabled",
+			"enabled",
+			false,
+		},
+		{
+			"already_enabled",
+			kmspb.CryptoKeyVersion_ENABLED,
+			"enabled",
+			"enabled",
+			false,
+		},
+		{
+			"restore",
+			kmspb.CryptoKeyVersion_DESTROY_SCHEDULED,
+			"enabled",
+			"enabled",
+			false,
+		},
+		{
+			"disable_destroy_scheduled",
+			kmspb.CryptoKeyVersion_DESTROY_SCHEDULED,
+			"disabled",
+			"",
+			true,
+		},
+		{
+			"destroyed",
+			kmspb.CryptoKeyVersion_DESTROYED,
+			"enabled",
+			"",
+			true,
+		},
+		{
+			"invalid_state",
+			kmspb.CryptoKeyVersion_ENABLED,
+			"destroyed",
+			"",
+			true,
+		},
+		{
+			"missing_state",
+			kmspb.CryptoKeyVersion_ENABLED,
+			"",
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+			f := testFakeKMSClient(t, b)
+
+			cryptoKey := testFakeCryptoKey(t, f, kmspb.CryptoKey_ENCRYPT_DECRYPT,
+				kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+			f.lock.Lock()
+			f.versions[cryptoKey][0].ckv.State = tc.from
+			f.lock.Unlock()
+
+			ctx := context.Background()
+			if err := b.putKey(ctx, storage, &Key{
+				Name:        "my-key",
+				CryptoKeyID: cryptoKey,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "keys/my-key/versions/1",
+				Data: map[string]interface{}{
+					"state": tc.state,
+				},
+			})
+			if tc.err {
+				if herr, ok := err.(logical.HTTPCodedError); !ok || herr.Code() != 400 {
+					t.Fatalf("expected 400 error, got %#v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema.ValidateResponse(t, schema.GetResponseSchema(t, b.Route("keys/my-key/versions/1"), logical.UpdateOperation), resp, true)
+
+			if v := resp.Data["state"]; v != tc.exp {
+				t.Errorf("expected %q to be %q", v, tc.exp)
+			}
+			ckv, err := f.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+				Name: cryptoKey + "/cryptoKeyVersions/1",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, exp := ckv.State.String(), map[string]string{"enabled": "ENABLED", "disabled": "DISABLED"}[tc.exp]; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+		})
+	}
+}
@@ -6,12 +6,12 @@ package gcpkms
 import (
 	"context"
 	"fmt"
+	"net/http"
 
-	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func (b *backend) pathPubkey() *framework.Path {
@@ -36,6 +36,7 @@ named key. The key will only be available if the key is asymmetric.
 				Description: `
 Name of the key for which to get the public key. This key must already exist in
 Vault and Google Cloud KMS.
+This may also be the name of a key alias.
 `,
 			},
 
@@ -48,8 +49,37 @@ This field is required.
 			},
 		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation: withFieldValidator(b.pathPubkeyRead),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.pathPubkeyRead),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"pem": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "PEM-encoded public key.",
+								Required:    true,
+							},
+							"algorithm": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Algorithm of the crypto key version.",
+								Required:    true,
+							},
+							"fingerprint": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Hex-encoded SHA-256 digest of the DER-encoded public key.",
+								Required:    true,
+							},
+							"kid": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "JWK thumbprint of the public key.",
+								Required:    true,
+							},
+						},
+					}},
+				},
+			},
 		},
 	}
 }
@@ -64,7 +94,7 @@ func (b *backend) pathPubkeyRead(ctx context.Context, req *logical.Request, d *f
 		return nil, errMissingFields("key_version")
 	}
 
-	k, err := b.Key(ctx, req.Storage, key)
+	k, err := b.resolveKey(ctx, req.Storage, key)
 	if err != nil {
 		if err == ErrKeyNotFound {
 			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -72,35 +102,52 @@ func (b *backend) pathPubkeyRead(ctx context.Context, req *logical.Request, d *f
 		return nil, err
 	}
 
-	if k.MinVersion > 0 && keyVersion < k.MinVersion {
-		resp := fmt.Sprintf("requested version %d is less than minimum allowed version of %d",
-			keyVersion, k.MinVersion)
-		return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	if resp, err := k.checkVersion(keyVersion); err != nil {
+		return resp, err
 	}
 
-	if k.MaxVersion > 0 && keyVersion > k.MaxVersion {
-		resp := fmt.Sprintf("requested version %d is greater than maximum allowed version of %d",
-			keyVersion, k.MaxVersion)
-		return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
 	}
+	defer closer()
 
-	kmsClient, closer, err := b.KMSClient(req.Storage)
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	release, err := b.acquireKey(k.Name)
 	if err != nil {
 		return nil, err
 	}
-	defer closer()
+	defer release()
+
+	ck, err := b.cryptoKey(ctx, kmsClient, k.CryptoKeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPurpose(key, ck, "pubkey"); err != nil {
+		return nil, err
+	}
 
+	cryptoKeyVersion := fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, keyVersion)
 	pk, err := kmsClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
-		Name: fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, keyVersion),
+		Name: cryptoKeyVersion,
 	})
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to get public key: {{err}}", err)
+		return nil, wrapKMSVersionError(ctx, kmsClient, key, cryptoKeyVersion, "failed to get public key: {{err}}", err)
+	}
+
+	fingerprint, kid, err := publicKeyMetadata(pk.Pem)
+	if err != nil {
+		return nil, err
 	}
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"pem":       pk.Pem,
-			"algorithm": algorithmToString(pk.Algorithm),
+			"pem":         pk.Pem,
+			"algorithm":   algorithmToString(pk.Algorithm),
+			"fingerprint": fingerprint,
+			"kid":         kid,
 		},
 	}, nil
 }
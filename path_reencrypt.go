@@ -7,13 +7,14 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"path"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func (b *backend) pathReencrypt() *framework.Path {
@@ -38,6 +39,7 @@ the requestor.
 				Description: `
 Name of the key to use for encryption. This key must already exist in Vault and
 Google Cloud KMS.
+This may also be the name of a key alias.
 `,
 			},
 
@@ -65,8 +67,31 @@ unspecified, this defaults to the latest active crypto key version.
 			},
 		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: withFieldValidator(b.pathReencryptWrite),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.withUsageForwarding(b.pathReencryptWrite)),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"ciphertext": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Base64-encoded ciphertext under the new crypto key version.",
+								Required:    true,
+							},
+							"key_version": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Version of the crypto key version used for encryption.",
+								Required:    true,
+							},
+							"ciphertext_hmac": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "HMAC of the ciphertext, if include_hmac is configured.",
+							},
+						},
+					}},
+				},
+			},
 		},
 	}
 }
@@ -78,7 +103,7 @@ func (b *backend) pathReencryptWrite(ctx context.Context, req *logical.Request,
 	aad := d.Get("additional_authenticated_data").(string)
 	keyVersion := d.Get("key_version").(int)
 
-	k, err := b.Key(ctx, req.Storage, key)
+	k, err := b.resolveKey(ctx, req.Storage, key)
 	if err != nil {
 		if err == ErrKeyNotFound {
 			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -86,21 +111,14 @@ func (b *backend) pathReencryptWrite(ctx context.Context, req *logical.Request,
 		return nil, err
 	}
 
-	cryptoKey := k.CryptoKeyID
-	if keyVersion > 0 {
-		if k.MinVersion > 0 && keyVersion < k.MinVersion {
-			resp := fmt.Sprintf("requested version %d is less than minimum allowed version of %d",
-				keyVersion, k.MinVersion)
-			return logical.ErrorResponse(resp), logical.ErrPermissionDenied
-		}
+	if resp, err := k.checkAAD(aad); err != nil {
+		return resp, err
+	}
 
-		if k.MaxVersion > 0 && keyVersion > k.MaxVersion {
-			resp := fmt.Sprintf("requested version %d is greater than maximum allowed version of %d",
-				keyVersion, k.MaxVersion)
-			return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	if keyVersion > 0 {
+		if resp, err := k.checkVersion(keyVersion); err != nil {
+			return resp, err
 		}
-
-		cryptoKey = fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKey, keyVersion)
 	}
 
 	// We gave the user back base64-encoded ciphertext in the /encrypt payload
@@ -109,19 +127,46 @@ func (b *backend) pathReencryptWrite(ctx context.Context, req *logical.Request,
 		return nil, errwrap.Wrapf("failed to base64 decode ciphtertext: {{err}}", err)
 	}
 
-	kmsClient, closer, err := b.KMSClient(req.Storage)
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
 	if err != nil {
 		return nil, err
 	}
 	defer closer()
 
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	release, err := b.acquireKey(k.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ck, err := b.cryptoKey(ctx, kmsClient, k.CryptoKeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPurpose(key, ck, "reencrypt"); err != nil {
+		return nil, err
+	}
+	if err := b.checkKeyFIPS(ctx, req.Storage, key, ck); err != nil {
+		return nil, err
+	}
+
+	cryptoKey := fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, keyVersion)
+	if keyVersion <= 0 {
+		if cryptoKey, err = k.encryptCryptoKey(k.CryptoKeyID, ck); err != nil {
+			return nil, err
+		}
+	}
+
 	decResp, err := kmsClient.Decrypt(ctx, &kmspb.DecryptRequest{
 		Name:                        k.CryptoKeyID, // KMS chooses the version
 		Ciphertext:                  ciphertext,
 		AdditionalAuthenticatedData: []byte(aad),
 	})
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to decrypt ciphertext: {{err}}", err)
+		return nil, wrapKMSError("failed to decrypt ciphertext: {{err}}", err)
 	}
 
 	encResp, err := kmsClient.Encrypt(ctx, &kmspb.EncryptRequest{
@@ -130,13 +175,21 @@ func (b *backend) pathReencryptWrite(ctx context.Context, req *logical.Request,
 		AdditionalAuthenticatedData: []byte(aad),
 	})
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to encrypt new plaintext: {{err}}", err)
+		return nil, wrapKMSError("failed to encrypt new plaintext: {{err}}", err)
 	}
 
-	return &logical.Response{
+	b.recordUsage(k.Name, "reencrypt")
+
+	resp := &logical.Response{
 		Data: map[string]interface{}{
 			"key_version": path.Base(encResp.Name),
 			"ciphertext":  base64.StdEncoding.EncodeToString(encResp.Ciphertext),
 		},
-	}, nil
+	}
+	if err := b.addResponseHMACs(ctx, req.Storage, resp, map[string][]byte{
+		"ciphertext": encResp.Ciphertext,
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
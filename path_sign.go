@@ -5,14 +5,18 @@ package gcpkms
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func (b *backend) pathSign() *framework.Path {
@@ -26,8 +30,17 @@ func (b *backend) pathSign() *framework.Path {
 
 		HelpSynopsis: "Signs a message or digest using a named key",
 		HelpDescription: `
-Use the named key to sign a digest string. The response will be the
+Use the named key to sign a digest string, or a message which Vault hashes
+with the digest algorithm of the crypto key version. The response will be the
 base64-encoded signature.
+
+Keys with allowed_digest_algorithms or min_digest_length set only sign input
+messages, since Vault cannot tell which algorithm produced a given digest.
+
+The response also includes the public_key_fingerprint, the hex-encoded SHA-256
+digest of the DER-encoded public key, and the kid, the RFC 7638 JWK thumbprint
+of the public key, which is also returned by the pubkey endpoint. These let
+verifiers select the correct public key without another request.
 `,
 
 		Fields: map[string]*framework.FieldSchema{
@@ -36,6 +49,7 @@ base64-encoded signature.
 				Description: `
 Name of the key in Vault to use for signing. This key must already exist in
 Vault and must map back to a Google Cloud KMS key.
+This may also be the name of a key alias.
 `,
 			},
 
@@ -43,22 +57,104 @@ Vault and must map back to a Google Cloud KMS key.
 				Type: framework.TypeString,
 				Description: `
 Digest to sign. This digest must use the same SHA algorithm as the underlying
-Cloud KMS key. The digest must be the base64-encoded binary value. This field
-is required.
+Cloud KMS key. The digest must be the base64-encoded binary value. Exactly one
+of digest and input is required.
+`,
+			},
+
+			"input": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Message to sign. Vault hashes the message with the digest algorithm of the
+crypto key version and signs the digest. The message must be the
+base64-encoded binary value. Exactly one of digest and input is required, and
+input is required if the key has allowed_digest_algorithms or
+min_digest_length set.
 `,
 			},
 
 			"key_version": &framework.FieldSchema{
 				Type: framework.TypeInt,
 				Description: `
-Integer version of the crypto key version to use for signing. This field is
-required.
+Integer version of the crypto key version to use for signing. If unspecified,
+the newest enabled version within the key's min_version and max_version is
+used, and returned as key_version.
+`,
+			},
+
+			"digest_algorithm": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Algorithm which produced the digest: "sha1", "sha256", "sha384", or "sha512".
+If specified, it must match the digest algorithm of the crypto key version. If
+unspecified, the digest algorithm of the crypto key version is assumed.
+`,
+			},
+
+			"format": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "raw",
+				Description: `
+Format of the signature. Options are "raw" for the signature returned by Cloud
+KMS, or "cms" for a detached CMS (PKCS#7) SignedData structure wrapping it, for
+tooling which only understands PKCS#7. CMS signatures include the key's
+signer_certificate if it is set. The default is "raw".
 `,
 			},
 		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: withFieldValidator(b.pathSignWrite),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: withFieldValidator(b.withUsageForwarding(b.pathSignWrite)),
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Fields: map[string]*framework.FieldSchema{
+							"signature": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Base64-encoded signature, or detached CMS signature if format is cms.",
+								Required:    true,
+							},
+							"key_version": &framework.FieldSchema{
+								Type:        framework.TypeInt,
+								Description: "Version of the crypto key version used for signing.",
+								Required:    true,
+							},
+							"crypto_key_version": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Resource ID of the crypto key version used for signing.",
+								Required:    true,
+							},
+							"protection_level": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Protection level of the crypto key version.",
+								Required:    true,
+							},
+							"algorithm": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Algorithm of the crypto key version.",
+								Required:    true,
+							},
+							"public_key_fingerprint": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Hex-encoded SHA-256 digest of the DER-encoded public key.",
+							},
+							"kid": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "JWK thumbprint of the public key.",
+							},
+							"format": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Format of the signature, if it is not raw.",
+							},
+							"failover_crypto_key": &framework.FieldSchema{
+								Type:        framework.TypeString,
+								Description: "Resource ID of the failover crypto key used, if the key's location was unavailable.",
+							},
+						},
+					}},
+				},
+			},
 		},
 	}
 }
@@ -68,17 +164,30 @@ required.
 func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	key := d.Get("key").(string)
 	digest := d.Get("digest").(string)
+	input := d.Get("input").(string)
 	keyVersion := d.Get("key_version").(int)
 
-	if digest == "" {
+	if digest == "" && input == "" {
 		return nil, errMissingFields("digest")
 	}
+	if digest != "" && input != "" {
+		return nil, logical.CodedError(400, "only one of digest and input can be given")
+	}
+
+	digestAlgorithm := strings.ToLower(d.Get("digest_algorithm").(string))
+	if _, ok := digestAlgorithms[digestAlgorithm]; digestAlgorithm != "" && !ok {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"invalid digest_algorithm %q, valid algorithms are %q",
+			digestAlgorithm, digestAlgorithmNames()))
+	}
 
-	if keyVersion == 0 {
-		return nil, errMissingFields("key_version")
+	format := d.Get("format").(string)
+	if format != "raw" && format != "cms" {
+		return nil, logical.CodedError(400, fmt.Sprintf(
+			"invalid format %q, valid formats are %q", format, []string{"raw", "cms"}))
 	}
 
-	k, err := b.Key(ctx, req.Storage, key)
+	k, err := b.resolveKey(ctx, req.Storage, key)
 	if err != nil {
 		if err == ErrKeyNotFound {
 			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -86,75 +195,214 @@ func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, d *fr
 		return nil, err
 	}
 
-	if k.MinVersion > 0 && keyVersion < k.MinVersion {
-		resp := fmt.Sprintf("requested version %d is less than minimum allowed version of %d",
-			keyVersion, k.MinVersion)
-		return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	if keyVersion > 0 {
+		if resp, err := k.checkVersion(keyVersion); err != nil {
+			return resp, err
+		}
 	}
 
-	if k.MaxVersion > 0 && keyVersion > k.MaxVersion {
-		resp := fmt.Sprintf("requested version %d is greater than maximum allowed version of %d",
-			keyVersion, k.MaxVersion)
-		return logical.ErrorResponse(resp), logical.ErrPermissionDenied
+	// A digest policy cannot be enforced on digests the caller computed, since
+	// the caller chooses which algorithm it claims produced them.
+	if input == "" && k.hasDigestPolicy() {
+		return nil, logical.CodedError(400, fmt.Sprintf("key %q has a digest "+
+			"policy and only signs input, not digest", k.Name))
 	}
 
-	kmsClient, closer, err := b.KMSClient(req.Storage)
-	if err != nil {
-		return nil, err
-	}
-	defer closer()
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
 
-	ckv, err := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
-		Name: fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, keyVersion),
-	})
+	release, err := b.acquireKey(k.Name)
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to get underlying crypto key: {{err}}", err)
+		return nil, err
 	}
+	defer release()
 
-	var dig *kmspb.Digest
+	var resp *kmspb.AsymmetricSignResponse
+	var signedVersion *kmspb.CryptoKeyVersion
+	var pk *kmspb.PublicKey
+	var pkErr error
+	used, err := b.cryptoKeyFailover(ctx, req.Storage, k, keyVersion > 0, isKMSUnavailable, func(kmsClient keyManagementClient, cryptoKeyID string) error {
+		fk := k.forCryptoKey(cryptoKeyID)
+		version := keyVersion
 
-	switch ckv.Algorithm {
-	case kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256,
-		kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256,
-		kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256,
-		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
-		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256,
-		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256,
-		kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:
-		d, err := base64.StdEncoding.DecodeString(digest)
+		ck, err := b.cryptoKey(ctx, kmsClient, fk.CryptoKeyID)
 		if err != nil {
-			return nil, errwrap.Wrapf("failed to decode base64 digest: {{err}}", err)
+			return err
 		}
-		dig = &kmspb.Digest{
-			Digest: &kmspb.Digest_Sha256{
-				Sha256: d,
-			},
+		if err := checkKeyPurpose(key, ck, "sign"); err != nil {
+			return err
+		}
+		if err := b.checkKeyFIPS(ctx, req.Storage, key, ck); err != nil {
+			return err
 		}
-	case kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:
-		d, err := base64.StdEncoding.DecodeString(digest)
+
+		if version == 0 {
+			version, err = latestKeyVersion(ctx, kmsClient, fk)
+			if err != nil {
+				return err
+			}
+		}
+
+		ckv, err := kmsClient.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+			Name: fmt.Sprintf("%s/cryptoKeyVersions/%d", fk.CryptoKeyID, version),
+		})
 		if err != nil {
-			return nil, errwrap.Wrapf("failed to decode base64 digest: {{err}}", err)
+			return wrapKMSError("failed to get underlying crypto key: {{err}}", err)
+		}
+		if err := errCryptoKeyVersionState(key, ckv); err != nil {
+			return err
+		}
+		if err := b.checkKeyVersionFIPS(ctx, req.Storage, key, ckv.ProtectionLevel, ckv.Algorithm); err != nil {
+			return err
+		}
+
+		if format == "cms" {
+			if _, _, err := cmsAlgorithms(ckv.Algorithm); err != nil {
+				return logical.CodedError(400, err.Error())
+			}
+		}
+
+		var keyDigest string
+		switch ckv.Algorithm {
+		case kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256,
+			kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256,
+			kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256,
+			kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+			kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256,
+			kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256,
+			kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:
+			keyDigest = "sha256"
+		case kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:
+			keyDigest = "sha384"
+		default:
+			return logical.CodedError(400, fmt.Sprintf(
+				"key version %d has algorithm %q which cannot be used to sign",
+				version, algorithmToString(ckv.Algorithm)))
 		}
-		dig = &kmspb.Digest{
-			Digest: &kmspb.Digest_Sha384{
+
+		algorithm := keyDigest
+		if digestAlgorithm != "" {
+			algorithm = digestAlgorithm
+		}
+		if algorithm != keyDigest {
+			return logical.CodedError(400, fmt.Sprintf("digest_algorithm %q does not "+
+				"match key version %d, which signs %s digests", algorithm, version, keyDigest))
+		}
+
+		var d []byte
+		if input != "" {
+			msg, err := base64.StdEncoding.DecodeString(input)
+			if err != nil {
+				return logical.CodedError(400, fmt.Sprintf("failed to base64 decode input: %s", err))
+			}
+			d = hashDigest(keyDigest, msg)
+		} else {
+			d, err = base64.StdEncoding.DecodeString(digest)
+			if err != nil {
+				return errwrap.Wrapf("failed to decode base64 digest: {{err}}", err)
+			}
+		}
+
+		// Digests Vault computed are of the key version's algorithm, so the
+		// policy holds; caller digests are only checked for their length.
+		if err := k.checkDigest(keyDigest, d); err != nil {
+			return err
+		}
+
+		dig := &kmspb.Digest{}
+		switch keyDigest {
+		case "sha256":
+			dig.Digest = &kmspb.Digest_Sha256{
+				Sha256: d,
+			}
+		case "sha384":
+			dig.Digest = &kmspb.Digest_Sha384{
 				Sha384: d,
-			},
+			}
 		}
-	default:
-		return nil, fmt.Errorf("unknown key signing algorithm: %s", ckv.Algorithm)
-	}
 
-	resp, err := kmsClient.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
-		Name:   ckv.Name,
-		Digest: dig,
+		resp, err = kmsClient.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
+			Name:   ckv.Name,
+			Digest: dig,
+		})
+		if err != nil {
+			return wrapKMSError("failed to sign digest: {{err}}", err)
+		}
+		signedVersion = ckv
+
+		// The public key metadata lets verifiers select the key without
+		// another call, but signing does not require permission to view the
+		// public key.
+		pk, pkErr = b.publicKey(ctx, kmsClient, signedVersion.Name)
+		return nil
 	})
 	if err != nil {
-		return nil, errwrap.Wrapf("failed to sign digest: {{err}}", err)
+		return nil, err
 	}
+	b.recordUsage(k.Name, "sign")
 
-	return &logical.Response{
+	r := &logical.Response{
 		Data: map[string]interface{}{
-			"signature": base64.StdEncoding.EncodeToString(resp.Signature),
+			"signature":          base64.StdEncoding.EncodeToString(resp.Signature),
+			"key_version":        versionNumber(signedVersion.Name),
+			"crypto_key_version": signedVersion.Name,
+			"protection_level":   protectionLevelToString(signedVersion.ProtectionLevel),
+			"algorithm":          algorithmToString(signedVersion.Algorithm),
 		},
-	}, nil
+	}
+
+	if pkErr != nil {
+		if format == "cms" {
+			return nil, pkErr
+		}
+		b.Logger().Warn("failed to get public key of signature", "key", k.Name, "error", pkErr)
+		r.AddWarning("The public key fingerprint and kid could not be determined: " + pkErr.Error())
+	} else {
+		fingerprint, kid, err := publicKeyMetadata(pk.Pem)
+		if err != nil {
+			return nil, err
+		}
+		r.Data["public_key_fingerprint"] = fingerprint
+		r.Data["kid"] = kid
+	}
+
+	if format == "cms" {
+		sig, err := cmsSignature(k, signedVersion.Algorithm, resp.Signature, pk.Pem)
+		if err != nil {
+			return nil, err
+		}
+		r.Data["signature"] = base64.StdEncoding.EncodeToString(sig)
+		r.Data["format"] = format
+	}
+
+	if used != k.CryptoKeyID {
+		r.Data["failover_crypto_key"] = used
+		r.AddWarning(fmt.Sprintf("the location of crypto key %q is unavailable, "+
+			"signed with failover crypto key %q", k.CryptoKeyID, used))
+	}
+	return r, nil
+}
+
+// cmsSignature wraps the signature into a detached CMS SignedData, identifying
+// the signer by the key's signer certificate if it has one.
+func cmsSignature(k *Key, a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm, signature []byte, publicKey string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, fmt.Errorf("public key is not in pem format: %s", publicKey)
+	}
+
+	var cert *x509.Certificate
+	if k.SignerCertificate != "" {
+		var err error
+		cert, err = parseSignerCertificate(k.SignerCertificate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sig, err := detachedCMSSignature(a, signature, block.Bytes, cert)
+	if err != nil {
+		return nil, logical.CodedError(400, err.Error())
+	}
+	return sig, nil
 }
@@ -14,13 +14,14 @@ import (
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
+	"fmt"
 	"math/big"
 	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
 
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 func TestPathSign_Write(t *testing.T) {
@@ -29,6 +30,222 @@ func TestPathSign_Write(t *testing.T) {
 		testFieldValidation(t, logical.UpdateOperation, "sign/my-key")
 	})
 
+	t.Run("invalid_format", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "sign/my-key",
+			Data: map[string]interface{}{
+				"digest": "AAAA",
+				"format": "pkcs12",
+			},
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 400 {
+			t.Errorf("expected 400 error, got %#v", err)
+		}
+	})
+
+	t.Run("digest_policy", func(t *testing.T) {
+
+		cryptoKey, cleanup := testCreateKMSCryptoKeyAsymmetricSign(t,
+			kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+		defer cleanup()
+
+		msg := []byte("hello world")
+		h := sha256.Sum256(msg)
+		digest := base64.StdEncoding.EncodeToString(h[:])
+		input := base64.StdEncoding.EncodeToString(msg)
+
+		cases := []struct {
+			name string
+			key  string
+			data map[string]interface{}
+			err  bool
+		}{
+			{
+				"input",
+				`"min_digest_length":256`,
+				map[string]interface{}{"input": input},
+				false,
+			},
+			{
+				"input_no_policy",
+				`"name":"my-key"`,
+				map[string]interface{}{"input": input},
+				false,
+			},
+			{
+				"digest_with_policy",
+				`"min_digest_length":256`,
+				map[string]interface{}{"digest": digest},
+				true,
+			},
+			{
+				"algorithm_not_allowed",
+				`"allowed_digest_algorithms":["sha384"]`,
+				map[string]interface{}{"input": input},
+				true,
+			},
+			{
+				"digest_and_input",
+				`"name":"my-key"`,
+				map[string]interface{}{"digest": digest, "input": input},
+				true,
+			},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+
+				b, storage := testBackend(t)
+
+				ctx := context.Background()
+				if err := storage.Put(ctx, &logical.StorageEntry{
+					Key:   "keys/my-key",
+					Value: []byte(`{"name":"my-key", "crypto_key_id":"` + cryptoKey + `", ` + tc.key + `}`),
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				resp, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "sign/my-key",
+					Data:      tc.data,
+				})
+				if (err != nil) != tc.err {
+					t.Fatal(err)
+				}
+				if tc.err {
+					if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 400 {
+						t.Errorf("expected 400 error, got %#v", err)
+					}
+					return
+				}
+
+				sig, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+				if err != nil {
+					t.Fatal(err)
+				}
+				pk, err := testKMSClient(t).GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
+					Name: cryptoKey + "/cryptoKeyVersions/1",
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				block, _ := pem.Decode([]byte(pk.Pem))
+				if block == nil {
+					t.Fatalf("not pem: %s", pk.Pem)
+				}
+				pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// The input was hashed by Vault before signing
+				var parsedSig struct{ R, S *big.Int }
+				if _, err := asn1.Unmarshal(sig, &parsedSig); err != nil {
+					t.Fatal(err)
+				}
+				if !ecdsa.Verify(pub.(*ecdsa.PublicKey), h[:], parsedSig.R, parsedSig.S) {
+					t.Error("invalid signature")
+				}
+			})
+		}
+	})
+
+	t.Run("auto_select_version", func(t *testing.T) {
+
+		cryptoKey, cleanup := testCreateKMSCryptoKeyAsymmetricSign(t,
+			kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+		defer cleanup()
+
+		kmsClient := testKMSClient(t)
+
+		ctx := context.Background()
+		if _, err := kmsClient.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+			Parent: cryptoKey,
+			CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+				State: kmspb.CryptoKeyVersion_ENABLED,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		h := sha256.Sum256([]byte("hello world"))
+		digest := base64.StdEncoding.EncodeToString(h[:])
+
+		cases := []struct {
+			name string
+			key  string
+			exp  int
+			err  bool
+		}{
+			{
+				"max_version",
+				`"max_version":1`,
+				1,
+				false,
+			},
+			{
+				"no_version_in_bounds",
+				`"min_version":5`,
+				0,
+				true,
+			},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+
+				b, storage := testBackend(t)
+
+				if err := storage.Put(ctx, &logical.StorageEntry{
+					Key:   "keys/my-key",
+					Value: []byte(`{"name":"my-key", "crypto_key_id":"` + cryptoKey + `", ` + tc.key + `}`),
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				resp, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "sign/my-key",
+					Data: map[string]interface{}{
+						"digest": digest,
+					},
+				})
+				if (err != nil) != tc.err {
+					t.Fatal(err)
+				}
+				if tc.err {
+					return
+				}
+
+				if v := resp.Data["key_version"]; v != tc.exp {
+					t.Errorf("expected %v to be %d", v, tc.exp)
+				}
+				if v, exp := resp.Data["crypto_key_version"], fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKey, tc.exp); v != exp {
+					t.Errorf("expected %v to be %q", v, exp)
+				}
+				if v, exp := resp.Data["protection_level"], "software"; v != exp {
+					t.Errorf("expected %v to be %q", v, exp)
+				}
+			})
+		}
+	})
+
 	t.Run("asymmetric", func(t *testing.T) {
 
 		algorithms := []kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathStatus() *framework.Path {
+	return &framework.Path{
+		Pattern: "status",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "read",
+			OperationSuffix: "status",
+		},
+
+		HelpSynopsis: "Report the health of the connection to Google Cloud KMS",
+		HelpDescription: `
+Report the health of this node's connection to Google Cloud KMS, for monitoring
+systems and debugging. This includes when a call to KMS last succeeded and
+failed, the age of each cached KMS client, whether calls are being held back
+because the KMS quota is exhausted and how many are waiting, and the type of
+the configured credentials. Reading the status does not call KMS.
+
+    $ vault read gcpkms/status
+`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: withFieldValidator(b.pathStatusRead),
+		},
+	}
+}
+
+// pathStatusRead corresponds to GET gcpkms/status and is used to report the
+// health of the connection to KMS.
+func (b *backend) pathStatusRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	c, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	data := map[string]interface{}{
+		"credential_type": c.identity().Type,
+	}
+
+	lastSuccess, lastFailure, lastError := b.health.status()
+	data["reachable"] = !lastSuccess.IsZero() && !lastSuccess.Before(lastFailure)
+	if !lastSuccess.IsZero() {
+		data["last_success_time"] = lastSuccess.Format(time.RFC3339)
+	}
+	if !lastFailure.IsZero() {
+		data["last_failure_time"] = lastFailure.Format(time.RFC3339)
+		data["last_error"] = lastError
+	}
+
+	until, queued, depth := b.throttle.status()
+	throttle := map[string]interface{}{
+		"backing_off": until.After(now),
+		"queued":      queued,
+		"queue_depth": depth,
+	}
+	if until.After(now) {
+		throttle["backoff_until"] = until.Format(time.RFC3339)
+	}
+	data["throttle"] = throttle
+
+	b.kmsClientLock.Lock()
+	clients := make([]map[string]interface{}, 0, len(b.kmsClients))
+	for ck, h := range b.kmsClients {
+		clients = append(clients, map[string]interface{}{
+			"endpoint":        ck.endpoint,
+			"service_account": ck.serviceAccount,
+			"profile":         ck.profile,
+			"age_seconds":     int64(now.Sub(h.createTime).Seconds()),
+			"in_use":          h.refs,
+		})
+	}
+	b.kmsClientLock.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i]["age_seconds"].(int64) > clients[j]["age_seconds"].(int64)
+	})
+	data["clients"] = clients
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathStatus_Read(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "status")
+	})
+
+	read := func(t *testing.T, b *backend, storage logical.Storage) map[string]interface{} {
+		t.Helper()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "status",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data
+	}
+
+	t.Run("no_calls", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		data := read(t, b, storage)
+		if v, exp := data["reachable"], false; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+		if v, exp := data["credential_type"], "application_default"; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+		if v := data["clients"].([]map[string]interface{}); len(v) != 0 {
+			t.Errorf("expected no clients, got %#v", v)
+		}
+	})
+
+	t.Run("calls", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		b.health.observe(errors.New("unavailable"))
+		b.health.observe(nil)
+
+		data := read(t, b, storage)
+		if v, exp := data["reachable"], true; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+		if v, exp := data["last_error"], "unavailable"; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func (b *backend) pathWrapKey() *framework.Path {
+	return &framework.Path{
+		Pattern: "wrapkey/" + framework.GenericNameRegex("key"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixGoogleCloudKMS,
+			OperationVerb:   "wrap",
+			OperationSuffix: "key-material",
+		},
+
+		HelpSynopsis: "Wrap key material with the public key of the named key",
+		HelpDescription: `
+Wrap caller-supplied key material with the public key of a Google Cloud KMS
+asymmetric decryption key, for import into an external HSM which holds the
+private key, or into Google Cloud KMS.
+
+The wrapped key uses the PKCS#11 CKM_RSA_AES_KEY_WRAP format: a new AES-256 key
+encrypted with the RSA public key using OAEP with the hash of the key's
+algorithm, followed by the key material wrapped with the AES key using AES key
+wrap with padding (RFC 5649). The key material never leaves Vault unwrapped.
+`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"key": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Name of the key whose public key wraps the key material. This key must already
+exist in Vault and Google Cloud KMS, and must be an RSA asymmetric decryption
+key. This may also be the name of a key alias.
+`,
+			},
+
+			"key_version": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+Integer version of the crypto key version whose public key wraps the key
+material. If unspecified, this defaults to the latest enabled crypto key version.
+`,
+			},
+
+			"key_material": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+Base64-encoded key material to wrap. This field is required.
+`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: withFieldValidator(b.pathWrapKeyWrite),
+		},
+	}
+}
+
+// pathWrapKeyWrite corresponds to PUT/POST gcpkms/wrapkey/:key and is used to
+// wrap key material with the public key of a crypto key version.
+func (b *backend) pathWrapKeyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	key := d.Get("key").(string)
+	keyVersion := d.Get("key_version").(int)
+
+	material := d.Get("key_material").(string)
+	if material == "" {
+		return nil, errMissingFields("key_material")
+	}
+	keyMaterial, err := base64.StdEncoding.DecodeString(material)
+	if err != nil {
+		return nil, logical.CodedError(400, fmt.Sprintf("failed to base64 decode key_material: %s", err))
+	}
+	if len(keyMaterial) == 0 {
+		return nil, logical.CodedError(400, "key_material cannot be empty")
+	}
+
+	k, err := b.resolveKey(ctx, req.Storage, key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, err
+	}
+
+	if keyVersion > 0 {
+		if resp, err := k.checkVersion(keyVersion); err != nil {
+			return resp, err
+		}
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, k)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	release, err := b.acquireKey(k.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ck, err := b.cryptoKey(ctx, kmsClient, k.CryptoKeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPurpose(key, ck, "wrapkey"); err != nil {
+		return nil, err
+	}
+	if err := b.checkKeyFIPS(ctx, req.Storage, key, ck); err != nil {
+		return nil, err
+	}
+
+	if keyVersion == 0 {
+		keyVersion, err = latestKeyVersion(ctx, kmsClient, k)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cryptoKeyVersion := fmt.Sprintf("%s/cryptoKeyVersions/%d", k.CryptoKeyID, keyVersion)
+	pk, err := kmsClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
+		Name: cryptoKeyVersion,
+	})
+	if err != nil {
+		return nil, wrapKMSVersionError(ctx, kmsClient, key, cryptoKeyVersion, "failed to get public key: {{err}}", err)
+	}
+
+	hash, ok := oaepHashes[pk.Algorithm]
+	if !ok {
+		return nil, logical.CodedError(400, fmt.Sprintf("key %q has algorithm %q "+
+			"which cannot wrap key material, an RSA decryption algorithm is required",
+			key, algorithmToString(pk.Algorithm)))
+	}
+
+	block, _ := pem.Decode([]byte(pk.Pem))
+	if block == nil {
+		return nil, fmt.Errorf("public key is not in pem format: %s", pk.Pem)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to parse public key: {{err}}", err)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an RSA public key")
+	}
+
+	wrapped, err := rsaAESKeyWrap(rsaPub, hash, keyMaterial)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_version": keyVersion,
+			"algorithm":   algorithmToString(pk.Algorithm),
+			"wrapped_key": base64.StdEncoding.EncodeToString(wrapped),
+		},
+	}, nil
+}
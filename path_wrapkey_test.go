@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPathWrapKey_Write(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.UpdateOperation, "wrapkey/my-key")
+	})
+
+	t.Run("invalid_key_material", func(t *testing.T) {
+
+		cases := []struct {
+			name     string
+			material string
+		}{
+			{"missing", ""},
+			{"not_base64", "not base64!"},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+
+				b, storage := testBackend(t)
+
+				ctx := context.Background()
+				_, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      "wrapkey/my-key",
+					Data: map[string]interface{}{
+						"key_material": tc.material,
+					},
+				})
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if cerr, ok := err.(logical.HTTPCodedError); !ok || cerr.Code() != 400 {
+					t.Errorf("expected 400 error, got %#v", err)
+				}
+			})
+		}
+	})
+}
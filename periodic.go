@@ -0,0 +1,108 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// periodicFunc is invoked by Vault on a timer. It rotates the service account
+// key in the config and keys which are due for a scheduled rotation, saves the
+// usage statistics of keys, automatically trims the crypto key versions of
+// keys with auto_trim enabled, and checks keys for drift and orphaned crypto
+// keys if configured.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	var errs *multierror.Error
+	if b.canSaveUsage() {
+		if err := b.flushUsage(ctx, req.Storage); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	// Only rotate and trim from one node in the cluster
+	if !b.WriteSafeReplicationState() {
+		return errs.ErrorOrNil()
+	}
+
+	now := time.Now().UTC()
+
+	if err := b.autoRotateRoot(ctx, req.Storage, now); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	if err := b.autoRotate(ctx, req.Storage, now); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	if b.autoTrimDue(now) {
+		if err := b.autoTrim(ctx, req.Storage, now); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	if err := b.autoDriftCheck(ctx, req.Storage, now); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	if err := b.autoOrphanCheck(ctx, req.Storage, now); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// autoRotateRoot rotates the service account key in the config if it is due.
+// The Vault SDK this plugin is built with has no rotation manager, so the
+// rotation is scheduled here from rotation_period alone.
+func (b *backend) autoRotateRoot(ctx context.Context, s logical.Storage, now time.Time) error {
+	c, err := b.Config(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !c.rotationDue(now) {
+		return nil
+	}
+
+	keyID, warnings, err := b.rotateRoot(ctx, s)
+	if err != nil {
+		return errwrap.Wrapf("failed to rotate the service account key in the config: {{err}}", err)
+	}
+	b.Logger().Info("rotated the service account key in the config", "private_key_id", keyID)
+	for _, w := range warnings {
+		b.Logger().Warn(w)
+	}
+	return nil
+}
+
+// autoDriftCheck checks keys for drift from their expected state if the
+// configured drift check interval has passed.
+func (b *backend) autoDriftCheck(ctx context.Context, s logical.Storage, now time.Time) error {
+	c, err := b.Config(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !b.driftCheckDue(now, c.DriftCheckInterval) {
+		return nil
+	}
+	return b.driftCheck(ctx, s)
+}
+
+// autoTrimDue returns true if the auto trim interval has passed since the last
+// automatic trim, and records now as the last run if so.
+func (b *backend) autoTrimDue(now time.Time) bool {
+	b.autoTrimLock.Lock()
+	defer b.autoTrimLock.Unlock()
+
+	if now.Sub(b.autoTrimLastRun) < b.autoTrimInterval {
+		return false
+	}
+	b.autoTrimLastRun = now
+	return true
+}
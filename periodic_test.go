@@ -0,0 +1,36 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_PeriodicFunc(t *testing.T) {
+
+	t.Run("auto_trim_interval", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := b.periodicFunc(ctx, &logical.Request{Storage: storage}); err != nil {
+			t.Fatal(err)
+		}
+
+		lastRun := b.autoTrimLastRun
+		if lastRun.IsZero() {
+			t.Fatal("expected auto trim to run")
+		}
+
+		if err := b.periodicFunc(ctx, &logical.Request{Storage: storage}); err != nil {
+			t.Fatal(err)
+		}
+		if !b.autoTrimLastRun.Equal(lastRun) {
+			t.Errorf("expected auto trim to be skipped within the interval")
+		}
+	})
+}
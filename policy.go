@@ -0,0 +1,219 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	uuid "github.com/satori/go.uuid"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// resourceLocationRegex matches the project and location of the resource ID
+// of a Cloud KMS location, key ring, or crypto key.
+var resourceLocationRegex = regexp.MustCompile(`^projects/([^/]+)/locations/([^/]+)(/|$)`)
+
+// keyNameTemplateVarRegex matches a variable in a crypto key name template.
+var keyNameTemplateVarRegex = regexp.MustCompile(`{{\s*([^{}\s]*)\s*}}`)
+
+// invalidResourceIDCharRegex matches the characters which are not allowed in
+// a key ring or crypto key ID.
+var invalidResourceIDCharRegex = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// checkKeyPolicy returns an error if the key policy in the config does not
+// allow a key to be created or registered at the resource, which is the
+// resource ID of a location, key ring, or crypto key.
+func (b *backend) checkKeyPolicy(ctx context.Context, s logical.Storage, resource string) error {
+	config, err := b.Config(ctx, s)
+	if err != nil {
+		return err
+	}
+	if len(config.AllowedLocations) == 0 && len(config.AllowedProjects) == 0 {
+		return nil
+	}
+
+	m := resourceLocationRegex.FindStringSubmatch(resource)
+	if m == nil {
+		return logical.CodedError(400, fmt.Sprintf("invalid resource ID %q, "+
+			"expected projects/<project>/locations/<location>/...", resource))
+	}
+	if err := config.checkProject(m[1]); err != nil {
+		return err
+	}
+	return config.checkLocation(m[2])
+}
+
+// checkProject returns an error if the config restricts the projects of keys
+// and the project is not one of them.
+func (c *Config) checkProject(project string) error {
+	if len(c.AllowedProjects) == 0 || strutil.StrListContains(c.AllowedProjects, project) {
+		return nil
+	}
+	return logical.CodedError(400, fmt.Sprintf("project %q is not allowed by "+
+		"the key policy, allowed projects are %q", project, c.AllowedProjects))
+}
+
+// checkLocation returns an error if the config restricts the locations of
+// keys and the location is not one of them.
+func (c *Config) checkLocation(location string) error {
+	if len(c.AllowedLocations) == 0 || strutil.StrListContains(c.AllowedLocations, location) {
+		return nil
+	}
+	return logical.CodedError(400, fmt.Sprintf("location %q is not allowed by "+
+		"the key policy, allowed locations are %q", location, c.AllowedLocations))
+}
+
+// restrictsCryptoKeys returns true if the config restricts the protection
+// levels or algorithms of keys, or enforces FIPS mode, so they must be known
+// to register a key.
+func (c *Config) restrictsCryptoKeys() bool {
+	return len(c.AllowedProtectionLevels) > 0 || len(c.AllowedAlgorithms) > 0 || c.FIPSEnforcement
+}
+
+// checkKeyNames returns an error if the config restricts the names of key
+// rings or crypto keys and either name does not match.
+func (c *Config) checkKeyNames(keyRing, cryptoKey string) error {
+	for _, f := range []struct {
+		kind, name, regex string
+	}{
+		{"key ring", keyRing, c.KeyRingNameRegex},
+		{"crypto key", cryptoKey, c.CryptoKeyNameRegex},
+	} {
+		if f.regex == "" {
+			continue
+		}
+		re, err := regexp.Compile(f.regex)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("invalid %s name regex in config: {{err}}", f.kind), err)
+		}
+		if !re.MatchString(f.name) {
+			return logical.CodedError(400, fmt.Sprintf("%s name %q is not allowed "+
+				"by the key policy, names must match %q", f.kind, f.name, f.regex))
+		}
+	}
+	return nil
+}
+
+// expandKeyNameTemplate returns the crypto key name generated by the template
+// for the key with the given name in the mount.
+func expandKeyNameTemplate(tmpl, mount, name string) (string, error) {
+	var err error
+	out := keyNameTemplateVarRegex.ReplaceAllStringFunc(tmpl, func(v string) string {
+		switch keyNameTemplateVarRegex.FindStringSubmatch(v)[1] {
+		case "mount":
+			return strings.Trim(invalidResourceIDCharRegex.ReplaceAllString(mount, "-"), "-")
+		case "name":
+			return name
+		case "uuid":
+			return uuid.NewV4().String()
+		}
+		if err == nil {
+			err = fmt.Errorf("unknown variable %s, valid variables are "+
+				"{{mount}}, {{name}}, and {{uuid}}", v)
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// checkCryptoKey returns an error if the config restricts the protection
+// levels or algorithms of keys, or enforces FIPS mode, and the crypto key is
+// not allowed.
+func (c *Config) checkCryptoKey(ck *kmspb.CryptoKey) error {
+	if c.FIPSEnforcement {
+		if err := checkCryptoKeyFIPS(ck); err != nil {
+			return err
+		}
+	}
+
+	t := ck.GetVersionTemplate()
+	if err := c.checkProtectionLevel(t.GetProtectionLevel()); err != nil {
+		return err
+	}
+	return c.checkAlgorithm(t.GetAlgorithm())
+}
+
+// checkProtectionLevel returns an error if the config restricts the
+// protection levels of keys and the protection level is not one of them.
+func (c *Config) checkProtectionLevel(p kmspb.ProtectionLevel) error {
+	name := policyProtectionLevelName(p)
+	if len(c.AllowedProtectionLevels) == 0 || strutil.StrListContains(c.AllowedProtectionLevels, name) {
+		return nil
+	}
+	return logical.CodedError(400, fmt.Sprintf("protection level %q is not "+
+		"allowed by the key policy, allowed protection levels are %q",
+		name, c.AllowedProtectionLevels))
+}
+
+// checkAlgorithm returns an error if the config restricts the algorithms of
+// keys and the algorithm is not one of them, or if the config enforces FIPS
+// mode and the algorithm is not FIPS-approved.
+func (c *Config) checkAlgorithm(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) error {
+	if c.FIPSEnforcement && !fipsAlgorithms[a] {
+		return errNotFIPSApproved(a)
+	}
+
+	name := policyAlgorithmName(a)
+	if len(c.AllowedAlgorithms) == 0 || strutil.StrListContains(c.AllowedAlgorithms, name) {
+		return nil
+	}
+	return logical.CodedError(400, fmt.Sprintf("algorithm %q is not allowed "+
+		"by the key policy, allowed algorithms are %q", name, c.AllowedAlgorithms))
+}
+
+// policyProtectionLevelName returns the name of the protection level used in
+// the key policy: the name Vault uses, or else the lowercase Cloud KMS name,
+// so protection levels Vault cannot create may still be allowed.
+func policyProtectionLevelName(p kmspb.ProtectionLevel) string {
+	if name := protectionLevelToString(p); name != "unknown" {
+		return name
+	}
+	return strings.ToLower(p.String())
+}
+
+// policyAlgorithmName returns the name of the algorithm used in the key
+// policy, in the same way as policyProtectionLevelName.
+func policyAlgorithmName(a kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) string {
+	if name := algorithmToString(a); name != "unspecified" {
+		return name
+	}
+	return strings.ToLower(a.String())
+}
+
+// parsePolicyProtectionLevel returns the policy name of the protection level
+// given by its Vault or Cloud KMS name, and false if there is no such
+// protection level.
+func parsePolicyProtectionLevel(name string) (string, bool) {
+	if _, ok := keyProtectionLevels[name]; ok {
+		return name, true
+	}
+	v, ok := kmspb.ProtectionLevel_value[strings.ToUpper(name)]
+	if !ok || v == int32(kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED) {
+		return "", false
+	}
+	return policyProtectionLevelName(kmspb.ProtectionLevel(v)), true
+}
+
+// parsePolicyAlgorithm returns the policy name of the algorithm given by its
+// Vault or Cloud KMS name, and false if there is no such algorithm.
+func parsePolicyAlgorithm(name string) (string, bool) {
+	if _, ok := keyAlgorithms[name]; ok {
+		return name, true
+	}
+	v, ok := kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm_value[strings.ToUpper(name)]
+	if !ok || v == int32(kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED) {
+		return "", false
+	}
+	return policyAlgorithmName(kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm(v)), true
+}
@@ -0,0 +1,297 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestConfig_CheckLocation(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		allowed  []string
+		location string
+		err      bool
+	}{
+		{"unrestricted", nil, "us-east1", false},
+		{"allowed", []string{"us-east1", "us-west1"}, "us-west1", false},
+		{"denied", []string{"us-east1"}, "europe-west1", true},
+		{"global_denied", []string{"us-east1"}, "global", true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			c := &Config{AllowedLocations: tc.allowed}
+			if err := c.checkLocation(tc.location); (err != nil) != tc.err {
+				t.Errorf("expected error to be %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestBackend_CheckKeyPolicy(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"allowed_locations": "us-east1,us-west1",
+			"allowed_projects":  "my-project",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		resource string
+		err      bool
+	}{
+		{"location", "projects/my-project/locations/us-east1", false},
+		{"key_ring", "projects/my-project/locations/us-west1/keyRings/r", false},
+		{"crypto_key", "projects/my-project/locations/us-east1/keyRings/r/cryptoKeys/k", false},
+		{"location_denied", "projects/my-project/locations/europe-west1/keyRings/r", true},
+		{"project_denied", "projects/other-project/locations/us-east1/keyRings/r", true},
+		{"invalid", "not-a-resource", true},
+	}
+
+	for _, tc := range cases {
+		err := b.checkKeyPolicy(ctx, storage, tc.resource)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error to be %t, got %v", tc.name, tc.err, err)
+		}
+		if v, ok := err.(logical.HTTPCodedError); err != nil && (!ok || v.Code() != 400) {
+			t.Errorf("%s: expected %q to be a 400", tc.name, err)
+		}
+	}
+
+	// Registration is refused outside the allowed locations
+	_, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/register/my-key",
+		Data: map[string]interface{}{
+			"crypto_key": "projects/my-project/locations/global/keyRings/r/cryptoKeys/k",
+			"verify":     false,
+		},
+	})
+	if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+		t.Errorf("expected %q to be a 400", err)
+	}
+}
+
+func TestConfig_CheckCryptoKey(t *testing.T) {
+
+	hsm := &kmspb.CryptoKey{
+		VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			ProtectionLevel: kmspb.ProtectionLevel_HSM,
+			Algorithm:       kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+		},
+	}
+	oaepSHA1 := &kmspb.CryptoKey{
+		VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			ProtectionLevel: kmspb.ProtectionLevel_HSM,
+			Algorithm:       kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA1,
+		},
+	}
+
+	cases := []struct {
+		name   string
+		config *Config
+		ck     *kmspb.CryptoKey
+		err    bool
+	}{
+		{"unrestricted", &Config{}, oaepSHA1, false},
+		{"protection_level_allowed", &Config{AllowedProtectionLevels: []string{"hsm"}}, hsm, false},
+		{"protection_level_denied", &Config{AllowedProtectionLevels: []string{"software"}}, hsm, true},
+		{"algorithm_allowed", &Config{AllowedAlgorithms: []string{"symmetric_encryption"}}, hsm, false},
+		{"algorithm_denied", &Config{AllowedAlgorithms: []string{"symmetric_encryption"}}, oaepSHA1, true},
+		{"unknown_algorithm_allowed", &Config{AllowedAlgorithms: []string{"rsa_decrypt_oaep_2048_sha1"}}, oaepSHA1, false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			if err := tc.config.checkCryptoKey(tc.ck); (err != nil) != tc.err {
+				t.Errorf("expected error to be %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestParsePolicyAlgorithm(t *testing.T) {
+
+	cases := []struct {
+		name string
+		exp  string
+		ok   bool
+	}{
+		{"symmetric_encryption", "symmetric_encryption", true},
+		{"google_symmetric_encryption", "symmetric_encryption", true},
+		{"rsa_decrypt_oaep_2048_sha1", "rsa_decrypt_oaep_2048_sha1", true},
+		{"crypto_key_version_algorithm_unspecified", "", false},
+		{"not_an_algorithm", "", false},
+	}
+
+	for _, tc := range cases {
+		v, ok := parsePolicyAlgorithm(tc.name)
+		if v != tc.exp || ok != tc.ok {
+			t.Errorf("%s: expected (%q, %t), got (%q, %t)", tc.name, tc.exp, tc.ok, v, ok)
+		}
+	}
+}
+
+func TestPathKeysRegister_PolicyRequiresVerify(t *testing.T) {
+
+	b, storage := testBackend(t)
+
+	ctx := context.Background()
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"allowed_protection_levels": "hsm",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := b.HandleRequest(ctx, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/register/my-key",
+		Data: map[string]interface{}{
+			"crypto_key": "projects/p/locations/global/keyRings/r/cryptoKeys/k",
+			"verify":     false,
+		},
+	})
+	if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+		t.Errorf("expected %q to be a 400", err)
+	}
+}
+
+func TestConfig_CheckKeyNames(t *testing.T) {
+
+	c := &Config{
+		KeyRingNameRegex:   "^prod-",
+		CryptoKeyNameRegex: "^team-[a-z]+-",
+	}
+
+	cases := []struct {
+		name      string
+		keyRing   string
+		cryptoKey string
+		err       bool
+	}{
+		{"allowed", "prod-ring", "team-payments-key", false},
+		{"key_ring_denied", "dev-ring", "team-payments-key", true},
+		{"crypto_key_denied", "prod-ring", "my-key", true},
+	}
+
+	for _, tc := range cases {
+		err := c.checkKeyNames(tc.keyRing, tc.cryptoKey)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error to be %t, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	if err := (&Config{}).checkKeyNames("any", "name"); err != nil {
+		t.Errorf("expected no restriction, got %v", err)
+	}
+}
+
+func TestPathKeys_CryptoKeyNameTemplate(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		template string
+		key      string
+	}{
+		{"too_long", "{{name}}-{{uuid}}-{{uuid}}", "my-key"},
+		{"invalid_characters", "{{name}}", "my.key"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+			f := testFakeKMSClient(t, b)
+
+			ctx := context.Background()
+			if _, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "config",
+				Data: map[string]interface{}{
+					"crypto_key_name_template": tc.template,
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.CreateOperation,
+				Path:      "keys/" + tc.key,
+				Data: map[string]interface{}{
+					"key_ring": "projects/p/locations/global/keyRings/r",
+				},
+			})
+			if v, ok := err.(logical.HTTPCodedError); !ok || v.Code() != 400 {
+				t.Fatalf("expected %q to be a 400", err)
+			}
+			if !strings.Contains(err.Error(), "crypto_key_name_template") {
+				t.Errorf("expected %q to name the template", err)
+			}
+
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			if n := len(f.cryptoKeys); n != 0 {
+				t.Errorf("expected no crypto keys to be created, got %d", n)
+			}
+		})
+	}
+}
+
+func TestExpandKeyNameTemplate(t *testing.T) {
+
+	v, err := expandKeyNameTemplate("{{mount}}-{{ name }}", "teams/gcp.kms/", "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "teams-gcp-kms-my-key"; v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+
+	v, err = expandKeyNameTemplate("{{name}}-{{uuid}}", "gcpkms/", "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resourceIDRegex.MatchString(v) || !strings.HasPrefix(v, "my-key-") || len(v) != len("my-key-")+36 {
+		t.Errorf("expected %q to be the name and a UUID", v)
+	}
+
+	if _, err := expandKeyNameTemplate("{{name}}-{{nope}}", "gcpkms/", "my-key"); err == nil {
+		t.Error("expected an error for an unknown variable")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// maxCachedPublicKeys is the most public keys kept in the backend's cache, so
+// a mount with many crypto key versions does not grow it without bound.
+const maxCachedPublicKeys = 4096
+
+// cachedPublicKey is an entry of the public keys cache. The purpose of the
+// crypto key is kept with the public key, so verifying with the cached copy
+// checks the key may be used to verify without calling KMS.
+type cachedPublicKey struct {
+	publicKey *kmspb.PublicKey
+	purpose   kmspb.CryptoKey_CryptoKeyPurpose
+}
+
+// publicKey returns the public key of the crypto key version, using the cached
+// copy if there is one. The public key of a crypto key version never changes,
+// so the cached copy is only removed when the version is trimmed or its key
+// is deleted.
+func (b *backend) publicKey(ctx context.Context, kmsClient keyManagementClient, cryptoKeyVersion string) (*kmspb.PublicKey, error) {
+	if c, ok := b.cachedPublicKey(cryptoKeyVersion); ok {
+		return c.publicKey, nil
+	}
+
+	pk, err := kmsClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
+		Name: cryptoKeyVersion,
+	})
+	if err != nil {
+		return nil, wrapKMSError("failed to get public key: {{err}}", err)
+	}
+
+	b.cachePublicKey(cryptoKeyVersion, pk, algorithmPurpose(pk.Algorithm))
+	return pk, nil
+}
+
+// cachedPublicKey returns the cached public key of the crypto key version
+// without calling KMS, and whether there is one.
+func (b *backend) cachedPublicKey(cryptoKeyVersion string) (*cachedPublicKey, bool) {
+	if v, ok := b.publicKeysCache.Get(cryptoKeyVersion); ok {
+		if c, ok := v.(*cachedPublicKey); ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// cachePublicKey caches the public key of the crypto key version with the
+// purpose of its crypto key. If the cache is full, an arbitrary entry is
+// evicted to make room.
+func (b *backend) cachePublicKey(cryptoKeyVersion string, pk *kmspb.PublicKey, purpose kmspb.CryptoKey_CryptoKeyPurpose) {
+	if _, ok := b.publicKeysCache.Get(cryptoKeyVersion); !ok && b.publicKeysCache.ItemCount() >= maxCachedPublicKeys {
+		for k := range b.publicKeysCache.Items() {
+			b.publicKeysCache.Delete(k)
+			break
+		}
+	}
+	b.publicKeysCache.SetDefault(cryptoKeyVersion, &cachedPublicKey{
+		publicKey: pk,
+		purpose:   purpose,
+	})
+}
+
+// evictPublicKeys removes the cached public keys of every version of the
+// crypto key. This must be called when versions are disabled or destroyed,
+// or the key is removed from Vault, so offline verification cannot use them.
+func (b *backend) evictPublicKeys(cryptoKeyID string) {
+	prefix := cryptoKeyID + "/cryptoKeyVersions/"
+	for k := range b.publicKeysCache.Items() {
+		if strings.HasPrefix(k, prefix) {
+			b.publicKeysCache.Delete(k)
+		}
+	}
+}
+
+// publicKeyMetadata returns the fingerprint and key ID of the PEM-encoded
+// public key. The fingerprint is the hex-encoded SHA-256 digest of the DER
+// SubjectPublicKeyInfo, and the key ID is the JWK thumbprint of RFC 7638.
+func publicKeyMetadata(p string) (string, string, error) {
+	block, _ := pem.Decode([]byte(p))
+	if block == nil {
+		return "", "", fmt.Errorf("public key is not in pem format: %s", p)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", "", errwrap.Wrapf("failed to parse public key: {{err}}", err)
+	}
+
+	kid, err := jwkThumbprint(pub)
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:]), kid, nil
+}
+
+// jwkThumbprint returns the base64url-encoded SHA-256 JWK thumbprint of the
+// public key, defined in RFC 7638, which is the conventional JWKS key ID.
+func jwkThumbprint(pub crypto.PublicKey) (string, error) {
+	// The members are ordered lexicographically, as the thumbprint requires
+	var members interface{}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{
+			Crv: pub.Curve.Params().Name,
+			Kty: "EC",
+			X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+		}
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", errwrap.Wrapf("failed to encode public key: {{err}}", err)
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+
+	t.Run("rsa", func(t *testing.T) {
+
+		// Example from RFC 7638 section 3.1.
+		n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		kid, err := jwkThumbprint(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := kid, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+
+		if _, err := jwkThumbprint("not a key"); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestPublicKeyMetadata(t *testing.T) {
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	fingerprint, kid, err := publicKeyMetadata(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(der)
+	if v, exp := fingerprint, hex.EncodeToString(sum[:]); v != exp {
+		t.Errorf("expected %q to be %q", v, exp)
+	}
+
+	exp, err := jwkThumbprint(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != exp {
+		t.Errorf("expected %q to be %q", kid, exp)
+	}
+
+	if _, _, err := publicKeyMetadata("not pem"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestBackend_PublicKeysCache(t *testing.T) {
+
+	b, _ := testBackend(t)
+
+	cryptoKey := "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	for i := 1; i <= maxCachedPublicKeys+10; i++ {
+		b.cachePublicKey(fmt.Sprintf("%s/cryptoKeyVersions/%d", cryptoKey, i),
+			&kmspb.PublicKey{}, kmspb.CryptoKey_ASYMMETRIC_SIGN)
+	}
+	if n := b.publicKeysCache.ItemCount(); n != maxCachedPublicKeys {
+		t.Errorf("expected %d to be %d", n, maxCachedPublicKeys)
+	}
+
+	b.evictPublicKeys(cryptoKey)
+	if n := b.publicKeysCache.ItemCount(); n != 0 {
+		t.Errorf("expected %d to be %d", n, 0)
+	}
+
+	// Only the versions of the crypto key are evicted, not those of a crypto
+	// key whose ID shares its prefix
+	other := cryptoKey + "2/cryptoKeyVersions/1"
+	b.cachePublicKey(cryptoKey+"/cryptoKeyVersions/1", &kmspb.PublicKey{},
+		kmspb.CryptoKey_ASYMMETRIC_SIGN)
+	b.cachePublicKey(other, &kmspb.PublicKey{}, kmspb.CryptoKey_ASYMMETRIC_SIGN)
+	b.evictPublicKeys(cryptoKey)
+	if _, ok := b.cachedPublicKey(other); !ok || b.publicKeysCache.ItemCount() != 1 {
+		t.Errorf("expected only %q to be cached", other)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/time/rate"
+)
+
+// rateLimits enforces the client-side limits on KMS calls made for crypto
+// operations, so a single noisy key cannot exhaust the project's KMS quota for
+// every other key on the mount.
+type rateLimits struct {
+	// global limits the rate of operations across all keys, or is nil if
+	// there is no limit.
+	global *rate.Limiter
+
+	keyRateLimit      float64
+	keyMaxConcurrency int
+
+	lock sync.Mutex
+	keys map[string]*keyLimits
+}
+
+// keyLimits are the limits for a single key.
+type keyLimits struct {
+	// limiter limits the rate of operations on the key, or is nil if there is
+	// no limit.
+	limiter *rate.Limiter
+
+	// sem caps the number of concurrent operations on the key, or is nil if
+	// there is no cap.
+	sem chan struct{}
+}
+
+// newRateLimits creates the rate limits from the config.
+func newRateLimits(c *Config) *rateLimits {
+	l := &rateLimits{
+		keyRateLimit:      c.KeyRateLimit,
+		keyMaxConcurrency: c.KeyMaxConcurrency,
+		keys:              make(map[string]*keyLimits),
+	}
+	if c.RateLimit > 0 {
+		l.global = newLimiter(c.RateLimit)
+	}
+	return l
+}
+
+// newLimiter creates a limiter which allows bursts of up to one second of
+// operations.
+func newLimiter(qps float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(qps), int(math.Max(1, math.Ceil(qps))))
+}
+
+// acquire reserves an operation on the key. It returns a 429 coded error if
+// the operation would exceed any of the limits. Otherwise the caller must call
+// the returned function once the operation completes.
+func (l *rateLimits) acquire(key string) (func(), error) {
+	kl := l.key(key)
+
+	if kl.sem != nil {
+		select {
+		case kl.sem <- struct{}{}:
+		default:
+			return nil, logical.CodedError(429, fmt.Sprintf(
+				"too many concurrent operations on key %q, retry the request later", key))
+		}
+	}
+
+	release := func() {
+		if kl.sem != nil {
+			<-kl.sem
+		}
+	}
+
+	if kl.limiter != nil && !kl.limiter.Allow() {
+		release()
+		return nil, logical.CodedError(429, fmt.Sprintf(
+			"rate limit exceeded for key %q, retry the request later", key))
+	}
+
+	if l.global != nil && !l.global.Allow() {
+		release()
+		return nil, logical.CodedError(429,
+			"rate limit exceeded, retry the request later")
+	}
+
+	return release, nil
+}
+
+// key returns the limits for the key, creating them if they do not exist.
+func (l *rateLimits) key(key string) *keyLimits {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	kl, ok := l.keys[key]
+	if !ok {
+		kl = new(keyLimits)
+		if l.keyRateLimit > 0 {
+			kl.limiter = newLimiter(l.keyRateLimit)
+		}
+		if l.keyMaxConcurrency > 0 {
+			kl.sem = make(chan struct{}, l.keyMaxConcurrency)
+		}
+		l.keys[key] = kl
+	}
+	return kl
+}
+
+// acquireKey reserves an operation on the key against the configured rate
+// limits. The caller must hold the client returned by KMSClient, which is when
+// the limits are loaded from the config, and must call the returned function
+// once the operation completes.
+func (b *backend) acquireKey(key string) (func(), error) {
+	var l *rateLimits
+	b.kmsClientLock.Lock()
+	if b.kmsSettings != nil {
+		l = b.kmsSettings.rateLimits
+	}
+	b.kmsClientLock.Unlock()
+
+	if l == nil {
+		return func() {}, nil
+	}
+	return l.acquire(key)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRateLimits_Acquire(t *testing.T) {
+
+	testLimited := func(tb testing.TB, err error) {
+		tb.Helper()
+
+		if err == nil {
+			tb.Fatal("expected error")
+		}
+		if code := err.(logical.HTTPCodedError).Code(); code != 429 {
+			tb.Errorf("expected %d to be %d", code, 429)
+		}
+	}
+
+	t.Run("no_limits", func(t *testing.T) {
+
+		l := newRateLimits(DefaultConfig())
+		for i := 0; i < 100; i++ {
+			release, err := l.acquire("my-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			release()
+		}
+	})
+
+	t.Run("key_max_concurrency", func(t *testing.T) {
+
+		l := newRateLimits(&Config{KeyMaxConcurrency: 1})
+
+		release, err := l.acquire("my-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = l.acquire("my-key")
+		testLimited(t, err)
+
+		// Other keys are not affected
+		otherRelease, err := l.acquire("my-other-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherRelease()
+
+		release()
+		if _, err := l.acquire("my-key"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("key_rate_limit", func(t *testing.T) {
+
+		l := newRateLimits(&Config{KeyRateLimit: 1})
+
+		if _, err := l.acquire("my-key"); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := l.acquire("my-key")
+		testLimited(t, err)
+
+		// Other keys are not affected
+		if _, err := l.acquire("my-other-key"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("rate_limit", func(t *testing.T) {
+
+		l := newRateLimits(&Config{RateLimit: 1, KeyMaxConcurrency: 1})
+
+		release, err := l.acquire("my-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+
+		_, err = l.acquire("my-other-key")
+		testLimited(t, err)
+
+		// The rejected operation does not hold a concurrency slot
+		if kl := l.key("my-other-key"); len(kl.sem) != 0 {
+			t.Errorf("expected %d to be %d", len(kl.sem), 0)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// reauthTokenSource is a refreshing token source which can be rebuilt from
+// freshly loaded credentials, such as after a workload identity federation
+// token is rotated underneath the plugin.
+type reauthTokenSource struct {
+	lock sync.Mutex
+	ts   oauth2.TokenSource
+	load func() (oauth2.TokenSource, error)
+}
+
+// newReauthTokenSource creates a token source which issues tokens from ts
+// until it is reloaded, after which it issues tokens from the token source
+// returned by load.
+func newReauthTokenSource(ts oauth2.TokenSource, load func() (oauth2.TokenSource, error)) *reauthTokenSource {
+	return &reauthTokenSource{
+		ts:   refreshingTokenSource(ts),
+		load: load,
+	}
+}
+
+// Token implements oauth2.TokenSource.
+func (s *reauthTokenSource) Token() (*oauth2.Token, error) {
+	s.lock.Lock()
+	ts := s.ts
+	s.lock.Unlock()
+	return ts.Token()
+}
+
+// reload loads fresh credentials, discarding any cached token.
+func (s *reauthTokenSource) reload() error {
+	ts, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.ts = refreshingTokenSource(ts)
+	s.lock.Unlock()
+	return nil
+}
+
+// reauthInterceptor is a gRPC interceptor which handles KMS rejecting the
+// credentials of the client for h. It retires the client, so later requests
+// use a new client, then reloads the credentials and retries the call once.
+func (b *backend) reauthInterceptor(h *kmsClientHandle) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if grpcstatus.Code(err) != grpccodes.Unauthenticated {
+			return err
+		}
+
+		b.Logger().Warn("KMS rejected the client credentials, reloading credentials and retrying",
+			"method", method)
+		b.retireClient(h)
+
+		if rerr := h.tokenSource.reload(); rerr != nil {
+			b.Logger().Error("failed to reload credentials", "error", rerr)
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestBackend_ReauthInterceptor(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		errs    []error
+		err     grpccodes.Code
+		calls   int
+		reloads int
+	}{
+		{
+			"success",
+			[]error{nil},
+			grpccodes.OK,
+			1,
+			0,
+		},
+		{
+			"other_error",
+			[]error{grpcstatus.Error(grpccodes.NotFound, "not found")},
+			grpccodes.NotFound,
+			1,
+			0,
+		},
+		{
+			"recovers",
+			[]error{
+				grpcstatus.Error(grpccodes.Unauthenticated, "expired"),
+				nil,
+			},
+			grpccodes.OK,
+			2,
+			1,
+		},
+		{
+			"retries_once",
+			[]error{
+				grpcstatus.Error(grpccodes.Unauthenticated, "expired"),
+				grpcstatus.Error(grpccodes.Unauthenticated, "expired"),
+			},
+			grpccodes.Unauthenticated,
+			2,
+			1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, _ := testBackend(t)
+
+			reloads := 0
+			h := &kmsClientHandle{
+				createTime: time.Now().UTC(),
+				lifetime:   time.Hour,
+				refs:       1,
+			}
+			h.tokenSource = newReauthTokenSource(&countingTokenSource{ttl: time.Hour},
+				func() (oauth2.TokenSource, error) {
+					reloads++
+					return &countingTokenSource{ttl: time.Hour}, nil
+				})
+			b.kmsClients[clientKey{}] = h
+
+			calls := 0
+			invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			}
+
+			err := b.reauthInterceptor(h)(context.Background(), "/test", nil, nil, nil, invoker)
+			if code := grpcstatus.Code(err); code != tc.err {
+				t.Errorf("expected %v to be %v", code, tc.err)
+			}
+			if calls != tc.calls {
+				t.Errorf("expected %d to be %d", calls, tc.calls)
+			}
+			if reloads != tc.reloads {
+				t.Errorf("expected %d to be %d", reloads, tc.reloads)
+			}
+
+			// The client is retired whenever the credentials were rejected
+			if retired := b.kmsClients[clientKey{}] == nil; retired != (tc.reloads > 0) {
+				t.Errorf("expected retired to be %t", tc.reloads > 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	// walTypeCryptoKey is the kind of WAL entry written before creating a
+	// crypto key.
+	walTypeCryptoKey = "crypto_key"
+)
+
+// walCryptoKey is the WAL entry written before creating a crypto key. If Vault
+// crashes after the crypto key is created but before the Vault key is saved,
+// the rollback handler uses it to find the crypto key.
+type walCryptoKey struct {
+	// Key is the name of the key in Vault.
+	Key string `json:"key" mapstructure:"key"`
+
+	// CryptoKeyID is the full resource ID of the crypto key being created.
+	CryptoKeyID string `json:"crypto_key_id" mapstructure:"crypto_key_id"`
+
+	// ImpersonateServiceAccount and ConfigName are those of the key being
+	// created, so the crypto key is recovered with the same client it was
+	// created with and the recovered key keeps them.
+	ImpersonateServiceAccount string `json:"impersonate_service_account,omitempty" mapstructure:"impersonate_service_account"`
+	ConfigName                string `json:"config_name,omitempty" mapstructure:"config_name"`
+
+	// StartTime is the unix time at which the create started. Crypto keys
+	// created before this time existed before the create and are never
+	// modified by the rollback.
+	StartTime int64 `json:"start_time" mapstructure:"start_time"`
+}
+
+// walRollback is called by the framework for each WAL entry which was not
+// deleted by the operation that wrote it.
+func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	switch kind {
+	case walTypeCryptoKey:
+		return b.cryptoKeyRollback(ctx, req, data)
+	default:
+		return fmt.Errorf("unknown WAL entry kind %q", kind)
+	}
+}
+
+// cryptoKeyRollback recovers from an interrupted key create. If the crypto key
+// was created and the Vault key does not exist, the registration is completed.
+// If the Vault key name has since been used for a different crypto key, the
+// orphaned crypto key is scheduled for destruction.
+func (b *backend) cryptoKeyRollback(ctx context.Context, req *logical.Request, data interface{}) error {
+	var entry walCryptoKey
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return errwrap.Wrapf("failed to decode WAL entry: {{err}}", err)
+	}
+
+	recovered := &Key{
+		Name:                      entry.Key,
+		CryptoKeyID:               entry.CryptoKeyID,
+		ImpersonateServiceAccount: entry.ImpersonateServiceAccount,
+		ConfigName:                entry.ConfigName,
+	}
+
+	kmsClient, closer, err := b.KeyKMSClient(ctx, req.Storage, recovered)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ctx, cancel := b.kmsContext(ctx)
+	defer cancel()
+
+	ck, err := kmsClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+		Name: entry.CryptoKeyID,
+	})
+	if err != nil {
+		// The crypto key was never created, so there is nothing to recover
+		if terr, ok := grpcstatus.FromError(err); ok && terr.Code() == grpccodes.NotFound {
+			return nil
+		}
+		return errwrap.Wrapf("failed to read crypto key: {{err}}", err)
+	}
+
+	// The crypto key existed before the create, so it is not ours to recover
+	if ck.CreateTime == nil || ck.CreateTime.Seconds < entry.StartTime {
+		return nil
+	}
+
+	k, err := b.Key(ctx, req.Storage, entry.Key)
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+
+	if k == nil {
+		b.Logger().Info("completing registration of crypto key after interrupted create",
+			"key", entry.Key, "crypto_key_id", ck.Name)
+
+		recovered.CryptoKeyID = ck.Name
+		_, err := b.putNewKey(ctx, req.Storage, recovered)
+		return err
+	}
+
+	if k.CryptoKeyID == ck.Name {
+		return nil
+	}
+
+	b.Logger().Warn("scheduling destruction of orphaned crypto key after interrupted create",
+		"key", entry.Key, "crypto_key_id", ck.Name)
+	return destroyCryptoKey(ctx, kmsClient, ck.Name)
+}
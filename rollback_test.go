@@ -0,0 +1,156 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestBackend_WALRollback(t *testing.T) {
+
+	t.Run("unknown_kind", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		if err := b.walRollback(context.Background(), &logical.Request{
+			Storage: storage,
+		}, "not-a-real-kind", nil); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("key_client", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+		testFakeKMSClient(t, b)
+
+		// The crypto key only exists in the client of the impersonated service
+		// account, not the default client
+		serviceAccount := "sa@p.iam.gserviceaccount.com"
+		f := newFakeKMSClient()
+		b.kmsClients[clientKey{serviceAccount: serviceAccount}] = &kmsClientHandle{
+			client:     f,
+			createTime: time.Now().UTC(),
+			lifetime:   time.Hour,
+		}
+		cryptoKey := testFakeCryptoKey(t, f, kmspb.CryptoKey_ENCRYPT_DECRYPT,
+			kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+
+		ctx := context.Background()
+		if err := b.walRollback(ctx, &logical.Request{
+			Storage: storage,
+		}, walTypeCryptoKey, map[string]interface{}{
+			"key":                         "my-key",
+			"crypto_key_id":               cryptoKey,
+			"impersonate_service_account": serviceAccount,
+			"start_time":                  float64(0),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := b.Key(ctx, storage, "my-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := k.CryptoKeyID, cryptoKey; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+		if v, exp := k.ImpersonateServiceAccount, serviceAccount; v != exp {
+			t.Errorf("expected %q to be %q", v, exp)
+		}
+	})
+
+	cryptoKey, cleanup := testCreateKMSCryptoKeySymmetric(t)
+	defer cleanup()
+
+	cases := []struct {
+		name      string
+		startTime int64
+		existing  string
+		exp       string
+		destroyed bool
+	}{
+		{
+			"completes_registration",
+			0,
+			"",
+			cryptoKey,
+			false,
+		},
+		{
+			"already_registered",
+			0,
+			cryptoKey,
+			cryptoKey,
+			false,
+		},
+		{
+			"created_before_start",
+			time.Now().Add(time.Hour).Unix(),
+			"",
+			"",
+			false,
+		},
+		{
+			"orphaned",
+			0,
+			"projects/p/locations/l/keyRings/kr/cryptoKeys/other",
+			"projects/p/locations/l/keyRings/kr/cryptoKeys/other",
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			if tc.existing != "" {
+				if err := storage.Put(ctx, &logical.StorageEntry{
+					Key:   "keys/my-key",
+					Value: []byte(`{"name":"my-key", "crypto_key_id":"` + tc.existing + `"}`),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// WAL entries are decoded from JSON, so pass the data as a map
+			if err := b.walRollback(ctx, &logical.Request{
+				Storage: storage,
+			}, walTypeCryptoKey, map[string]interface{}{
+				"key":           "my-key",
+				"crypto_key_id": cryptoKey,
+				"start_time":    float64(tc.startTime),
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			k, err := b.Key(ctx, storage, "my-key")
+			if tc.exp == "" {
+				if err != ErrKeyNotFound {
+					t.Errorf("expected key to not be registered: %v", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v, exp := k.CryptoKeyID, tc.exp; v != exp {
+					t.Errorf("expected %q to be %q", v, exp)
+				}
+			}
+
+			if tc.destroyed {
+				testCryptoKeyVersionsDestroyed(t, cryptoKey)
+			}
+		})
+	}
+}
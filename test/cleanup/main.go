@@ -18,10 +18,10 @@ import (
 
 	"github.com/gammazero/workerpool"
 	"google.golang.org/api/iterator"
-	"google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
 	kmsapi "cloud.google.com/go/kms/apiv1"
-	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
 )
 
 var (
@@ -168,7 +168,7 @@ func listCKs(parent string) []string {
 						NextRotationTime: nil,
 						RotationSchedule: nil,
 					},
-					UpdateMask: &field_mask.FieldMask{
+					UpdateMask: &fieldmaskpb.FieldMask{
 						Paths: []string{
 							"next_rotation_time",
 							"rotation_period",
@@ -197,7 +197,7 @@ func disableCKRotation(ck string) {
 			NextRotationTime: nil,
 			RotationSchedule: nil,
 		},
-		UpdateMask: &field_mask.FieldMask{
+		UpdateMask: &fieldmaskpb.FieldMask{
 			Paths: []string{"next_rotation_time", "rotation_period"},
 		},
 	}); err != nil {
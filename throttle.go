@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+var (
+	// throttleInitialBackoff and throttleMaxBackoff bound how long calls to
+	// KMS are held back after KMS reports the quota is exhausted. The backoff
+	// doubles each time KMS reports the quota is still exhausted.
+	throttleInitialBackoff = 1 * time.Second
+	throttleMaxBackoff     = 60 * time.Second
+)
+
+// quotaThrottle holds back calls to KMS after KMS reports the project's quota
+// is exhausted, instead of hammering the API and extending the quota penalty.
+// Calls made while backing off wait in a queue of bounded depth; calls which
+// do not fit in the queue fail immediately with a throttledError.
+type quotaThrottle struct {
+	lock       sync.Mutex
+	until      time.Time
+	backoff    time.Duration
+	queued     int
+	queueDepth int
+}
+
+// throttledError is returned for calls to KMS which were not made because the
+// throttle queue is full.
+type throttledError struct {
+	retryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("KMS quota exceeded and too many requests are waiting, "+
+		"retry after %s", e.retryAfter.Round(time.Second))
+}
+
+// setQueueDepth sets the maximum number of calls which may wait while backing
+// off.
+func (t *quotaThrottle) setQueueDepth(depth int) {
+	t.lock.Lock()
+	t.queueDepth = depth
+	t.lock.Unlock()
+}
+
+// status returns when the current backoff ends, which is in the past if the
+// throttle is not backing off, and the number of calls waiting and the maximum
+// which may wait.
+func (t *quotaThrottle) status() (time.Time, int, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.until, t.queued, t.queueDepth
+}
+
+// wait blocks until the throttle is not backing off. It returns a
+// throttledError if the queue is full, or the context's error if it is
+// cancelled while waiting.
+func (t *quotaThrottle) wait(ctx context.Context) error {
+	t.lock.Lock()
+	d := time.Until(t.until)
+	if d <= 0 {
+		t.lock.Unlock()
+		return nil
+	}
+	if t.queued >= t.queueDepth {
+		t.lock.Unlock()
+		return &throttledError{retryAfter: d}
+	}
+	t.queued++
+	t.lock.Unlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	t.lock.Lock()
+	t.queued--
+	t.lock.Unlock()
+
+	return err
+}
+
+// observe records the result of a call to KMS. A ResourceExhausted error
+// starts or extends the backoff, honoring the delay KMS asks for if it is
+// longer. A successful call resets the backoff.
+func (t *quotaThrottle) observe(err error) {
+	if err == nil {
+		t.lock.Lock()
+		t.backoff = 0
+		t.lock.Unlock()
+		return
+	}
+
+	s, ok := grpcstatus.FromError(err)
+	if !ok || s.Code() != grpccodes.ResourceExhausted {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch {
+	case t.backoff == 0:
+		t.backoff = throttleInitialBackoff
+	case t.backoff < throttleMaxBackoff:
+		t.backoff *= 2
+		if t.backoff > throttleMaxBackoff {
+			t.backoff = throttleMaxBackoff
+		}
+	}
+
+	d := t.backoff
+	for _, detail := range s.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+			if v := info.RetryDelay.AsDuration(); v > d {
+				d = v
+			}
+		}
+	}
+
+	if until := time.Now().Add(d); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// unaryInterceptor is a gRPC interceptor which applies the throttle to every
+// call made by the KMS client, including each retry.
+func (t *quotaThrottle) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := t.wait(ctx); err != nil {
+		return err
+	}
+
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	t.observe(err)
+	return err
+}
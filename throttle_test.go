@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestQuotaThrottle(t *testing.T) {
+
+	exhausted := grpcstatus.Error(grpccodes.ResourceExhausted, "quota exceeded")
+
+	t.Run("not_backing_off", func(t *testing.T) {
+
+		var q quotaThrottle
+		q.observe(grpcstatus.Error(grpccodes.NotFound, "not found"))
+		if err := q.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("queue_full", func(t *testing.T) {
+
+		var q quotaThrottle
+		q.observe(exhausted)
+
+		var terr *throttledError
+		if err := q.wait(context.Background()); !errors.As(err, &terr) {
+			t.Fatalf("expected throttled error, got %v", err)
+		}
+	})
+
+	t.Run("queued", func(t *testing.T) {
+
+		var q quotaThrottle
+		q.setQueueDepth(1)
+		q.observe(exhausted)
+
+		// Shorten the backoff so the test does not wait for it
+		q.lock.Lock()
+		q.until = time.Now().Add(50 * time.Millisecond)
+		q.lock.Unlock()
+
+		start := time.Now()
+		if err := q.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Errorf("expected to wait for the backoff, waited %s", d)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+
+		var q quotaThrottle
+		q.setQueueDepth(1)
+		q.observe(exhausted)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := q.wait(ctx); err != context.Canceled {
+			t.Fatalf("expected %v to be %v", err, context.Canceled)
+		}
+		if q.queued != 0 {
+			t.Errorf("expected %d to be %d", q.queued, 0)
+		}
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+
+		var q quotaThrottle
+
+		q.observe(exhausted)
+		if q.backoff != throttleInitialBackoff {
+			t.Errorf("expected %s to be %s", q.backoff, throttleInitialBackoff)
+		}
+
+		q.observe(exhausted)
+		if exp := 2 * throttleInitialBackoff; q.backoff != exp {
+			t.Errorf("expected %s to be %s", q.backoff, exp)
+		}
+
+		for i := 0; i < 10; i++ {
+			q.observe(exhausted)
+		}
+		if q.backoff != throttleMaxBackoff {
+			t.Errorf("expected %s to be %s", q.backoff, throttleMaxBackoff)
+		}
+
+		q.observe(nil)
+		if q.backoff != 0 {
+			t.Errorf("expected %s to be reset", q.backoff)
+		}
+	})
+}
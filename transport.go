@@ -0,0 +1,220 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// parseProxyURL parses and validates the URL of an egress proxy.
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid proxy_url %q, scheme must be http or https", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url %q, missing host", s)
+	}
+	return u, nil
+}
+
+// redactProxyURL returns the proxy URL with any password redacted, so it can
+// be returned when reading the config.
+func redactProxyURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// parseCACertificate parses a PEM-encoded bundle of CA certificates into a
+// certificate pool.
+func parseCACertificate(s string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(s)) {
+		return nil, fmt.Errorf("invalid ca_certificate, no PEM-encoded certificates found")
+	}
+	return pool, nil
+}
+
+// transport holds the egress proxy and CA bundle used to reach KMS and the
+// token endpoints.
+type transport struct {
+	proxyURL  *url.URL
+	tlsConfig *tls.Config
+}
+
+// newTransport creates the transport from the config. It returns nil if the
+// config does not use a proxy or a custom CA bundle.
+func newTransport(c *Config) (*transport, error) {
+	if c.ProxyURL == "" && c.CACertificate == "" {
+		return nil, nil
+	}
+
+	t := new(transport)
+
+	if c.ProxyURL != "" {
+		u, err := parseProxyURL(c.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		t.proxyURL = u
+	}
+
+	if c.CACertificate != "" {
+		pool, err := parseCACertificate(c.CACertificate)
+		if err != nil {
+			return nil, err
+		}
+		t.tlsConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return t, nil
+}
+
+// context returns a context which makes oauth2 use the transport when
+// fetching tokens.
+func (t *transport) context(ctx context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if t.proxyURL != nil {
+		tr.Proxy = http.ProxyURL(t.proxyURL)
+	}
+	if t.tlsConfig != nil {
+		tr.TLSClientConfig = t.tlsConfig.Clone()
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: tr})
+}
+
+// clientOptions returns the options which make the KMS client use the
+// transport.
+func (t *transport) clientOptions() []option.ClientOption {
+	if t == nil {
+		return nil
+	}
+
+	var opts []option.ClientOption
+	if t.proxyURL != nil {
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithContextDialer(t.dialProxy)))
+	}
+	if t.tlsConfig != nil {
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithTransportCredentials(
+			credentials.NewTLS(t.tlsConfig.Clone()))))
+	}
+	return opts
+}
+
+// dialProxy opens a connection to addr through the proxy with an HTTP CONNECT
+// request.
+func (t *transport) dialProxy(ctx context.Context, addr string) (net.Conn, error) {
+	proxyAddr := t.proxyURL.Host
+	if t.proxyURL.Port() == "" {
+		if t.proxyURL.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(t.proxyURL.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(t.proxyURL.Hostname(), "80")
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial proxy: %w", err)
+	}
+
+	if t.proxyURL.Scheme == "https" {
+		cfg := &tls.Config{
+			ServerName: t.proxyURL.Hostname(),
+			MinVersion: tls.VersionTLS12,
+		}
+		if t.tlsConfig != nil {
+			cfg.RootCAs = t.tlsConfig.RootCAs
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	// Abort the CONNECT if the context is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := t.proxyURL.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to proxy: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		stop()
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+
+	// The proxy may have sent bytes from the server along with the response
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were already read into a
+// buffer.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
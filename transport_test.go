@@ -0,0 +1,228 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testCACertificate returns a PEM-encoded self-signed CA certificate.
+func testCACertificate(tb testing.TB) string {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestNewTransport(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		c     *Config
+		nil   bool
+		proxy bool
+		tls   bool
+		err   bool
+	}{
+		{
+			"none",
+			&Config{},
+			true,
+			false,
+			false,
+			false,
+		},
+		{
+			"proxy",
+			&Config{ProxyURL: "http://proxy.example.com:3128"},
+			false,
+			true,
+			false,
+			false,
+		},
+		{
+			"ca_certificate",
+			&Config{CACertificate: testCACertificate(t)},
+			false,
+			false,
+			true,
+			false,
+		},
+		{
+			"invalid_proxy_scheme",
+			&Config{ProxyURL: "socks5://proxy.example.com"},
+			false,
+			false,
+			false,
+			true,
+		},
+		{
+			"invalid_ca_certificate",
+			&Config{CACertificate: "not a certificate"},
+			false,
+			false,
+			false,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			tr, err := newTransport(tc.c)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if tc.err {
+				return
+			}
+
+			if (tr == nil) != tc.nil {
+				t.Fatalf("expected nil to be %t", tc.nil)
+			}
+			if tr == nil {
+				return
+			}
+			if (tr.proxyURL != nil) != tc.proxy {
+				t.Errorf("expected proxy to be %t", tc.proxy)
+			}
+			if (tr.tlsConfig != nil) != tc.tls {
+				t.Errorf("expected tls to be %t", tc.tls)
+			}
+		})
+	}
+}
+
+func TestTransport_DialProxy(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		status int
+		err    bool
+	}{
+		{
+			"connected",
+			http.StatusOK,
+			false,
+		},
+		{
+			"refused",
+			http.StatusProxyAuthRequired,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			reqCh := make(chan *http.Request, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				reqCh <- req
+
+				resp := &http.Response{
+					StatusCode: tc.status,
+					ProtoMajor: 1,
+					ProtoMinor: 1,
+				}
+				resp.Write(conn)
+
+				// Echo back whatever is sent through the tunnel
+				io.Copy(conn, conn)
+			}()
+
+			tr, err := newTransport(&Config{
+				ProxyURL: "http://user:pass@" + ln.Addr().String(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := tr.dialProxy(ctx, "cloudkms.googleapis.com:443")
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+
+			req := <-reqCh
+			if v, exp := req.Method, http.MethodConnect; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+			if v, exp := req.Host, "cloudkms.googleapis.com:443"; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+			if v, exp := req.Header.Get("Proxy-Authorization"), "Basic dXNlcjpwYXNz"; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+
+			if tc.err {
+				return
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Fatal(err)
+			}
+			if v, exp := string(buf), "ping"; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// usageStoragePrefix is the storage prefix of the usage statistics of keys,
+// which are saved separately from the keys so counting an operation never
+// rewrites the key.
+const usageStoragePrefix = "usage/"
+
+// usageOperations are the operations counted in the usage statistics of keys.
+var usageOperations = []string{"encrypt", "decrypt", "reencrypt", "sign", "verify"}
+
+// keyUsage is the usage statistics of a key: the number of each operation and
+// when each operation was last done.
+type keyUsage struct {
+	Counts   map[string]uint64    `json:"counts"`
+	LastUsed map[string]time.Time `json:"last_used"`
+}
+
+// newKeyUsage returns empty usage statistics.
+func newKeyUsage() *keyUsage {
+	return &keyUsage{
+		Counts:   make(map[string]uint64),
+		LastUsed: make(map[string]time.Time),
+	}
+}
+
+// merge adds the counts of o to u, and keeps the later last use of each
+// operation.
+func (u *keyUsage) merge(o *keyUsage) {
+	for op, n := range o.Counts {
+		u.Counts[op] += n
+	}
+	for op, t := range o.LastUsed {
+		if t.After(u.LastUsed[op]) {
+			u.LastUsed[op] = t
+		}
+	}
+}
+
+// lastUsed returns the time of the last operation of any kind, or the zero
+// time if the key was never used.
+func (u *keyUsage) lastUsed() time.Time {
+	var last time.Time
+	for _, t := range u.LastUsed {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// withUsageForwarding wraps the callback of an operation which counts usage.
+// Usage is counted in memory by the node which handles the operation, and
+// performance standbys cannot save it, so their counts are only reported by
+// their own stats reads. If forward_usage is configured, operations are
+// instead forwarded from performance standbys to the active node, so every
+// operation is saved at the cost of standbys doing no crypto work.
+func (b *backend) withUsageForwarding(f framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+			c, err := b.Config(ctx, req.Storage)
+			if err != nil {
+				return nil, err
+			}
+			if c.ForwardUsage {
+				return nil, logical.ErrPerfStandbyPleaseForward
+			}
+		}
+		return f(ctx, req, d)
+	}
+}
+
+// canSaveUsage returns true if this node can save usage statistics. They are
+// in local storage, so every node but performance standbys and DR
+// secondaries can.
+func (b *backend) canSaveUsage() bool {
+	s := b.System().ReplicationState()
+	return !s.HasState(consts.ReplicationPerformanceStandby) && !s.HasState(consts.ReplicationDRSecondary)
+}
+
+// recordUsage counts an operation on the key. Counts are held in memory until
+// they are flushed to storage by the periodic function.
+func (b *backend) recordUsage(key, op string) {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+
+	u, ok := b.usage[key]
+	if !ok {
+		u = newKeyUsage()
+		b.usage[key] = u
+	}
+	u.Counts[op]++
+	u.LastUsed[op] = time.Now().UTC()
+}
+
+// storedKeyUsage returns the usage statistics of the key saved in storage.
+func (b *backend) storedKeyUsage(ctx context.Context, s logical.Storage, key string) (*keyUsage, error) {
+	u := newKeyUsage()
+
+	entry, err := s.Get(ctx, usageStoragePrefix+key)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to read usage of %q: {{err}}", key), err)
+	}
+	if entry == nil {
+		return u, nil
+	}
+
+	if err := entry.DecodeJSON(u); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to decode usage of %q: {{err}}", key), err)
+	}
+	if u.Counts == nil {
+		u.Counts = make(map[string]uint64)
+	}
+	if u.LastUsed == nil {
+		u.LastUsed = make(map[string]time.Time)
+	}
+	return u, nil
+}
+
+// KeyUsage returns the usage statistics of the key, including operations which
+// have not been flushed to storage yet.
+func (b *backend) KeyUsage(ctx context.Context, s logical.Storage, key string) (*keyUsage, error) {
+	u, err := b.storedKeyUsage(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+
+	b.usageLock.Lock()
+	if pending, ok := b.usage[key]; ok {
+		u.merge(pending)
+	}
+	b.usageLock.Unlock()
+
+	return u, nil
+}
+
+// flushUsage adds the operations counted in memory to the usage statistics in
+// storage. Counts of keys which were deleted since they were counted are
+// dropped, and counts which fail to save are kept for the next flush.
+func (b *backend) flushUsage(ctx context.Context, s logical.Storage) error {
+	b.usageLock.Lock()
+	pending := b.usage
+	b.usage = make(map[string]*keyUsage)
+	b.usageLock.Unlock()
+
+	var errs *multierror.Error
+	for key, p := range pending {
+		if err := b.saveKeyUsage(ctx, s, key, p); err != nil {
+			errs = multierror.Append(errs, err)
+
+			b.usageLock.Lock()
+			if u, ok := b.usage[key]; ok {
+				p.merge(u)
+			}
+			b.usage[key] = p
+			b.usageLock.Unlock()
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// saveKeyUsage adds the pending usage statistics to the ones in storage under
+// the key's lock, unless the key no longer exists, so statistics are not
+// recreated for a deleted key.
+func (b *backend) saveKeyUsage(ctx context.Context, s logical.Storage, key string, pending *keyUsage) error {
+	unlock := b.lockKey(key)
+	defer unlock()
+
+	if _, err := b.Key(ctx, s, key); err != nil {
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+	return b.saveUsage(ctx, s, key, pending)
+}
+
+// saveUsage adds the pending usage statistics to the ones in storage.
+func (b *backend) saveUsage(ctx context.Context, s logical.Storage, key string, pending *keyUsage) error {
+	u, err := b.storedKeyUsage(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	u.merge(pending)
+
+	entry, err := logical.StorageEntryJSON(usageStoragePrefix+key, u)
+	if err != nil {
+		return errwrap.Wrapf("failed to create storage entry: {{err}}", err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to save usage of %q: {{err}}", key), err)
+	}
+	return nil
+}
+
+// deleteUsage removes the usage statistics of the key, such as when the key is
+// deleted.
+func (b *backend) deleteUsage(ctx context.Context, s logical.Storage, key string) error {
+	b.usageLock.Lock()
+	delete(b.usage, key)
+	b.usageLock.Unlock()
+
+	if err := s.Delete(ctx, usageStoragePrefix+key); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to delete usage of %q: {{err}}", key), err)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_FlushUsage(t *testing.T) {
+
+	b, storage := testBackend(t)
+	ctx := context.Background()
+
+	if err := storage.Put(ctx, &logical.StorageEntry{
+		Key:   "keys/my-key",
+		Value: []byte(`{"name":"my-key"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.recordUsage("my-key", "encrypt")
+	b.recordUsage("my-key", "encrypt")
+	b.recordUsage("my-key", "decrypt")
+
+	if err := b.flushUsage(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.usage) != 0 {
+		t.Errorf("expected pending usage to be flushed, got %#v", b.usage)
+	}
+
+	b.recordUsage("my-key", "encrypt")
+
+	// Pending counts are included before they are flushed
+	u, err := b.KeyUsage(ctx, storage, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := u.Counts["encrypt"], uint64(3); v != exp {
+		t.Errorf("expected %d to be %d", v, exp)
+	}
+	if v, exp := u.Counts["decrypt"], uint64(1); v != exp {
+		t.Errorf("expected %d to be %d", v, exp)
+	}
+
+	if err := b.flushUsage(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	u, err = b.storedKeyUsage(ctx, storage, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := u.Counts["encrypt"], uint64(3); v != exp {
+		t.Errorf("expected %d to be %d", v, exp)
+	}
+	if u.LastUsed["encrypt"].IsZero() {
+		t.Errorf("expected last use of encrypt to be recorded")
+	}
+
+	if err := b.deleteUsage(ctx, storage, "my-key"); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := storage.Get(ctx, usageStoragePrefix+"my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Errorf("expected usage to be deleted")
+	}
+
+	// Counts of keys deleted since they were counted are dropped
+	b.recordUsage("deleted-key", "encrypt")
+	if err := b.flushUsage(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = storage.Get(ctx, usageStoragePrefix+"deleted-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Errorf("expected no usage to be saved for a deleted key")
+	}
+	if len(b.usage) != 0 {
+		t.Errorf("expected pending usage to be dropped, got %#v", b.usage)
+	}
+}
+
+func TestBackend_UsageForwarding(t *testing.T) {
+
+	paths := []string{"encrypt/my-key", "decrypt/my-key", "reencrypt/my-key", "sign/my-key", "verify/my-key"}
+
+	cases := []struct {
+		name         string
+		standby      bool
+		forwardUsage bool
+		forwarded    bool
+	}{
+		{"active", false, true, false},
+		{"standby", true, false, false},
+		{"standby_forward_usage", true, true, true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			if _, err := b.HandleRequest(ctx, &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      "config",
+				Data: map[string]interface{}{
+					"forward_usage": tc.forwardUsage,
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if tc.standby {
+				b.System().(*logical.StaticSystemView).ReplicationStateVal = consts.ReplicationPerformanceStandby
+			}
+
+			for _, p := range paths {
+				_, err := b.HandleRequest(ctx, &logical.Request{
+					Storage:   storage,
+					Operation: logical.UpdateOperation,
+					Path:      p,
+				})
+				if forwarded := err == logical.ErrPerfStandbyPleaseForward; forwarded != tc.forwarded {
+					t.Errorf("%s: expected forwarded to be %t, got %v", p, tc.forwarded, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBackend_PeriodicFlushUsage(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		state   consts.ReplicationState
+		flushed bool
+	}{
+		{"active", 0, true},
+		{"performance_secondary", consts.ReplicationPerformanceSecondary, true},
+		{"performance_standby", consts.ReplicationPerformanceStandby, false},
+		{"dr_secondary", consts.ReplicationDRSecondary, false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+			b.System().(*logical.StaticSystemView).ReplicationStateVal = tc.state
+
+			ctx := context.Background()
+			if err := storage.Put(ctx, &logical.StorageEntry{
+				Key:   "keys/my-key",
+				Value: []byte(`{"name":"my-key"}`),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			b.recordUsage("my-key", "encrypt")
+
+			if err := b.periodicFunc(ctx, &logical.Request{Storage: storage}); err != nil {
+				t.Fatal(err)
+			}
+			if flushed := len(b.usage) == 0; flushed != tc.flushed {
+				t.Errorf("expected flushed to be %t", tc.flushed)
+			}
+		})
+	}
+}
+
+func TestPathKeysStats_Read(t *testing.T) {
+
+	t.Run("field_validation", func(t *testing.T) {
+
+		testFieldValidation(t, logical.ReadOperation, "keys/my-key/stats")
+	})
+
+	t.Run("not_exist", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/my-key/stats",
+		})
+		if err != logical.ErrInvalidRequest {
+			t.Errorf("expected %q to be %q", err, logical.ErrInvalidRequest)
+		}
+	})
+
+	t.Run("exist", func(t *testing.T) {
+
+		b, storage := testBackend(t)
+
+		ctx := context.Background()
+		if err := storage.Put(ctx, &logical.StorageEntry{
+			Key:   "keys/my-key",
+			Value: []byte(`{"name":"my-key"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/my-key/stats",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := resp.Data["never_used"], true; v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+
+		b.recordUsage("my-key", "sign")
+
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/my-key/stats",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, exp := resp.Data["total"], uint64(1); v != exp {
+			t.Errorf("expected %v to be %v", v, exp)
+		}
+		if _, ok := resp.Data["last_used_time"]; !ok {
+			t.Errorf("expected last_used_time to be set")
+		}
+	})
+}
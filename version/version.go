@@ -20,4 +20,8 @@ var (
 
 	// HumanVersion is the human-formatted version of the plugin.
 	HumanVersion = fmt.Sprintf("%s v%s (%s)", Name, Version, GitCommit)
+
+	// PluginVersion is the semantic version the plugin reports to Vault, which
+	// must match the version the plugin is registered with for pinning.
+	PluginVersion = "v" + Version
 )
@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// kwpIV is the alternative initial value of AES key wrap with padding, defined
+// in RFC 5649 section 3.
+var kwpIV = []byte{0xa6, 0x59, 0x59, 0xa6}
+
+// rsaAESKeyWrap wraps the key material in the format of PKCS#11
+// CKM_RSA_AES_KEY_WRAP: a new AES-256 key encrypted with the RSA public key
+// using OAEP, followed by the key material wrapped with the AES key using AES
+// key wrap with padding.
+func rsaAESKeyWrap(pub *rsa.PublicKey, hash crypto.Hash, material []byte) ([]byte, error) {
+	kek := make([]byte, 32)
+	if _, err := rand.Read(kek); err != nil {
+		return nil, errwrap.Wrapf("failed to generate wrapping key: {{err}}", err)
+	}
+
+	h := sha256.New()
+	switch hash {
+	case crypto.SHA1:
+		h = sha1.New()
+	case crypto.SHA512:
+		h = sha512.New()
+	}
+
+	wrappedKEK, err := rsa.EncryptOAEP(h, rand.Reader, pub, kek, nil)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to encrypt wrapping key: {{err}}", err)
+	}
+
+	wrapped, err := aesKeyWrapPad(kek, material)
+	if err != nil {
+		return nil, err
+	}
+	return append(wrappedKEK, wrapped...), nil
+}
+
+// aesKeyWrapPad wraps the key material with the key encryption key using AES
+// key wrap with padding, defined in RFC 5649.
+func aesKeyWrapPad(kek, material []byte) ([]byte, error) {
+	if len(material) == 0 {
+		return nil, fmt.Errorf("key material cannot be empty")
+	}
+	if uint64(len(material)) > 0xffffffff {
+		return nil, fmt.Errorf("key material is too long")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, errwrap.Wrapf("invalid wrapping key: {{err}}", err)
+	}
+
+	// The key material is zero-padded to a multiple of the 64-bit semiblock
+	// and its length is bound into the initial value.
+	n := (len(material) + 7) / 8
+	out := make([]byte, 8*(n+1))
+	copy(out, kwpIV)
+	binary.BigEndian.PutUint32(out[4:8], uint32(len(material)))
+	copy(out[8:], material)
+
+	// A single semiblock is encrypted directly with the initial value.
+	if n == 1 {
+		block.Encrypt(out, out)
+		return out, nil
+	}
+
+	// Otherwise it is wrapped with the key wrap process of RFC 3394.
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[8*i:8*i+8])
+			block.Encrypt(b[:], b[:])
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[8*i:8*i+8], b[8:])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAESKeyWrapPad(t *testing.T) {
+
+	// Test vectors from RFC 5649 section 6.
+	kek, _ := hex.DecodeString("5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+
+	cases := []struct {
+		name     string
+		material string
+		wrapped  string
+	}{
+		{
+			"twenty_octets",
+			"c37b7e6492584340bed12207808941155068f738",
+			"138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a",
+		},
+		{
+			"seven_octets",
+			"466f7250617369",
+			"afbeb0f07dfbf5419200f2ccb50bb24f",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			material, _ := hex.DecodeString(tc.material)
+			wrapped, err := aesKeyWrapPad(kek, material)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, exp := hex.EncodeToString(wrapped), tc.wrapped; v != exp {
+				t.Errorf("expected %q to be %q", v, exp)
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+
+		if _, err := aesKeyWrapPad(kek, nil); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestRSAAESKeyWrap(t *testing.T) {
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	material := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, err := rsaAESKeyWrap(&priv.PublicKey, crypto.SHA256, material)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kek, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, wrapped[:256], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, exp := len(kek), 32; v != exp {
+		t.Fatalf("expected %d to be %d", v, exp)
+	}
+
+	exp, err := aesKeyWrapPad(kek, material)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := wrapped[256:]; !bytes.Equal(v, exp) {
+		t.Errorf("expected %x to be %x", v, exp)
+	}
+}
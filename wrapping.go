@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// responseWrappingNone, responseWrappingWarn, and responseWrappingForce
+	// are the policies for responses which return plaintext. With "warn",
+	// unwrapped responses carry a warning, and with "force", responses are
+	// always wrapped.
+	responseWrappingNone  = "none"
+	responseWrappingWarn  = "warn"
+	responseWrappingForce = "force"
+
+	// defaultResponseWrapTTL is the default TTL of the wrapping token for
+	// responses which are wrapped by force.
+	defaultResponseWrapTTL = 5 * time.Minute
+)
+
+// validateResponseWrapping validates a response wrapping policy.
+func validateResponseWrapping(policy string) error {
+	switch policy {
+	case responseWrappingNone, responseWrappingWarn, responseWrappingForce:
+		return nil
+	}
+	return logical.CodedError(400, fmt.Sprintf("invalid response_wrapping %q, "+
+		"valid policies are %q, %q, and %q", policy,
+		responseWrappingNone, responseWrappingWarn, responseWrappingForce))
+}
+
+// wrapPlaintextResponse applies the response wrapping policy of the key, or of
+// the config if the key has none, to a response which returns plaintext.
+// Responses the client already asked to wrap are left alone.
+func (b *backend) wrapPlaintextResponse(ctx context.Context, req *logical.Request, k *Key, resp *logical.Response) error {
+	if req.WrapInfo != nil && req.WrapInfo.TTL > 0 {
+		return nil
+	}
+
+	c, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	policy := k.ResponseWrapping
+	if policy == "" {
+		policy = c.ResponseWrapping
+	}
+
+	switch policy {
+	case responseWrappingWarn:
+		resp.AddWarning("the response contains plaintext and is not wrapped, " +
+			"request response wrapping to keep plaintext out of client logs")
+	case responseWrappingForce:
+		ttl := c.ResponseWrapTTL
+		if ttl <= 0 {
+			ttl = defaultResponseWrapTTL
+		}
+		resp.WrapInfo = &wrapping.ResponseWrapInfo{
+			TTL: ttl,
+		}
+	}
+	return nil
+}
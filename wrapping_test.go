@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gcpkms
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_WrapPlaintextResponse(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		config   string
+		key      *Key
+		wrapInfo *logical.RequestWrapInfo
+		warning  bool
+		wrapTTL  time.Duration
+	}{
+		{
+			"default",
+			`{}`,
+			&Key{},
+			nil,
+			false,
+			0,
+		},
+		{
+			"config_warn",
+			`{"response_wrapping":"warn"}`,
+			&Key{},
+			nil,
+			true,
+			0,
+		},
+		{
+			"key_overrides_config",
+			`{"response_wrapping":"warn"}`,
+			&Key{ResponseWrapping: "none"},
+			nil,
+			false,
+			0,
+		},
+		{
+			"key_force",
+			`{"response_wrap_ttl":60000000000}`,
+			&Key{ResponseWrapping: "force"},
+			nil,
+			false,
+			time.Minute,
+		},
+		{
+			"already_wrapped",
+			`{"response_wrapping":"force"}`,
+			&Key{},
+			&logical.RequestWrapInfo{TTL: time.Hour},
+			false,
+			0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			b, storage := testBackend(t)
+
+			ctx := context.Background()
+			if err := storage.Put(ctx, &logical.StorageEntry{
+				Key:   "config",
+				Value: []byte(tc.config),
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			resp := &logical.Response{
+				Data: map[string]interface{}{
+					"plaintext": "hello",
+				},
+			}
+			if err := b.wrapPlaintextResponse(ctx, &logical.Request{
+				Storage:  storage,
+				WrapInfo: tc.wrapInfo,
+			}, tc.key, resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if v := len(resp.Warnings) > 0; v != tc.warning {
+				t.Errorf("expected warning %t to be %t", v, tc.warning)
+			}
+
+			var ttl time.Duration
+			if resp.WrapInfo != nil {
+				ttl = resp.WrapInfo.TTL
+			}
+			if ttl != tc.wrapTTL {
+				t.Errorf("expected %s to be %s", ttl, tc.wrapTTL)
+			}
+		})
+	}
+}